@@ -0,0 +1,56 @@
+package main
+
+import "unsafe"
+
+// arenaBlock è la dimensione dei blocchi in cui lo split copia le righe.
+const arenaBlock = 4 << 20
+
+// lineArena raccoglie i byte delle righe dello split in pochi blocchi
+// grandi invece di allocare una stringa per riga: un chunk da 100 MB costa
+// una ventina di allocazioni invece di centinaia di migliaia, e le righe
+// vicine nell'input restano vicine in memoria durante l'ordinamento.
+//
+// Le righe sono stringhe che puntano dentro i blocchi. I byte di un blocco
+// non vengono mai riscritti e il blocco viene liberato dal GC quando nessuna
+// riga lo usa più; chi conserva una riga oltre la vita del chunk (prima e
+// ultima riga, indice del run set, chiavi di partizione) ne fa una copia con
+// strings.Clone, per non trattenere l'intero blocco.
+type lineArena struct {
+	block []byte // blocco corrente, riempito in append
+}
+
+// add copia b nell'arena e restituisce la stringa corrispondente. Le righe
+// più lunghe di un quarto di blocco hanno un'allocazione propria.
+func (a *lineArena) add(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if len(b) > arenaBlock/4 {
+		return string(b)
+	}
+	if len(b) > cap(a.block)-len(a.block) {
+		a.block = make([]byte, 0, arenaBlock)
+	}
+	start := len(a.block)
+	a.block = append(a.block, b...)
+	return unsafe.String(&a.block[start], len(b))
+}
+
+// reset abbandona il blocco corrente, che il GC libera insieme alle righe.
+func (a *lineArena) reset() {
+	a.block = nil
+}
+
+// parseRecord interpreta una riga di input con lo schema corrente. Se lo
+// schema ha Slice il record viene copiato nell'arena, altrimenti è la
+// stringa allocata da Parse.
+func parseRecord(arena *lineArena, line []byte) (string, bool) {
+	if opts.schemaDef.Slice == nil {
+		return opts.schemaDef.Parse(line)
+	}
+	b, ok := opts.schemaDef.Slice(line)
+	if !ok {
+		return "", false
+	}
+	return arena.add(b), true
+}
@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// avroSchemaName è lo schema dei record letti da file Avro, selezionato da --avro.
+const avroSchemaName = "avro"
+
+// Valori di --avro-output.
+const (
+	avroOutAvro  = "avro"  // object container file con lo schema dell'input
+	avroOutJSONL = "jsonl" // un documento JSON per record
+)
+
+// avroRawSep separa, nella forma interna di un record, il documento JSON usato
+// per le chiavi dal datum Avro originale in base64, conservato per l'output
+// Avro. Il JSON prodotto da avroJSON non contiene mai questo byte.
+const avroRawSep = "\x1f"
+
+// avroMagic apre ogni object container file.
+var avroMagic = []byte("Obj\x01")
+
+// avroBlockBytes è la dimensione oltre cui l'output Avro chiude un blocco.
+const avroBlockBytes = 1 << 20
+
+// errAvroShort segnala un datum troncato.
+var errAvroShort = errors.New("datum troncato")
+
+// avroInput è lo schema del primo file Avro letto, riscritto nell'output.
+var avroInput struct {
+	schema string
+}
+
+// avroType è un tipo di uno schema Avro.
+type avroType struct {
+	kind     string      // null, boolean, int, long, float, double, bytes, string, record, enum, array, map, union o fixed
+	name     string      // nome completo di record, enum e fixed
+	fields   []avroField // campi di un record
+	symbols  []string    // simboli di un enum
+	items    *avroType   // elementi di un array o valori di una map
+	branches []*avroType // rami di una union
+	size     int         // byte di un fixed
+}
+
+// avroField è un campo di un record.
+type avroField struct {
+	name string
+	typ  *avroType
+}
+
+// parseAvroOutput verifica il valore di --avro-output.
+func parseAvroOutput(v string) error {
+	if v == avroOutAvro || v == avroOutJSONL {
+		return nil
+	}
+	return fmt.Errorf("--avro-output non valido: %q (attesi avro o jsonl)", v)
+}
+
+// avroOutput indica se l'output è un file Avro.
+func avroOutput() bool {
+	return opts.avro && opts.avroOutput == avroOutAvro
+}
+
+// avroSchemaParser interpreta uno schema Avro, risolvendo i riferimenti ai
+// tipi con nome già definiti.
+type avroSchemaParser struct {
+	names map[string]*avroType
+}
+
+// parseAvroSchema interpreta lo schema JSON di un file Avro.
+func parseAvroSchema(schema []byte) (*avroType, error) {
+	p := &avroSchemaParser{names: map[string]*avroType{}}
+	return p.parse(schema, "")
+}
+
+func isAvroPrimitive(kind string) bool {
+	switch kind {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+// avroFullName restituisce il nome completo di un tipo, con il namespace
+// esplicito o, in mancanza, con quello del tipo che lo contiene.
+func avroFullName(name, namespace, enclosing string) string {
+	switch {
+	case strings.Contains(name, "."):
+		return name
+	case namespace != "":
+		return namespace + "." + name
+	case enclosing != "":
+		return enclosing + "." + name
+	}
+	return name
+}
+
+func (p *avroSchemaParser) parse(raw json.RawMessage, ns string) (*avroType, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("tipo mancante")
+	}
+	switch raw[0] {
+	case '"':
+		var name string
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return nil, err
+		}
+		if isAvroPrimitive(name) {
+			return &avroType{kind: name}, nil
+		}
+		if t, ok := p.names[avroFullName(name, "", ns)]; ok {
+			return t, nil
+		}
+		if t, ok := p.names[name]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("tipo %q sconosciuto", name)
+	case '[':
+		var branches []json.RawMessage
+		if err := json.Unmarshal(raw, &branches); err != nil {
+			return nil, err
+		}
+		t := &avroType{kind: "union"}
+		for _, b := range branches {
+			bt, err := p.parse(b, ns)
+			if err != nil {
+				return nil, err
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+	}
+	var obj struct {
+		Type      json.RawMessage `json:"type"`
+		Name      string          `json:"name"`
+		Namespace string          `json:"namespace"`
+		Fields    []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+		Symbols []string        `json:"symbols"`
+		Items   json.RawMessage `json:"items"`
+		Values  json.RawMessage `json:"values"`
+		Size    int             `json:"size"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	var kind string
+	if json.Unmarshal(obj.Type, &kind) != nil {
+		// {"type": {...}}: il tipo è annidato.
+		return p.parse(obj.Type, ns)
+	}
+	t := &avroType{kind: kind}
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		if obj.Name == "" {
+			return nil, fmt.Errorf("%s senza nome", kind)
+		}
+		t.name = avroFullName(obj.Name, obj.Namespace, ns)
+		// Registrato prima dei campi: un record può contenere sé stesso.
+		p.names[t.name] = t
+	}
+	switch kind {
+	case "record", "error":
+		t.kind = "record"
+		inner := ""
+		if i := strings.LastIndexByte(t.name, '.'); i >= 0 {
+			inner = t.name[:i]
+		}
+		for _, f := range obj.Fields {
+			ft, err := p.parse(f.Type, inner)
+			if err != nil {
+				return nil, fmt.Errorf("campo %s.%s: %w", t.name, f.Name, err)
+			}
+			t.fields = append(t.fields, avroField{name: f.Name, typ: ft})
+		}
+	case "enum":
+		t.symbols = obj.Symbols
+	case "fixed":
+		t.size = obj.Size
+	case "array", "map":
+		items := obj.Items
+		if kind == "map" {
+			items = obj.Values
+		}
+		it, err := p.parse(items, ns)
+		if err != nil {
+			return nil, err
+		}
+		t.items = it
+	default:
+		// Tipo primitivo con attributi, ad esempio un logicalType.
+		if !isAvroPrimitive(kind) {
+			return p.parse(obj.Type, ns)
+		}
+	}
+	return t, nil
+}
+
+// avroData è il contenuto di un blocco in corso di decodifica.
+type avroData struct {
+	b []byte
+}
+
+func (d *avroData) long() (int64, error) {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		return 0, errAvroShort
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *avroData) take(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(d.b)) {
+		return nil, errAvroShort
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+// avroJSON decodifica da d un valore di tipo t e lo aggiunge a buf come JSON.
+// I campi dei record mantengono l'ordine dello schema, le union diventano il
+// valore del ramo scelto e bytes e fixed diventano stringhe con un carattere
+// (da U+0000 a U+00FF) per byte, come nella codifica JSON di Avro.
+func avroJSON(buf []byte, t *avroType, d *avroData) ([]byte, error) {
+	switch t.kind {
+	case "null":
+		return append(buf, "null"...), nil
+	case "boolean":
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendBool(buf, b[0] != 0), nil
+	case "int", "long":
+		v, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(buf, v, 10), nil
+	case "float":
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONFloat(buf, float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 32), nil
+	case "double":
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONFloat(buf, math.Float64frombits(binary.LittleEndian.Uint64(b)), 64), nil
+	case "bytes", "string":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, b, t.kind == "bytes"), nil
+	case "fixed":
+		b, err := d.take(int64(t.size))
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, b, true), nil
+	case "enum":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(t.symbols)) {
+			return nil, fmt.Errorf("simbolo %d fuori dall'enum %s", i, t.name)
+		}
+		return appendJSONString(buf, []byte(t.symbols[i]), false), nil
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(t.branches)) {
+			return nil, fmt.Errorf("ramo %d fuori dalla union", i)
+		}
+		return avroJSON(buf, t.branches[i], d)
+	case "record":
+		buf = append(buf, '{')
+		for i, f := range t.fields {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, []byte(f.name), false)
+			buf = append(buf, ':')
+			var err error
+			if buf, err = avroJSON(buf, f.typ, d); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	}
+	// array e map: blocchi di elementi, chiusi da un conteggio nullo. Un
+	// conteggio negativo è seguito dalla dimensione in byte del blocco.
+	open, end := byte('['), byte(']')
+	if t.kind == "map" {
+		open, end = '{', '}'
+	}
+	buf = append(buf, open)
+	first := true
+	for {
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return append(buf, end), nil
+		}
+		if n < 0 {
+			n = -n
+			if _, err := d.long(); err != nil {
+				return nil, err
+			}
+		}
+		for ; n > 0; n-- {
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+			if t.kind == "map" {
+				kn, err := d.long()
+				if err != nil {
+					return nil, err
+				}
+				k, err := d.take(kn)
+				if err != nil {
+					return nil, err
+				}
+				buf = append(appendJSONString(buf, k, false), ':')
+			}
+			if buf, err = avroJSON(buf, t.items, d); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// appendJSONFloat scrive un numero in virgola mobile. NaN e infiniti, che
+// JSON non rappresenta, diventano stringhe.
+func appendJSONFloat(buf []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(buf, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(buf, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(buf, `"-Infinity"`...)
+	}
+	return strconv.AppendFloat(buf, f, 'g', -1, bits)
+}
+
+// appendJSONString scrive s come stringa JSON. Con raw ogni byte è un
+// carattere; altrimenti s è UTF-8 e le sequenze non valide diventano U+FFFD.
+func appendJSONString(buf, s []byte, raw bool) []byte {
+	buf = append(buf, '"')
+	for len(s) > 0 {
+		r, n := rune(s[0]), 1
+		if !raw && r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRune(s)
+		}
+		s = s[n:]
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r < 0x20 || r == 0x7f:
+			buf = append(buf, `\u00`...)
+			buf = append(buf, "0123456789abcdef"[r>>4], "0123456789abcdef"[r&0xf])
+		default:
+			buf = utf8.AppendRune(buf, r)
+		}
+	}
+	return append(buf, '"')
+}
+
+// avroReader converte un object container file Avro nei record di testo
+// dello schema avro, uno per riga, decodificando un blocco alla volta.
+type avroReader struct {
+	name   string // file letto, per i messaggi
+	r      *bufio.Reader
+	schema *avroType
+	codec  string
+	sync   []byte
+	out    []byte // record convertiti non ancora letti
+	err    error
+	opened bool
+}
+
+// newAvroReader restituisce i record del file Avro name letto da r.
+func newAvroReader(r io.Reader, name string) *avroReader {
+	return &avroReader{name: name, r: bufio.NewReader(r)}
+}
+
+func (ar *avroReader) Read(p []byte) (int, error) {
+	for len(ar.out) == 0 && ar.err == nil {
+		if !ar.opened {
+			ar.opened = true
+			ar.err = ar.readHeader()
+		} else {
+			ar.err = ar.readBlock()
+		}
+		if ar.err != nil && ar.err != io.EOF {
+			ar.err = fmt.Errorf("%s: %w", ar.name, ar.err)
+		}
+	}
+	if len(ar.out) == 0 {
+		return 0, ar.err
+	}
+	n := copy(p, ar.out)
+	ar.out = ar.out[n:]
+	return n, nil
+}
+
+// readHeader legge l'intestazione del file: magic, metadati e marker di
+// sincronizzazione.
+func (ar *avroReader) readHeader() error {
+	head := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(ar.r, head); err != nil || !bytes.Equal(head, avroMagic) {
+		return fmt.Errorf("input Avro non valido: manca l'intestazione Obj")
+	}
+	meta := map[string][]byte{}
+	for {
+		n, err := binary.ReadVarint(ar.r)
+		if err != nil {
+			return fmt.Errorf("input Avro non valido: metadati troncati")
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			if _, err := binary.ReadVarint(ar.r); err != nil {
+				return fmt.Errorf("input Avro non valido: metadati troncati")
+			}
+		}
+		for ; n > 0; n-- {
+			k, err := ar.readBytes()
+			if err != nil {
+				return err
+			}
+			v, err := ar.readBytes()
+			if err != nil {
+				return err
+			}
+			meta[string(k)] = v
+		}
+	}
+	ar.sync = make([]byte, 16)
+	if _, err := io.ReadFull(ar.r, ar.sync); err != nil {
+		return fmt.Errorf("input Avro non valido: marker di sincronizzazione troncato")
+	}
+	schema := meta["avro.schema"]
+	t, err := parseAvroSchema(schema)
+	if err != nil {
+		return fmt.Errorf("schema Avro non valido: %w", err)
+	}
+	ar.schema = t
+	ar.codec = string(meta["avro.codec"])
+	switch ar.codec {
+	case "":
+		ar.codec = "null"
+	case "null", "deflate", "snappy", "zstandard":
+	default:
+		return fmt.Errorf("codec Avro %q non supportato (attesi null, deflate, snappy o zstandard)", ar.codec)
+	}
+	// L'output Avro ha un solo schema: gli input devono condividerlo.
+	switch {
+	case avroInput.schema == "":
+		avroInput.schema = string(schema)
+	case avroOutput() && avroInput.schema != string(schema):
+		return fmt.Errorf("gli input Avro hanno schemi diversi: usa --avro-output jsonl")
+	}
+	return nil
+}
+
+// readBytes legge un valore bytes (lunghezza e contenuto) dall'intestazione.
+func (ar *avroReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadVarint(ar.r)
+	if err != nil || n < 0 || n > maxFrameBytes {
+		return nil, fmt.Errorf("input Avro non valido: metadati troncati")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(ar.r, b); err != nil {
+		return nil, fmt.Errorf("input Avro non valido: metadati troncati")
+	}
+	return b, nil
+}
+
+// readBlock legge e converte il blocco successivo. Alla fine del file
+// restituisce io.EOF.
+func (ar *avroReader) readBlock() error {
+	count, err := binary.ReadVarint(ar.r)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("input Avro non valido: blocco troncato")
+	}
+	size, err := binary.ReadVarint(ar.r)
+	if err != nil || count < 0 || size < 0 || size > maxFrameBytes {
+		return fmt.Errorf("input Avro non valido: intestazione di blocco errata")
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(ar.r, block); err != nil {
+		return fmt.Errorf("input Avro non valido: blocco troncato")
+	}
+	sync := make([]byte, len(ar.sync))
+	if _, err := io.ReadFull(ar.r, sync); err != nil || !bytes.Equal(sync, ar.sync) {
+		return fmt.Errorf("input Avro non valido: marker di sincronizzazione errato")
+	}
+	data, err := avroDecompress(ar.codec, block)
+	if err != nil {
+		return fmt.Errorf("blocco Avro %s non valido: %w", ar.codec, err)
+	}
+	d := &avroData{b: data}
+	for ; count > 0; count-- {
+		start := d.b
+		if ar.out, err = avroJSON(ar.out, ar.schema, d); err != nil {
+			return fmt.Errorf("record Avro non valido: %w", err)
+		}
+		if avroOutput() {
+			datum := start[:len(start)-len(d.b)]
+			ar.out = append(ar.out, avroRawSep...)
+			n := len(ar.out)
+			ar.out = append(ar.out, make([]byte, base64.StdEncoding.EncodedLen(len(datum)))...)
+			base64.StdEncoding.Encode(ar.out[n:], datum)
+		}
+		ar.out = append(ar.out, '\n')
+	}
+	return nil
+}
+
+// avroZstd è il decoder zstd dei blocchi, creato al primo uso.
+var avroZstd *zstd.Decoder
+
+// avroDecompress restituisce il contenuto di un blocco compresso con codec.
+func avroDecompress(codec string, block []byte) ([]byte, error) {
+	switch codec {
+	case "deflate":
+		return io.ReadAll(flate.NewReader(bytes.NewReader(block)))
+	case "snappy":
+		// Il blocco snappy è seguito dal CRC-32 big-endian del contenuto.
+		if len(block) < 4 {
+			return nil, errAvroShort
+		}
+		data, err := snappy.Decode(nil, block[:len(block)-4])
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(block[len(block)-4:]) {
+			return nil, fmt.Errorf("CRC errato")
+		}
+		return data, nil
+	case "zstandard":
+		if avroZstd == nil {
+			dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			avroZstd = dec
+		}
+		return avroZstd.DecodeAll(block, nil)
+	}
+	return block, nil
+}
+
+// avroRecord accetta le righe prodotte da avroReader.
+func avroRecord(line []byte) (string, bool) {
+	line = bytes.TrimSuffix(line, []byte{recordSep})
+	if len(line) == 0 {
+		return "", false
+	}
+	return string(line), true
+}
+
+// avroKey costruisce la chiave dal documento JSON del record, come per i
+// record JSON Lines.
+func avroKey(record string) string {
+	doc, _, _ := strings.Cut(record, avroRawSep)
+	return jsonKey(doc)
+}
+
+// formatAvro restituisce il documento JSON del record o, con output Avro, il
+// datum originale in base64, riscritto in binario da avroWriter.
+func formatAvro(record string) string {
+	doc, datum, _ := strings.Cut(record, avroRawSep)
+	if avroOutput() {
+		return datum
+	}
+	return doc
+}
+
+// avroWriter scrive un object container file Avro a partire dalle righe di
+// output, ognuna con un datum in base64. I datum vengono raccolti in blocchi
+// non compressi di circa avroBlockBytes byte.
+type avroWriter struct {
+	w     io.Writer
+	sync  []byte
+	block []byte
+	count int64
+	line  []byte // riga incompleta tra due Write
+	err   error
+}
+
+// newAvroWriter scrive su w l'intestazione del file, con lo schema del primo input.
+func newAvroWriter(w io.Writer) (*avroWriter, error) {
+	aw := &avroWriter{w: w, sync: make([]byte, 16)}
+	if _, err := rand.Read(aw.sync); err != nil {
+		return nil, err
+	}
+	head := append([]byte(nil), avroMagic...)
+	head = binary.AppendVarint(head, 2)
+	for _, kv := range [][2]string{{"avro.schema", avroInput.schema}, {"avro.codec", "null"}} {
+		for _, s := range kv {
+			head = binary.AppendVarint(head, int64(len(s)))
+			head = append(head, s...)
+		}
+	}
+	head = binary.AppendVarint(head, 0)
+	head = append(head, aw.sync...)
+	if _, err := w.Write(head); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (aw *avroWriter) Write(p []byte) (int, error) {
+	if aw.err != nil {
+		return 0, aw.err
+	}
+	aw.line = append(aw.line, p...)
+	for {
+		i := bytes.IndexByte(aw.line, '\n')
+		if i < 0 {
+			break
+		}
+		aw.add(bytes.TrimSuffix(aw.line[:i], []byte("\r")))
+		aw.line = aw.line[i+1:]
+	}
+	aw.line = append(aw.line[:0:0], aw.line...)
+	return len(p), aw.err
+}
+
+// add aggiunge al blocco corrente il datum della riga line.
+func (aw *avroWriter) add(line []byte) {
+	if aw.err != nil {
+		return
+	}
+	datum := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(datum, line)
+	if err != nil {
+		aw.err = fmt.Errorf("output Avro: record non valido: %w", err)
+		return
+	}
+	aw.block = append(aw.block, datum[:n]...)
+	aw.count++
+	if len(aw.block) >= avroBlockBytes {
+		aw.flush()
+	}
+}
+
+// flush scrive il blocco corrente con il marker di sincronizzazione.
+func (aw *avroWriter) flush() {
+	if aw.err != nil || aw.count == 0 {
+		return
+	}
+	head := binary.AppendVarint(nil, aw.count)
+	head = binary.AppendVarint(head, int64(len(aw.block)))
+	for _, b := range [][]byte{head, aw.block, aw.sync} {
+		if _, err := aw.w.Write(b); err != nil {
+			aw.err = err
+			return
+		}
+	}
+	aw.block, aw.count = aw.block[:0], 0
+}
+
+// Close scrive l'ultimo blocco. Non chiude il writer sottostante.
+func (aw *avroWriter) Close() error {
+	if len(aw.line) > 0 {
+		aw.add(aw.line)
+		aw.line = nil
+	}
+	aw.flush()
+	return aw.err
+}
+
+func init() {
+	// Record di object container file Avro: vedi --avro.
+	schema.Register(schema.Schema{Name: avroSchemaName, Parse: avroRecord, Key: avroKey, Format: formatAvro})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// testAvroSchema è lo schema dei file Avro dei test: un record con tipi
+// primitivi, una union, un array e un enum.
+const testAvroSchema = `{"type":"record","name":"Evento","namespace":"shop","fields":[` +
+	`{"name":"id","type":"long"},` +
+	`{"name":"nome","type":"string"},` +
+	`{"name":"prezzo","type":["null","double"]},` +
+	`{"name":"tag","type":{"type":"array","items":"string"}},` +
+	`{"name":"stato","type":{"type":"enum","name":"Stato","symbols":["NUOVO","CHIUSO"]}}]}`
+
+// testAvroSync è il marker di sincronizzazione dei file dei test.
+var testAvroSync = []byte("0123456789abcdef")
+
+// avroEvent codifica un record di testAvroSchema con l'id indicato e ne
+// restituisce anche la forma JSON attesa.
+func avroEvent(id int) (datum []byte, doc string) {
+	nome := fmt.Sprintf("evento %d", id)
+	datum = binary.AppendVarint(datum, int64(id))
+	datum = binary.AppendVarint(datum, int64(len(nome)))
+	datum = append(datum, nome...)
+	prezzo := "null"
+	if id%2 == 0 {
+		datum = binary.AppendVarint(datum, 0)
+	} else {
+		datum = binary.AppendVarint(datum, 1)
+		datum = binary.LittleEndian.AppendUint64(datum, math.Float64bits(float64(id)+0.5))
+		prezzo = fmt.Sprintf("%d.5", id)
+	}
+	tags := []string{"a", "b"}[:id%3%2+1]
+	datum = binary.AppendVarint(datum, int64(len(tags)))
+	for _, tag := range tags {
+		datum = binary.AppendVarint(datum, int64(len(tag)))
+		datum = append(datum, tag...)
+	}
+	datum = binary.AppendVarint(datum, 0)
+	datum = binary.AppendVarint(datum, int64(id%2))
+	doc = fmt.Sprintf(`{"id":%d,"nome":%q,"prezzo":%s,"tag":["%s"],"stato":"%s"}`,
+		id, nome, prezzo, strings.Join(tags, `","`), []string{"NUOVO", "CHIUSO"}[id%2])
+	return datum, doc
+}
+
+// avroContainer compone un object container file con un blocco per
+// elemento di blocks, compresso con codec.
+func avroContainer(t *testing.T, codec string, blocks [][][]byte) []byte {
+	t.Helper()
+	out := append([]byte(nil), avroMagic...)
+	out = binary.AppendVarint(out, 2)
+	for _, s := range []string{"avro.schema", testAvroSchema, "avro.codec", codec} {
+		out = binary.AppendVarint(out, int64(len(s)))
+		out = append(out, s...)
+	}
+	out = binary.AppendVarint(out, 0)
+	out = append(out, testAvroSync...)
+	for _, block := range blocks {
+		data := bytes.Join(block, nil)
+		switch codec {
+		case "deflate":
+			var b bytes.Buffer
+			w, _ := flate.NewWriter(&b, flate.BestSpeed)
+			w.Write(data)
+			w.Close()
+			data = b.Bytes()
+		case "snappy":
+			data = binary.BigEndian.AppendUint32(snappy.Encode(nil, data), crc32.ChecksumIEEE(data))
+		case "zstandard":
+			enc, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = enc.EncodeAll(data, nil)
+			enc.Close()
+		}
+		out = binary.AppendVarint(out, int64(len(block)))
+		out = binary.AppendVarint(out, int64(len(data)))
+		out = append(out, data...)
+		out = append(out, testAvroSync...)
+	}
+	return out
+}
+
+// readAvroContainer legge un file scritto con --avro-output avro e ne
+// restituisce lo schema e i blocchi decodificati, concatenati.
+func readAvroContainer(t *testing.T, path string) (schema string, data []byte) {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(bytes.NewReader(raw))
+	head := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(r, head); err != nil || !bytes.Equal(head, avroMagic) {
+		t.Fatalf("intestazione Obj mancante: %q", head)
+	}
+	readBytes := func() []byte {
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	meta := map[string]string{}
+	for {
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		for ; n > 0; n-- {
+			k := readBytes()
+			meta[string(k)] = string(readBytes())
+		}
+	}
+	if meta["avro.codec"] != "null" {
+		t.Fatalf("codec dell'output %q, atteso null", meta["avro.codec"])
+	}
+	sync := make([]byte, 16)
+	if _, err := io.ReadFull(r, sync); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := binary.ReadVarint(r); err == io.EOF {
+			return meta["avro.schema"], data
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, readBytes()...)
+		marker := make([]byte, 16)
+		if _, err := io.ReadFull(r, marker); err != nil || !bytes.Equal(marker, sync) {
+			t.Fatalf("marker di sincronizzazione errato: %q", marker)
+		}
+	}
+}
+
+// TestAvroRoundTrip scrive file Avro con ogni codec, li ordina per id e
+// verifica sia l'output JSON Lines sia l'output Avro, che deve contenere
+// i datum originali nell'ordine delle chiavi e lo schema dell'input.
+func TestAvroRoundTrip(t *testing.T) {
+	const n = 500
+	var datums [][]byte
+	docs := make([]string, n)
+	raw := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		id := (i * 37) % n
+		datum, doc := avroEvent(id)
+		datums = append(datums, datum)
+		docs[id], raw[id] = doc, datum
+	}
+	// Tre blocchi di dimensioni diverse.
+	blocks := [][][]byte{datums[:1], datums[1:200], datums[200:]}
+	for _, codec := range []string{"null", "deflate", "snappy", "zstandard"} {
+		t.Run(codec, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "eventi.avro")
+			if err := os.WriteFile(input, avroContainer(t, codec, blocks), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mustRunSorter(t, dir, "--avro", "--avro-output", "jsonl", "--json-key", "id", "--input", input, "--output", "out.jsonl")
+			equalLines(t, "jsonl", readLines(t, filepath.Join(dir, "out.jsonl")), docs)
+
+			mustRunSorter(t, dir, "--avro", "--json-key", "id:desc", "--chunk-bytes", "20000", "--input", input, "--output", "out.avro")
+			schema, data := readAvroContainer(t, filepath.Join(dir, "out.avro"))
+			if schema != testAvroSchema {
+				t.Errorf("schema dell'output diverso dall'input: %s", schema)
+			}
+			var want []byte
+			for id := n - 1; id >= 0; id-- {
+				want = append(want, raw[id]...)
+			}
+			if !bytes.Equal(data, want) {
+				t.Fatalf("datum dell'output diversi da quelli attesi (%d byte, attesi %d)", len(data), len(want))
+			}
+
+			// L'output Avro si rilegge come input.
+			mustRunSorter(t, dir, "--avro", "--avro-output", "jsonl", "--json-key", "id", "--input", "out.avro", "--output", "again.jsonl")
+			equalLines(t, "riletto", readLines(t, filepath.Join(dir, "again.jsonl")), docs)
+		})
+	}
+}
+
+// TestAvroCorrupt verifica che file troncati o corrotti facciano fallire
+// l'esecuzione con un errore che indica il problema, invece di produrre
+// un output parziale.
+func TestAvroCorrupt(t *testing.T) {
+	var datums [][]byte
+	for id := 0; id < 50; id++ {
+		datum, _ := avroEvent(id)
+		datums = append(datums, datum)
+	}
+	valid := avroContainer(t, "null", [][][]byte{datums[:25], datums[25:]})
+	headerLen := bytes.Index(valid, testAvroSync) + len(testAvroSync)
+	deflated := avroContainer(t, "deflate", [][][]byte{datums})
+	snappied := avroContainer(t, "snappy", [][][]byte{datums})
+	// Un blocco che dichiara più record di quelli che contiene.
+	short := avroContainer(t, "null", [][][]byte{datums[:3]})
+	short[headerLen] = byte(binary.AppendVarint(nil, 4)[0])
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"senza magic", valid[4:], "manca l'intestazione Obj"},
+		{"metadati troncati", valid[:20], "metadati troncati"},
+		{"sync dell'intestazione troncato", valid[:headerLen-3], "marker di sincronizzazione troncato"},
+		{"blocco troncato", valid[:len(valid)-200], "blocco troncato"},
+		{"sync del blocco errato", append(valid[:len(valid)-1:len(valid)-1], 'x'), "marker di sincronizzazione errato"},
+		{"dimensione negativa", append(append(valid[:headerLen:headerLen], binary.AppendVarint(binary.AppendVarint(nil, 1), -5)...), testAvroSync...), "intestazione di blocco errata"},
+		{"deflate corrotto", corruptBlock(deflated), "blocco Avro deflate non valido"},
+		{"CRC snappy errato", corruptLastBlockByte(snappied), "CRC errato"},
+		{"record oltre il blocco", short, "datum troncato"},
+		{"codec sconosciuto", bytes.Replace(valid, []byte("\x08null"), []byte("\x08lzma"), 1), `codec Avro "lzma" non supportato`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "eventi.avro")
+			if err := os.WriteFile(input, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, output := range []string{"avro", "jsonl"} {
+				out, err := runSorter(t, dir, "--avro", "--avro-output", output, "--json-key", "id", "--input", input, "--output", "out")
+				if err == nil {
+					t.Fatalf("--avro-output %s: nessun errore\n%s", output, out)
+				}
+				if !strings.Contains(out, tt.want) {
+					t.Errorf("--avro-output %s: l'errore non contiene %q:\n%s", output, tt.want, out)
+				}
+			}
+		})
+	}
+}
+
+// corruptBlock altera i primi byte del contenuto del primo blocco di data.
+func corruptBlock(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	headerLen := bytes.Index(data, testAvroSync) + len(testAvroSync)
+	_, n1 := binary.Varint(data[headerLen:])
+	_, n2 := binary.Varint(data[headerLen+n1:])
+	start := headerLen + n1 + n2
+	for i := start; i < start+4; i++ {
+		data[i] = 0xff
+	}
+	return data
+}
+
+// corruptLastBlockByte altera il CRC in coda all'ultimo blocco snappy.
+func corruptLastBlockByte(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	data[len(data)-len(testAvroSync)-1] ^= 0xff
+	return data
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// binarySchemaName è lo schema dei record binari a lunghezza fissa,
+// selezionato da --record-size.
+const binarySchemaName = "binary"
+
+// framedSchemaName è lo schema dei record preceduti dalla loro lunghezza,
+// selezionato da --record-framing.
+const framedSchemaName = "framed"
+
+// Valori di --record-framing: come è codificata la lunghezza di ogni record.
+const (
+	framingUint32BE = "uint32be" // 4 byte big-endian
+	framingUint32LE = "uint32le" // 4 byte little-endian
+	framingVarint   = "varint"   // varint senza segno, come i messaggi protobuf delimitati
+)
+
+// maxFrameBytes è la lunghezza massima di un record con --record-framing:
+// una lunghezza maggiore indica quasi sempre un input disallineato.
+const maxFrameBytes = 1 << 30
+
+// maxFrameToken è il token più lungo letto da scanFramed: lunghezza e contenuto.
+const maxFrameToken = maxFrameBytes + binary.MaxVarintLen64
+
+// binaryRecords indica se i record sono binari, senza separatori: i chunk li
+// contengono uno dopo l'altro e l'output non aggiunge terminatori.
+func binaryRecords() bool {
+	return opts.recordSize > 0 || opts.framing != ""
+}
+
+// parseRecordFraming verifica il valore di --record-framing.
+func parseRecordFraming(v string) error {
+	switch v {
+	case "", framingUint32BE, framingUint32LE, framingVarint:
+		return nil
+	}
+	return fmt.Errorf("--record-framing non valido: %q (attesi uint32be, uint32le o varint)", v)
+}
+
+// configureBinary verifica le opzioni di --record-size. I record binari non
+// hanno campi: la chiave è il record intero o l'intervallo di --key-bytes,
+// confrontato byte per byte.
+func configureBinary() error {
+	if opts.schemaDef.Name != binarySchemaName {
+		return nil
+	}
+	if opts.recordSize <= 0 {
+		return fmt.Errorf("--schema %s richiede --record-size", binarySchemaName)
+	}
+	if len(opts.keySpecs) > 0 {
+		return fmt.Errorf("--record-size non è combinabile con --key: la chiave si indica con --key-bytes")
+	}
+	if opts.keyLen > 0 && opts.keyOffset+opts.keyLen > opts.recordSize {
+		return fmt.Errorf("--key-bytes %s oltre la fine dei record di %d byte", opts.keyBytes, opts.recordSize)
+	}
+	return nil
+}
+
+// readFixed legge il prossimo record di --record-size byte. Un record
+// incompleto alla fine dell'input è un errore: i record successivi sarebbero
+// tutti disallineati.
+func (rr *recordReader) readFixed() ([]byte, error) {
+	if cap(rr.buf) < opts.recordSize {
+		rr.buf = make([]byte, opts.recordSize)
+	}
+	rr.buf = rr.buf[:opts.recordSize]
+	n, err := io.ReadFull(rr.r, rr.buf)
+	rr.offset += int64(n)
+	switch err {
+	case nil:
+		return rr.buf, nil
+	case io.ErrUnexpectedEOF:
+		return nil, fmt.Errorf("record incompleto di %d byte alla fine dell'input (--record-size %d)", n, opts.recordSize)
+	}
+	return nil, err
+}
+
+// binaryRecord accetta i record letti da readFixed così come sono.
+func binaryRecord(line []byte) (string, bool) {
+	return string(line), len(line) == opts.recordSize
+}
+
+// scanFixed è la funzione di split dei chunk di record binari: ogni token è
+// un record di --record-size byte, senza separatori.
+func scanFixed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) >= opts.recordSize {
+		return opts.recordSize, data[:opts.recordSize], nil
+	}
+	if atEOF && len(data) > 0 {
+		return 0, nil, fmt.Errorf("record incompleto di %d byte (--record-size %d)", len(data), opts.recordSize)
+	}
+	return 0, nil, nil
+}
+
+// readFramed legge il prossimo record di --record-framing e ne restituisce
+// il contenuto, senza la lunghezza. Un input che finisce a metà di un record
+// è un errore.
+func (rr *recordReader) readFramed() ([]byte, error) {
+	var n uint64
+	switch opts.framing {
+	case framingVarint:
+		v, err := binary.ReadUvarint(rr.r)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lunghezza del record all'offset %d non valida: %w", rr.offset, err)
+		}
+		n = v
+		rr.offset += int64(len(binary.AppendUvarint(nil, v)))
+	default:
+		var hdr [4]byte
+		k, err := io.ReadFull(rr.r, hdr[:])
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lunghezza del record all'offset %d incompleta (%d byte)", rr.offset, k)
+		}
+		rr.offset += 4
+		n = uint64(frameOrder().Uint32(hdr[:]))
+	}
+	if n > maxFrameBytes || opts.maxRecordBytes > 0 && n > uint64(opts.maxRecordBytes) {
+		return nil, fmt.Errorf("record all'offset %d di %d byte: lunghezza oltre il limite, input disallineato?", rr.offset, n)
+	}
+	if uint64(cap(rr.buf)) < n {
+		rr.buf = make([]byte, n)
+	}
+	rr.buf = rr.buf[:n]
+	k, err := io.ReadFull(rr.r, rr.buf)
+	rr.offset += int64(k)
+	if err != nil {
+		return nil, fmt.Errorf("record incompleto alla fine dell'input: %d byte su %d", k, n)
+	}
+	return rr.buf, nil
+}
+
+// frameOrder restituisce l'ordine dei byte delle lunghezze a 4 byte.
+func frameOrder() binary.ByteOrder {
+	if opts.framing == framingUint32LE {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// appendFrameHeader aggiunge a dst la lunghezza n codificata secondo --record-framing.
+func appendFrameHeader(dst []byte, n int) []byte {
+	if opts.framing == framingVarint {
+		return binary.AppendUvarint(dst, uint64(n))
+	}
+	var b [4]byte
+	frameOrder().PutUint32(b[:], uint32(n))
+	return append(dst, b[:]...)
+}
+
+// framedRecord accetta il contenuto di ogni record letto da readFramed,
+// anche vuoto; nil indica la fine dell'input.
+func framedRecord(line []byte) (string, bool) {
+	return string(line), line != nil
+}
+
+// scanFramed è la funzione di split dei chunk di record con
+// --record-framing: ogni token è il contenuto di un record.
+func scanFramed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	var n uint64
+	hdr := 4
+	if opts.framing == framingVarint {
+		n, hdr = binary.Uvarint(data)
+		if hdr < 0 {
+			return 0, nil, fmt.Errorf("lunghezza del record non valida")
+		}
+	} else if len(data) >= 4 {
+		n = uint64(frameOrder().Uint32(data))
+	} else {
+		hdr = 0
+	}
+	if hdr > 0 && uint64(len(data)-hdr) >= n {
+		return hdr + int(n), data[hdr : hdr+int(n)], nil
+	}
+	if atEOF && len(data) > 0 {
+		return 0, nil, fmt.Errorf("record incompleto di %d byte", len(data))
+	}
+	return 0, nil, nil
+}
+
+// writeRecord scrive un record nei chunk: con --record-framing preceduto dalla
+// lunghezza, con i record a lunghezza fissa così com'è, con --chunk-format
+// binary preceduto dalla lunghezza in varint, altrimenti seguito da
+// recordSep. Restituisce i byte scritti.
+func writeRecord(w *bufio.Writer, s string) int {
+	n := len(s)
+	var hdr [binary.MaxVarintLen64]byte
+	switch {
+	case opts.framing != "":
+		h := appendFrameHeader(hdr[:0], len(s))
+		w.Write(h)
+		n += len(h)
+	case binaryChunks():
+		h := appendChunkHeader(hdr[:0], len(s))
+		w.Write(h)
+		w.WriteString(s)
+		return n + len(h)
+	}
+	w.WriteString(s)
+	if !binaryRecords() {
+		w.WriteByte(recordSep)
+		n++
+	}
+	return n
+}
+
+// writeOutput scrive un record come va scritto in output: con
+// --record-framing preceduto dalla lunghezza, altrimenti seguito da lineEnd.
+// Record e terminatore sono scritti separatamente, senza concatenarli in una
+// nuova stringa per ogni riga.
+func writeOutput(w *bufio.Writer, s string) error {
+	if opts.framing != "" {
+		var hdr [binary.MaxVarintLen64]byte
+		w.Write(appendFrameHeader(hdr[:0], len(s)))
+		_, err := w.WriteString(s)
+		return err
+	}
+	w.WriteString(s)
+	_, err := w.WriteString(lineEnd)
+	return err
+}
+
+func init() {
+	// Record binari di --record-size byte, senza delimitatori.
+	schema.Register(schema.Schema{Name: binarySchemaName, Parse: binaryRecord})
+	// Record preceduti dalla lunghezza (--record-framing).
+	schema.Register(schema.Schema{Name: framedSchemaName, Parse: framedRecord})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Valori di --bom.
+const (
+	bomStrip = "strip" // il BOM viene tolto dall'input e l'output è UTF-8 senza BOM
+	bomKeep  = "keep"  // l'output riprende il BOM e la codifica del primo input
+)
+
+// byteOrderMark è un BOM riconosciuto all'inizio di un input.
+type byteOrderMark struct {
+	name string
+	mark []byte
+	le   bool // UTF-16 little-endian
+}
+
+// byteOrderMarks sono i BOM riconosciuti. Gli input UTF-16 vengono convertiti
+// in UTF-8 prima della lettura dei record: i confronti avvengono sempre su UTF-8.
+var byteOrderMarks = []byteOrderMark{
+	{name: "UTF-8", mark: []byte{0xef, 0xbb, 0xbf}},
+	{name: "UTF-16LE", mark: []byte{0xff, 0xfe}, le: true},
+	{name: "UTF-16BE", mark: []byte{0xfe, 0xff}},
+}
+
+// inputBOM è il BOM del primo input letto (nil se non ne ha uno), riscritto
+// in testa all'output con --bom keep.
+var inputBOM struct {
+	bom     *byteOrderMark
+	checked bool
+}
+
+// parseBOM verifica il valore di --bom.
+func parseBOM(v string) error {
+	if v == bomStrip || v == bomKeep {
+		return nil
+	}
+	return fmt.Errorf("--bom non valido: %q (attesi strip o keep)", v)
+}
+
+// detectBOM restituisce il BOM all'inizio di head, o nil.
+func detectBOM(head []byte) *byteOrderMark {
+	for i := range byteOrderMarks {
+		if bytes.HasPrefix(head, byteOrderMarks[i].mark) {
+			return &byteOrderMarks[i]
+		}
+	}
+	return nil
+}
+
+// stripBOM toglie l'eventuale BOM all'inizio di r, così non finisce nella
+// chiave del primo record, e converte in UTF-8 gli input UTF-16. Il BOM del
+// primo input viene ricordato per --bom keep. Senza BOM l'input viene letto
+// nella codifica di --input-encoding; il BOM, se c'è, ha la precedenza. I
+// record binari restano invariati.
+func stripBOM(r io.Reader) io.Reader {
+	if binaryRecords() {
+		return r
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(3)
+	bom := detectBOM(head)
+	if !inputBOM.checked {
+		inputBOM.bom, inputBOM.checked = bom, true
+	}
+	if bom == nil {
+		return transcodeInput(br)
+	}
+	br.Discard(len(bom.mark))
+	if bom.name == "UTF-8" {
+		return br
+	}
+	return utf16Encoding(bom).NewDecoder().Reader(br)
+}
+
+// utf16Encoding restituisce la codifica UTF-16 del BOM. Il BOM è già stato
+// tolto (o viene scritto a parte), quindi la codifica non lo gestisce.
+func utf16Encoding(bom *byteOrderMark) encoding.Encoding {
+	order := unicode.BigEndian
+	if bom.le {
+		order = unicode.LittleEndian
+	}
+	return unicode.UTF16(order, unicode.IgnoreBOM)
+}
+
+// outputBOM scrive su w il BOM del primo input, con --bom keep, e restituisce
+// il writer su cui scrivere l'output: per gli input UTF-16 converte di nuovo
+// l'UTF-8 dei record nella codifica originale.
+func outputBOM(w io.Writer) (io.Writer, error) {
+	if opts.bom != bomKeep || inputBOM.bom == nil {
+		return w, nil
+	}
+	if _, err := w.Write(inputBOM.bom.mark); err != nil {
+		return nil, err
+	}
+	if inputBOM.bom.name == "UTF-8" {
+		return w, nil
+	}
+	return utf16Encoding(inputBOM.bom).NewEncoder().Writer(w), nil
+}
+
+// hasBOM indica se il file inizia con un BOM. Serve alle modalità che
+// leggono l'input per intervalli di byte, come --coop.
+func hasBOM(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 3)
+	n, _ := io.ReadFull(f, head)
+	return detectBOM(head[:n]) != nil
+}
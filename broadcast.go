@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Dimensioni del fan-out dello stream ordinato verso i consumer registrati.
+const (
+	broadcastBatchLines = 4096 // righe per batch inviato ai consumer
+	broadcastQueueLen   = 64   // batch in coda per ciascun consumer prima della backpressure
+)
+
+// stringList è un flag ripetibile che accumula tutti i valori ricevuti.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// streamConsumer riceve, in ordine, le righe prodotte dal merge.
+type streamConsumer interface {
+	name() string
+	consume(lines []string) error
+	close() error
+}
+
+// subscriber è un consumer registrato con la propria coda: ogni consumer
+// procede al suo ritmo e un consumer lento non rallenta gli altri finché
+// la sua coda non è piena.
+type subscriber struct {
+	c       streamConsumer
+	ch      chan []string
+	drop    bool  // con la coda piena scarta i batch invece di bloccare il merge
+	dropped int64 // righe scartate per backpressure
+	err     error
+	done    chan struct{}
+}
+
+// broadcaster distribuisce lo stream ordinato a più consumer indipendenti.
+type broadcaster struct {
+	subs  []*subscriber
+	batch []string
+}
+
+// register aggiunge un consumer e avvia la goroutine che lo alimenta.
+func (b *broadcaster) register(c streamConsumer, drop bool) {
+	s := &subscriber{c: c, ch: make(chan []string, broadcastQueueLen), drop: drop, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for lines := range s.ch {
+			if s.err != nil {
+				continue // consumer fallito: svuota la coda senza bloccare il merge
+			}
+			s.err = c.consume(lines)
+		}
+		if err := c.close(); s.err == nil {
+			s.err = err
+		}
+	}()
+	b.subs = append(b.subs, s)
+}
+
+// send accoda una riga; i batch completi vengono inviati a tutti i consumer.
+func (b *broadcaster) send(line string) {
+	b.batch = append(b.batch, line)
+	if len(b.batch) >= broadcastBatchLines {
+		b.flush()
+	}
+}
+
+// flush invia il batch corrente. Il batch è condiviso in sola lettura tra
+// i consumer, per questo ne viene allocato uno nuovo a ogni invio.
+func (b *broadcaster) flush() {
+	if len(b.batch) == 0 {
+		return
+	}
+	for _, s := range b.subs {
+		if !s.drop {
+			s.ch <- b.batch
+			continue
+		}
+		select {
+		case s.ch <- b.batch:
+		default:
+			s.dropped += int64(len(b.batch))
+		}
+	}
+	b.batch = make([]string, 0, broadcastBatchLines)
+}
+
+// close invia le righe residue, attende che tutti i consumer abbiano finito
+// e restituisce il primo errore riscontrato.
+func (b *broadcaster) close() error {
+	b.flush()
+	for _, s := range b.subs {
+		close(s.ch)
+	}
+	var first error
+	for _, s := range b.subs {
+		<-s.done
+		if s.dropped > 0 {
+			fmt.Printf("⚠️  consumer %s: %d righe scartate per backpressure\n", s.c.name(), s.dropped)
+		}
+		if s.err != nil && first == nil {
+			first = fmt.Errorf("consumer %s: %w", s.c.name(), s.err)
+		}
+	}
+	return first
+}
+
+// newBroadcaster crea i consumer descritti dalle specifiche di --tee.
+// Formato: tipo:destinazione[,drop], con tipo file, tcp o stats.
+// Restituisce nil se non è richiesto alcun consumer.
+func newBroadcaster(specs []string) (*broadcaster, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	b := &broadcaster{batch: make([]string, 0, broadcastBatchLines)}
+	for _, spec := range specs {
+		c, drop, err := parseConsumer(spec)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+		b.register(c, drop)
+	}
+	return b, nil
+}
+
+// parseConsumer interpreta una specifica di --tee.
+func parseConsumer(spec string) (streamConsumer, bool, error) {
+	drop := strings.HasSuffix(spec, ",drop")
+	spec = strings.TrimSuffix(strings.TrimSuffix(spec, ",drop"), ",block")
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "file":
+		f, err := os.Create(target)
+		if err != nil {
+			return nil, false, err
+		}
+		return &writerConsumer{label: spec, c: f, w: bufio.NewWriterSize(f, writerBufferSize)}, drop, nil
+	case "tcp":
+		conn, err := net.Dial("tcp", target)
+		if err != nil {
+			return nil, false, err
+		}
+		return &writerConsumer{label: spec, c: conn, w: bufio.NewWriterSize(conn, writerBufferSize)}, drop, nil
+	case "stats":
+		return &statsConsumer{}, drop, nil
+	}
+	return nil, false, fmt.Errorf("consumer --tee non valido: %q (attesi file:PATH, tcp:HOST:PORT o stats)", spec)
+}
+
+// writerConsumer scrive lo stream su un file o su una connessione di rete.
+type writerConsumer struct {
+	label string
+	c     io.Closer
+	w     *bufio.Writer
+}
+
+func (wc *writerConsumer) name() string { return wc.label }
+
+func (wc *writerConsumer) consume(lines []string) error {
+	for _, l := range lines {
+		if err := writeOutput(wc.w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wc *writerConsumer) close() error {
+	err := wc.w.Flush()
+	if cerr := wc.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// statsConsumer aggrega lo stream e stampa un riepilogo alla chiusura.
+type statsConsumer struct {
+	lines    int64
+	bytes    int64
+	distinct int64
+	last     string
+}
+
+func (sc *statsConsumer) name() string { return "stats" }
+
+func (sc *statsConsumer) consume(lines []string) error {
+	for _, l := range lines {
+		if sc.lines == 0 || l != sc.last {
+			sc.distinct++
+			sc.last = l
+		}
+		sc.lines++
+		sc.bytes += int64(len(l)) + 1
+	}
+	return nil
+}
+
+func (sc *statsConsumer) close() error {
+	fmt.Printf("📊 stats: %d righe, %d distinte, %d byte\n", sc.lines, sc.distinct, sc.bytes)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Pool dei buffer dello split. Ogni chunk passa dallo split a un worker e
+// poi su disco: senza riciclo ogni chunk allocherebbe una nuova slice di
+// righe, le chiavi per l'ordinamento e un writer, tutti della stessa taglia
+// di quelli del chunk precedente.
+var (
+	linesPool       sync.Pool // *[]string: righe in attesa di formare un chunk
+	keyedPool       sync.Pool // *[]keyedLine: chiavi di sortLines
+	chunkWriterPool sync.Pool // *bufio.Writer: scrittura dei chunk su disco
+)
+
+// getLines restituisce una slice di righe vuota, riciclata se disponibile,
+// altrimenti nuova con capacità hint (di solito le righe del chunk precedente).
+func getLines(hint int) []string {
+	if v, ok := linesPool.Get().(*[]string); ok {
+		return (*v)[:0]
+	}
+	return make([]string, 0, hint)
+}
+
+// putLines rimette nel pool una slice di righe non più usata. Le stringhe
+// vengono azzerate perché il pool non trattenga le righe del chunk.
+func putLines(lines []string) {
+	if cap(lines) == 0 {
+		return
+	}
+	clear(lines)
+	lines = lines[:0]
+	linesPool.Put(&lines)
+}
+
+// getKeyed restituisce una slice di n chiavi, riciclata se abbastanza grande.
+func getKeyed(n int) []keyedLine {
+	if v, ok := keyedPool.Get().(*[]keyedLine); ok && cap(*v) >= n {
+		return (*v)[:n]
+	}
+	return make([]keyedLine, n)
+}
+
+// putKeyed rimette nel pool una slice di chiavi non più usata.
+func putKeyed(keys []keyedLine) {
+	clear(keys)
+	keys = keys[:0]
+	keyedPool.Put(&keys)
+}
+
+// getChunkWriter restituisce un writer bufferizzato su w.
+func getChunkWriter(w io.Writer) *bufio.Writer {
+	if b, ok := chunkWriterPool.Get().(*bufio.Writer); ok {
+		b.Reset(w)
+		return b
+	}
+	return bufio.NewWriter(w)
+}
+
+// putChunkWriter rimette nel pool un writer già svuotato con Flush.
+func putChunkWriter(b *bufio.Writer) {
+	b.Reset(nil)
+	chunkWriterPool.Put(b)
+}
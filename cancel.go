@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Motivi di annullamento registrati nel rapporto.
+const (
+	cancelSignal  = "signal"  // SIGINT o SIGTERM
+	cancelTimeout = "timeout" // superato --timeout
+)
+
+// cancelFile è il nome del rapporto di annullamento nella directory dei chunk.
+const cancelFile = "cancel.json"
+
+// cancelReport descrive un'esecuzione annullata: il motivo strutturato e il
+// punto raggiunto, così chi la rilancia in automatico può decidere se
+// riprendere o ripartire da capo.
+type cancelReport struct {
+	Reason     string         `json:"reason"` // cancelSignal o cancelTimeout
+	Detail     string         `json:"detail"` // segnale ricevuto o durata del timeout
+	Time       time.Time      `json:"time"`
+	PID        int            `json:"pid"`
+	Phase      string         `json:"phase"`            // fase in corso (split, merge...)
+	Bytes      int64          `json:"bytes"`            // byte elaborati fino all'annullamento
+	Written    int64          `json:"written"`          // byte scritti su disco
+	Merges     [][]chunkState `json:"merges,omitempty"` // posizione nei chunk dei merge in corso
+	ResumeSafe bool           `json:"resume_safe"`      // il lavoro fatto può essere ripreso
+	Resume     string         `json:"resume"`           // come proseguire
+}
+
+// watchCancel intercetta SIGINT e SIGTERM e, se timeout > 0, annulla
+// l'esecuzione allo scadere. In entrambi i casi scrive il rapporto in dir,
+// lo riassume su stderr ed esce con il codice convenzionale: 128 più il
+// numero del segnale, oppure 124 come timeout(1).
+func watchCancel(timeout time.Duration, dir string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	go func() {
+		select {
+		case sig := <-sigs:
+			code := 130
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			cancelRun(cancelSignal, sig.String(), dir, code)
+		case <-expired:
+			cancelRun(cancelTimeout, timeout.String(), dir, 124)
+		}
+	}()
+}
+
+// cancelRun registra l'annullamento ed esce con code.
+func cancelRun(reason, detail, dir string, code int) {
+	progress.mu.Lock()
+	phase := progress.phase
+	progress.mu.Unlock()
+	r := cancelReport{
+		Reason:  reason,
+		Detail:  detail,
+		Time:    time.Now().UTC(),
+		PID:     os.Getpid(),
+		Phase:   phase,
+		Bytes:   atomic.LoadInt64(&progress.bytes),
+		Written: atomic.LoadInt64(&diskPlan.written),
+		Merges:  mergeStates(),
+		Resume:  "rieseguire da capo: i chunk parziali non sono riutilizzabili",
+	}
+	// In modalità cooperativa i task completati sono registrati nel manifest
+	// e quelli di un processo terminato vengono riassegnati.
+	if opts.coop != "" {
+		r.ResumeSafe = true
+		r.Resume = "rilanciare con lo stesso --coop: i task completati restano validi"
+	}
+	// Con --resume uno split completato resta su disco e si riprende dal merge.
+	if resumable() {
+		r.ResumeSafe = true
+		r.Resume = "rilanciare con --resume e gli stessi input e opzioni: lo split completato viene ripreso"
+	}
+
+	path, err := writeCancelReport(dir, r)
+	if err != nil {
+		path = "non scritto: " + err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  Esecuzione annullata (%s: %s) nella fase %s dopo %s elaborati. Rapporto: %s\n",
+		reason, detail, phase, formatBytes(r.Bytes), path)
+	exitRun(code)
+}
+
+// writeCancelReport scrive il rapporto in dir/cancel.json e ne restituisce il percorso.
+func writeCancelReport(dir string, r cancelReport) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, cancelFile)
+	return path, os.WriteFile(path, append(data, '\n'), 0644)
+}
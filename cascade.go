@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cascadeDir è la directory dei run intermedi del merge a più livelli in
+// corso. I run sono già decompressi: newChunkMerger non li tratta come input
+// di --merge.
+var cascadeDir string
+
+// cascadeMerge riduce files a non più di --merge-fan-in file, così che il
+// merge finale non esaurisca i file descriptor né la memoria dei buffer di
+// lettura. Ogni livello fonde gruppi di file consecutivi in run intermedi
+// (l'ordine dei file resta quello di partenza, come richiede --stable) e
+// fonde solo quanti file servono per arrivare a --merge-fan-in: con poco
+// più di --merge-fan-in chunk viene riscritta solo una piccola parte dei
+// dati. I run stanno in una directory temporanea dentro --chunk-dir, che
+// cleanup rimuove.
+func cascadeMerge(files []string) (runs []string, cleanup func(), err error) {
+	fanIn := opts.mergeFanIn
+	if len(files) <= fanIn {
+		return files, func() {}, nil
+	}
+	if err := os.MkdirAll(opts.chunkDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.MkdirTemp(opts.chunkDir, "cascade-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cascadeDir = dir
+	trackTemp(dir)
+	cleanup = func() {
+		os.RemoveAll(dir)
+		cascadeDir = ""
+	}
+
+	n := 0
+	for level := 1; len(files) > fanIn; level++ {
+		before := len(files)
+		var next []string
+		excess, i := len(files)-fanIn, 0
+		for excess > 0 {
+			// Un gruppo di g file ne toglie g-1 dal merge successivo.
+			g := min(fanIn, excess+1, len(files)-i)
+			if g < 2 {
+				break
+			}
+			path := filepath.Join(dir, fmt.Sprintf("run_%05d.txt", n))
+			n++
+			if err := writeRun(files[i:i+g], path); err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			// I run di un livello precedente non servono più.
+			for _, f := range files[i : i+g] {
+				if filepath.Dir(f) == dir {
+					os.Remove(f)
+				}
+			}
+			next = append(next, path)
+			i += g
+			excess -= g - 1
+		}
+		files = append(next, files[i:]...)
+		fmt.Fprintf(status, "🔹 Merge intermedio (livello %d): %d file ridotti a %d\n", level, before, len(files))
+	}
+	return files, cleanup, nil
+}
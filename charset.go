@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// charsetUTF8 è il valore predefinito di --input-encoding: l'input viene
+// letto così com'è.
+const charsetUTF8 = "utf-8"
+
+// inputCharsets sono le codifiche accettate da --input-encoding, con i loro
+// nomi alternativi.
+var inputCharsets = map[string]encoding.Encoding{
+	"latin1":       charmap.ISO8859_1,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"latin9":       charmap.ISO8859_15,
+	"windows-1252": charmap.Windows1252,
+	"cp1252":       charmap.Windows1252,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+}
+
+// inputCharset è la codifica scelta con --input-encoding (nil per UTF-8).
+var inputCharset encoding.Encoding
+
+// parseInputEncoding verifica il valore di --input-encoding e imposta inputCharset.
+func parseInputEncoding(v string) error {
+	name := strings.ToLower(v)
+	if name == charsetUTF8 || name == "utf8" {
+		inputCharset = nil
+		return nil
+	}
+	enc, ok := inputCharsets[name]
+	if !ok {
+		return fmt.Errorf("--input-encoding non valido: %q (attesi utf-8, latin1, iso-8859-15, windows-1252, utf-16le o utf-16be)", v)
+	}
+	inputCharset = enc
+	return nil
+}
+
+// transcodeInput converte in UTF-8, durante la lettura, un input nella
+// codifica di --input-encoding.
+func transcodeInput(br *bufio.Reader) io.Reader {
+	if inputCharset == nil {
+		return br
+	}
+	return inputCharset.NewDecoder().Reader(br)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Valori di --chunk-compression.
+const (
+	chunkCompressNone   = "none"   // chunk scritti così come sono
+	chunkCompressSnappy = "snappy" // stream snappy: veloce, rapporto modesto
+	chunkCompressZstd   = "zstd"   // Zstandard al livello più veloce: rapporto migliore, più CPU
+)
+
+// parseChunkCompression verifica il valore di --chunk-compression.
+func parseChunkCompression(v string) error {
+	switch v {
+	case chunkCompressNone, chunkCompressSnappy, chunkCompressZstd:
+		return nil
+	}
+	return fmt.Errorf("--chunk-compression non valido: %q (attesi none, snappy o zstd)", v)
+}
+
+// compressChunk restituisce un writer che comprime su w i record di un chunk
+// o di un run intermedio secondo --chunk-compression, da chiudere dopo
+// l'ultima scrittura per completare lo stream (w resta aperto). Ogni worker
+// comprime il proprio chunk: gli encoder usano una sola goroutine.
+func compressChunk(w io.Writer) (io.WriteCloser, error) {
+	switch opts.chunkCompress {
+	case chunkCompressSnappy:
+		return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), nil
+	case chunkCompressZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// decompressChunk restituisce il contenuto di un chunk letto da r. Il
+// decoder zstd usa goroutine proprie: dec, se non nil, va chiuso insieme
+// al file.
+func decompressChunk(r io.Reader) (src io.Reader, dec io.Closer, err error) {
+	switch opts.chunkCompress {
+	case chunkCompressSnappy:
+		return s2.NewReader(r), nil, nil
+	case chunkCompressZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		rc := zr.IOReadCloser()
+		return rc, rc, nil
+	}
+	return r, nil, nil
+}
+
+// chunkCompressed indica se il file è un chunk o un run scritto dallo split
+// con --chunk-compression, e non un input di --merge.
+func chunkCompressed(file string) bool {
+	if opts.chunkCompress == chunkCompressNone {
+		return false
+	}
+	return !opts.merge || filepath.Dir(file) == cascadeDir
+}
+
+// openChunk apre un chunk e ne restituisce il contenuto decompresso; close
+// chiude il decoder e il file.
+func openChunk(path string) (r io.Reader, close func(), err error) {
+	f, err := openRetry(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rf := &retryReader{r: f, name: path}
+	if !chunkCompressed(path) {
+		return rf, func() { f.Close() }, nil
+	}
+	src, dec, err := decompressChunk(rf)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return src, func() {
+		if dec != nil {
+			dec.Close()
+		}
+		f.Close()
+	}, nil
+}
+
+// reportChunkCompression riporta quanto occupano su disco i chunk compressi
+// rispetto ai loro record.
+func reportChunkCompression(chunks []chunkInfo) {
+	if opts.chunkCompress == chunkCompressNone {
+		return
+	}
+	var raw, disk int64
+	for _, c := range chunks {
+		raw += c.Bytes
+		if fi, err := os.Stat(c.Path); err == nil {
+			disk += fi.Size()
+		}
+	}
+	if raw == 0 {
+		return
+	}
+	fmt.Fprintf(status, "💽 Chunk %s: %s su disco per %s di record (%.0f%%)\n",
+		opts.chunkCompress, formatBytes(disk), formatBytes(raw), float64(disk)*100/float64(raw))
+}
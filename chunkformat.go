@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+)
+
+// Valori di --chunk-format.
+const (
+	chunkFormatText   = "text"   // record seguiti dal separatore, come nell'output
+	chunkFormatBinary = "binary" // record preceduti dalla lunghezza in varint
+)
+
+// parseChunkFormat verifica il valore di --chunk-format.
+func parseChunkFormat(v string) error {
+	switch v {
+	case chunkFormatText, chunkFormatBinary:
+		return nil
+	}
+	return fmt.Errorf("--chunk-format non valido: %q (attesi text o binary)", v)
+}
+
+// maxChunkHeader è la lunghezza massima della lunghezza in testa a un record.
+const maxChunkHeader = binary.MaxVarintLen64
+
+// binaryChunks indica se i chunk e i run intermedi usano il formato binario.
+// I record di --record-size e --record-framing non hanno separatori da
+// cercare: i loro chunk restano nel formato dell'input.
+func binaryChunks() bool {
+	return opts.chunkFormat == chunkFormatBinary && !binaryRecords()
+}
+
+// binaryChunk indica se file va letto nel formato binario: i chunk e i run
+// scritti dallo split, non gli input di --merge.
+func binaryChunk(file string) bool {
+	return binaryChunks() && (!opts.merge || filepath.Dir(file) == cascadeDir)
+}
+
+// appendChunkHeader aggiunge a dst la lunghezza di un record di n byte nel
+// formato binario dei chunk.
+func appendChunkHeader(dst []byte, n int) []byte {
+	return binary.AppendUvarint(dst, uint64(n))
+}
+
+// scanChunkRecord è la funzione di split dei chunk in formato binario: la
+// lunghezza in testa a ogni record dice dove finisce, senza cercare il
+// separatore byte per byte. Sui chunk mappati ogni token è una sotto-slice
+// della regione mappata.
+func scanChunkRecord(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	n, hdr := binary.Uvarint(data)
+	if hdr < 0 {
+		return 0, nil, fmt.Errorf("lunghezza del record non valida nel chunk")
+	}
+	if hdr > 0 && uint64(len(data)-hdr) >= n {
+		return hdr + int(n), data[hdr : hdr+int(n)], nil
+	}
+	if atEOF && len(data) > 0 {
+		return 0, nil, fmt.Errorf("record incompleto di %d byte nel chunk", len(data))
+	}
+	return 0, nil, nil
+}
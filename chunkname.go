@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runID identifica l'esecuzione corrente: è l'ID della sessione nel registro
+// e compare nel nome dei chunk, così i chunk lasciati nella stessa
+// --chunk-dir da un'esecuzione interrotta non finiscono nel merge di questa.
+var runID = newRunID()
+
+// newRunID compone un ID di esecuzione: il millisecondo di avvio, il PID e
+// quattro byte casuali, in base 36 e separati da '-'. Il solo istante non
+// basta: processi avviati nello stesso millisecondo (xargs -P, GNU
+// parallel) scriverebbero chunk con lo stesso nome nella stessa directory;
+// i byte casuali distinguono anche processi con lo stesso PID in container
+// diversi.
+func newRunID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return strconv.FormatInt(time.Now().UnixMilli(), 36) + "-" +
+		strconv.FormatInt(int64(os.Getpid()), 36) + "-" +
+		strconv.FormatInt(int64(binary.BigEndian.Uint32(b[:])), 36)
+}
+
+// chunkName restituisce il nome del chunk numero id dell'esecuzione
+// corrente. Con sei cifre l'ordine alfabetico coincide con quello numerico
+// fino a un milione di chunk; chunkFiles ordina comunque per numero.
+func chunkName(id int) string {
+	return fmt.Sprintf("chunk_%s_%06d.txt", runID, id)
+}
+
+// chunkPattern è il glob dei chunk dell'esecuzione corrente in dir.
+func chunkPattern(dir string) string {
+	return filepath.Join(dir, "chunk_"+runID+"_*.txt")
+}
+
+// chunkID restituisce il numero di un file chunk_RUN_N.txt, o -1 se il nome
+// non ha questo formato.
+func chunkID(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), ".txt")
+	i := strings.LastIndexByte(name, '_')
+	if !strings.HasPrefix(name, "chunk_") || i < len("chunk_") {
+		return -1
+	}
+	id, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// Trattamento dei chunk di esecuzioni precedenti (--stale-chunks).
+const (
+	staleWarn   = "warn"   // segnalati e ignorati dal merge
+	staleRefuse = "refuse" // l'esecuzione non parte
+	staleClean  = "clean"  // rimossi prima dello split
+)
+
+// staleChunks restituisce i file lasciati in dir da altre esecuzioni:
+// chunk, chunk delle partizioni, directory dei run intermedi e, senza
+// --resume, il manifest di uno split conservato per la ripresa. Il merge
+// legge solo i chunk con il runID corrente, ma quelli vecchi occupano
+// spazio e, se l'esecuzione precedente era su un altro input, confondono
+// chi ispeziona la directory. I chunk ripresi da resumeChunks hanno già il
+// runID corrente.
+func staleChunks(dir string) []string {
+	var stale []string
+	for _, pattern := range []string{"chunk_*.txt", "part_*/chunk_*.txt", "cascade-*"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, m := range matches {
+			if !strings.HasPrefix(filepath.Base(m), "chunk_"+runID+"_") {
+				stale = append(stale, m)
+			}
+		}
+	}
+	if manifest := filepath.Join(dir, resumeName); !opts.resume {
+		if _, err := os.Stat(manifest); err == nil {
+			stale = append(stale, manifest)
+		}
+	}
+	return stale
+}
+
+// checkStaleChunks applica --stale-chunks ai file di altre esecuzioni
+// trovati nella directory dei chunk.
+func checkStaleChunks(dir string) error {
+	stale := staleChunks(dir)
+	if len(stale) == 0 {
+		return nil
+	}
+	var size int64
+	for _, p := range stale {
+		size += dirSize(p)
+	}
+	switch opts.staleChunks {
+	case staleRefuse:
+		return fmt.Errorf("%s contiene %d chunk di esecuzioni precedenti (%s): rimuovili o usa --stale-chunks clean", dir, len(stale), formatBytes(size))
+	case staleClean:
+		for _, p := range stale {
+			if err := os.RemoveAll(p); err != nil {
+				return fmt.Errorf("rimozione dei chunk di esecuzioni precedenti: %w", err)
+			}
+		}
+		fmt.Fprintf(status, "📝 Rimossi %d chunk di esecuzioni precedenti (%s) da %s\n", len(stale), formatBytes(size), dir)
+	default:
+		fmt.Fprintf(status, "⚠️  %s contiene %d chunk di esecuzioni precedenti (%s), ignorati dal merge; --stale-chunks clean li rimuove\n", dir, len(stale), formatBytes(size))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRunIDUnique verifica che due esecuzioni avviate nello stesso
+// millisecondo, anche con lo stesso PID, abbiano ID e nomi dei chunk
+// diversi, e che i chunk dell'una siano estranei per l'altra.
+func TestRunIDUnique(t *testing.T) {
+	a, b := newRunID(), newRunID()
+	if a == b {
+		t.Fatalf("due ID uguali: %s", a)
+	}
+	for _, id := range []string{a, b} {
+		parts := strings.Split(id, "-")
+		if len(parts) != 3 || strings.Contains(id, "_") {
+			t.Fatalf("ID %q: attesi istante, PID e suffisso casuale separati da '-'", id)
+		}
+		if pid := strconv.FormatInt(int64(os.Getpid()), 36); parts[1] != pid {
+			t.Errorf("ID %q: PID %q, atteso %q", id, parts[1], pid)
+		}
+	}
+
+	saved := runID
+	defer func() { runID = saved }()
+	dir := t.TempDir()
+	runID = a
+	mine := filepath.Join(dir, chunkName(7))
+	if chunkID(mine) != 7 {
+		t.Fatalf("chunkID(%s) = %d, atteso 7", mine, chunkID(mine))
+	}
+	runID = b
+	other := filepath.Join(dir, chunkName(7))
+	for _, p := range []string{mine, other} {
+		if err := os.WriteFile(p, []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runID = a
+	if got, _ := filepath.Glob(chunkPattern(dir)); len(got) != 1 || got[0] != mine {
+		t.Errorf("chunk dell'esecuzione: %v, atteso solo %s", got, mine)
+	}
+	if got := staleChunks(dir); len(got) != 1 || got[0] != other {
+		t.Errorf("chunk estranei: %v, atteso solo %s", got, other)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"sort"
+)
+
+// mergeSegment è un tratto dell'output finale: un gruppo di chunk i cui
+// intervalli di chiavi si sovrappongono, da fondere, oppure un singolo chunk
+// isolato da copiare così com'è.
+type mergeSegment struct {
+	files []string // file del gruppo, nell'ordine di creazione
+	copy  bool     // chunk isolato: i suoi byte sono già l'output
+	lines int64    // con copy: record del chunk
+}
+
+// chunksCopyable indica se i byte di un chunk coincidono con l'output delle
+// sue righe: nessuna formattazione dello schema, nessun passo sullo stream
+// e lo stesso terminatore di record in ingresso e in uscita.
+func chunksCopyable() bool {
+	if opts.schemaDef != nil && opts.schemaDef.Format != nil {
+		return false
+	}
+	return !binaryRecords() && !binaryChunks() && !avroOutput() && lineEnd == string(recordSep) &&
+		!opts.count && !opts.unique && len(opts.then) == 0 && len(opts.tees) == 0 && !opts.manifest
+}
+
+// keyBefore indica se ogni riga con la chiave di a precede ogni riga con la
+// chiave di b. A chiavi uguali l'ordine dipende dalle righe o dall'origine:
+// i due chunk vanno fusi.
+func keyBefore(a, b string) bool {
+	ka, kb := sortKey(a), sortKey(b)
+	if opts.reverse {
+		ka, kb = kb, ka
+	}
+	return ka < kb
+}
+
+// planSegments divide i chunk in segmenti usando la prima e l'ultima riga
+// registrate dallo split. I chunk, in ordine di prima chiave, formano un
+// gruppo finché si sovrappongono all'intervallo accumulato; un gruppo di un
+// solo chunk viene copiato con io.Copy senza passare dal torneo. Con dati
+// già quasi ordinati gran parte dell'input esce così. Senza informazioni sui
+// chunk (infos nil) resta un solo segmento con tutti i file.
+func planSegments(files []string, infos map[string]chunkInfo) []mergeSegment {
+	all := []mergeSegment{{files: files}}
+	if infos == nil || len(files) < 2 || !chunksCopyable() {
+		return all
+	}
+	order := make([]int, len(files))
+	for i, f := range files {
+		info, ok := infos[f]
+		if !ok || info.Lines == 0 {
+			return all
+		}
+		order[i] = i
+	}
+	first := func(i int) string { return infos[files[i]].First }
+	last := func(i int) string { return infos[files[i]].Last }
+	sort.SliceStable(order, func(a, b int) bool { return keyBefore(first(order[a]), first(order[b])) })
+
+	var segs []mergeSegment
+	var group []int
+	var groupLast string
+	emit := func() {
+		if len(group) == 1 {
+			info := infos[files[group[0]]]
+			segs = append(segs, mergeSegment{files: files[group[0] : group[0]+1], copy: true, lines: int64(info.Lines)})
+			return
+		}
+		// I file del gruppo restano nell'ordine di creazione, come richiede --stable.
+		sort.Ints(group)
+		seg := mergeSegment{}
+		for _, i := range group {
+			seg.files = append(seg.files, files[i])
+		}
+		segs = append(segs, seg)
+	}
+	for _, i := range order {
+		if len(group) > 0 && !keyBefore(groupLast, first(i)) {
+			group = append(group, i)
+			if keyBefore(groupLast, last(i)) {
+				groupLast = last(i)
+			}
+			continue
+		}
+		if len(group) > 0 {
+			emit()
+		}
+		group, groupLast = []int{i}, last(i)
+	}
+	emit()
+	return segs
+}
+
+// copyChunk copia in w il contenuto di un chunk isolato, decompresso se
+// scritto con --chunk-compression.
+func copyChunk(w io.Writer, path string) error {
+	r, closeChunk, err := openChunk(path)
+	if err != nil {
+		return err
+	}
+	defer closeChunk()
+	n, err := io.Copy(w, r)
+	advanceProgress(int(n))
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Limiti della dimensione automatica dei chunk.
+const (
+	minAutoChunk = 16 << 20 // sotto questa soglia i chunk diventano troppi per il merge
+	maxAutoChunk = 1 << 30  // oltre questa soglia l'ordinamento di un chunk non scala più
+	// chunkMemFactor stima la memoria occupata da un chunk rispetto ai suoi
+	// byte: ogni riga ha anche l'header della stringa nella slice e, durante
+	// l'ordinamento, la sua keyedLine. Con righe da 32 byte è circa 3.
+	chunkMemFactor = 3
+	// splitQueue è la capacità della coda dei chunk inviati ai worker.
+	splitQueue = 8
+	// writeQueue è la capacità della coda dei chunk ordinati in attesa di
+	// un worker di scrittura.
+	writeQueue = 2
+)
+
+// configureChunkSize dimensiona i chunk quando --chunk-bytes è 0, in base a
+// --mem-budget o alla memoria disponibile: metà va ai chunk che possono
+// essere vivi insieme durante lo split (quello in formazione, quelli nelle
+// due code e quelli dei worker di ordinamento e di scrittura), il resto
+// resta al runtime e alla cache delle pagine.
+// Senza informazioni sulla memoria si usa maxDiskSize. La coda di --pq segue
+// la stessa proporzione.
+func configureChunkSize() {
+	opts.pqItems = maxItems
+	if opts.chunkBytes > 0 {
+		return
+	}
+	avail := opts.memBudget
+	if avail == 0 {
+		avail = availableMemory()
+	}
+	if avail == 0 {
+		opts.chunkBytes = maxDiskSize
+		return
+	}
+	live := uint64(opts.workers + opts.ioWorkers + splitQueue + writeQueue + 1)
+	size := avail / 2 / live / chunkMemFactor
+	size = max(size, minAutoChunk)
+	size = min(size, maxAutoChunk)
+	opts.chunkBytes = int(size)
+	opts.pqItems = int(int64(maxItems) * int64(opts.chunkBytes) / maxDiskSize)
+	fmt.Fprintf(status, "💽 Chunk da %d MB (memoria %d MB, %d worker)\n",
+		opts.chunkBytes>>20, avail>>20, opts.workers)
+}
+
+// availableMemory restituisce la memoria utilizzabile dal processo: il minimo
+// tra la memoria disponibile del sistema (MemAvailable su Linux) e il limite
+// di memoryLimit. 0 se non è nota.
+func availableMemory() uint64 {
+	avail := memAvailable()
+	if limit := memoryLimit(); limit > 0 && (avail == 0 || limit < avail) {
+		avail = limit
+	}
+	return avail
+}
+
+// memAvailable legge MemAvailable da /proc/meminfo; 0 dove il file non esiste.
+func memAvailable() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// MemAvailable:   12345678 kB
+		rest, ok := strings.CutPrefix(sc.Text(), "MemAvailable:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb << 10
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Algoritmi di ordinamento dei chunk selezionabili con --chunk-sort. Producono
+// tutti lo stesso ordine (lessSeq); cambia solo il costo sui diversi dati.
+const (
+	chunkSortAuto     = "auto"     // radix con chiavi di lunghezza fissa, altrimenti std
+	chunkSortStd      = "std"      // ordinamento per confronto (pdqsort)
+	chunkSortRadix    = "radix"    // radix MSD sui byte della chiave
+	chunkSortParallel = "parallel" // parti ordinate in parallelo e poi fuse
+	chunkSortStable   = "stable"   // merge sort stabile, adatto a dati quasi ordinati
+)
+
+// radixCutoff è la dimensione sotto la quale il radix passa al confronto.
+const radixCutoff = 64
+
+// radixAutoMin è il numero minimo di righe di un chunk perché --chunk-sort
+// auto scelga il radix: sotto questa soglia il confronto costa già poco.
+const radixAutoMin = 4096
+
+// parseChunkSort verifica il valore di --chunk-sort.
+func parseChunkSort(v string) error {
+	switch v {
+	case chunkSortAuto, chunkSortStd, chunkSortRadix, chunkSortParallel, chunkSortStable:
+		return nil
+	}
+	return fmt.Errorf("--chunk-sort non valido: %q (attesi auto, std, radix, parallel o stable)", v)
+}
+
+// lessKeyedLine confronta due righe di un chunk secondo le opzioni correnti.
+func lessKeyedLine(a, b *keyedLine) bool {
+	return lessSeq(a.key, a.line, a.seq, b.key, b.line, b.seq)
+}
+
+// sortKeyed ordina le righe con l'algoritmo scelto da --chunk-sort.
+func sortKeyed(keys []keyedLine) {
+	switch opts.chunkSort {
+	case chunkSortRadix:
+		radixSortKeyed(keys)
+	case chunkSortParallel:
+		parallelSortKeyed(keys, runtime.GOMAXPROCS(0))
+	case chunkSortStable:
+		sort.SliceStable(keys, func(i, j int) bool { return lessKeyedLine(&keys[i], &keys[j]) })
+	case chunkSortAuto, "":
+		if len(keys) >= radixAutoMin && fixedLengthKeys(keys) {
+			radixSortKeyed(keys)
+			return
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessKeyedLine(&keys[i], &keys[j]) })
+	default:
+		sort.Slice(keys, func(i, j int) bool { return lessKeyedLine(&keys[i], &keys[j]) })
+	}
+}
+
+// radixSortKeyed ordina per chiave con un radix MSD, poi sistema ogni gruppo
+// di chiavi uguali con lessSeq, che decide gli spareggi (riga, ordine di
+// origine) e l'eventuale --reverse. Conviene con chiavi corte e di lunghezza
+// fissa, come quelle di --key-bytes, dove evita quasi tutti i confronti.
+func radixSortKeyed(keys []keyedLine) {
+	tmp := make([]keyedLine, len(keys))
+	radixPass(keys, tmp, 0)
+	if opts.reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	for i := 0; i < len(keys); {
+		j := i + 1
+		for j < len(keys) && keys[j].key == keys[i].key {
+			j++
+		}
+		if j-i > 1 {
+			group := keys[i:j]
+			sort.Slice(group, func(a, b int) bool { return lessKeyedLine(&group[a], &group[b]) })
+		}
+		i = j
+	}
+}
+
+// fixedLengthKeys indica se tutte le chiavi hanno la stessa lunghezza.
+func fixedLengthKeys(keys []keyedLine) bool {
+	for i := 1; i < len(keys); i++ {
+		if len(keys[i].key) != len(keys[0].key) {
+			return false
+		}
+	}
+	return true
+}
+
+// fixedLengthLines indica se tutte le righe hanno la stessa lunghezza.
+func fixedLengthLines(lines []string) bool {
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) != len(lines[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// radixSortStrings ordina byte per byte righe senza chiave con un radix MSD.
+// È il percorso rapido di --chunk-sort auto per i record di lunghezza fissa:
+// lavora direttamente sulle stringhe, senza costruire le keyedLine, e con
+// righe identiche non servono spareggi.
+func radixSortStrings(lines []string) {
+	radixPassStrings(lines, make([]string, len(lines)), 0)
+}
+
+// radixPassStrings è radixPass per le righe senza chiave.
+func radixPassStrings(lines, tmp []string, depth int) {
+	if len(lines) < radixCutoff {
+		sort.Slice(lines, func(i, j int) bool { return lines[i][depth:] < lines[j][depth:] })
+		return
+	}
+	var count [257]int
+	for _, l := range lines {
+		count[radixBucket(l, depth)]++
+	}
+	var start [257]int
+	for b := 1; b < 257; b++ {
+		start[b] = start[b-1] + count[b-1]
+	}
+	pos := start
+	for _, l := range lines {
+		b := radixBucket(l, depth)
+		tmp[pos[b]] = l
+		pos[b]++
+	}
+	copy(lines, tmp)
+	for b := 1; b < 257; b++ {
+		if count[b] > 1 {
+			lo, hi := start[b], start[b]+count[b]
+			radixPassStrings(lines[lo:hi], tmp[lo:hi], depth+1)
+		}
+	}
+}
+
+// radixPass distribuisce keys nei bucket del byte in posizione depth (le
+// chiavi già terminate vengono prima) e ordina ricorsivamente ogni bucket.
+func radixPass(keys, tmp []keyedLine, depth int) {
+	if len(keys) < radixCutoff {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].key[depth:] < keys[j].key[depth:] })
+		return
+	}
+	var count [257]int
+	for i := range keys {
+		count[radixBucket(keys[i].key, depth)]++
+	}
+	var start [257]int
+	for b := 1; b < 257; b++ {
+		start[b] = start[b-1] + count[b-1]
+	}
+	pos := start
+	for i := range keys {
+		b := radixBucket(keys[i].key, depth)
+		tmp[pos[b]] = keys[i]
+		pos[b]++
+	}
+	copy(keys, tmp)
+	// Il bucket 0 contiene chiavi terminate e già uguali tra loro.
+	for b := 1; b < 257; b++ {
+		if count[b] > 1 {
+			lo, hi := start[b], start[b]+count[b]
+			radixPass(keys[lo:hi], tmp[lo:hi], depth+1)
+		}
+	}
+}
+
+// radixBucket restituisce il bucket di key alla posizione depth: 0 se la
+// chiave è terminata, altrimenti il byte più uno.
+func radixBucket(key string, depth int) int {
+	if depth >= len(key) {
+		return 0
+	}
+	return int(key[depth]) + 1
+}
+
+// parallelSortKeyed divide le righe in parts parti, le ordina in parallelo e
+// le fonde a coppie, anch'esse in parallelo. Serve quando i chunk sono pochi
+// e grandi rispetto al numero di CPU.
+func parallelSortKeyed(keys []keyedLine, parts int) {
+	if parts < 2 || len(keys) < 2*radixCutoff {
+		sort.Slice(keys, func(i, j int) bool { return lessKeyedLine(&keys[i], &keys[j]) })
+		return
+	}
+	bounds := make([]int, parts+1)
+	for p := 0; p <= parts; p++ {
+		bounds[p] = p * len(keys) / parts
+	}
+	var wg sync.WaitGroup
+	for p := 0; p < parts; p++ {
+		part := keys[bounds[p]:bounds[p+1]]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer removeTempsOnPanic()
+			sort.Slice(part, func(i, j int) bool { return lessKeyedLine(&part[i], &part[j]) })
+		}()
+	}
+	wg.Wait()
+
+	tmp := make([]keyedLine, len(keys))
+	src, dst := keys, tmp
+	for len(bounds) > 2 {
+		next := []int{0}
+		for p := 0; p+1 < len(bounds); p += 2 {
+			lo := bounds[p]
+			if p+2 >= len(bounds) {
+				// Parte dispari: viene copiata così com'è.
+				copy(dst[lo:], src[lo:bounds[p+1]])
+				next = append(next, bounds[p+1])
+				continue
+			}
+			mid, hi := bounds[p+1], bounds[p+2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer removeTempsOnPanic()
+				mergeKeyed(dst[lo:hi], src[lo:mid], src[mid:hi])
+			}()
+			next = append(next, hi)
+		}
+		wg.Wait()
+		src, dst = dst, src
+		bounds = next
+	}
+	if &src[0] != &keys[0] {
+		copy(keys, src)
+	}
+}
+
+// mergeKeyed fonde in dst le sequenze ordinate a e b; a parità vince a, che
+// precede b nell'input.
+func mergeKeyed(dst, a, b []keyedLine) {
+	i, j := 0, 0
+	for k := range dst {
+		if j >= len(b) || i < len(a) && !lessKeyedLine(&b[j], &a[i]) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
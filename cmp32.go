@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// compareKeys confronta due chiavi come strings.Compare. Le chiavi di
+// strLength byte, come i record di fixed32 o le chiavi di --key-bytes 32,
+// passano da compare32, che su amd64 confronta i 32 byte con due confronti
+// SSE2 da 16 byte e su arm64 con quattro parole da 8 byte, senza il ciclo
+// generico della runtime sulle lunghezze: nel merge e nell'ordinamento dei
+// chunk è il confronto che si ripete più spesso.
+func compareKeys(a, b string) int {
+	if len(a) == strLength && len(b) == strLength {
+		return compare32(unsafe.StringData(a), unsafe.StringData(b))
+	}
+	return strings.Compare(a, b)
+}
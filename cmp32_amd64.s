@@ -0,0 +1,40 @@
+#include "textflag.h"
+
+// func compare32(a, b *byte) int
+//
+// Confronta i due blocchi da 16 byte con PCMPEQB: PMOVMSKB raccoglie in un
+// bit per byte le posizioni uguali e BSF trova il primo byte diverso.
+TEXT ·compare32(SB), NOSPLIT, $0-24
+	MOVQ	a+0(FP), SI
+	MOVQ	b+8(FP), DI
+	MOVOU	(SI), X0
+	MOVOU	(DI), X1
+	PCMPEQB	X1, X0
+	PMOVMSKB	X0, AX
+	XORL	$0xffff, AX
+	JNZ	diff
+	MOVOU	16(SI), X0
+	MOVOU	16(DI), X1
+	PCMPEQB	X1, X0
+	PMOVMSKB	X0, AX
+	XORL	$0xffff, AX
+	JZ	equal
+	ADDQ	$16, SI
+	ADDQ	$16, DI
+
+diff:
+	BSFL	AX, BX
+	MOVBLZX	(SI)(BX*1), CX
+	MOVBLZX	(DI)(BX*1), DX
+	CMPL	CX, DX
+	JHI	greater
+	MOVQ	$-1, ret+16(FP)
+	RET
+
+greater:
+	MOVQ	$1, ret+16(FP)
+	RET
+
+equal:
+	MOVQ	$0, ret+16(FP)
+	RET
@@ -0,0 +1,36 @@
+#include "textflag.h"
+
+// func compare32(a, b *byte) int
+//
+// Carica i 32 byte con due LDP per lato e confronta le parole da 8 byte; la
+// prima diversa, riportata in big endian con REV, decide l'ordine.
+TEXT ·compare32(SB), NOSPLIT, $0-24
+	MOVD	a+0(FP), R0
+	MOVD	b+8(FP), R1
+	LDP	(R0), (R2, R3)
+	LDP	(R1), (R4, R5)
+	CMP	R2, R4
+	BNE	diff
+	CMP	R3, R5
+	BNE	diffnext
+	LDP	16(R0), (R2, R3)
+	LDP	16(R1), (R4, R5)
+	CMP	R2, R4
+	BNE	diff
+	CMP	R3, R5
+	BNE	diffnext
+	MOVD	ZR, ret+16(FP)
+	RET
+
+diffnext:
+	MOVD	R3, R2
+	MOVD	R5, R4
+
+diff:
+	REV	R2, R2
+	REV	R4, R4
+	CMP	R2, R4
+	MOVD	$1, R6
+	CNEG	HI, R6, R6
+	MOVD	R6, ret+16(FP)
+	RET
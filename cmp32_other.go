@@ -0,0 +1,25 @@
+//go:build !(amd64 || arm64)
+
+package main
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// compare32 confronta i 32 byte puntati da a e b come due stringhe: -1, 0
+// o 1. Senza assembly confronta quattro parole da 8 byte in big endian, che
+// hanno lo stesso ordine dei byte.
+func compare32(a, b *byte) int {
+	x, y := unsafe.Slice(a, strLength), unsafe.Slice(b, strLength)
+	for i := 0; i < strLength; i += 8 {
+		u, v := binary.BigEndian.Uint64(x[i:]), binary.BigEndian.Uint64(y[i:])
+		if u != v {
+			if u < v {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
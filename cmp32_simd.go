@@ -0,0 +1,9 @@
+//go:build amd64 || arm64
+
+package main
+
+// compare32 confronta i 32 byte puntati da a e b come due stringhe: -1, 0
+// o 1. È implementata in assembly (cmp32_amd64.s, cmp32_arm64.s).
+//
+//go:noescape
+func compare32(a, b *byte) int
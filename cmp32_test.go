@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// checkCompare32 confronta compare32 e compareKeys con bytes.Compare sulla
+// coppia a, b e sulla coppia invertita.
+func checkCompare32(t *testing.T, what string, a, b []byte) {
+	t.Helper()
+	for _, p := range [][2][]byte{{a, b}, {b, a}} {
+		want := bytes.Compare(p[0], p[1])
+		if got := compare32(&p[0][0], &p[1][0]); got != want {
+			t.Fatalf("%s: compare32(%x, %x) = %d, atteso %d", what, p[0], p[1], got, want)
+		}
+		if got := compareKeys(string(p[0]), string(p[1])); got != want {
+			t.Fatalf("%s: compareKeys(%x, %x) = %d, atteso %d", what, p[0], p[1], got, want)
+		}
+	}
+}
+
+// TestCompare32 verifica l'assembly di compare32 (e la versione in Go sulle
+// altre architetture) contro bytes.Compare: coppie che differiscono in un
+// solo byte, in ogni posizione e in particolare ai bordi delle parole da 8
+// byte e dei blocchi da 16, con byte col bit alto, e coppie casuali.
+func TestCompare32(t *testing.T) {
+	// Coppie di byte diversi: il confronto deve essere senza segno.
+	pairs := [][2]byte{{0, 1}, {'a', 'b'}, {0x7f, 0x80}, {0x00, 0xff}, {0x80, 0xff}, {0xfe, 0xff}, {0x01, 0x80}}
+	bases := map[string]byte{"zeri": 0, "lettere": 'm', "bit alto": 0xc0, "0xff": 0xff}
+	for name, fill := range bases {
+		for pos := 0; pos < strLength; pos++ {
+			for _, p := range pairs {
+				a := bytes.Repeat([]byte{fill}, strLength)
+				b := bytes.Repeat([]byte{fill}, strLength)
+				a[pos], b[pos] = p[0], p[1]
+				checkCompare32(t, name, a, b)
+				// I byte successivi, diversi nel verso opposto, non contano.
+				for j := pos + 1; j < strLength; j++ {
+					a[j], b[j] = 0xff, 0x00
+				}
+				checkCompare32(t, name+" con differenze successive", a, b)
+			}
+		}
+		a := bytes.Repeat([]byte{fill}, strLength)
+		checkCompare32(t, name+" uguali", a, bytes.Clone(a))
+	}
+
+	// Ai bordi delle parole una differenza in entrambi i byte adiacenti: in
+	// little endian il byte successivo pesa di più e darebbe il verso sbagliato.
+	for _, edge := range []int{8, 16, 24} {
+		a := bytes.Repeat([]byte{'m'}, strLength)
+		b := bytes.Clone(a)
+		a[edge-1], a[edge] = 0x01, 0xff
+		b[edge-1], b[edge] = 0x02, 0x00
+		checkCompare32(t, "bordo di parola", a, b)
+	}
+
+	// Coppie casuali su un alfabeto ridotto, così che i prefissi comuni
+	// siano frequenti, a indirizzi non allineati.
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []byte{0x00, 0x01, '0', 'a', 0x7f, 0x80, 0xfe, 0xff}
+	buf := make([]byte, 2*strLength+16)
+	for i := 0; i < 20000; i++ {
+		off := rng.Intn(8)
+		a, b := buf[off:off+strLength], buf[off+strLength+8:off+2*strLength+8]
+		for j := range a {
+			a[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+		copy(b, a)
+		// Dal primo byte diverso in poi, b è casuale.
+		for j := rng.Intn(strLength + 1); j < strLength; j++ {
+			b[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+		checkCompare32(t, "casuale", a, b)
+	}
+}
+
+// TestCompareKeysLengths verifica che le chiavi di lunghezza diversa da
+// strLength, anche con un prefisso di 32 byte uguale, seguano strings.Compare.
+func TestCompareKeysLengths(t *testing.T) {
+	k := string(bytes.Repeat([]byte{'k'}, strLength))
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{k, k + "a", -1},
+		{k + "a", k, 1},
+		{k[:31], k, -1},
+		{"", k, -1},
+		{k[:31] + "\xff", k, 1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareKeys(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareKeys(%q, %q) = %d, atteso %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
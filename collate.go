@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collatorPool fornisce un collator per goroutine: collate.Collator non è
+// sicuro per l'uso concorrente e i worker dello split calcolano le chiavi in parallelo.
+var collatorPool sync.Pool
+
+// collatorEntry abbina un collator al buffer in cui genera le chiavi.
+type collatorEntry struct {
+	c   *collate.Collator
+	buf collate.Buffer
+}
+
+// parseLocale converte un nome di locale POSIX (it_IT, it_IT.UTF-8) o BCP 47
+// (it-IT) nel tag di lingua corrispondente.
+func parseLocale(name string) (language.Tag, error) {
+	if i := strings.IndexAny(name, ".@"); i >= 0 {
+		name = name[:i]
+	}
+	tag, err := language.Parse(strings.ReplaceAll(name, "_", "-"))
+	if err != nil {
+		return language.Und, fmt.Errorf("locale --locale non valida %q: %w", name, err)
+	}
+	return tag, nil
+}
+
+// initCollation prepara il pool di collator per la locale indicata.
+func initCollation(name string) error {
+	tag, err := parseLocale(name)
+	if err != nil {
+		return err
+	}
+	var copts []collate.Option
+	if opts.ignoreCase {
+		copts = append(copts, collate.IgnoreCase)
+	}
+	// Un nuovo pool: i collator già creati per la locale o le opzioni
+	// precedenti (setOrderArgs riapplica le opzioni) non vanno riusati.
+	collatorPool = sync.Pool{New: func() interface{} {
+		return &collatorEntry{c: collate.New(tag, copts...)}
+	}}
+	return nil
+}
+
+// collationKey restituisce la chiave di collazione di una riga: una sequenza
+// di byte il cui ordine binario coincide con l'ordine linguistico della locale.
+// Generandola una sola volta per riga l'heap del merge continua a confrontare
+// semplici stringhe.
+func collationKey(line string) string {
+	e := collatorPool.Get().(*collatorEntry)
+	key := string(e.c.KeyFromString(&e.buf, line))
+	e.buf.Reset()
+	collatorPool.Put(e)
+	return key
+}
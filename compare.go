@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// keyedLine associa a una riga la sua chiave di confronto, calcolata una
+// sola volta prima dell'ordinamento del chunk, e la sua posizione nel chunk.
+type keyedLine struct {
+	key  string
+	line string
+	seq  int64
+}
+
+// keyed indica se le opzioni correnti richiedono una chiave di confronto
+// diversa dalla riga stessa.
+func (o *options) keyed() bool {
+	return o.lineEndings == eolPreserve || o.ignoreCase || o.ignoreBlanks || o.dictionary || o.byLength || o.timestamp != "" || o.ip || o.idTime || o.locale != "" || len(o.keySpecs) > 0 || o.numeric || o.natural || o.versionSort || o.monthSort || o.humanNumeric || o.random || o.keyLen > 0 ||
+		o.normalize != "" || o.schemaDef != nil && o.schemaDef.Key != nil
+}
+
+// sortKey restituisce la chiave di confronto di una riga secondo le opzioni
+// correnti. Senza opzioni la chiave coincide con la riga (nessuna allocazione).
+// La riga di output non viene mai modificata: la chiave serve solo a confrontare.
+func sortKey(line string) string {
+	// Con --line-endings preserve il '\r' resta nel record ma non nel confronto.
+	if opts.lineEndings == eolPreserve {
+		line = strings.TrimSuffix(line, "\r")
+	}
+	// Con --normalize le forme composte e scomposte dello stesso testo hanno
+	// la stessa chiave; se la riga è già normalizzata non viene copiata.
+	if opts.normalize != "" {
+		line = opts.normForm.String(line)
+	}
+	if len(opts.keySpecs) > 0 {
+		return fieldKey(line)
+	}
+	// Con --key-bytes la chiave è una porzione della riga, senza copie: le
+	// opzioni di confronto si applicano solo a quella porzione.
+	if opts.keyLen > 0 {
+		line = byteRange(line, opts.keyOffset, opts.keyLen)
+	}
+	if opts.ignoreBlanks {
+		line = line[skipBlanks(line, 0):]
+	}
+	// Come in GNU sort, -d filtra la chiave prima di ogni altro confronto.
+	if opts.dictionary {
+		line = dictionaryKey(line)
+	}
+	if opts.byLength {
+		return lengthKey(line, textKey(line))
+	}
+	return textKey(line)
+}
+
+// textKey restituisce la chiave di confronto del testo già ridotto da
+// sortKey (intervallo di byte, spazi iniziali, filtro -d).
+func textKey(line string) string {
+	if opts.random {
+		// Come GNU sort -R con -f: righe che differiscono solo per le
+		// maiuscole hanno lo stesso hash.
+		if opts.ignoreCase {
+			return randomKey(strings.ToUpper(line))
+		}
+		return randomKey(line)
+	}
+	if opts.timestamp != "" {
+		return timestampKey(line, opts.tsFormat)
+	}
+	if opts.ip {
+		return ipKey(line)
+	}
+	if opts.idTime {
+		return idTimeKey(line)
+	}
+	if opts.schemaDef != nil && opts.schemaDef.Key != nil && opts.keyLen == 0 {
+		return opts.schemaDef.Key(line)
+	}
+	if opts.numeric {
+		return numericKey(line)
+	}
+	if opts.humanNumeric {
+		return humanKey(line)
+	}
+	if opts.versionSort {
+		return versionKey(line)
+	}
+	if opts.monthSort {
+		return monthKey(line)
+	}
+	if opts.natural {
+		return naturalKey(line, opts.ignoreCase)
+	}
+	if opts.locale != "" {
+		// La collazione gestisce anche --ignore-case.
+		return collationKey(line)
+	}
+	if opts.ignoreCase {
+		// Come GNU sort -f: le minuscole vengono confrontate come maiuscole.
+		return strings.ToUpper(line)
+	}
+	return line
+}
+
+// lessKeyed confronta due righe tramite le chiavi e, a parità di chiave,
+// tramite il contenuto originale, così l'ordine finale è deterministico.
+// Con --reverse l'intero confronto, riga originale compresa, è invertito.
+func lessKeyed(aKey, aLine, bKey, bLine string) bool {
+	if opts.reverse {
+		aKey, aLine, bKey, bLine = bKey, bLine, aKey, aLine
+	}
+	if c := compareKeys(aKey, bKey); c != 0 {
+		return c < 0
+	}
+	return compareKeys(aLine, bLine) < 0
+}
+
+// lessSeq confronta come lessKeyed ma, con --stable, a parità di chiave
+// decide l'ordine di origine (seq) invece del contenuto delle righe, come
+// GNU sort -s. --reverse non inverte l'ordine di origine. Anche --unique e
+// --count implicano questo spareggio: tra righe con la stessa chiave resta
+// la prima dell'input.
+func lessSeq(aKey, aLine string, aSeq int64, bKey, bLine string, bSeq int64) bool {
+	if (opts.stable || opts.unique || opts.count) && aKey == bKey {
+		return aSeq < bSeq
+	}
+	return lessKeyed(aKey, aLine, bKey, bLine)
+}
+
+// sortLines ordina in memoria le righe di un chunk secondo le opzioni correnti,
+// con l'algoritmo scelto da --chunk-sort.
+func sortLines(lines []string) {
+	if !opts.keyed() && !opts.reverse {
+		switch opts.chunkSort {
+		case chunkSortStd:
+			sort.Strings(lines)
+			return
+		case chunkSortAuto, "":
+			if len(lines) >= radixAutoMin && fixedLengthLines(lines) {
+				radixSortStrings(lines)
+			} else {
+				sort.Strings(lines)
+			}
+			return
+		}
+	}
+	keys := getKeyed(len(lines))
+	for i, l := range lines {
+		keys[i] = keyedLine{key: sortKey(l), line: l, seq: int64(i)}
+	}
+	sortKeyed(keys)
+	for i := range keys {
+		lines[i] = keys[i].line
+	}
+	putKeyed(keys)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setOrder applica le opzioni di ordinamento impostate da set, come dopo la
+// lettura dei flag, e le ripristina alla fine del test. Nel processo dei
+// test i flag non sono registrati: servono i loro valori predefiniti.
+func setOrder(t *testing.T, set func(o *options)) {
+	t.Helper()
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts = options{schema: defaultSchemaName, csvDelimiter: ",", ipOrder: ipV4First}
+	set(&opts)
+	if err := applyOrderOptions(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testUUIDv7 compone un UUIDv7 con i millisecondi ms e il byte casuale r.
+func testUUIDv7(ms uint64, r byte) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], ms<<16|0x7000|uint64(r))
+	b[8] = 0x80 | r
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// testULID compone un ULID con i millisecondi ms e il byte casuale r.
+func testULID(ms uint64, r byte) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], ms<<16|uint64(r))
+	b[15] = r
+	n := new(big.Int).SetBytes(b[:])
+	out := make([]byte, 26)
+	mod := new(big.Int)
+	for i := len(out) - 1; i >= 0; i-- {
+		n.DivMod(n, big.NewInt(32), mod)
+		out[i] = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"[mod.Int64()]
+	}
+	return string(out)
+}
+
+// TestLessModes verifica per ogni modalità di confronto che lessKeyed,
+// sulle chiavi di sortKey, metta le righe di want nell'ordine indicato:
+// ogni coppia nei due versi, l'ordinamento di una permutazione e, con
+// --reverse, l'ordine inverso. A parità di chiave decide la riga, quindi
+// want è un ordine totale. Lo stesso ordine deve uscire dall'esecuzione
+// completa con i flag della modalità, attraverso chunk e merge.
+func TestLessModes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string         // flag equivalenti a set, per l'esecuzione completa
+		set  func(o *options) // opzioni per il confronto nel processo dei test
+		want []string
+	}{
+		{"natural", []string{"--natural"}, func(o *options) { o.natural = true }, []string{
+			"", "1", "\x01x", "file0", "file00", "file1", "file2", "file10", "file10a", "file010b", "fileA",
+			"v2", "v100", "v99999999999999999999", "v100000000000000000000",
+		}},
+		{"natural ignore-case", []string{"--natural", "--ignore-case"}, func(o *options) { o.natural, o.ignoreCase = true, true }, []string{
+			"a2", "A10", "A10b", "a10C", "b1", "B2",
+		}},
+		{"locale it_IT", []string{"--locale", "it_IT.UTF-8"}, func(o *options) { o.locale = "it_IT.UTF-8" }, []string{
+			"a", "A", "à", "b", "B", "citta", "città", "Città", "zebra", "Zucca",
+		}},
+		{"locale ignore-case", []string{"--locale", "it_IT", "--ignore-case"}, func(o *options) { o.locale, o.ignoreCase = "it_IT", true }, []string{
+			"A", "a", "à", "B", "b", "zebra", "Zucca",
+		}},
+		{"timestamp rfc3339", []string{"--timestamp", "rfc3339"}, func(o *options) { o.timestamp = "rfc3339" }, []string{
+			"", "garbage", "1969-12-31T23:59:59Z prima del 1970",
+			"2024-01-01T01:00:00+02:00 ieri in UTC", "2023-12-31T23:30:00Z",
+			"2024-01-01T00:00:00Z a", "2024-01-01T00:00:00Z b", "2024-01-01T00:00:00.000000001Z", "2024-01-01T00:00:00.5Z",
+		}},
+		{"timestamp epoch", []string{"--timestamp", "epoch"}, func(o *options) { o.timestamp = "epoch" }, []string{
+			"1e3", "abc", "-1.5", "-1", "0", "1", "1.000000001", "1.5", "9", "10", "1700000000 evento",
+		}},
+		{"timestamp syslog", []string{"--timestamp", "syslog"}, func(o *options) { o.timestamp = "syslog" }, []string{
+			"host senza data", "Jan  2 03:04:05 host", "Jan 10 00:00:00 host", "Feb  1 00:00:00 host", "Dec 31 23:59:59 host",
+		}},
+		{"timestamp layout", []string{"--timestamp", "02/01/2006 15:04"}, func(o *options) { o.timestamp = "02/01/2006 15:04" }, []string{
+			"31/12/2023", "31/12/2023 23:59 b", "01/01/2024 00:00 a", "02/01/2024 09:30",
+		}},
+		{"ip v4-first", []string{"--ip"}, func(o *options) { o.ip, o.ipOrder = true, ipV4First }, []string{
+			"", "300.1.1.1", "host", "9.255.255.255", "10.0.0.0", "10.0.0.0/8", "10.0.0.0/16", "10.0.0.1",
+			"10.0.0.1:80", "10.0.0.1:443", "10.0.0.2,", "192.168.1.1 x", "::1", "::ffff:1.2.3.4", "2001:db8::1", "fe80::1%eth0",
+		}},
+		{"ip v6-first", []string{"--ip", "--ip-order", "v6-first"}, func(o *options) { o.ip, o.ipOrder = true, ipV6First }, []string{
+			"host", "::1", "2001:db8::1", "[2001:db8::1]:443", "1.2.3.4", "10.0.0.1",
+		}},
+		{"ip mixed", []string{"--ip", "--ip-order", "mixed"}, func(o *options) { o.ip, o.ipOrder = true, ipMixed }, []string{
+			"host", "::1", "1.2.3.4", "::ffff:1.2.3.4", "10.0.0.1", "2001:db8::1",
+		}},
+		{"id-time", []string{"--id-time"}, func(o *options) { o.idTime = true }, []string{
+			"", "550e8400-e29b-41d4-a716-446655440000 uuid v4", "ZZZZZZZZZZZZZZZZZZZZZZZZZZ", "non-un-id",
+			testULID(1000, 0), testUUIDv7(1000, 1), testUUIDv7(1000, 2),
+			testULID(2000, 9) + ",csv",
+			"{" + testUUIDv7(3000, 0) + "}",
+			"urn:uuid:" + testUUIDv7(4000, 0),
+			"\t" + testULID(5000, 0),
+			strings.ToLower(testULID(6000, 0)),
+			testUUIDv7(1<<47, 0),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setOrder(t, tt.set)
+			keys := make([]string, len(tt.want))
+			for i, l := range tt.want {
+				keys[i] = sortKey(l)
+			}
+			for i := range tt.want {
+				for j := i + 1; j < len(tt.want); j++ {
+					if !lessKeyed(keys[i], tt.want[i], keys[j], tt.want[j]) {
+						t.Errorf("%q non precede %q", tt.want[i], tt.want[j])
+					}
+					if lessKeyed(keys[j], tt.want[j], keys[i], tt.want[i]) {
+						t.Errorf("%q precede %q", tt.want[j], tt.want[i])
+					}
+				}
+			}
+
+			rng := rand.New(rand.NewSource(int64(len(tt.want))))
+			for _, reverse := range []bool{false, true} {
+				opts.reverse = reverse
+				lines := slices.Clone(tt.want)
+				rng.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+				sortLines(lines)
+				want := slices.Clone(tt.want)
+				if reverse {
+					slices.Reverse(want)
+				}
+				if !slices.Equal(lines, want) {
+					t.Errorf("reverse %v: sortLines = %q, atteso %q", reverse, lines, want)
+				}
+			}
+
+			dir := t.TempDir()
+			lines := slices.Clone(tt.want)
+			rng.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+			input := writeLines(t, dir, "in.txt", lines)
+			mustRunSorter(t, dir, append(tt.args, "--chunk-bytes", "64", "--in-memory=false", "--input", input, "--output", "out.txt")...)
+			equalLines(t, tt.name, readLines(t, filepath.Join(dir, "out.txt")), tt.want)
+		})
+	}
+}
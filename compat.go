@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Modalità compatibile con GNU sort (--gnu come primo argomento).
+//
+// È accettato questo sottoinsieme delle opzioni di GNU sort, tradotto nelle
+// opzioni native equivalenti:
+//
+//	-t SEP, --field-separator=SEP    --field-sep
+//	-k KEY, --key=KEY                --key (opzioni per chiave b, d, f, h, M, n, r, R, V)
+//	-b, --ignore-leading-blanks      --ignore-leading-blanks
+//	-d, --dictionary-order           --dictionary-order
+//	-z, --zero-terminated            --zero-terminated
+//	-f, --ignore-case                --ignore-case
+//	-n, --numeric-sort               --numeric
+//	-r, --reverse                    --reverse
+//	-m, --merge                      --merge
+//	-c, --check                      --check
+//	-s, --stable                     --stable
+//	-u, --unique                     --unique
+//	-V, --version-sort               --version-sort
+//	-R, --random-sort                --random
+//	-M, --month-sort                 --month-sort
+//	-h, --human-numeric-sort         --human-numeric
+//	-o FILE, --output=FILE           --output (default: stdout)
+//	-S SIZE, --buffer-size=SIZE      --chunk-bytes (suffissi b, K, M, G, T; default K)
+//	-T DIR, --temporary-directory=DIR --chunk-dir (default: $TMPDIR/sithlords-PID)
+//	--parallel=N                     --workers
+//	--batch-size=NMERGE              --merge-fan-in
+//	--compress-program=PROG          --chunk-compression (zstd per zstd, snappy per gli altri)
+//	FILE...                          --input (default: stdin)
+//
+// --dry-run stampa la riga di comando nativa equivalente senza eseguire nulla:
+// serve a verificare che uno script esistente sia coperto prima di migrarlo.
+
+// gnuShort associa le opzioni brevi senza argomento al flag nativo.
+var gnuShort = map[byte]string{
+	'b': "ignore-leading-blanks",
+	'f': "ignore-case",
+	'n': "numeric",
+	'r': "reverse",
+	'm': "merge",
+	'c': "check",
+	's': "stable",
+	'u': "unique",
+	'V': "version-sort",
+	'R': "random",
+	'M': "month-sort",
+	'h': "human-numeric",
+	'd': "dictionary-order",
+	'z': "zero-terminated",
+}
+
+// gnuShortArg associa le opzioni brevi con argomento al nome lungo di GNU sort.
+var gnuShortArg = map[byte]string{
+	't': "field-separator",
+	'k': "key",
+	'o': "output",
+	'S': "buffer-size",
+	'T': "temporary-directory",
+}
+
+// gnuLong associa le opzioni lunghe di GNU sort al flag nativo. Le opzioni
+// con argomento sono quelle il cui valore è riportato in gnuLongArg.
+var gnuLong = map[string]string{
+	"ignore-leading-blanks": "ignore-leading-blanks",
+	"ignore-case":           "ignore-case",
+	"numeric-sort":          "numeric",
+	"reverse":               "reverse",
+	"merge":                 "merge",
+	"check":                 "check",
+	"stable":                "stable",
+	"unique":                "unique",
+	"version-sort":          "version-sort",
+	"random-sort":           "random",
+	"month-sort":            "month-sort",
+	"human-numeric-sort":    "human-numeric",
+	"dictionary-order":      "dictionary-order",
+	"zero-terminated":       "zero-terminated",
+}
+
+// gnuLongArg associa le opzioni lunghe con argomento al flag nativo.
+var gnuLongArg = map[string]string{
+	"field-separator":     "field-sep",
+	"key":                 "key",
+	"output":              "output",
+	"buffer-size":         "chunk-bytes",
+	"temporary-directory": "chunk-dir",
+	"parallel":            "workers",
+	"batch-size":          "merge-fan-in",
+	"compress-program":    "chunk-compression",
+}
+
+// parseGNUArgs interpreta args con la sintassi di GNU sort e imposta i flag
+// nativi corrispondenti. Le opzioni non supportate producono un errore invece
+// di essere ignorate, così uno script non cambia comportamento in silenzio.
+func parseGNUArgs(args []string) error {
+	set := func(name, value string) error {
+		if name == "chunk-bytes" {
+			n, err := parseBufferSize(value)
+			if err != nil {
+				return err
+			}
+			value = strconv.FormatInt(n, 10)
+		}
+		// I chunk non passano da un programma esterno: PROG sceglie solo il codec.
+		if name == "chunk-compression" {
+			codec := chunkCompressSnappy
+			if filepath.Base(value) == "zstd" {
+				codec = chunkCompressZstd
+			}
+			value = codec
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("--gnu: valore non valido per %s: %w", name, err)
+		}
+		return nil
+	}
+
+	var files []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case a == "--dry-run":
+			opts.dryRun = true
+		case strings.HasPrefix(a, "--"):
+			name, value, hasValue := strings.Cut(a[2:], "=")
+			if native, ok := gnuLong[name]; ok && !hasValue {
+				if err := set(native, "true"); err != nil {
+					return err
+				}
+				continue
+			}
+			native, ok := gnuLongArg[name]
+			if !ok {
+				return fmt.Errorf("--gnu: opzione non supportata: %s", a)
+			}
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("--gnu: l'opzione --%s richiede un argomento", name)
+				}
+				i++
+				value = args[i]
+			}
+			if err := set(native, value); err != nil {
+				return err
+			}
+		case len(a) > 1 && a[0] == '-':
+			// Opzioni brevi raggruppate (-rn) con argomento attaccato
+			// (-t, -k2,2) o nell'elemento successivo (-k 2,2).
+			for j := 1; j < len(a); j++ {
+				if native, ok := gnuShort[a[j]]; ok {
+					if err := set(native, "true"); err != nil {
+						return err
+					}
+					continue
+				}
+				long, ok := gnuShortArg[a[j]]
+				if !ok {
+					return fmt.Errorf("--gnu: opzione non supportata: -%c", a[j])
+				}
+				value := a[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return fmt.Errorf("--gnu: l'opzione -%c richiede un argomento", a[j])
+					}
+					i++
+					value = args[i]
+				}
+				if err := set(gnuLongArg[long], value); err != nil {
+					return err
+				}
+				break
+			}
+		default:
+			files = append(files, a)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, f := range files {
+		if err := flag.Set("input", f); err != nil {
+			return err
+		}
+	}
+	// Come GNU sort, senza -o il risultato va su stdout e senza -T i file
+	// temporanei finiscono in $TMPDIR, in una directory propria del processo.
+	if !flagWasSet("output") {
+		flag.Set("output", "-")
+	}
+	if !flagWasSet("chunk-dir") {
+		flag.Set("chunk-dir", filepath.Join(os.TempDir(), fmt.Sprintf("sithlords-%d", os.Getpid())))
+		opts.gnuTempDir = true
+	}
+	return nil
+}
+
+// parseBufferSize interpreta la dimensione di -S: un numero con suffisso
+// opzionale b (byte), K, M, G o T; senza suffisso l'unità è il KiB come in GNU sort.
+func parseBufferSize(v string) (int64, error) {
+	if v == "" {
+		return 0, fmt.Errorf("--gnu: dimensione -S vuota")
+	}
+	mult := int64(1 << 10)
+	num := v
+	if last := v[len(v)-1]; !isDigit(last) {
+		num = v[:len(v)-1]
+		switch last {
+		case 'b':
+			mult = 1
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		case 't', 'T':
+			mult = 1 << 40
+		case '%':
+			return 0, fmt.Errorf("--gnu: dimensione -S in percentuale non supportata: %s", v)
+		default:
+			return 0, fmt.Errorf("--gnu: dimensione -S non valida: %s", v)
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("--gnu: dimensione -S non valida: %s", v)
+	}
+	return n * mult, nil
+}
+
+// flagWasSet indica se il flag è stato impostato esplicitamente.
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// shellQuote restituisce s in una forma sicura da incollare in una shell POSIX.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`*?[]{};&|<>()~#!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Valori di --input-compression.
+const (
+	compressAuto  = "auto"  // formato riconosciuto dai magic byte o dall'estensione
+	compressNone  = "none"  // input letto così com'è
+	compressGzip  = "gzip"  // input sempre compresso con gzip
+	compressZstd  = "zstd"  // input sempre compresso con Zstandard
+	compressBzip2 = "bzip2" // input sempre compresso con bzip2
+	compressXz    = "xz"    // input sempre compresso con xz
+)
+
+// compressionFormat descrive un formato di compressione dell'input.
+type compressionFormat struct {
+	name  string
+	magic []byte // primi byte del flusso
+	ext   string // estensione tipica dei file
+}
+
+// compressionFormats sono i formati riconosciuti in modalità auto.
+var compressionFormats = []compressionFormat{
+	{compressGzip, []byte{0x1f, 0x8b}, ".gz"},
+	{compressZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst"},
+	{compressBzip2, []byte("BZh"), ".bz2"},
+	{compressXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, ".xz"},
+}
+
+// maxMagic è la lunghezza del magic più lungo.
+const maxMagic = 6
+
+// parseInputCompression verifica il valore di --input-compression.
+func parseInputCompression(v string) error {
+	if v == compressAuto || v == compressNone {
+		return nil
+	}
+	for _, f := range compressionFormats {
+		if v == f.name {
+			return nil
+		}
+	}
+	return fmt.Errorf("--input-compression non valido: %q (attesi auto, none, gzip, zstd, bzip2 o xz)", v)
+}
+
+// detectCompression restituisce il formato dell'input secondo
+// --input-compression: in modalità auto dai primi byte (head) oppure, se non
+// corrispondono a nessun formato, dall'estensione di name, così un archivio
+// danneggiato dà un errore invece di essere ordinato come testo. "" indica
+// un input non compresso.
+func detectCompression(head []byte, name string) string {
+	switch opts.compression {
+	case compressNone:
+		return ""
+	case compressAuto:
+	default:
+		return opts.compression
+	}
+	for _, f := range compressionFormats {
+		if bytes.HasPrefix(head, f.magic) {
+			return f.name
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, f := range compressionFormats {
+		if ext == f.ext {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// decompress restituisce il contenuto di r (letto dal file name, "-" per
+// stdin), decompresso secondo il formato rilevato da detectCompression. I
+// flussi concatenati (come quelli di pigz o di più file uniti con cat)
+// vengono letti di seguito. Il decoder zstd usa goroutine proprie: il reader
+// restituito è allora un io.Closer, da chiudere insieme al file.
+func decompress(r io.Reader, name string) (io.Reader, error) {
+	if opts.compression == compressNone {
+		return r, nil
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(maxMagic)
+	format := detectCompression(head, name)
+	var dr io.Reader
+	var err error
+	switch format {
+	case "":
+		return br, nil
+	case compressGzip:
+		dr, err = gzip.NewReader(br)
+	case compressZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(br); err == nil {
+			dr = zr.IOReadCloser()
+		}
+	case compressBzip2:
+		dr = bzip2.NewReader(br)
+	case compressXz:
+		dr, err = xz.NewReader(br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("input %s non valido: %w", format, err)
+	}
+	return dr, nil
+}
+
+// compressedInput indica se il file verrà decompresso. Serve alle modalità
+// che leggono l'input per intervalli di byte, come --coop.
+func compressedInput(path string) bool {
+	if opts.compression != compressAuto {
+		return opts.compression != compressNone
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, maxMagic)
+	n, _ := io.ReadFull(f, head)
+	return detectCompression(head[:n], path) != ""
+}
+
+// parseOutputCompression verifica --output-compression e il suo livello.
+func parseOutputCompression() error {
+	maxLevel := 0
+	switch opts.outCompression {
+	case compressNone:
+	case compressGzip:
+		maxLevel = gzip.BestCompression
+	case compressZstd:
+		maxLevel = 22
+	default:
+		return fmt.Errorf("--output-compression non valido: %q (attesi none, gzip o zstd)", opts.outCompression)
+	}
+	if opts.outLevel < 0 || opts.outLevel > maxLevel {
+		return fmt.Errorf("--output-compression-level %d non valido per %s (da 1 a %d, 0 = predefinito)", opts.outLevel, opts.outCompression, maxLevel)
+	}
+	return nil
+}
+
+// compressOutput restituisce un writer che comprime su w secondo
+// --output-compression, da chiudere dopo l'ultima scrittura per completare
+// il flusso. Senza compressione Close non fa nulla.
+func compressOutput(w io.Writer) (io.WriteCloser, error) {
+	switch opts.outCompression {
+	case compressGzip:
+		level := gzip.DefaultCompression
+		if opts.outLevel > 0 {
+			level = opts.outLevel
+		}
+		return gzip.NewWriterLevel(w, level)
+	case compressZstd:
+		var zopts []zstd.EOption
+		if opts.outLevel > 0 {
+			zopts = append(zopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.outLevel)))
+		}
+		return zstd.NewWriter(w, zopts...)
+	}
+	return nopWriteCloser{w}, nil
+}
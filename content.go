@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// contentSeed è il seme degli hash di --verify-content, lo stesso per lo
+// split e per il merge dell'esecuzione.
+var contentSeed = maphash.MakeSeed()
+
+// contentDigest riassume un multiinsieme di record indipendentemente
+// dall'ordine: il numero di record e la somma (modulo 2^64) dei loro hash.
+// Un record perso, duplicato o alterato cambia la somma con probabilità
+// praticamente certa; l'ordine in cui i record arrivano non la cambia.
+type contentDigest struct {
+	records int64
+	sum     uint64
+}
+
+// add aggiunge un record al riassunto.
+func (d *contentDigest) add(s string) {
+	d.records++
+	d.sum += maphash.String(contentSeed, s)
+}
+
+// splitContent e mergeContent sono i riassunti dei record entrati nei chunk
+// e di quelli usciti dal merge finale, calcolati con --verify-content.
+var splitContent, mergeContent contentDigest
+
+// checkVerifyContent verifica che --verify-content sia applicabile: servono
+// uno split e un merge che restituisca tutti i record dei chunk.
+func checkVerifyContent() error {
+	if !opts.verifyContent {
+		return nil
+	}
+	if opts.unique || opts.edges > 0 {
+		return fmt.Errorf("--verify-content non è combinabile con --unique e --edges, che non restituiscono tutti i record")
+	}
+	if opts.merge || opts.check || opts.runSet != "" || opts.query != "" || opts.coop != "" || opts.pqDir != "" {
+		return fmt.Errorf("--verify-content richiede split e merge: non è combinabile con --merge, --check, --run-set, --query, --coop e --pq")
+	}
+	return nil
+}
+
+// verifyContent confronta i record entrati nei chunk con quelli usciti dal
+// merge e restituisce un errore se differiscono.
+func verifyContent() error {
+	if !opts.verifyContent {
+		return nil
+	}
+	if splitContent != mergeContent {
+		return fmt.Errorf("verifica del contenuto fallita: lo split ha letto %d record (hash %016x), il merge ne ha restituiti %d (hash %016x)",
+			splitContent.records, splitContent.sum, mergeContent.records, mergeContent.sum)
+	}
+	fmt.Fprintf(status, "✅ Contenuto verificato: %d record, hash %016x\n", mergeContent.records, mergeContent.sum)
+	return nil
+}
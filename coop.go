@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// File della directory condivisa dalla modalità cooperativa (--coop).
+const (
+	coopManifestName = "coop.json" // stato condiviso del lavoro
+	coopLockName     = "coop.lock" // lock che serializza gli accessi al manifest
+	coopPoll         = 200 * time.Millisecond
+)
+
+// Stati di un'unità di lavoro cooperativa.
+const (
+	coopPending = "pending" // da assegnare
+	coopRunning = "running" // assegnata al processo Owner
+	coopDone    = "done"    // completata
+)
+
+// coopClaim è lo stato di assegnazione di un'unità di lavoro.
+type coopClaim struct {
+	State string `json:"state"`
+	Owner int    `json:"owner,omitempty"` // PID del processo che la sta eseguendo
+}
+
+// coopRange è un intervallo di byte di un file di input da dividere in chunk.
+// I limiti non sono allineati alle righe: ogni processo li allinea allo
+// stesso modo con alignLine, quindi gli intervalli restano contigui.
+type coopRange struct {
+	coopClaim
+	Input  int      `json:"input"` // indice del file in coopManifest.Inputs
+	Start  int64    `json:"start"`
+	End    int64    `json:"end"`
+	Dir    string   `json:"dir"`              // directory dei chunk, relativa alla directory cooperativa
+	Chunks []string `json:"chunks,omitempty"` // chunk scritti, in ordine di input
+}
+
+// coopGroup è un gruppo di chunk consecutivi da fondere in un unico run.
+type coopGroup struct {
+	coopClaim
+	Chunks []string `json:"chunks"`
+	Output string   `json:"output"`
+}
+
+// coopManifest è lo stato condiviso da tutti i processi di una sessione
+// cooperativa. Viene letto e riscritto solo sotto il lock di coopLockName.
+type coopManifest struct {
+	Version   int         `json:"version"`
+	OrderArgs []string    `json:"orderArgs"` // opzioni di ordinamento, come nei run set
+	Inputs    []string    `json:"inputs"`
+	Ranges    []coopRange `json:"ranges"`
+	Groups    []coopGroup `json:"groups,omitempty"` // create quando tutti gli intervalli sono completati
+	Final     coopClaim   `json:"final"`            // merge finale nel file di output
+}
+
+// coopTask è il lavoro assegnato a un processo da claim.
+type coopTask struct {
+	kind  string // "range", "group", "final", "wait" o "exit"
+	index int    // indice dell'intervallo o del gruppo
+}
+
+// coopOrderArgs restituisce le opzioni di ordinamento senza il seme di
+// --random, che i processi senza seme esplicito adottano dal manifest.
+func coopOrderArgs(args []string) []string {
+	var out []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-random-seed=") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// newCoopManifest divide gli input in intervalli di rangeBytes byte.
+func newCoopManifest(inputs []string, rangeBytes int64) (*coopManifest, error) {
+	m := &coopManifest{Version: 1, OrderArgs: orderArgs(), Inputs: inputs, Final: coopClaim{State: coopPending}}
+	for i, path := range inputs {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		for start := int64(0); start < fi.Size(); start += rangeBytes {
+			end := start + rangeBytes
+			if end > fi.Size() {
+				end = fi.Size()
+			}
+			m.Ranges = append(m.Ranges, coopRange{
+				coopClaim: coopClaim{State: coopPending},
+				Input:     i,
+				Start:     start,
+				End:       end,
+				Dir:       fmt.Sprintf("range_%04d", len(m.Ranges)),
+			})
+		}
+	}
+	return m, nil
+}
+
+// withCoopManifest esegue fn sul manifest della directory cooperativa tenendo
+// il lock esclusivo, e salva il manifest se fn termina senza errori. Il primo
+// processo crea il manifest; gli altri verificano di avere gli stessi input e
+// le stesse opzioni di ordinamento.
+func withCoopManifest(dir string, fn func(m *coopManifest) error) error {
+	lock, err := os.OpenFile(filepath.Join(dir, coopLockName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	path := filepath.Join(dir, coopManifestName)
+	m := &coopManifest{}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if m, err = newCoopManifest(opts.inputs, opts.coopRangeBytes); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, m); err != nil {
+			return fmt.Errorf("manifest cooperativo non valido: %w", err)
+		}
+	}
+	if !reflect.DeepEqual(m.Inputs, []string(opts.inputs)) {
+		return fmt.Errorf("--coop: gli input %q non coincidono con quelli della sessione in %s (%q)", opts.inputs, dir, m.Inputs)
+	}
+	if !reflect.DeepEqual(coopOrderArgs(m.OrderArgs), coopOrderArgs(orderArgs())) {
+		return fmt.Errorf("--coop: le opzioni di ordinamento non coincidono con quelle della sessione in %s (%s)", dir, strings.Join(m.OrderArgs, " "))
+	}
+
+	if err := fn(m); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// claim assegna a pid la prossima unità di lavoro. Le unità assegnate a un
+// processo che non esiste più tornano disponibili. Se restano solo unità in
+// corso presso altri processi il chiamante deve attendere; a merge finale
+// assegnato o completato non c'è altro da fare.
+func (m *coopManifest) claim(pid int) coopTask {
+	reclaim := func(c *coopClaim) {
+		if c.State == coopRunning && !processAlive(c.Owner) {
+			c.State, c.Owner = coopPending, 0
+		}
+	}
+	take := func(c *coopClaim) {
+		c.State, c.Owner = coopRunning, pid
+	}
+
+	if m.Final.State != coopPending {
+		reclaim(&m.Final)
+	}
+	if m.Final.State != coopPending {
+		return coopTask{kind: "exit"}
+	}
+
+	rangesDone := true
+	for i := range m.Ranges {
+		r := &m.Ranges[i]
+		reclaim(&r.coopClaim)
+		if r.State == coopPending {
+			take(&r.coopClaim)
+			return coopTask{kind: "range", index: i}
+		}
+		rangesDone = rangesDone && r.State == coopDone
+	}
+	if !rangesDone {
+		return coopTask{kind: "wait"}
+	}
+
+	groupsDone := true
+	for i := range m.Groups {
+		g := &m.Groups[i]
+		reclaim(&g.coopClaim)
+		if g.State == coopPending {
+			take(&g.coopClaim)
+			return coopTask{kind: "group", index: i}
+		}
+		groupsDone = groupsDone && g.State == coopDone
+	}
+	if !groupsDone {
+		return coopTask{kind: "wait"}
+	}
+	take(&m.Final)
+	return coopTask{kind: "final"}
+}
+
+// makeGroups raggruppa i chunk di tutti gli intervalli, nell'ordine di input,
+// in gruppi di al più fanIn chunk consecutivi.
+func (m *coopManifest) makeGroups(fanIn int) {
+	var chunks []string
+	for _, r := range m.Ranges {
+		chunks = append(chunks, r.Chunks...)
+	}
+	for start := 0; start < len(chunks); start += fanIn {
+		end := start + fanIn
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		m.Groups = append(m.Groups, coopGroup{
+			coopClaim: coopClaim{State: coopPending},
+			Chunks:    chunks[start:end],
+			Output:    fmt.Sprintf("group_%04d.txt", len(m.Groups)),
+		})
+	}
+}
+
+// runCoop partecipa alla sessione cooperativa in dir: ripete claim ed
+// esecuzione delle unità di lavoro finché ce ne sono. Il processo che ottiene
+// il merge finale scrive outputFile; gli altri terminano senza output.
+func runCoop(dir, outputFile string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	pid := os.Getpid()
+	for {
+		var task coopTask
+		var m coopManifest
+		err := withCoopManifest(dir, func(cm *coopManifest) error {
+			task = cm.claim(pid)
+			m = *cm
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// I processi senza --random-seed adottano il seme della sessione.
+		if opts.random && !reflect.DeepEqual(m.OrderArgs, orderArgs()) {
+			if err := setOrderArgs(m.OrderArgs); err != nil {
+				return err
+			}
+		}
+
+		switch task.kind {
+		case "exit":
+			if m.Final.State == coopDone {
+				fmt.Fprintf(status, "✅ [%d] La sessione in %s è già completata\n", pid, dir)
+			} else {
+				fmt.Fprintf(status, "✅ [%d] Nessun lavoro rimasto: il merge finale è del processo %d\n", pid, m.Final.Owner)
+			}
+			return nil
+		case "wait":
+			setPhase("attesa degli altri processi")
+			time.Sleep(coopPoll)
+		case "range":
+			r := m.Ranges[task.index]
+			fmt.Fprintf(status, "🔹 [%d] Split di %s, byte %d-%d...\n", pid, m.Inputs[r.Input], r.Start, r.End)
+			setPhase("split cooperativo")
+			chunks, err := coopSplit(dir, m.Inputs[r.Input], r)
+			if err != nil {
+				return err
+			}
+			err = withCoopManifest(dir, func(cm *coopManifest) error {
+				cr := &cm.Ranges[task.index]
+				cr.State, cr.Owner, cr.Chunks = coopDone, 0, chunks
+				for _, r := range cm.Ranges {
+					if r.State != coopDone {
+						return nil
+					}
+				}
+				cm.makeGroups(opts.coopFanIn)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case "group":
+			g := m.Groups[task.index]
+			fmt.Fprintf(status, "🔹 [%d] Merge del gruppo %s (%d chunk)...\n", pid, g.Output, len(g.Chunks))
+			setPhase("merge cooperativo")
+			if err := writeRun(coopPaths(dir, g.Chunks), filepath.Join(dir, g.Output)); err != nil {
+				return err
+			}
+			err := withCoopManifest(dir, func(cm *coopManifest) error {
+				cg := &cm.Groups[task.index]
+				cg.State, cg.Owner = coopDone, 0
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case "final":
+			fmt.Fprintf(status, "🔹 [%d] Merge finale di %d gruppi...\n", pid, len(m.Groups))
+			setPhase("merge finale cooperativo")
+			files := make([]string, len(m.Groups))
+			for i, g := range m.Groups {
+				files[i] = filepath.Join(dir, g.Output)
+			}
+			merge := mergeFiles
+			if opts.edges > 0 {
+				merge = func(files []string, out string) error { return mergeEdges(files, out, opts.edges) }
+			}
+			if err := merge(files, outputFile); err != nil {
+				return err
+			}
+			err := withCoopManifest(dir, func(cm *coopManifest) error {
+				cm.Final = coopClaim{State: coopDone}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(status, "✅ [%d] Output scritto in %s\n", pid, outputFile)
+			return nil
+		}
+	}
+}
+
+// coopSplit divide in chunk le righe che iniziano nell'intervallo r del file
+// path e restituisce i percorsi dei chunk relativi alla directory cooperativa.
+// I chunk lasciati da un processo interrotto vengono eliminati prima.
+func coopSplit(dir, path string, r coopRange) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	start, err := alignLine(f, r.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := alignLine(f, r.End)
+	if err != nil {
+		return nil, err
+	}
+
+	out := filepath.Join(dir, r.Dir)
+	if err := os.RemoveAll(out); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return nil, err
+	}
+	chunks, err := splitReader(&eolReader{r: io.NewSectionReader(f, start, end-start)}, out)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(chunks))
+	for i, c := range chunks {
+		names[i] = filepath.Join(r.Dir, filepath.Base(c.Path))
+	}
+	return names, nil
+}
+
+// alignLine restituisce l'inizio del primo record che comincia a off o dopo:
+// off stesso se è l'inizio del file o segue un separatore (recordSep),
+// altrimenti il byte successivo al primo separatore da off in poi (o la fine del file).
+func alignLine(f *os.File, off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, off-1, 1<<62))
+	prev, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if prev == recordSep {
+		return off, nil
+	}
+	rest, err := r.ReadSlice(recordSep)
+	for err == bufio.ErrBufferFull {
+		off += int64(len(rest))
+		rest, err = r.ReadSlice(recordSep)
+	}
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return off + int64(len(rest)), nil
+}
+
+// writeRun fonde i file ordinati in un unico run intermedio, con le righe
+// così come sono nei chunk: conteggi e formato di output vengono applicati
+// solo dal merge finale.
+func writeRun(files []string, path string) error {
+	m, err := newChunkMerger(files)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+	f, err := createChunk(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cw, err := compressChunk(&spaceWriter{w: f, path: path})
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(cw, opts.writerBuf)
+	for {
+		item, ok := m.next()
+		if !ok {
+			break
+		}
+		writeRecord(w, item.value)
+	}
+	if err := m.Err(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// coopPaths risolve i percorsi relativi alla directory cooperativa.
+func coopPaths(dir string, names []string) []string {
+	paths := make([]string, len(names))
+	for i, n := range names {
+		paths[i] = filepath.Join(dir, n)
+	}
+	return paths
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile non è implementata su questo sistema: --coop non è disponibile.
+func lockFile(f *os.File) error {
+	return fmt.Errorf("--coop non è supportata su questo sistema")
+}
+
+// tryLockFile non è implementata su questo sistema: le esecuzioni non
+// prendono il lock della directory dei chunk e dell'output.
+func tryLockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
+
+func processAlive(pid int) bool { return true }
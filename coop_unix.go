@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquisisce il lock esclusivo su f, attendendo se è di un altro
+// processo. Il kernel lo rilascia anche se il processo termina in modo anomalo.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// tryLockFile acquisisce il lock esclusivo su f senza attendere: se è di un
+// altro processo restituisce errLocked.
+func tryLockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile rilascia il lock acquisito con lockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// processAlive indica se esiste ancora il processo pid su questa macchina.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
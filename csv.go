@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// csvSchemaName è lo schema dei record CSV, selezionato da --csv.
+const csvSchemaName = "csv"
+
+// csvFieldSep separa i campi nella forma interna dei record CSV. Nei campi
+// il separatore, '\\', '\n' e '\r' vengono scritti come sequenze di escape,
+// così il record interno sta su una sola riga e --key lo divide in colonne.
+const csvFieldSep = "\x1f"
+
+// csvKey è una colonna di --csv-key indicata per nome, da risolvere
+// sull'intestazione.
+type csvKey struct {
+	name string
+	spec int // posizione della chiave in opts.keySpecs
+}
+
+// csvNamedKeys sono le chiavi --csv-key in attesa dell'intestazione.
+var csvNamedKeys []csvKey
+
+// csvHeader è l'intestazione letta con --header, scritta in testa all'output.
+var csvHeader struct {
+	record string
+	seen   bool
+}
+
+// configureCSV verifica le opzioni di --csv e --tsv e aggiunge a
+// opts.keySpecs le chiavi di --csv-key. Va chiamata da applyOrderOptions
+// dopo la lettura di --key.
+func configureCSV() error {
+	opts.csv = opts.schemaDef.Name == csvSchemaName
+	opts.tsv = opts.schemaDef.Name == tsvSchemaName
+	csvNamedKeys = nil
+	if opts.csvDelimiter != "," && !opts.csv {
+		return fmt.Errorf("--csv-delimiter richiede --csv")
+	}
+	if !opts.csv && !opts.tsv {
+		if opts.header || len(opts.csvKeys) > 0 {
+			return fmt.Errorf("--header e --csv-key richiedono --csv o --tsv")
+		}
+		return nil
+	}
+	if opts.fieldSep != "" || opts.keyLen > 0 {
+		return fmt.Errorf("--%s non è combinabile con --field-sep e --key-bytes: le colonne si indicano con --key o --csv-key", opts.schemaDef.Name)
+	}
+	if len(opts.csvKeys) > 0 && len(opts.keys) > 0 {
+		return fmt.Errorf("--csv-key non è combinabile con --key")
+	}
+	if opts.tsv {
+		opts.separator = tsvFieldSep
+	} else {
+		comma, err := parseFieldSep(opts.csvDelimiter)
+		if err != nil || comma == "" || strings.ContainsAny(comma, "\"\r\n\x1f") {
+			return fmt.Errorf("--csv-delimiter non valido %q: serve un solo carattere diverso da virgolette e newline", opts.csvDelimiter)
+		}
+		opts.csvComma = comma
+		opts.separator = csvFieldSep
+	}
+	for _, v := range opts.csvKeys {
+		k, name, err := parseCSVKey(v)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			if !opts.header {
+				return fmt.Errorf("--csv-key %s: i nomi di colonna richiedono --header", v)
+			}
+			csvNamedKeys = append(csvNamedKeys, csvKey{name: name, spec: len(opts.keySpecs)})
+		}
+		opts.keySpecs = append(opts.keySpecs, k)
+	}
+	return nil
+}
+
+// parseCSVKey interpreta una chiave COL[:TIPO][:desc] di --csv-key. COL è il
+// numero della colonna (da 1) o il suo nome nell'intestazione, restituito in
+// name e risolto da resolveCSVKeys. Senza tipo né desc la chiave eredita le
+// opzioni globali, come una chiave di --key senza opzioni.
+func parseCSVKey(v string) (k keySpec, name string, err error) {
+	parts := strings.Split(v, ":")
+	if parts[0] == "" {
+		return k, "", fmt.Errorf("--csv-key non valida %q: manca la colonna", v)
+	}
+	if n, err := strconv.Atoi(parts[0]); err == nil {
+		if n <= 0 {
+			return k, "", fmt.Errorf("--csv-key non valida %q: le colonne partono da 1", v)
+		}
+		k.startField, k.endField = n, n
+	} else {
+		name = parts[0]
+	}
+	for _, hint := range parts[1:] {
+		switch hint {
+		case "text":
+		case "nocase":
+			k.ignoreCase = true
+		case "num":
+			k.numeric = true
+		case "human":
+			k.human = true
+		case "version":
+			k.version = true
+		case "month":
+			k.month = true
+		case "desc":
+			k.reverse = true
+		default:
+			return k, "", fmt.Errorf("--csv-key non valida %q: tipo %q sconosciuto (attesi text, nocase, num, human, version, month e desc)", v, hint)
+		}
+		k.hasOpts = true
+	}
+	return k, name, nil
+}
+
+// resolveCSVKeys assegna alle chiavi indicate per nome la loro colonna
+// nell'intestazione.
+func resolveCSVKeys(header string) error {
+	fields := strings.Split(header, tsvFieldSep)
+	if opts.csv {
+		fields = csvFields(header)
+	}
+	for _, ck := range csvNamedKeys {
+		col := 0
+		for i, f := range fields {
+			if f == ck.name {
+				col = i + 1
+				break
+			}
+		}
+		if col == 0 {
+			return fmt.Errorf("--csv-key: colonna %q assente nell'intestazione", ck.name)
+		}
+		opts.keySpecs[ck.spec].startField, opts.keySpecs[ck.spec].endField = col, col
+	}
+	return nil
+}
+
+// skipCSVHeader indica se il record s è un'intestazione da togliere dai dati.
+// Con --header il primo record dell'input è l'intestazione: viene conservata
+// per l'output e usata per risolvere i nomi di --csv-key. Con più input le
+// intestazioni identiche dei file successivi vengono scartate.
+func skipCSVHeader(s string) (bool, error) {
+	if !opts.header {
+		return false, nil
+	}
+	if !csvHeader.seen {
+		csvHeader.record, csvHeader.seen = s, true
+		return true, resolveCSVKeys(s)
+	}
+	return s == csvHeader.record, nil
+}
+
+// csvScan segue lo stato delle virgolette di un record CSV letto a righe,
+// per capire se un newline chiude il record o fa parte di un campo.
+type csvScan struct {
+	quoted  bool // dentro un campo tra virgolette
+	started bool // il campo corrente ha già dei caratteri
+}
+
+// feed avanza lo stato sui byte di b. Come in encoding/csv con LazyQuotes,
+// una virgoletta all'interno di un campo senza virgolette è un carattere normale.
+func (c *csvScan) feed(b []byte) {
+	comma := []byte(opts.csvComma)
+	for i := 0; i < len(b); i++ {
+		switch {
+		case c.quoted:
+			if b[i] == '"' {
+				if i+1 < len(b) && b[i+1] == '"' {
+					i++
+				} else {
+					c.quoted = false
+				}
+			}
+		case b[i] == '"' && !c.started:
+			c.quoted, c.started = true, true
+		case b[i] == '\n':
+			c.started = false
+		case bytes.HasPrefix(b[i:], comma):
+			c.started = false
+			i += len(comma) - 1
+		default:
+			c.started = true
+		}
+	}
+}
+
+// joinQuoted completa un record CSV il cui ultimo campo tra virgolette
+// contiene dei newline, leggendo le righe successive fino alla virgoletta di
+// chiusura. line è la prima riga del record.
+func (rr *recordReader) joinQuoted(line []byte, err error) ([]byte, error) {
+	var sc csvScan
+	sc.feed(line)
+	if !sc.quoted || err != nil {
+		return line, err
+	}
+	rr.csvBuf = append(rr.csvBuf[:0], line...)
+	for sc.quoted && err == nil {
+		line, err = rr.read()
+		line = normalizeEOL(line)
+		sc.feed(line)
+		rr.csvBuf = append(rr.csvBuf, line...)
+		// Una virgoletta mai chiusa non deve portare in memoria tutto l'input.
+		if rr.maxBytes > 0 && len(rr.csvBuf) > rr.maxBytes {
+			return nil, fmt.Errorf("record CSV all'offset %d oltre %d byte: virgolette non chiuse?", rr.offset-int64(len(rr.csvBuf)), rr.maxBytes)
+		}
+	}
+	return rr.csvBuf, err
+}
+
+// csvRecord converte un record CSV nella forma interna. Le righe vuote e i
+// record con virgolette non chiuse o seguite da altro testo vengono scartati.
+func csvRecord(line []byte) (string, bool) {
+	s := strings.TrimSuffix(string(line), "\n")
+	s = strings.TrimSuffix(s, "\r")
+	if s == "" {
+		return "", false
+	}
+	comma := opts.csvComma
+	var b strings.Builder
+	b.Grow(len(s))
+	for first := true; ; first = false {
+		if !first {
+			b.WriteString(csvFieldSep)
+		}
+		if !strings.HasPrefix(s, `"`) {
+			i := strings.Index(s, comma)
+			if i < 0 {
+				writeCSVEscaped(&b, s)
+				return b.String(), true
+			}
+			writeCSVEscaped(&b, s[:i])
+			s = s[i+len(comma):]
+			continue
+		}
+		// Campo tra virgolette: "" vale una virgoletta e, come in
+		// encoding/csv, \r\n all'interno del campo diventa \n.
+		s = s[1:]
+		for {
+			i := strings.IndexByte(s, '"')
+			if i < 0 {
+				return "", false
+			}
+			writeCSVEscaped(&b, strings.ReplaceAll(s[:i], "\r\n", "\n"))
+			s = s[i+1:]
+			if !strings.HasPrefix(s, `"`) {
+				break
+			}
+			b.WriteByte('"')
+			s = s[1:]
+		}
+		switch {
+		case s == "":
+			return b.String(), true
+		case strings.HasPrefix(s, comma):
+			s = s[len(comma):]
+		default:
+			return "", false
+		}
+	}
+}
+
+// writeCSVEscaped scrive il campo f in b con le sequenze di escape della forma interna.
+func writeCSVEscaped(b *strings.Builder, f string) {
+	for i := 0; i < len(f); i++ {
+		switch f[i] {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case csvFieldSep[0]:
+			b.WriteString(`\u`)
+		default:
+			b.WriteByte(f[i])
+		}
+	}
+}
+
+// csvUnescape restituisce il valore originale di un campo della forma interna.
+func csvUnescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'u':
+			b.WriteString(csvFieldSep)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// csvFields restituisce i campi di un record nella forma interna.
+func csvFields(record string) []string {
+	fields := strings.Split(record, csvFieldSep)
+	for i, f := range fields {
+		fields[i] = csvUnescape(f)
+	}
+	return fields
+}
+
+// formatCSV riscrive un record come riga CSV. I campi vengono racchiusi tra
+// virgolette solo quando serve, come fa encoding/csv.
+func formatCSV(record string) string {
+	var b strings.Builder
+	b.Grow(len(record) + 8)
+	for i, f := range csvFields(record) {
+		if i > 0 {
+			b.WriteString(opts.csvComma)
+		}
+		if !csvNeedsQuotes(f) {
+			b.WriteString(f)
+			continue
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.ReplaceAll(f, `"`, `""`))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// csvNeedsQuotes indica se il campo va scritto tra virgolette.
+func csvNeedsQuotes(f string) bool {
+	if f == "" {
+		return false
+	}
+	if strings.ContainsAny(f, "\"\r\n") || strings.Contains(f, opts.csvComma) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(f)
+	return r == ' ' || r == '\t'
+}
+
+func init() {
+	// Record CSV (RFC 4180), anche su più righe: vedi --csv.
+	schema.Register(schema.Schema{Name: csvSchemaName, Parse: csvRecord, Format: formatCSV})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCSVRecords(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		input string
+		want  string
+	}{
+		{
+			"newline e virgolette nei campi",
+			[]string{"--csv", "--csv-key", "1:num"},
+			"3,\"riga uno\nriga due\"\n1,semplice\n2,\"a \"\"citato\"\", con virgola\"\n",
+			"1,semplice\n2,\"a \"\"citato\"\", con virgola\"\n3,\"riga uno\nriga due\"\n",
+		},
+		{
+			"newline nell'ultimo campo",
+			[]string{"--csv", "--csv-key", "2"},
+			"1,\"b\n\nfine\"\n2,a\n3,\"\n\"\n",
+			"3,\"\n\"\n2,a\n1,\"b\n\nfine\"\n",
+		},
+		{
+			"CRLF tra virgolette",
+			[]string{"--csv", "--csv-key", "1:num"},
+			"2,\"x\r\ny\"\r\n1,z\r\n",
+			"1,z\r\n2,\"x\ny\"\r\n",
+		},
+		{
+			"virgolette solo dove servono",
+			[]string{"--csv", "--csv-key", "1"},
+			"b,\"senza\"\na,\" spazio\"\nc,\"\"\n",
+			"a,\" spazio\"\nb,senza\nc,\n",
+		},
+		{
+			"separatore punto e virgola",
+			[]string{"--csv", "--csv-delimiter", ";", "--csv-key", "2:num"},
+			"a;10;\"x;y\"\nb;9;\"z,w\"\n",
+			"b;9;z,w\na;10;\"x;y\"\n",
+		},
+		{
+			"intestazione per nome",
+			[]string{"--csv", "--header", "--csv-key", "prezzo:num", "--csv-key", "nome"},
+			"nome,prezzo\nmela,10\n\"pera\nabate\",9\nkiwi,10\nfico,-1.5\n",
+			"nome,prezzo\nfico,-1.5\n\"pera\nabate\",9\nkiwi,10\nmela,10\n",
+		},
+		{
+			"intestazione su più righe",
+			[]string{"--csv", "--header", "--csv-key", "valore\ncorrente:num:desc"},
+			"\"valore\ncorrente\"\n2\n10\n1\n",
+			"\"valore\ncorrente\"\n10\n2\n1\n",
+		},
+		{
+			"chiave num e chiave testo",
+			[]string{"--csv", "--csv-key", "1:num", "--csv-key", "2:desc"},
+			"10,a\n9,b\n10,c\n-3,d\n,e\n1e3,f\n",
+			"-3,d\n,e\n1e3,f\n9,b\n10,c\n10,a\n",
+		},
+		{
+			"chiave testo sui numeri",
+			[]string{"--csv", "--csv-key", "1"},
+			"10\n9\n-3\n",
+			"-3\n10\n9\n",
+		},
+		{
+			"skip-header conta i record",
+			[]string{"--csv", "--skip-header", "1", "--csv-key", "1:num"},
+			"\"titolo\nsu due righe\"\n3\n1\n2\n",
+			"\"titolo\nsu due righe\"\n1\n2\n3\n",
+		},
+		{
+			"skip-header e header",
+			[]string{"--csv", "--skip-header", "1", "--header", "--csv-key", "n:num"},
+			"# esportato il 1/1\nn,nome\n2,b\n1,a\n",
+			"# esportato il 1/1\nn,nome\n1,a\n2,b\n",
+		},
+		{
+			"tsv",
+			[]string{"--tsv", "--header", "--csv-key", "n:num"},
+			"n\tnome\n2\t\"b\"\n1\ta\n",
+			"n\tnome\n1\ta\n2\t\"b\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "in.csv"), []byte(tt.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mustRunSorter(t, dir, append(tt.args, "--input", "in.csv", "--output", "out.csv")...)
+			got, err := os.ReadFile(filepath.Join(dir, "out.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output:\n%q\natteso:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCSVHeaderMultipleInputs verifica che con --header l'intestazione dei
+// file successivi, se identica, non finisca tra i dati.
+func TestCSVHeaderMultipleInputs(t *testing.T) {
+	dir := t.TempDir()
+	a := writeLines(t, dir, "a.csv", []string{"id,nome", "3,c", "1,a"})
+	b := writeLines(t, dir, "b.csv", []string{"id,nome", "2,b"})
+	mustRunSorter(t, dir, "--csv", "--header", "--csv-key", "id:num", "--input", a, "--input", b, "--output", "out.csv")
+	equalLines(t, "output", readLines(t, filepath.Join(dir, "out.csv")), []string{"id,nome", "1,a", "2,b", "3,c"})
+}
+
+func TestCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"colonna assente", []string{"--csv", "--header", "--csv-key", "costo"}, `colonna "costo" assente nell'intestazione`},
+		{"nome senza header", []string{"--csv", "--csv-key", "nome"}, "i nomi di colonna richiedono --header"},
+		{"colonna zero", []string{"--csv", "--csv-key", "0"}, "le colonne partono da 1"},
+		{"tipo sconosciuto", []string{"--csv", "--csv-key", "1:data"}, `tipo "data" sconosciuto`},
+		{"con --key", []string{"--csv", "--csv-key", "1", "--key", "2"}, "--csv-key non è combinabile con --key"},
+		{"delimitatore virgolette", []string{"--csv", "--csv-delimiter", `"`}, "--csv-delimiter non valido"},
+		{"header senza csv", []string{"--header"}, "--header e --csv-key richiedono --csv o --tsv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeLines(t, dir, "in.csv", []string{"nome,prezzo", "mela,1"})
+			out, err := runSorter(t, dir, append(tt.args, "--input", "in.csv", "--output", "out.csv")...)
+			if err == nil || !strings.Contains(out, tt.want) {
+				t.Errorf("errore %v, l'output non contiene %q:\n%s", err, tt.want, out)
+			}
+		})
+	}
+}
+
+// TestCSVChunks confronta l'ordinamento di record CSV casuali, con
+// separatori, virgolette e newline nei campi, con quello di encoding/csv e
+// sort.SliceStable, in memoria e attraverso i chunk.
+func TestCSVChunks(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	pieces := []string{"a", "Z", ",", `"`, "\n", " ", "é", "x,y", `""`, "\r\n"}
+	records := [][]string{{"id", "valore", "nota"}}
+	for i := 0; i < 3000; i++ {
+		var nota strings.Builder
+		for j := rng.Intn(6); j > 0; j-- {
+			nota.WriteString(pieces[rng.Intn(len(pieces))])
+		}
+		records = append(records, []string{fmt.Sprintf("r%04d", i), strconv.Itoa(rng.Intn(200) - 100), nota.String()})
+	}
+	var input strings.Builder
+	w := csv.NewWriter(&input)
+	w.WriteAll(records)
+
+	// encoding/csv riporta \r\n tra virgolette a \n, come --csv.
+	parsed, err := csv.NewReader(strings.NewReader(input.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := parsed[1:]
+	sort.SliceStable(data, func(i, j int) bool {
+		a, _ := strconv.Atoi(data[i][1])
+		b, _ := strconv.Atoi(data[j][1])
+		if a != b {
+			return a > b
+		}
+		return data[i][0] < data[j][0]
+	})
+	var want strings.Builder
+	w = csv.NewWriter(&want)
+	w.WriteAll(parsed)
+
+	for _, args := range [][]string{nil, {"--chunk-bytes", "4000"}} {
+		t.Run(strings.Join(append([]string{"csv"}, args...), " "), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "in.csv"), []byte(input.String()), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mustRunSorter(t, dir, append(args, "--csv", "--header", "--csv-key", "valore:num:desc", "--csv-key", "id", "--input", "in.csv", "--output", "out.csv")...)
+			got, err := os.ReadFile(filepath.Join(dir, "out.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want.String() {
+				gl, wl := strings.Split(string(got), "\n"), strings.Split(want.String(), "\n")
+				for i := range min(len(gl), len(wl)) {
+					if gl[i] != wl[i] {
+						t.Fatalf("riga %d dell'output = %q, attesa %q", i+1, gl[i], wl[i])
+					}
+				}
+				t.Fatalf("output di %d righe, attese %d", len(gl), len(wl))
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// directFallback segnala una sola volta che --direct-io non è disponibile.
+var directFallback sync.Once
+
+// createChunk crea un file temporaneo dell'ordinamento (chunk dello split o
+// run intermedio). Con --direct-io la scrittura scavalca la page cache: i
+// dati intermedi, letti una sola volta dal merge, non spingono fuori dalla
+// cache l'input e il resto del sistema. Se il filesystem non lo supporta si
+// torna alla scrittura normale.
+func createChunk(path string) (io.WriteCloser, error) {
+	trackTemp(path)
+	if !opts.directIO {
+		return createRetry(path)
+	}
+	w, err := createDirect(path)
+	if err == errDirectUnsupported {
+		directFallback.Do(func() {
+			fmt.Fprintf(status, "⚠️  --direct-io non supportato per %s: i chunk passano dalla page cache\n", path)
+		})
+		return createRetry(path)
+	}
+	return w, err
+}
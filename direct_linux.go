@@ -0,0 +1,114 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Con O_DIRECT indirizzo del buffer, lunghezza delle scritture e offset nel
+// file devono essere multipli della dimensione dei blocchi del dispositivo:
+// 4096 copre i dischi a settori da 512 byte e da 4 KB.
+const (
+	directAlign   = 4096
+	directBufSize = 1 << 20 // byte accumulati prima di ogni scrittura
+)
+
+// errDirectUnsupported indica che il filesystem non accetta O_DIRECT (tmpfs, alcuni overlay).
+var errDirectUnsupported = errors.New("O_DIRECT non supportato")
+
+// directFile scrive un file con O_DIRECT accumulando i dati in un buffer
+// allineato e scrivendo solo blocchi interi. La coda finale, più corta di un
+// blocco, viene scritta in Close dopo aver tolto O_DIRECT dal descrittore.
+type directFile struct {
+	f   *os.File
+	buf []byte // allineato a directAlign
+	n   int    // byte in attesa in buf
+}
+
+// createDirect crea path per la scrittura con O_DIRECT.
+func createDirect(path string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, 0666)
+	if errors.Is(err, syscall.EINVAL) {
+		return nil, errDirectUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &directFile{f: f, buf: alignedBuffer(directBufSize)}, nil
+}
+
+// alignedBuffer alloca n byte che iniziano a un indirizzo multiplo di directAlign.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directAlign)
+	off := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	return b[off : off+n]
+}
+
+// Write accumula p nel buffer e lo scrive ogni volta che è pieno. Se la
+// scrittura fallisce i dati restano nel buffer e n indica quanto di p è
+// stato accettato, così spaceWriter può riprovare dopo un disco pieno.
+func (d *directFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if d.n == len(d.buf) {
+			if err := d.flush(d.n); err != nil {
+				return written, err
+			}
+		}
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		written += c
+	}
+	return written, nil
+}
+
+// flush scrive i primi n byte del buffer e sposta in testa quelli rimasti.
+func (d *directFile) flush(n int) error {
+	w, err := d.f.Write(d.buf[:n])
+	copy(d.buf, d.buf[w:d.n])
+	d.n -= w
+	return err
+}
+
+// Close scrive i blocchi interi rimasti, poi la coda senza O_DIRECT, e chiude il file.
+func (d *directFile) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	var err error
+	if full := d.n &^ (directAlign - 1); full > 0 {
+		err = d.flush(full)
+	}
+	if err == nil && d.n > 0 {
+		if err = clearDirect(d.f); err == nil {
+			err = d.flush(d.n)
+		}
+	}
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	d.f = nil
+	return err
+}
+
+// clearDirect toglie O_DIRECT dai flag del descrittore di f.
+func clearDirect(f *os.File) error {
+	fd := f.Fd()
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	if errno != 0 {
+		return errno
+	}
+	if _, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFL, flags&^syscall.O_DIRECT); errno != 0 {
+		return errno
+	}
+	return nil
+}
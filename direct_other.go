@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// errDirectUnsupported indica che --direct-io non è disponibile.
+var errDirectUnsupported = errors.New("O_DIRECT non supportato")
+
+// createDirect non è implementata fuori da Linux: i chunk vengono scritti
+// con la page cache.
+func createDirect(path string) (io.WriteCloser, error) {
+	return nil, errDirectUnsupported
+}
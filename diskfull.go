@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// diskFullRetry è l'intervallo tra i tentativi di scrittura con --disk-full-wait.
+const diskFullRetry = 10 * time.Second
+
+// diskPlan stima lo spazio su disco richiesto dall'esecuzione, per indicare
+// nei messaggi di disco pieno quanto spazio serve ancora.
+var diskPlan struct {
+	expect   int64  // byte che l'esecuzione scriverà in tutto (0 = sconosciuti)
+	written  int64  // byte scritti finora tramite spaceWriter (atomico)
+	chunkDir string // directory dei chunk, conservati anche in caso di errore
+}
+
+// diskFullMu serializza le attese per disco pieno: i writer bloccati
+// contemporaneamente non sovrappongono i loro messaggi.
+var diskFullMu sync.Mutex
+
+// diskFullReported indica che il disco pieno è già stato segnalato: senza
+// --disk-full-wait gli altri worker terminano senza ripetere il messaggio.
+var diskFullReported bool
+
+// spaceWriter scrive su w riconoscendo il disco pieno (ENOSPC). Invece di
+// restituire subito l'errore, che farebbe perdere i chunk già completati,
+// segnala su stderr lo spazio libero e quello ancora necessario e, con
+// --disk-full-wait, riprova finché lo spazio non viene liberato.
+// Va posto sotto il bufio.Writer, che dopo un errore non accetta altre scritture.
+type spaceWriter struct {
+	w    io.Writer
+	path string // file in scrittura, per i messaggi
+}
+
+func (s *spaceWriter) Write(p []byte) (int, error) {
+	written, attempt := 0, 0
+	var deadline time.Time
+	for {
+		n, err := s.w.Write(p[written:])
+		written += n
+		atomic.AddInt64(&diskPlan.written, int64(n))
+		// Gli errori transitori (--io-retries) ripetono la scrittura del resto.
+		if err != nil && !errors.Is(err, syscall.ENOSPC) {
+			attempt++
+			if retryWait("scrittura di "+s.path, err, attempt) {
+				continue
+			}
+		}
+		if err == nil || !errors.Is(err, syscall.ENOSPC) {
+			return written, err
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(opts.diskFullWait)
+		}
+		if !waitForSpace(s.path, deadline) {
+			return written, fmt.Errorf("disco pieno scrivendo %s: %w", s.path, err)
+		}
+	}
+}
+
+// waitForSpace segnala il disco pieno durante la scrittura di path e, se
+// deadline non è passata, attende un intervallo prima del nuovo tentativo.
+// Restituisce false quando bisogna rinunciare.
+func waitForSpace(path string, deadline time.Time) bool {
+	diskFullMu.Lock()
+	defer diskFullMu.Unlock()
+	if diskFullReported && opts.diskFullWait == 0 {
+		return false
+	}
+	diskFullReported = true
+
+	dir := filepath.Dir(path)
+	msg := fmt.Sprintf("⚠️  Disco pieno scrivendo %s", path)
+	if free, total, err := diskUsage(dir); err == nil {
+		msg += fmt.Sprintf(": liberi %s su %s", formatBytes(free), formatBytes(total))
+	}
+	if used := dirSize(diskPlan.chunkDir); used > 0 {
+		msg += fmt.Sprintf(", chunk già scritti %s in %s", formatBytes(used), diskPlan.chunkDir)
+	}
+	if need := diskPlan.expect - atomic.LoadInt64(&diskPlan.written); diskPlan.expect > 0 && need > 0 {
+		msg += fmt.Sprintf(", servono ancora circa %s", formatBytes(need))
+	}
+	fmt.Fprintln(os.Stderr, msg)
+
+	if !time.Now().Before(deadline) {
+		if opts.diskFullWait == 0 {
+			fmt.Fprintln(os.Stderr, "   Libera spazio o usa --chunk-dir/--output su un altro disco; con --disk-full-wait l'esecuzione attende invece di terminare.")
+		}
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "   In attesa di spazio libero fino a %s (nuovo tentativo ogni %s)...\n", deadline.Format("15:04:05"), diskFullRetry)
+	wait := time.Until(deadline)
+	if wait > diskFullRetry {
+		wait = diskFullRetry
+	}
+	time.Sleep(wait)
+	return true
+}
+
+// checkDiskSpace verifica prima dello split che i file system dei chunk e
+// dell'output abbiano lo spazio richiesto: i chunk occupano circa quanto
+// l'input, l'output altrettanto, e i chunk restano su disco fino alla fine
+// del merge. Se lo spazio manca restituisce subito un errore invece di
+// fermarsi con il disco pieno a metà del merge. Le stime impossibili (stdin,
+// input o chunk compressi, output compresso, remoto o su stdout) si saltano.
+func checkDiskSpace(chunkDir, outputFile string) error {
+	size := inputSize(opts.inputs)
+	if size == 0 {
+		return nil
+	}
+	for _, in := range opts.inputs {
+		if compressedInput(in) {
+			return nil
+		}
+	}
+	type need struct {
+		dir, what string
+		bytes     int64
+	}
+	var needs []need
+	if opts.chunkCompress == chunkCompressNone {
+		needs = append(needs, need{chunkDir, "i chunk", size})
+	}
+	if dir := outputSpaceDir(outputFile); dir != "" && opts.outCompression == compressNone {
+		needs = append(needs, need{dir, "l'output", size})
+	}
+	// Sullo stesso file system chunk e output si sommano.
+	if len(needs) == 2 && sameFilesystem(needs[0].dir, needs[1].dir) {
+		needs = []need{{needs[0].dir, "chunk e output", 2 * size}}
+	}
+	for _, n := range needs {
+		free, _, err := diskUsage(n.dir)
+		if err != nil || free >= n.bytes {
+			continue
+		}
+		return fmt.Errorf("spazio insufficiente in %s: servono circa %s per %s, liberi %s\n"+
+			"   Libera spazio, usa --chunk-dir/--output su un altro disco o --chunk-compression; --space-check=false salta la verifica.",
+			n.dir, formatBytes(n.bytes), n.what, formatBytes(free))
+	}
+	return nil
+}
+
+// outputSpaceDir restituisce la directory esistente in cui finirà l'output
+// del merge, o una stringa vuota se l'output non occupa il disco locale.
+func outputSpaceDir(outputFile string) string {
+	dir := filepath.Dir(outputFile)
+	switch {
+	case opts.runSet != "":
+		return ""
+	case opts.partitionKey != nil || opts.partitionBy != "":
+		dir = opts.partitionDir
+	case outputFile == "-":
+		return ""
+	}
+	if _, ok, _ := parseObjectURL(outputFile); ok {
+		return ""
+	}
+	// La directory può non esistere ancora: conta il file system del
+	// primo antenato esistente.
+	for {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// dirSize restituisce la dimensione totale dei file in dir (0 se non leggibile).
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// inputSize restituisce la dimensione complessiva dei file di input, o 0 se
+// non è nota (stdin o file non regolari).
+func inputSize(paths []string) int64 {
+	var total int64
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if p == "-" || err != nil || !fi.Mode().IsRegular() {
+			return 0
+		}
+		total += fi.Size()
+	}
+	return total
+}
+
+// formatBytes rende leggibile una dimensione in byte (es. 1.5 GB).
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
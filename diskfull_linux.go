@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// diskUsage restituisce lo spazio disponibile e la capacità del filesystem
+// che contiene path.
+func diskUsage(path string) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
+
+// sameFilesystem indica se a e b stanno sullo stesso file system.
+func sameFilesystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// diskUsage non è implementata fuori da Linux: i messaggi di disco pieno
+// non riportano lo spazio libero.
+func diskUsage(path string) (free, total int64, err error) {
+	return 0, 0, errors.New("non supportato")
+}
+
+// sameFilesystem non è implementata fuori da Linux: senza diskUsage la
+// verifica dello spazio non si fa comunque.
+func sameFilesystem(a, b string) bool {
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// edgeStats riassume lo stream ordinato attraversato da --edges.
+type edgeStats struct {
+	records  int64 // record attraversati dal merge
+	distinct int64 // chiavi distinte
+	bytes    int64 // byte dei record, esclusi i terminatori di riga
+	minLen   int   // lunghezza del record più corto
+	maxLen   int   // lunghezza del record più lungo
+}
+
+// mergeEdges esegue il merge dei file ordinati senza scrivere l'output
+// completo: conserva solo i primi e gli ultimi n record, che scrive su
+// outputFile, e riporta su status le statistiche dell'intero stream.
+// La coda degli ultimi n record è un buffer circolare, quindi la memoria
+// usata dipende da n e non dalla dimensione dell'input.
+func mergeEdges(files []string, outputFile string, n int) error {
+	runs, cleanup, err := cascadeMerge(files)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	m, err := newChunkMerger(runs)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	head := make([]string, 0, n)
+	tail := make([]string, n)
+	var st edgeStats
+	var lastKey string
+	for {
+		item, ok := m.next()
+		if !ok {
+			break
+		}
+		if st.records == 0 || item.key != lastKey {
+			st.distinct++
+			lastKey = item.key
+		}
+		l := len(item.value)
+		if st.records == 0 || l < st.minLen {
+			st.minLen = l
+		}
+		if l > st.maxLen {
+			st.maxLen = l
+		}
+		st.bytes += int64(l)
+		if len(head) < n {
+			head = append(head, item.value)
+		} else {
+			tail[(st.records-int64(n))%int64(n)] = item.value
+		}
+		st.records++
+	}
+	if err := m.Err(); err != nil {
+		return err
+	}
+
+	out, err := createOutput(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	comp, err := compressOutput(out)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriterSize(comp, opts.writerBuf)
+	for _, s := range head {
+		writeOutput(writer, formatRecord(s))
+	}
+	// I record oltre i primi n sono nel buffer circolare: se sono più di n
+	// il più vecchio si trova alla posizione successiva all'ultimo scritto.
+	rest := st.records - int64(len(head))
+	if rest > int64(n) {
+		rest = int64(n)
+	}
+	first := (st.records - int64(n) - rest) % int64(n)
+	for i := int64(0); i < rest; i++ {
+		writeOutput(writer, formatRecord(tail[(first+i)%int64(n)]))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := comp.Close(); err != nil {
+		return err
+	}
+	if err := commitOutput(out); err != nil {
+		return err
+	}
+
+	omitted := st.records - int64(len(head)) - rest
+	fmt.Fprintf(status, "📝 Primi %d e ultimi %d record scritti in %s (%d omessi)\n", len(head), rest, outputFile, omitted)
+	if st.records > 0 {
+		fmt.Fprintf(status, "📊 %d record, %d chiavi distinte, lunghezza min/media/max %d/%.1f/%d byte\n",
+			st.records, st.distinct, st.minLen, float64(st.bytes)/float64(st.records), st.maxLen)
+	}
+	return nil
+}
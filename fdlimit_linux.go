@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// openFileLimit porta il limite soft dei file aperti (RLIMIT_NOFILE) al
+// limite hard e restituisce quello in vigore.
+func openFileLimit() (limit uint64, ok bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	if rl.Cur < rl.Max {
+		raised := rl
+		raised.Cur = rl.Max
+		if syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised) == nil {
+			rl = raised
+		}
+	}
+	return rl.Cur, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// openFileLimit non è implementata fuori da Linux: il fan-in del merge
+// resta quello di --merge-fan-in o del profilo I/O.
+func openFileLimit() (limit uint64, ok bool) {
+	return 0, false
+}
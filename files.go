@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// inputRecord normalizza un record letto dall'input e indica se va ordinato.
+// Vengono mantenute solo le righe di strLength caratteri, spazi esclusi.
+// È il parser dello schema fixed32.
+func inputRecord(line []byte) (string, bool) {
+	clean, ok := inputSlice(line)
+	return string(clean), ok
+}
+
+// inputSlice è inputRecord senza allocazione.
+func inputSlice(line []byte) ([]byte, bool) {
+	clean := bytes.TrimSpace(line)
+	if len(clean) != strLength {
+		return nil, false
+	}
+	return clean, true
+}
+
+// multiFile concatena più file di input in un unico flusso.
+type multiFile struct {
+	io.Reader
+	files    []*os.File
+	decoders []io.Closer // decompressori da chiudere (zstd)
+}
+
+// Close chiude tutti i file aperti da openInputs (stdin escluso) e i loro decompressori.
+func (m *multiFile) Close() error {
+	for _, d := range m.decoders {
+		d.Close()
+	}
+	var first error
+	for _, f := range m.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openInputs apre i file di input in sequenza; "-" indica lo standard input.
+// Gli input compressi vengono decompressi secondo --input-compression e
+// convertiti in testo da inputText. Ogni file termina con un separatore
+// (recordSep) anche se manca nel file, così l'ultimo record di un file non
+// viene unito al primo del successivo.
+func openInputs(paths []string) (*multiFile, error) {
+	m := &multiFile{}
+	readers := make([]io.Reader, 0, len(paths))
+	for _, p := range paths {
+		if p == "-" {
+			r, err := decompress(os.Stdin, p)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			m.addDecoder(r)
+			readers = append(readers, terminated(inputText(r, p)))
+			continue
+		}
+		f, err := openRetry(p)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		r, err := decompress(&retryReader{r: f, name: p}, p)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		m.addDecoder(r)
+		readers = append(readers, terminated(inputText(r, p)))
+	}
+	m.Reader = io.MultiReader(readers...)
+	return m, nil
+}
+
+// inputText restituisce il flusso da cui leggere i record di r, letto dal
+// file name: i file Avro di --avro vengono convertiti in righe JSON, gli
+// altri input passano da stripBOM.
+func inputText(r io.Reader, name string) io.Reader {
+	if opts.avro {
+		return newAvroReader(r, name)
+	}
+	return stripBOM(r)
+}
+
+// addDecoder registra r tra i decompressori da chiudere, se ne ha bisogno.
+func (m *multiFile) addDecoder(r io.Reader) {
+	if c, ok := r.(io.Closer); ok {
+		m.decoders = append(m.decoders, c)
+	}
+}
+
+// terminated restituisce r tramite un eolReader. I record binari di
+// --record-size e --record-framing non hanno separatori e restano invariati.
+func terminated(r io.Reader) io.Reader {
+	if binaryRecords() {
+		return r
+	}
+	return &eolReader{r: r}
+}
+
+// eolReader restituisce il flusso di r aggiungendo un separatore finale se manca.
+// È l'unico punto in cui lo split tratta un ultimo record non terminato: a
+// valle ogni record, compreso l'ultimo, termina con recordSep.
+type eolReader struct {
+	r    io.Reader
+	last byte // ultimo byte letto
+	seen bool // il flusso non è vuoto
+	eof  bool // r è esaurito
+	done bool // il separatore finale, se serviva, è stato emesso
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+	if e.done {
+		return 0, io.EOF
+	}
+	if !e.eof {
+		n, err := e.r.Read(p)
+		if n > 0 {
+			e.last, e.seen = p[n-1], true
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		e.eof = true
+		if n > 0 {
+			return n, nil
+		}
+	}
+	e.done = true
+	if e.last == recordSep || len(p) == 0 || !e.seen {
+		return 0, io.EOF
+	}
+	p[0] = recordSep
+	return 1, io.EOF
+}
+
+// createOutput crea il file di output; "-" indica lo standard output,
+// che non viene chiuso alla fine.
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if u, ok, err := parseObjectURL(path); ok {
+		if err != nil {
+			return nil, err
+		}
+		return newObjectWriter(u)
+	}
+	return createRetry(path)
+}
+
+// nopWriteCloser adatta un io.Writer che non va chiuso.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// checkSorted verifica che il file sia ordinato secondo le opzioni correnti.
+// Come GNU sort -c, segnala la prima riga fuori ordine con il suo numero;
+// con --unique anche due chiavi uguali consecutive sono un errore.
+func checkSorted(path string) error {
+	in, err := openInputs([]string{path})
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// La verifica non scrive file: i record deviati vengono confrontati troncati.
+	policy := opts.oversizePolicy
+	if policy == oversizeDivert {
+		policy = oversizeTruncate
+	}
+	reader := newRecordReader(bufio.NewReader(in), opts.maxRecordBytes, policy, nil)
+	var prev, prevKey string
+	have := false
+	for n := 1; ; n++ {
+		line, err := reader.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		advanceProgress(len(line))
+		s, ok := opts.schemaDef.Parse(line)
+		if holdHeader(line) {
+			ok = false
+		} else if ok {
+			header, err := skipCSVHeader(s)
+			if err != nil {
+				return err
+			}
+			ok = !header
+		}
+		if ok {
+			key := sortKey(s)
+			if have && lessSeq(key, s, 1, prevKey, prev, 0) {
+				return fmt.Errorf("%s:%d: disordine: %s", path, n, formatRecord(s))
+			}
+			if have && opts.unique && key == prevKey {
+				return fmt.Errorf("%s:%d: duplicato: %s", path, n, formatRecord(s))
+			}
+			prev, prevKey, have = s, key, true
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestUnterminatedFinalRecord verifica che un ultimo record senza
+// separatore resti intatto e al suo posto in ogni percorso di lettura: lo
+// split con il merge dei chunk, --merge con e senza mmap, --zero-terminated
+// e gli input compressi. L'output ha sempre il separatore finale.
+func TestUnterminatedFinalRecord(t *testing.T) {
+	lines := testLines(3000)
+	first, second := lines[:1500], lines[1500:]
+	want := sortedCopy(lines)
+	tests := []struct {
+		name  string
+		files [][]string // contenuto degli input, ordinato con --merge
+		sep   string
+		gzip  bool
+		args  []string
+	}{
+		{name: "split e merge", files: [][]string{first, second}, sep: "\n",
+			args: []string{"--chunk-bytes", "20000", "--in-memory=false"}},
+		{name: "split a pipeline", files: [][]string{first, second}, sep: "\n",
+			args: []string{"--chunk-bytes", "20000", "--in-memory=false", "--parse-workers", "2"}},
+		{name: "in memoria", files: [][]string{first, second}, sep: "\n"},
+		{name: "già ordinato", files: [][]string{want}, sep: "\n"},
+		{name: "merge mmap", files: [][]string{sortedCopy(first), sortedCopy(second)}, sep: "\n",
+			args: []string{"--merge", "--mmap=true"}},
+		{name: "merge senza mmap", files: [][]string{sortedCopy(first), sortedCopy(second)}, sep: "\n",
+			args: []string{"--merge", "--mmap=false"}},
+		{name: "zero-terminated", files: [][]string{first, second}, sep: "\x00",
+			args: []string{"--zero-terminated", "--chunk-bytes", "20000", "--in-memory=false"}},
+		{name: "gzip", files: [][]string{first, second}, sep: "\n", gzip: true,
+			args: []string{"--chunk-bytes", "20000", "--in-memory=false"}},
+		{name: "merge gzip", files: [][]string{sortedCopy(first), sortedCopy(second)}, sep: "\n", gzip: true,
+			args: []string{"--merge"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := []string{"--output", "out.txt"}
+			for i, records := range tt.files {
+				// Nessun separatore dopo l'ultimo record.
+				data := []byte(strings.Join(records, tt.sep))
+				name := filepath.Join(dir, "input"+string(rune('a'+i)))
+				if tt.gzip {
+					data = gzipBytes(t, data)
+					name += ".gz"
+				}
+				if err := os.WriteFile(name, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "--input", name)
+			}
+			mustRunSorter(t, dir, append(args, tt.args...)...)
+			got, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasSuffix(got, []byte(tt.sep)) {
+				t.Fatalf("output senza separatore finale: ...%q", got[max(0, len(got)-20):])
+			}
+			records := strings.Split(strings.TrimSuffix(string(got), tt.sep), tt.sep)
+			equalLines(t, "out.txt", records, want)
+		})
+	}
+}
+
+// gzipBytes comprime data con gzip.
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// Valori di GOGC per fase, usati solo con un budget di memoria: il limite
+// di memoria del runtime impedisce all'heap di crescere oltre il budget
+// anche con GOGC alto.
+const (
+	// Lo split alloca una stringa per riga e butta via i chunk appena
+	// scritti: con il GOGC predefinito il GC partirebbe di continuo.
+	gcPercentSplit = 400
+	// Il merge ha un heap piccolo e stabile (i buffer dei chunk): il GOGC
+	// predefinito lo tiene compatto.
+	gcPercentMerge = 100
+)
+
+// parseMemBudget interpreta --mem-budget: 0 (nessun budget), auto (la
+// memoria disponibile, vedi availableMemory) oppure una dimensione con
+// suffisso opzionale K, M o G.
+func parseMemBudget(v string) (uint64, error) {
+	if v == "auto" {
+		return availableMemory(), nil
+	}
+	n, err := parseMemSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("--mem-budget non valido: %q (attesi auto, 0 o una dimensione come 4G)", v)
+	}
+	return n, nil
+}
+
+// applyMemBudget imposta il limite di memoria del runtime al budget, così il
+// GC diventa più aggressivo solo quando l'heap si avvicina al budget invece
+// di lasciare crescere il processo fino all'OOM. GOMEMLIMIT, se impostata
+// dall'utente, ha la precedenza.
+func applyMemBudget() {
+	if opts.memBudget == 0 || os.Getenv("GOMEMLIMIT") != "" {
+		return
+	}
+	debug.SetMemoryLimit(int64(opts.memBudget))
+	fmt.Fprintf(status, "💽 Budget di memoria: %d MB (GOGC %d nello split, %d nel merge)\n",
+		opts.memBudget>>20, gcPercentSplit, gcPercentMerge)
+}
+
+// tuneGC adatta GOGC alla fase: alto nello split, che alloca molto, e
+// predefinito nel merge. Senza budget, o con GOGC impostata dall'utente, il
+// GC resta quello del runtime.
+func tuneGC(phase string) {
+	if opts.memBudget == 0 || os.Getenv("GOMEMLIMIT") != "" || os.Getenv("GOGC") != "" {
+		return
+	}
+	if strings.HasPrefix(phase, "split") {
+		debug.SetGCPercent(gcPercentSplit)
+	} else {
+		debug.SetGCPercent(gcPercentMerge)
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/afraccalvieri-ca/SithLords
+
+go 1.26.0
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.42.0
+)
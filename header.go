@@ -0,0 +1,28 @@
+package main
+
+import "bufio"
+
+// heldHeader raccoglie le prime righe dell'input trattenute da --skip-header:
+// restano fuori dall'ordinamento e vanno invariate in testa all'output.
+var heldHeader [][]byte
+
+// holdHeader trattiene line se fa parte delle prime --skip-header righe
+// dell'input e indica se l'ha trattenuta.
+func holdHeader(line []byte) bool {
+	if len(heldHeader) >= opts.skipHeader || len(line) == 0 {
+		return false
+	}
+	heldHeader = append(heldHeader, append([]byte(nil), line...))
+	return true
+}
+
+// writeHeldHeader scrive in w le righe trattenute da --skip-header. Solo
+// l'ultima riga di un input senza newline finale riceve il terminatore.
+func writeHeldHeader(w *bufio.Writer) {
+	for _, line := range heldHeader {
+		w.Write(line)
+		if line[len(line)-1] != recordSep {
+			w.WriteString(lineEnd)
+		}
+	}
+}
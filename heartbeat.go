@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress registra l'avanzamento dell'esecuzione per gli heartbeat e per il
+// rilevamento degli stalli. I byte vengono solo incrementati nei cicli di
+// lettura e scrittura: è il watcher a campionarli e a calcolare da quanto
+// tempo non cambiano, così il percorso critico non legge l'orologio.
+var progress struct {
+	bytes   int64 // byte elaborati (letti nello split, restituiti dal merge), atomico
+	mu      sync.Mutex
+	phase   string                // fase corrente, per i messaggi
+	mergers map[*chunkMerger]bool // merge in corso, per la diagnostica
+}
+
+// setPhase imposta la fase corrente riportata negli heartbeat e adatta il
+// GC alla fase.
+func setPhase(phase string) {
+	progress.mu.Lock()
+	progress.phase = phase
+	progress.mu.Unlock()
+	tuneGC(phase)
+}
+
+// advanceProgress registra n byte elaborati.
+func advanceProgress(n int) {
+	atomic.AddInt64(&progress.bytes, int64(n))
+}
+
+// trackMerger aggiunge o rimuove un merge dall'elenco riportato nella diagnostica.
+func trackMerger(m *chunkMerger, open bool) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if open {
+		if progress.mergers == nil {
+			progress.mergers = map[*chunkMerger]bool{}
+		}
+		progress.mergers[m] = true
+	} else {
+		delete(progress.mergers, m)
+	}
+}
+
+// startHeartbeat avvia il watcher dell'avanzamento. Ogni interval (se > 0)
+// scrive su status la fase corrente e i byte elaborati; se per stallAfter
+// (se > 0) i byte non avanzano segnala su stderr un probabile stallo e scrive
+// un file di diagnostica in dir, una sola volta finché l'avanzamento non riprende.
+func startHeartbeat(interval, stallAfter time.Duration, dir string) {
+	if interval <= 0 && stallAfter <= 0 {
+		return
+	}
+	go func() {
+		start := time.Now()
+		lastBytes, lastChange, lastBeat := int64(0), start, start
+		stalled := false
+		for now := range time.Tick(time.Second) {
+			bytes := atomic.LoadInt64(&progress.bytes)
+			if bytes != lastBytes {
+				if stalled {
+					stalled = false
+					fmt.Fprintf(os.Stderr, "✅ Avanzamento ripreso dopo %s\n", now.Sub(lastChange).Round(time.Second))
+				}
+				lastBytes, lastChange = bytes, now
+			}
+			progress.mu.Lock()
+			phase := progress.phase
+			progress.mu.Unlock()
+
+			if interval > 0 && now.Sub(lastBeat) >= interval {
+				rate := float64(bytes) / now.Sub(start).Seconds()
+				fmt.Fprintf(status, "💓 [%s] fase %s: %s elaborati (%s/s), ultimo avanzamento %s fa\n",
+					now.Format("15:04:05"), phase, formatBytes(bytes), formatBytes(int64(rate)), now.Sub(lastChange).Round(time.Second))
+				lastBeat = now
+			}
+			if stallAfter > 0 && !stalled && now.Sub(lastChange) >= stallAfter {
+				stalled = true
+				path, err := writeStallDump(dir, phase, bytes, lastChange)
+				if err != nil {
+					path = "non scritta: " + err.Error()
+				}
+				fmt.Fprintf(os.Stderr, "⚠️  Nessun avanzamento da %s nella fase %s: probabile stallo. Diagnostica: %s\n",
+					now.Sub(lastChange).Round(time.Second), phase, path)
+			}
+		}
+	}()
+}
+
+// chunkState è la posizione di lettura di un chunk in un merge in corso.
+type chunkState struct {
+	File  string `json:"file"`
+	Lines int64  `json:"lines"` // righe già lette
+	EOF   bool   `json:"eof"`   // chunk esaurito
+}
+
+// mergeStates restituisce, per ogni merge in corso, la posizione di lettura
+// dei suoi chunk.
+func mergeStates() [][]chunkState {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	var states [][]chunkState
+	for m := range progress.mergers {
+		chunks := make([]chunkState, len(m.readers))
+		for i, r := range m.readers {
+			name := memoryRunPath
+			if r.file != nil {
+				name = r.file.Name()
+			}
+			chunks[i] = chunkState{File: name, Lines: atomic.LoadInt64(&r.consumed), EOF: atomic.LoadInt32(&r.eof) != 0}
+		}
+		states = append(states, chunks)
+	}
+	return states
+}
+
+// writeStallDump scrive in dir un file con lo stato dell'esecuzione: fase,
+// memoria, stato dei chunk aperti nel merge e stack di tutte le goroutine.
+func writeStallDump(dir, phase string, bytes int64, lastChange time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("stall-%s-%d.txt", time.Now().Format("20060102-150405"), os.Getpid()))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	fmt.Fprintf(f, "fase: %s\nbyte elaborati: %d\nultimo avanzamento: %s\n", phase, bytes, lastChange.Format(time.RFC3339))
+	fmt.Fprintf(f, "heap: %s in uso, %s dal sistema, %d GC\ngoroutine: %d\n\n",
+		formatBytes(int64(ms.HeapAlloc)), formatBytes(int64(ms.HeapSys)), ms.NumGC, runtime.NumGoroutine())
+
+	for _, chunks := range mergeStates() {
+		fmt.Fprintf(f, "merge di %d chunk:\n", len(chunks))
+		for _, c := range chunks {
+			state := "aperto"
+			if c.EOF {
+				state = "esaurito"
+			}
+			fmt.Fprintf(f, "  %s: %d righe lette, %s\n", c.File, c.Lines, state)
+		}
+	}
+
+	// Stack di tutte le goroutine, raddoppiando il buffer finché non basta.
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	fmt.Fprintf(f, "\ngoroutine:\n%s", buf)
+	return path, f.Close()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyEntry è una voce del registro delle sessioni: un'esecuzione con le
+// opzioni effettive, comprese quelle lasciate al valore predefinito, così può
+// essere ripetuta identica anche dopo un cambio dei default.
+type historyEntry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`  // directory di lavoro
+	Exe  string    `json:"exe"`  // eseguibile usato
+	Argv []string  `json:"argv"` // argomenti originali, per riferimento
+	Args []string  `json:"args"` // opzioni native risolte, come -nome=valore
+}
+
+// defaultHistoryFile restituisce il percorso predefinito del registro.
+func defaultHistoryFile() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "sithlords", "history.jsonl")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "sithlords", "history.jsonl")
+}
+
+// recordSession aggiunge l'esecuzione corrente al registro. Un errore di
+// scrittura del registro non deve fermare l'ordinamento: viene solo segnalato.
+func recordSession(path string) {
+	if path == "" {
+		return
+	}
+	e := historyEntry{
+		ID:   runID,
+		Time: time.Now().UTC(),
+		Argv: os.Args[1:],
+		Args: resolvedArgs(),
+	}
+	e.Dir, _ = os.Getwd()
+	if exe, err := os.Executable(); err == nil {
+		e.Exe = exe
+	}
+
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "⚠️  registro delle sessioni non aggiornato:", err)
+		return
+	}
+	fmt.Fprintf(status, "📝 Sessione %s (history --show %s)\n", e.ID, e.ID)
+}
+
+// resolvedArgs restituisce tutte le opzioni native con il loro valore
+// effettivo, impostate o meno, escluse quelle che non hanno senso da ripetere.
+func resolvedArgs() []string {
+	skip := map[string]bool{"history-file": true}
+	var args []string
+	seen := map[flag.Value]bool{}
+	flag.VisitAll(func(f *flag.Flag) {
+		// Gli alias (-k/--key, -t/--field-sep) compaiono una volta sola.
+		if skip[f.Name] || seen[f.Value] {
+			return
+		}
+		seen[f.Value] = true
+		if list, ok := f.Value.(*stringList); ok {
+			for _, v := range *list {
+				args = append(args, "-"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// runHistory gestisce il sottocomando history:
+//
+//	history [--limit N]      elenca le ultime sessioni
+//	history --show ID        stampa lo script che ripete la sessione
+//	history --replay ID      ripete la sessione
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	file := fs.String("history-file", defaultHistoryFile(), "registro delle sessioni")
+	show := fs.String("show", "", "stampa lo script che ripete la sessione indicata")
+	replay := fs.String("replay", "", "ripete la sessione indicata")
+	limit := fs.Int("limit", 20, "numero di sessioni elencate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	entries, err := readHistory(*file)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *show != "":
+		e, err := findSession(entries, *show)
+		if err != nil {
+			return err
+		}
+		fmt.Print(sessionScript(e))
+		return nil
+	case *replay != "":
+		e, err := findSession(entries, *replay)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(e.Exe, e.Args...)
+		cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = e.Dir, os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+
+	if len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %s  %s  %s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"), e.Dir, strings.Join(e.Argv, " "))
+	}
+	return nil
+}
+
+// readHistory legge tutte le voci del registro; un registro assente è vuoto.
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // voce troncata da un'esecuzione interrotta
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// findSession cerca la sessione con l'ID indicato, accettando un prefisso univoco.
+func findSession(entries []historyEntry, id string) (historyEntry, error) {
+	var found []historyEntry
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+		if strings.HasPrefix(e.ID, id) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return historyEntry{}, fmt.Errorf("sessione %q non trovata", id)
+	case 1:
+		return found[0], nil
+	}
+	return historyEntry{}, fmt.Errorf("sessione %q ambigua: %d corrispondenze", id, len(found))
+}
+
+// sessionScript restituisce uno script sh che ripete la sessione.
+func sessionScript(e historyEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# sessione %s del %s\n# argomenti originali: %s\n", e.ID, e.Time.Format(time.RFC3339), strings.Join(e.Argv, " "))
+	fmt.Fprintf(&b, "cd %s || exit 1\nexec %s", shellQuote(e.Dir), shellQuote(e.Exe))
+	for _, a := range e.Args {
+		io.WriteString(&b, " \\\n  "+shellQuote(a))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package main
+
+// humanUnits elenca i suffissi riconosciuti da --human-numeric in ordine di
+// grandezza, compresi R e Q delle versioni recenti di GNU sort; k minuscolo
+// vale come K.
+const humanUnits = "KMGTPEZYRQ"
+
+// humanKey restituisce la chiave di s per --human-numeric, come GNU sort -h:
+// i numeri con suffisso SI/IEC (1K, 23M, 4G) si confrontano prima per
+// suffisso e poi per valore, quindi 900K precede 1M. Il formato è un byte
+// con l'ordine del suffisso (con segno: -1G precede -1M) seguito dalla
+// chiave di numericKey.
+func humanKey(s string) string {
+	i := 0
+	for i < len(s) && isBlank(s[i]) {
+		i++
+	}
+	neg := i < len(s) && s[i] == '-'
+	if neg {
+		i++
+	}
+	nonzero := false
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		nonzero = nonzero || s[i] > '0' && s[i] <= '9'
+		i++
+	}
+	// Come in GNU sort, lo zero resta zero qualunque sia il suffisso.
+	order := 0
+	if nonzero && i < len(s) {
+		c := s[i]
+		if c == 'k' {
+			c = 'K'
+		}
+		for u := 0; u < len(humanUnits); u++ {
+			if humanUnits[u] == c {
+				order = u + 1
+				break
+			}
+		}
+	}
+	if neg {
+		order = -order
+	}
+	return string([]byte{byte(128 + order)}) + numericKey(s)
+}
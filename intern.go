@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// internedCost è quanto un record duplicato pesa sul chunk con --intern:
+// solo l'header della stringa nella slice delle righe, non i suoi byte.
+const internedCost = int(unsafe.Sizeof(""))
+
+// internTable condivide tra i record uguali di un chunk un'unica copia dei
+// byte. Con dati molto ripetitivi (log, codici, chiavi con pochi valori
+// distinti) la memoria di un chunk va quasi tutta nelle stringhe duplicate:
+// con l'interning un chunk contiene molti più record a parità di memoria.
+// La tabella vale per i chunk in formazione e viene svuotata a ogni invio ai
+// worker, così non trattiene le righe dei chunk già scritti. Ogni voce costa
+// qualche decina di byte: su dati quasi tutti distinti l'interning spreca
+// memoria invece di risparmiarla.
+type internTable struct {
+	m     map[string]string
+	hits  int64 // record che hanno riusato una copia esistente
+	saved int64 // byte non copiati grazie al riuso
+}
+
+func newInternTable() *internTable {
+	return &internTable{m: make(map[string]string)}
+}
+
+// parse interpreta line come parseRecord e restituisce la copia già vista
+// del record, se esiste; dup indica un record già presente nella tabella.
+func (t *internTable) parse(arena *lineArena, line []byte) (s string, ok, dup bool) {
+	if opts.schemaDef.Slice != nil {
+		b, ok := opts.schemaDef.Slice(line)
+		if !ok {
+			return "", false, false
+		}
+		// La ricerca con string(b) non alloca: il record finisce nell'arena
+		// solo se è nuovo.
+		if s, seen := t.m[string(b)]; seen {
+			t.hits++
+			t.saved += int64(len(s))
+			return s, true, true
+		}
+		s = arena.add(b)
+		t.m[s] = s
+		return s, true, false
+	}
+	s, ok = opts.schemaDef.Parse(line)
+	if !ok {
+		return s, false, false
+	}
+	if prev, seen := t.m[s]; seen {
+		t.hits++
+		t.saved += int64(len(s))
+		return prev, true, true
+	}
+	t.m[s] = s
+	return s, true, false
+}
+
+// reset svuota la tabella quando i chunk in formazione passano ai worker.
+func (t *internTable) reset() {
+	clear(t.m)
+}
+
+// report riporta i record condivisi durante lo split.
+func (t *internTable) report() {
+	if t.hits > 0 {
+		fmt.Fprintf(status, "🔹 Interning: %d record duplicati condivisi, %s non copiati\n", t.hits, formatBytes(t.saved))
+	}
+}
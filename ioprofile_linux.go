@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// networkFS elenca i magic number (statfs f_type) dei file system di rete.
+var networkFS = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517B:     true, // SMB
+	0xFF534D42: true, // CIFS
+	0xFE534D42: true, // SMB2
+	0x00C36400: true, // Ceph
+	0x01021997: true, // 9p
+	0x65735546: true, // FUSE (sshfs, s3fs, ...)
+}
+
+// detectDevice classifica il dispositivo che ospita path come network, nvme,
+// ssd o hdd. Restituisce una stringa vuota se non riesce a stabilirlo.
+func detectDevice(path string) string {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err == nil && networkFS[uint32(fs.Type)] {
+		return "network"
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return ""
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	sys, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return ""
+	}
+
+	// Le partizioni non hanno la directory queue: si usa quella del disco.
+	for _, dir := range []string{sys, filepath.Dir(sys)} {
+		rot, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(filepath.Base(dir), "nvme"):
+			return "nvme"
+		case strings.TrimSpace(string(rot)) == "1":
+			return "hdd"
+		default:
+			return "ssd"
+		}
+	}
+	return ""
+}
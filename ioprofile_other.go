@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// detectDevice non è implementata fuori da Linux: --io-profile auto usa il profilo fixed.
+func detectDevice(path string) string { return "" }
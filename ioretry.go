@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ioRetryMaxDelay limita l'attesa tra due tentativi di --io-retries.
+const ioRetryMaxDelay = 30 * time.Second
+
+// retryable indica se un errore di I/O può essere transitorio: una chiamata
+// interrotta, una risorsa momentaneamente non disponibile, un errore del
+// dispositivo o un timeout, tipici dei file system di rete e dei dischi di
+// scratch instabili. File mancanti, permessi e disco pieno sono definitivi
+// (il disco pieno ha la propria gestione, vedi spaceWriter).
+func retryable(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// retryWait decide se ripetere un'operazione fallita con err al tentativo
+// attempt (da 1): con un errore transitorio e tentativi rimasti lo segnala
+// su stderr, attende con backoff esponenziale da --io-retry-delay e
+// restituisce true.
+func retryWait(what string, err error, attempt int) bool {
+	if !retryable(err) || attempt > opts.ioRetries {
+		return false
+	}
+	delay := opts.ioRetryDelay << (attempt - 1)
+	if delay <= 0 || delay > ioRetryMaxDelay {
+		delay = ioRetryMaxDelay
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  %s: %v; nuovo tentativo (%d di %d) tra %s\n", what, err, attempt, opts.ioRetries, delay)
+	time.Sleep(delay)
+	return true
+}
+
+// withRetry esegue op e la ripete finché fallisce con un errore transitorio,
+// al più --io-retries volte.
+func withRetry(what string, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryWait(what, err, attempt) {
+			return err
+		}
+	}
+}
+
+// createRetry crea path come os.Create, ripetendo gli errori transitori.
+func createRetry(path string) (f *os.File, err error) {
+	err = withRetry("creazione di "+path, func() error {
+		f, err = os.Create(path)
+		return err
+	})
+	return f, err
+}
+
+// openRetry apre path come os.Open, ripetendo gli errori transitori.
+func openRetry(path string) (f *os.File, err error) {
+	err = withRetry("apertura di "+path, func() error {
+		f, err = os.Open(path)
+		return err
+	})
+	return f, err
+}
+
+// retryReader legge da r ripetendo le letture fallite con un errore
+// transitorio. Una lettura che ha restituito dei byte li consegna: se
+// l'errore persiste, si ripresenta alla lettura successiva. Va posto sotto
+// i decompressori, che dopo un errore non accettano altre letture.
+type retryReader struct {
+	r    io.Reader
+	name string // file letto, per i messaggi
+}
+
+func (r *retryReader) Read(p []byte) (n int, err error) {
+	err = withRetry("lettura di "+r.name, func() error {
+		var rerr error
+		n, rerr = r.r.Read(p)
+		if n > 0 && retryable(rerr) {
+			return nil
+		}
+		return rerr
+	})
+	return n, err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// setRetries imposta --io-retries con un'attesa minima per la durata del test.
+func setRetries(t *testing.T, n int) {
+	saved, savedDelay := opts.ioRetries, opts.ioRetryDelay
+	opts.ioRetries, opts.ioRetryDelay = n, time.Microsecond
+	t.Cleanup(func() { opts.ioRetries, opts.ioRetryDelay = saved, savedDelay })
+}
+
+func TestWithRetry(t *testing.T) {
+	eio := &os.PathError{Op: "read", Path: "chunk", Err: syscall.EIO}
+	tests := []struct {
+		name    string
+		retries int
+		errs    []error // errori dei tentativi successivi; poi l'operazione riesce
+		calls   int     // tentativi attesi
+		fail    bool
+	}{
+		{"riuscita", 3, nil, 1, false},
+		{"EIO poi riuscita", 3, []error{syscall.EIO}, 2, false},
+		{"errore avvolto", 3, []error{eio, eio}, 3, false},
+		{"transitori diversi", 3, []error{syscall.EINTR, syscall.EAGAIN, syscall.ETIMEDOUT}, 4, false},
+		{"tentativi esauriti", 2, []error{syscall.EIO, syscall.EIO, syscall.EIO}, 3, true},
+		{"nessun tentativo", 0, []error{syscall.EIO}, 1, true},
+		{"definitivo", 3, []error{os.ErrNotExist}, 1, true},
+		{"disco pieno", 3, []error{syscall.ENOSPC}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetries(t, tt.retries)
+			calls := 0
+			err := withRetry("prova", func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.calls {
+				t.Errorf("%d tentativi, attesi %d", calls, tt.calls)
+			}
+			if (err != nil) != tt.fail {
+				t.Fatalf("errore %v, fallimento atteso: %v", err, tt.fail)
+			}
+			if tt.fail && !errors.Is(err, tt.errs[calls-1]) {
+				t.Errorf("errore %v, atteso quello dell'ultimo tentativo", err)
+			}
+		})
+	}
+}
+
+// flakyReader restituisce data a pezzi di size byte; le letture elencate
+// in fail (contate da 1) falliscono con err, dopo aver consegnato i byte se
+// partial è true.
+type flakyReader struct {
+	data    string
+	size    int
+	fail    map[int]bool
+	err     error
+	partial bool
+	reads   int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	r.reads++
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.size)], r.data)
+	if r.fail[r.reads] {
+		if !r.partial {
+			return 0, r.err
+		}
+		r.data = r.data[n:]
+		return n, r.err
+	}
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRetryReader(t *testing.T) {
+	data := strings.Repeat("0123456789", 100)
+	tests := []struct {
+		name    string
+		retries int
+		r       *flakyReader
+		fail    bool
+	}{
+		{"senza errori", 3, &flakyReader{size: 7}, false},
+		{"errori sparsi", 1, &flakyReader{size: 7, fail: map[int]bool{2: true, 5: true, 40: true}, err: syscall.EIO}, false},
+		{"errori consecutivi", 3, &flakyReader{size: 64, fail: map[int]bool{3: true, 4: true, 5: true}, err: syscall.EINTR}, false},
+		{"byte con l'errore", 0, &flakyReader{size: 64, fail: map[int]bool{2: true, 3: true}, err: syscall.EAGAIN, partial: true}, false},
+		{"tentativi esauriti", 2, &flakyReader{size: 64, fail: map[int]bool{2: true, 3: true, 4: true}, err: syscall.EIO}, true},
+		{"errore definitivo", 3, &flakyReader{size: 64, fail: map[int]bool{2: true}, err: syscall.EACCES}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetries(t, tt.retries)
+			tt.r.data = data
+			got, err := io.ReadAll(&retryReader{r: tt.r, name: "input"})
+			if tt.fail {
+				if !errors.Is(err, tt.r.err) {
+					t.Fatalf("errore %v, atteso %v", err, tt.r.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != data {
+				t.Fatalf("letti %d byte diversi dall'input (%d byte)", len(got), len(data))
+			}
+		})
+	}
+}
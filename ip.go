@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Ordini delle famiglie di indirizzi accettati da --ip-order.
+const (
+	ipV4First = "v4-first" // IPv4 prima di IPv6
+	ipV6First = "v6-first" // IPv6 prima di IPv4
+	ipMixed   = "mixed"    // IPv4 come IPv6 mappati (::ffff:a.b.c.d), in un unico ordine
+)
+
+// parseIPOrder verifica il valore di --ip-order.
+func parseIPOrder(v string) error {
+	switch v {
+	case ipV4First, ipV6First, ipMixed:
+		return nil
+	}
+	return fmt.Errorf("--ip-order non valido: %q (attesi v4-first, v6-first o mixed)", v)
+}
+
+// ipKey restituisce la chiave di s per --ip: l'indirizzo IPv4 o IPv6 letto
+// all'inizio di s (dopo gli spazi), confrontabile come numero. Il primo byte
+// indica la famiglia secondo --ip-order (0 se non c'è un indirizzo valido:
+// questi valori precedono tutti gli altri), seguono i 4 o 16 byte
+// dell'indirizzo e, se presenti, la lunghezza del prefisso di una notazione
+// CIDR (10.0.0.0/8) o la porta (10.0.0.1:443, [::1]:443).
+func ipKey(s string) string {
+	text, ok := leadingFields(s, 1)
+	if !ok {
+		return "\x00"
+	}
+	addr, suffix, ok := parseIPToken(text)
+	if !ok {
+		return "\x00"
+	}
+	var class byte = 1
+	switch {
+	case opts.ipOrder == ipMixed:
+		addr = netip.AddrFrom16(addr.As16())
+	case addr.Is4() == (opts.ipOrder == ipV6First):
+		class = 2
+	}
+	buf := make([]byte, 0, 1+16+len(suffix))
+	buf = append(buf, class)
+	buf = append(buf, addr.AsSlice()...)
+	return string(append(buf, suffix...))
+}
+
+// parseIPToken interpreta un indirizzo, un prefisso CIDR o un indirizzo con
+// porta. suffix codifica la lunghezza del prefisso (1 byte) o la porta (2 byte).
+func parseIPToken(text string) (addr netip.Addr, suffix []byte, ok bool) {
+	if a, err := netip.ParseAddr(text); err == nil {
+		return a.WithZone(""), nil, true
+	}
+	if p, err := netip.ParsePrefix(text); err == nil {
+		return p.Addr(), []byte{byte(p.Bits())}, true
+	}
+	if ap, err := netip.ParseAddrPort(text); err == nil {
+		return ap.Addr().WithZone(""), []byte{byte(ap.Port() >> 8), byte(ap.Port())}, true
+	}
+	// IPv4 seguito da punteggiatura, come in "10.0.0.1," o "10.0.0.1;".
+	if t := strings.TrimRight(text, ",;)]"); t != text {
+		return parseIPToken(t)
+	}
+	return netip.Addr{}, nil, false
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// jsonSchemaName è lo schema dei record JSON Lines, selezionato da --json-key.
+const jsonSchemaName = "jsonl"
+
+// jsonPath è una chiave di --json-key: il percorso del valore nel documento
+// e il modo di confrontarlo.
+type jsonPath struct {
+	spec  string     // percorso come scritto dall'utente, per i messaggi
+	steps []jsonStep // passi del percorso
+	hint  string     // tipo di confronto ("" = secondo il tipo JSON)
+	desc  bool       // ordine inverso per questa chiave
+	ts    tsFormat   // formato del tipo time
+}
+
+// jsonStep è un passo di un percorso: un campo di un oggetto o, con index
+// non negativo, un elemento di un array.
+type jsonStep struct {
+	name  string
+	index int
+}
+
+// configureJSON interpreta le chiavi di --json-key. Va chiamata da
+// applyOrderOptions dopo la lettura di --key.
+func configureJSON() error {
+	opts.jsonPaths = opts.jsonPaths[:0]
+	if len(opts.jsonKeys) == 0 {
+		return nil
+	}
+	if len(opts.keySpecs) > 0 || opts.keyLen > 0 {
+		return fmt.Errorf("--json-key non è combinabile con --key e --key-bytes")
+	}
+	for _, v := range opts.jsonKeys {
+		p, err := parseJSONKey("--json-key", v)
+		if err != nil {
+			return err
+		}
+		opts.jsonPaths = append(opts.jsonPaths, p)
+	}
+	return nil
+}
+
+// parseJSONKey interpreta una chiave PATH[:TIPO][:desc] di --json-key (o
+// dell'opzione flag con la stessa sintassi). Il percorso è una sequenza di
+// campi separati da punti, con indici di array tra quadre e nomi tra
+// virgolette per i campi che contengono punti: user.id, items[0].sku,
+// $.meta["content.type"]. Il prefisso $ è facoltativo.
+func parseJSONKey(flag, v string) (jsonPath, error) {
+	parts := strings.Split(v, ":")
+	p := jsonPath{spec: parts[0]}
+	// I due punti possono comparire in un nome tra virgolette: i tipi sono
+	// solo le parti finali riconosciute.
+	for len(parts) > 1 && isJSONHint(parts[len(parts)-1]) {
+		switch h := parts[len(parts)-1]; h {
+		case "desc":
+			p.desc = true
+		default:
+			if p.hint != "" {
+				return p, fmt.Errorf("%s non valida %q: più di un tipo", flag, v)
+			}
+			p.hint = h
+		}
+		parts = parts[:len(parts)-1]
+	}
+	p.spec = strings.Join(parts, ":")
+	steps, err := parseJSONPath(p.spec)
+	if err != nil {
+		return p, fmt.Errorf("%s non valida %q: %w", flag, v, err)
+	}
+	p.steps = steps
+	if p.hint == "time" {
+		p.ts, _ = parseTimestampFormat("rfc3339")
+	}
+	return p, nil
+}
+
+// isJSONHint riconosce i tipi di confronto di --json-key.
+func isJSONHint(h string) bool {
+	switch h {
+	case "text", "nocase", "num", "human", "version", "month", "time", "desc":
+		return true
+	}
+	return false
+}
+
+// parseJSONPath divide un percorso nei suoi passi.
+func parseJSONPath(path string) ([]jsonStep, error) {
+	s := strings.TrimPrefix(path, "$")
+	var steps []jsonStep
+	for s != "" {
+		switch {
+		case s[0] == '.':
+			s = s[1:]
+			n := strings.IndexAny(s, ".[")
+			if n < 0 {
+				n = len(s)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("campo vuoto nel percorso")
+			}
+			steps = append(steps, jsonStep{name: s[:n], index: -1})
+			s = s[n:]
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if strings.HasPrefix(s, `["`) {
+				end = strings.Index(s, `"]`) + 1
+			}
+			if end <= 0 {
+				return nil, fmt.Errorf("parentesi quadra non chiusa")
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			if name, err := strconv.Unquote(inner); err == nil {
+				steps = append(steps, jsonStep{name: name, index: -1})
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("indice di array %q non valido", inner)
+			}
+			steps = append(steps, jsonStep{index: i})
+		case len(steps) == 0:
+			// Il primo campo può non avere il punto iniziale.
+			s = "." + s
+		default:
+			return nil, fmt.Errorf("atteso . o [ in %q", s)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("percorso vuoto")
+	}
+	return steps, nil
+}
+
+// jsonRecord accetta le righe che contengono un documento JSON valido. Le
+// righe vuote e quelle non valide vengono scartate e contate.
+func jsonRecord(line []byte) (string, bool) {
+	line = bytes.TrimSuffix(line, []byte{recordSep})
+	if !json.Valid(line) {
+		return "", false
+	}
+	return string(line), true
+}
+
+// jsonKey costruisce la chiave composta di un record a partire da --json-key.
+// Senza chiavi il record si confronta come testo.
+func jsonKey(record string) string {
+	if len(opts.jsonPaths) == 0 {
+		return record
+	}
+	buf := make([]byte, 0, 64)
+	for i := range opts.jsonPaths {
+		p := &opts.jsonPaths[i]
+		raw, ok := jsonLookup(record, p.steps)
+		buf = appendKeyPart(buf, jsonValueKey(raw, ok, p), p.desc)
+	}
+	return string(buf)
+}
+
+// jsonLookup restituisce il valore JSON grezzo nel percorso indicato. ok è
+// false se un passo non esiste o attraversa un valore del tipo sbagliato.
+// Ogni passo decodifica solo il livello che attraversa.
+func jsonLookup(record string, steps []jsonStep) (raw json.RawMessage, ok bool) {
+	raw = json.RawMessage(record)
+	for _, st := range steps {
+		if st.index < 0 {
+			var obj map[string]json.RawMessage
+			if json.Unmarshal(raw, &obj) != nil {
+				return nil, false
+			}
+			if raw, ok = obj[st.name]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		var arr []json.RawMessage
+		if json.Unmarshal(raw, &arr) != nil || st.index >= len(arr) {
+			return nil, false
+		}
+		raw = arr[st.index]
+	}
+	return bytes.TrimSpace(raw), true
+}
+
+// jsonValueKey restituisce la chiave di un valore. Senza tipo l'ordine segue
+// il tipo JSON: valore assente, null, false, true, numeri (per valore),
+// stringhe (anche con --ignore-case), infine oggetti e array come testo
+// compatto. Con un tipo il valore (la stringa, o il testo JSON per gli altri
+// tipi) viene confrontato come le chiavi di --key; assenti e null vanno per primi.
+func jsonValueKey(raw json.RawMessage, ok bool, p *jsonPath) string {
+	if !ok || len(raw) == 0 {
+		return "0"
+	}
+	text := string(raw)
+	isString := raw[0] == '"'
+	if isString {
+		json.Unmarshal(raw, &text)
+	}
+	if p.hint != "" {
+		if text == "null" && !isString {
+			return "0"
+		}
+		switch p.hint {
+		case "nocase":
+			text = strings.ToUpper(text)
+		case "num":
+			text = numericKey(jsonNumber(text))
+		case "human":
+			text = humanKey(text)
+		case "version":
+			text = versionKey(text)
+		case "month":
+			text = monthKey(text)
+		case "time":
+			text = timestampKey(text, p.ts)
+		}
+		return "1" + text
+	}
+	switch raw[0] {
+	case 'n':
+		return "1"
+	case 'f':
+		return "2"
+	case 't':
+		return "3"
+	case '"':
+		if opts.ignoreCase {
+			text = strings.ToUpper(text)
+		}
+		return "5" + text
+	case '{', '[':
+		var b bytes.Buffer
+		if json.Compact(&b, raw) == nil {
+			text = b.String()
+		}
+		return "6" + text
+	}
+	return "4" + numericKey(jsonNumber(text))
+}
+
+// jsonNumber riscrive in notazione decimale un numero con esponente, che
+// numericKey non interpreta. Gli altri valori restano invariati.
+func jsonNumber(s string) string {
+	if !strings.ContainsAny(s, "eE") {
+		return s
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func init() {
+	// Documenti JSON, uno per riga (JSON Lines): vedi --json-key.
+	schema.Register(schema.Schema{Name: jsonSchemaName, Parse: jsonRecord, Key: jsonKey})
+}
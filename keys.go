@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// keySpec descrive una chiave di ordinamento nel formato di GNU sort -k:
+// F[.C][OPTS][,F[.C][OPTS]]. Campi e caratteri sono numerati da 1.
+type keySpec struct {
+	startField int  // primo campo della chiave
+	startChar  int  // carattere iniziale nel campo (0 = inizio del campo)
+	endField   int  // ultimo campo della chiave (0 = fino a fine riga)
+	endChar    int  // ultimo carattere nel campo (0 = fine del campo)
+	ignoreCase bool // opzione f: confronto senza maiuscole/minuscole
+	reverse    bool // opzione r: ordine inverso per questa chiave
+	numeric    bool // opzione n: confronto numerico
+	version    bool // opzione V: confronto tra versioni
+	random     bool // opzione R: ordine casuale (hash della chiave)
+	month      bool // opzione M: confronto tra nomi di mesi
+	human      bool // opzione h: numeri con suffissi K, M, G...
+	dictionary bool // opzione d: solo spazi, lettere e cifre
+	blankStart bool // opzione b sulla posizione iniziale: ignora gli spazi a inizio campo
+	blankEnd   bool // opzione b sulla posizione finale
+	hasOpts    bool // la chiave ha opzioni proprie e ignora quelle globali
+}
+
+// parseKeySpec interpreta una specifica di --key.
+func parseKeySpec(spec string) (keySpec, error) {
+	var k keySpec
+	start, end, hasEnd := strings.Cut(spec, ",")
+
+	var err error
+	if k.startField, k.startChar, err = parseKeyPos(start, &k, false); err != nil {
+		return k, fmt.Errorf("chiave --key non valida %q: %w", spec, err)
+	}
+	if k.startField == 0 || k.startChar < 0 {
+		return k, fmt.Errorf("chiave --key non valida %q: campi e caratteri partono da 1", spec)
+	}
+	if hasEnd {
+		if k.endField, k.endChar, err = parseKeyPos(end, &k, true); err != nil {
+			return k, fmt.Errorf("chiave --key non valida %q: %w", spec, err)
+		}
+		if k.endField == 0 {
+			return k, fmt.Errorf("chiave --key non valida %q: il campo finale parte da 1", spec)
+		}
+		if k.endChar < 0 {
+			k.endChar = 0 // F.0 equivale alla fine del campo
+		}
+	}
+	if k.dictionary && (k.numeric || k.human || k.month) {
+		return k, fmt.Errorf("chiave --key non valida %q: l'opzione d non è combinabile con n, h e M", spec)
+	}
+	return k, nil
+}
+
+// parseKeyPos interpreta una posizione F[.C][OPTS] e registra le opzioni in k.
+// L'opzione b vale solo per la posizione su cui compare (end indica quella finale).
+// Un carattere esplicito a 0 viene restituito come -1 per poterlo rifiutare
+// sulla posizione iniziale.
+func parseKeyPos(pos string, k *keySpec, end bool) (field, char int, err error) {
+	n := strings.IndexFunc(pos, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if n < 0 {
+		n = len(pos)
+	}
+	num, flags := pos[:n], pos[n:]
+
+	f, c, hasChar := strings.Cut(num, ".")
+	if field, err = strconv.Atoi(f); err != nil {
+		return 0, 0, fmt.Errorf("numero di campo %q", f)
+	}
+	if hasChar {
+		if char, err = strconv.Atoi(c); err != nil {
+			return 0, 0, fmt.Errorf("numero di carattere %q", c)
+		}
+		if char == 0 {
+			char = -1
+		}
+	}
+	for _, o := range flags {
+		switch o {
+		case 'f':
+			k.ignoreCase = true
+		case 'r':
+			k.reverse = true
+		case 'n':
+			k.numeric = true
+		case 'V':
+			k.version = true
+		case 'R':
+			k.random = true
+		case 'M':
+			k.month = true
+		case 'h':
+			k.human = true
+		case 'd':
+			k.dictionary = true
+		case 'b':
+			if end {
+				k.blankEnd = true
+			} else {
+				k.blankStart = true
+			}
+		default:
+			return 0, 0, fmt.Errorf("opzione di chiave %q non supportata", o)
+		}
+		k.hasOpts = true
+	}
+	return field, char, nil
+}
+
+// parseKeyBytes interpreta il valore di --key-bytes nel formato OFFSET:LEN.
+// Un valore vuoto restituisce lunghezza 0 (opzione assente).
+func parseKeyBytes(v string) (offset, length int, err error) {
+	if v == "" {
+		return 0, 0, nil
+	}
+	o, l, ok := strings.Cut(v, ":")
+	if ok {
+		offset, err = strconv.Atoi(o)
+	}
+	if ok && err == nil {
+		length, err = strconv.Atoi(l)
+	}
+	if !ok || err != nil || offset < 0 || length <= 0 {
+		return 0, 0, fmt.Errorf("--key-bytes non valido %q: atteso OFFSET:LEN con OFFSET >= 0 e LEN > 0", v)
+	}
+	return offset, length, nil
+}
+
+// byteRange restituisce i length byte di s a partire da offset, troncati
+// alla fine di s. Non alloca: il risultato condivide la memoria di s.
+func byteRange(s string, offset, length int) string {
+	if offset >= len(s) {
+		return ""
+	}
+	if length > len(s)-offset {
+		length = len(s) - offset
+	}
+	return s[offset : offset+length]
+}
+
+// parseFieldSep interpreta il valore di --field-sep: un singolo carattere
+// (anche multibyte), oppure \t o "tab" per il tabulatore.
+func parseFieldSep(v string) (string, error) {
+	switch v {
+	case "":
+		return "", nil
+	case "\\t", "tab":
+		return "\t", nil
+	}
+	if utf8.RuneCountInString(v) != 1 {
+		return "", fmt.Errorf("separatore --field-sep non valido %q: serve un solo carattere", v)
+	}
+	return v, nil
+}
+
+// isBlank riconosce i separatori di campo predefiniti (spazio e tab). Come
+// in GNU sort vale anche '\n', che può comparire in un record con --zero-terminated.
+func isBlank(c byte) bool { return c == ' ' || c == '\t' || c == '\n' }
+
+// skipBlanks restituisce l'offset del primo carattere non vuoto da i in poi.
+func skipBlanks(line string, i int) int {
+	for i < len(line) && isBlank(line[i]) {
+		i++
+	}
+	return i
+}
+
+// dictionaryKey restituisce s con i soli spazi, tab, lettere e cifre, come
+// GNU sort -d. Lettere e cifre sono quelle Unicode, così il testo accentato
+// non perde caratteri. Se non c'è nulla da scartare s viene restituita senza copie.
+func dictionaryKey(s string) string {
+	keep := func(r rune) bool { return r == ' ' || r == '\t' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	i := strings.IndexFunc(s, func(r rune) bool { return !keep(r) })
+	if i < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s[:i])
+	for _, r := range s[i:] {
+		if keep(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// skipFields restituisce l'offset di inizio del campo successivo agli n campi
+// iniziali. Come in GNU sort, senza separatore esplicito un campo è formato
+// dagli spazi che lo precedono seguiti da caratteri non vuoti; con --field-sep
+// il separatore non appartiene a nessun campo e due separatori consecutivi
+// delimitano un campo vuoto.
+func skipFields(line string, n int) int {
+	i := 0
+	for ; n > 0 && i < len(line); n-- {
+		i = fieldEnd(line, i)
+		if opts.separator != "" && i < len(line) {
+			i += len(opts.separator)
+		}
+	}
+	return i
+}
+
+// fieldEnd restituisce la fine del campo che inizia all'offset i.
+func fieldEnd(line string, i int) int {
+	if opts.separator != "" {
+		if n := strings.Index(line[i:], opts.separator); n >= 0 {
+			return i + n
+		}
+		return len(line)
+	}
+	for i < len(line) && isBlank(line[i]) {
+		i++
+	}
+	for i < len(line) && !isBlank(line[i]) {
+		i++
+	}
+	return i
+}
+
+// keyBounds restituisce la porzione [a, b) di line selezionata dalla chiave.
+// Come in GNU sort, con l'opzione b (o --ignore-leading-blanks per le chiavi
+// senza opzioni proprie) gli spazi a inizio campo non contano nella posizione
+// del carattere.
+func (k *keySpec) keyBounds(line string) (a, b int) {
+	blankStart, blankEnd := k.blankStart, k.blankEnd
+	if !k.hasOpts {
+		blankStart, blankEnd = opts.ignoreBlanks, opts.ignoreBlanks
+	}
+	a = skipFields(line, k.startField-1)
+	if blankStart {
+		a = skipBlanks(line, a)
+	}
+	if k.startChar > 0 {
+		a += k.startChar - 1
+	}
+	if a > len(line) {
+		a = len(line)
+	}
+
+	if k.endField == 0 {
+		return a, len(line)
+	}
+	b = skipFields(line, k.endField-1)
+	if k.endChar == 0 {
+		b = fieldEnd(line, b)
+	} else {
+		if blankEnd {
+			b = skipBlanks(line, b)
+		}
+		b += k.endChar
+	}
+	if b > len(line) {
+		b = len(line)
+	}
+	if b < a {
+		b = a
+	}
+	return a, b
+}
+
+// appendKeyPart aggiunge a dst una componente della chiave composta in forma
+// prefix-free: 0x00 diventa 0x00 0xFF e la componente termina con 0x00 0x00.
+// Così l'ordine binario della concatenazione rispetta l'ordine chiave per chiave;
+// con reverse i byte vengono complementati e l'ordine della componente si inverte.
+func appendKeyPart(dst []byte, part string, reverse bool) []byte {
+	start := len(dst)
+	for i := 0; i < len(part); i++ {
+		if part[i] == 0 {
+			dst = append(dst, 0, 0xFF)
+		} else {
+			dst = append(dst, part[i])
+		}
+	}
+	dst = append(dst, 0, 0)
+	if reverse {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+	return dst
+}
+
+// fieldKey costruisce la chiave composta di una riga a partire da --key.
+// Ogni chiave applica le proprie opzioni oppure, se non ne ha, quelle globali.
+// --reverse inverte già l'intero confronto in lessKeyed: le chiavi con
+// opzioni proprie lo compensano, perché per GNU sort non lo ereditano.
+func fieldKey(line string) string {
+	buf := make([]byte, 0, len(line)+4*len(opts.keySpecs))
+	for i := range opts.keySpecs {
+		k := &opts.keySpecs[i]
+		a, b := k.keyBounds(line)
+		part := line[a:b]
+		if opts.csv {
+			part = csvUnescape(part)
+		}
+
+		fold, numeric, human, version, month, random, reverse := opts.ignoreCase, opts.numeric, opts.humanNumeric, opts.versionSort, opts.monthSort, opts.random, false
+		dictionary := opts.dictionary
+		if k.hasOpts {
+			fold, numeric, human, version, month, random, reverse = k.ignoreCase, k.numeric, k.human, k.version, k.month, k.random, k.reverse != opts.reverse
+			dictionary = k.dictionary
+		}
+		if dictionary {
+			part = dictionaryKey(part)
+		}
+		text := part
+		switch {
+		case random && fold:
+			part = randomKey(strings.ToUpper(part))
+		case random:
+			part = randomKey(part)
+		case numeric:
+			part = numericKey(part)
+		case human:
+			part = humanKey(part)
+		case version:
+			part = versionKey(part)
+		case month:
+			part = monthKey(part)
+		case opts.timestamp != "" && !k.hasOpts:
+			part = timestampKey(part, opts.tsFormat)
+		case opts.ip && !k.hasOpts:
+			part = ipKey(part)
+		case opts.idTime && !k.hasOpts:
+			part = idTimeKey(part)
+		case opts.natural && !k.hasOpts:
+			part = naturalKey(part, fold)
+		case opts.locale != "":
+			part = collationKey(part)
+		case fold:
+			part = strings.ToUpper(part)
+		}
+		if opts.byLength && !k.hasOpts {
+			part = lengthKey(text, part)
+		}
+		buf = appendKeyPart(buf, part, reverse)
+	}
+	return string(buf)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// lengthKey restituisce la chiave di --by-length: la lunghezza di s in
+// caratteri, su 4 byte big-endian, seguita da key, la chiave che decide tra
+// testi della stessa lunghezza. Le righe corte precedono quindi le lunghe e,
+// a parità di lunghezza, l'ordine è quello lessicografico.
+func lengthKey(s, key string) string {
+	buf := make([]byte, 4, 4+len(key))
+	binary.BigEndian.PutUint32(buf, uint32(utf8.RuneCountInString(s)))
+	return string(append(buf, key...))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Modalità di --line-endings.
+const (
+	eolAuto     = "auto"     // come il primo record dell'input, con i terminatori uniformati
+	eolLF       = "lf"       // output con \n
+	eolCRLF     = "crlf"     // output con \r\n
+	eolPreserve = "preserve" // ogni riga conserva il proprio terminatore
+)
+
+// lineEnd è il terminatore delle righe di output. Con preserve resta "\n":
+// l'eventuale '\r' fa parte del record.
+var lineEnd = "\n"
+
+// recordSep separa i record nell'input e nei chunk: '\n', oppure NUL con
+// --zero-terminated, che usa NUL anche come terminatore di output.
+var recordSep byte = '\n'
+
+// eolPending indica che in modalità auto il terminatore va ancora ricavato
+// dal primo record letto (input da stdin).
+var eolPending bool
+
+// configureLineEndings verifica --line-endings e fissa il terminatore di
+// output. In modalità auto con input da file il terminatore viene letto
+// subito dalla prima riga del primo input, così è lo stesso in tutte le
+// modalità (--merge, --coop, --check); da stdin viene ricavato dal primo record.
+func configureLineEndings() error {
+	// I record binari vengono riscritti senza alcun terminatore.
+	if binaryRecords() {
+		lineEnd = ""
+		return nil
+	}
+	// I record letti dai file Avro sono righe terminate da '\n'.
+	if opts.avro {
+		return nil
+	}
+	if opts.zeroTerminated {
+		if opts.lineEndings != eolAuto {
+			return fmt.Errorf("--line-endings non è combinabile con --zero-terminated")
+		}
+		recordSep, lineEnd = 0, "\x00"
+		return nil
+	}
+	switch opts.lineEndings {
+	case eolLF, eolPreserve:
+	case eolCRLF:
+		lineEnd = "\r\n"
+	case eolAuto:
+		if len(opts.inputs) == 0 || opts.inputs[0] == "-" {
+			eolPending = true
+			return nil
+		}
+		if firstLineCRLF(opts.inputs[0]) {
+			lineEnd = "\r\n"
+		}
+	default:
+		return fmt.Errorf("--line-endings non valido: %q (attesi auto, lf, crlf o preserve)", opts.lineEndings)
+	}
+	return nil
+}
+
+// firstLineCRLF indica se la prima riga del file termina con \r\n. Un file
+// che non si può leggere vale come \n: l'errore, se l'input serve, emerge
+// alla lettura vera e propria.
+func firstLineCRLF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r, err := decompress(f, path)
+	if err != nil {
+		return false
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	line, _ := bufio.NewReader(stripBOM(r)).ReadSlice('\n')
+	return bytes.HasSuffix(line, []byte("\r\n"))
+}
+
+// normalizeEOL uniforma il terminatore di un record letto dall'input: tranne
+// che con preserve, \r\n diventa \n, così il '\r' non entra nel confronto né
+// nei chunk. Modifica line sul posto.
+func normalizeEOL(line []byte) []byte {
+	if opts.zeroTerminated || opts.lineEndings == eolPreserve || !bytes.HasSuffix(line, []byte("\r\n")) {
+		if eolPending && len(line) > 0 {
+			eolPending = false
+		}
+		return line
+	}
+	if eolPending {
+		eolPending = false
+		lineEnd = "\r\n"
+	}
+	line[len(line)-2] = '\n'
+	return line[:len(line)-1]
+}
+
+// scanRecords è la funzione di split dei chunk con --line-endings preserve o
+// --zero-terminated: come bufio.ScanLines ma divide su recordSep e non toglie
+// il '\r', che fa parte del record.
+func scanRecords(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, recordSep); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
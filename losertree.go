@@ -0,0 +1,84 @@
+package main
+
+// loserTree è l'albero dei perdenti (tournament tree) del merge k-way. Le
+// foglie sono gli elementi correnti delle k sorgenti; ogni nodo interno
+// conserva il perdente del confronto tra i vincitori dei suoi due sottoalberi
+// e tree[0] il vincitore assoluto. Quando il vincitore viene sostituito dalla
+// riga successiva della sua sorgente basta ripetere i confronti sul suo
+// percorso fino alla radice: log2 k confronti invece dei circa 2·log2 k di
+// un heap, e nessun passaggio per interface{} come con container/heap.
+type loserTree struct {
+	items []heapItem // elemento corrente di ogni sorgente
+	live  []bool     // la sorgente ha ancora un elemento
+	tree  []int      // tree[0] vincitore, tree[1:] perdenti dei nodi interni
+}
+
+// newLoserTree crea un albero per k sorgenti, tutte ancora senza elementi:
+// vanno impostati con set prima di init.
+func newLoserTree(k int) *loserTree {
+	return &loserTree{items: make([]heapItem, k), live: make([]bool, k), tree: make([]int, k)}
+}
+
+// set imposta l'elemento corrente della sorgente i.
+func (t *loserTree) set(i int, item heapItem) {
+	t.items[i], t.live[i] = item, true
+}
+
+// remove segnala che la sorgente i è esaurita.
+func (t *loserTree) remove(i int) {
+	t.items[i], t.live[i] = heapItem{}, false
+}
+
+// beats indica se l'elemento della sorgente a precede quello di b. Le
+// sorgenti esaurite perdono contro tutte le altre.
+func (t *loserTree) beats(a, b int) bool {
+	if !t.live[a] || !t.live[b] {
+		return t.live[a]
+	}
+	x, y := &t.items[a], &t.items[b]
+	return lessSeq(x.key, x.value, x.seq, y.key, y.value, y.seq)
+}
+
+// init gioca il torneo completo sugli elementi correnti.
+func (t *loserTree) init() {
+	if len(t.items) > 0 {
+		t.tree[0] = t.play(1)
+	}
+}
+
+// play gioca il torneo del sottoalbero con radice n e ne restituisce il
+// vincitore. Le foglie sono i nodi da k a 2k-1: la foglia k+i è la sorgente i.
+func (t *loserTree) play(n int) int {
+	k := len(t.items)
+	if n >= k {
+		return n - k
+	}
+	l, r := t.play(2*n), t.play(2*n+1)
+	if t.beats(r, l) {
+		t.tree[n] = l
+		return r
+	}
+	t.tree[n] = r
+	return l
+}
+
+// replay aggiorna l'albero dopo che l'elemento della sorgente i è cambiato
+// (di solito perché i era il vincitore ed è stato consumato).
+func (t *loserTree) replay(i int) {
+	w := i
+	for n := (i + len(t.items)) / 2; n > 0; n /= 2 {
+		if t.beats(t.tree[n], w) {
+			t.tree[n], w = w, t.tree[n]
+		}
+	}
+	t.tree[0] = w
+}
+
+// winner restituisce la sorgente con l'elemento più piccolo. ok è false se
+// tutte le sorgenti sono esaurite.
+func (t *loserTree) winner() (i int, ok bool) {
+	if len(t.items) == 0 || !t.live[t.tree[0]] {
+		return 0, false
+	}
+	return t.tree[0], true
+}
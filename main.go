@@ -2,270 +2,2016 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"container/heap"
+	"crypto/ed25519"
+	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"runtime"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
 )
 
-// heapItem rappresenta un elemento nel heap usato per il merge.
-// Contiene la stringa (value) e l'indice del chunkReader da cui proviene.
-// Serve per mantenere traccia da quale file leggere la prossima riga.
+// heapItem rappresenta un elemento in attesa nel merge (albero dei perdenti)
+// o nella coda di priorità (heap). Contiene la stringa (value) e l'indice
+// del chunkReader da cui proviene, da cui leggere la prossima riga.
 type heapItem struct {
 	value string // valore testuale della riga
+	key   string // chiave di confronto (coincide con value senza opzioni)
 	index int    // indice del chunkReader di origine
+	seq   int64  // ordine di origine, usato come spareggio con --stable
 }
 
 // minHeapBuffered è un heap minimo di heapItem ordinato alfabeticamente
-// per mantenere sempre in cima la stringa più piccola.
+// per mantenere sempre in cima la stringa più piccola. push e pop lavorano
+// sul posto, senza passare da container/heap: nessun heapItem viene
+// convertito in interface{} e la slice non si rialloca finché resta entro
+// la sua capacità.
 type minHeapBuffered []heapItem
 
-// Len restituisce la lunghezza dell'heap (numero di elementi)
-func (h minHeapBuffered) Len() int { return len(h) }
+// less confronta due elementi dell'heap secondo le opzioni correnti.
+func (h minHeapBuffered) less(i, j int) bool {
+	return lessSeq(h[i].key, h[i].value, h[i].seq, h[j].key, h[j].value, h[j].seq)
+}
 
-// Less confronta due elementi dell'heap per mantenere ordine alfabetico
-func (h minHeapBuffered) Less(i, j int) bool { return h[i].value < h[j].value }
+// push aggiunge un elemento risalendo fino alla sua posizione.
+func (h *minHeapBuffered) push(it heapItem) {
+	*h = append(*h, it)
+	q := *h
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.less(i, parent) {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
 
-// Swap scambia due elementi nell'heap
-func (h minHeapBuffered) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+// pop toglie e restituisce l'elemento più piccolo.
+func (h *minHeapBuffered) pop() heapItem {
+	q := *h
+	top := q[0]
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = heapItem{}
+	*h = q[:n]
+	h.down(0)
+	return top
+}
 
-// Push aggiunge un nuovo elemento all'heap (richiesto da container/heap)
-func (h *minHeapBuffered) Push(x interface{}) {
-	*h = append(*h, x.(heapItem))
+// replaceTop sostituisce l'elemento più piccolo con it: un solo passaggio
+// verso il basso invece di pop seguito da push.
+func (h minHeapBuffered) replaceTop(it heapItem) {
+	h[0] = it
+	h.down(0)
 }
 
-// Pop rimuove e restituisce l'ultimo elemento dell'heap (richiesto da container/heap)
-func (h *minHeapBuffered) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[:n-1]
-	return item
+// down fa scendere l'elemento i fino alla sua posizione.
+func (h minHeapBuffered) down(i int) {
+	n := len(h)
+	for {
+		small, l := i, 2*i+1
+		if l < n && h.less(l, small) {
+			small = l
+		}
+		if r := l + 1; r < n && h.less(r, small) {
+			small = r
+		}
+		if small == i {
+			return
+		}
+		h[i], h[small] = h[small], h[i]
+		i = small
+	}
 }
 
 // chunkReader rappresenta un file chunk con un buffer interno.
 // **MODIFICA CHIAVE**: Ora contiene un `*bufio.Scanner` per mantenere lo stato di lettura.
 type chunkReader struct {
-	file    *os.File       // file chunk aperto
-	scanner *bufio.Scanner // Scanner per leggere il file in modo stateful
-	buffer  []string       // buffer interno di righe lette in RAM
-	index   int            // indice del chunkReader (per identificazione)
+	file    *os.File        // file chunk aperto
+	dec     io.Closer       // decompressore dell'input con --merge (nil se assente)
+	scanner *bufio.Scanner  // Scanner per leggere il file in modo stateful
+	split   bufio.SplitFunc // divisione in record, dello scanner o della memoria mappata
+	mapped  []byte          // contenuto del chunk mappato in memoria (nil = scanner)
+	pos     int             // con mapped: offset del prossimo record
+	buffer  []string        // buffer interno di righe lette in RAM
+	memory  []string        // righe della run in memoria non ancora nel buffer (file nil)
+	index   int             // indice del chunkReader (per identificazione)
+
+	prefetch chan prefetched // con --prefetch: blocchi letti in anticipo (nil = lettura sincrona)
+	stop     chan struct{}   // chiuso da stopPrefetch per fermare la lettura in anticipo
+	stopped  chan struct{}   // chiuso quando la goroutine di lettura è terminata
+
+	consumed int64 // righe inserite nell'heap (atomico, per la diagnostica degli stalli)
+	eof      int32 // 1 quando il chunk è esaurito (atomico)
+}
+
+// chunkInfo descrive un chunk ordinato scritto su disco durante lo split.
+// Le informazioni vengono raccolte dai worker e salvate nel manifest dei run set.
+type chunkInfo struct {
+	Path  string       `json:"file"`            // percorso del file chunk
+	Lines int          `json:"lines"`           // numero di righe scritte
+	Bytes int64        `json:"bytes"`           // dimensione in byte del file
+	First string       `json:"first"`           // prima riga (la più piccola)
+	Last  string       `json:"last"`            // ultima riga (la più grande)
+	Index []indexEntry `json:"index,omitempty"` // indice sparso per il posizionamento
+
+	Partition string `json:"partition,omitempty"` // chiave di partizione (solo con --partition)
+}
+
+// chunkJob è un blocco di righe che un worker dello split ordina e scrive su disco.
+type chunkJob struct {
+	lines     []string
+	id        int
+	dir       string // directory in cui scrivere il chunk
+	partition string // chiave di partizione delle righe
+	recycle   bool   // dopo la scrittura lines torna nel pool
 }
 
 // Costanti per configurare dimensioni RAM e I/O buffer
 const (
 	maxDiskSize      = 100 * 1024 * 1024 // 100 MB massimo chunk su disco
-	maxItems         = 500_000           // max elementi in memoria per chunk
+	maxItems         = 500_000           // max elementi in memoria nella coda di priorità
 	strLength        = 32                // lunghezza stringhe alfanumeriche
 	bufferLines      = 5000             // numero di righe lette per batch da ogni chunk nel merge
 	readerBufSize    = 256 * 1024        // buffer di lettura da 512 KB
 	writerBufferSize = 4 * 1024 * 1024   // buffer di scrittura da 4 MB
 )
 
+// options raccoglie le opzioni lette dalla riga di comando.
+type options struct {
+	maxRecordBytes int    // dimensione massima di un record in byte (0 = illimitata)
+	oversizePolicy string // truncate, reject o divert
+	oversizeFile   string // file laterale per i record deviati
+	rejectsFile    string // file in cui scrivere i record scartati ("" = nessuno)
+	ignoreCase     bool   // confronto senza distinzione maiuscole/minuscole
+	ignoreBlanks   bool   // ignora gli spazi iniziali delle chiavi (come GNU sort -b)
+	dictionary     bool   // confronta solo spazi, lettere e cifre (come GNU sort -d)
+	locale         string     // locale per la collazione linguistica (es. it_IT)
+	keys           stringList // specifiche --key nel formato di GNU sort
+	keySpecs       []keySpec  // chiavi interpretate da applyOrderOptions
+	fieldSep       string     // separatore di campo per --key (--field-sep)
+	separator      string     // separatore interpretato da applyOrderOptions ("" = spazi)
+	keyBytes       string     // intervallo di byte della chiave, OFFSET:LEN (--key-bytes)
+	keyOffset      int        // primo byte della chiave interpretato da applyOrderOptions
+	keyLen         int        // lunghezza della chiave in byte (0 = --key-bytes assente)
+	runSet         string // directory in cui conservare i chunk come run set
+	query          string // run set da interrogare invece di ordinare
+	queryFrom      string // limite inferiore (incluso) della query
+	queryTo        string // limite superiore (incluso) della query
+	queryLimit     int        // numero massimo di righe restituite dalla query
+	tees           stringList // consumer aggiuntivi dello stream ordinato (--tee)
+	pqDir          string     // directory di spill della coda di priorità (--pq)
+	partition      string     // chiave di partizione nel formato di --key
+	partitionKey   *keySpec   // chiave di partizione interpretata (nil = nessuna)
+	partitionDir   string     // directory dei file di output per partizione
+	partitionBy    string     // partizione dell'output del merge per prefisso o intervallo di chiavi
+	numeric        bool       // confronto numerico (come GNU sort -n)
+	reverse        bool       // ordine inverso (come GNU sort -r)
+	natural        bool       // le sequenze di cifre si confrontano come numeri
+	versionSort    bool       // confronto tra versioni (come GNU sort -V)
+	monthSort      bool       // confronto tra nomi di mesi (come GNU sort -M)
+	byLength       bool       // prima le righe più corte, poi ordine lessicografico
+	timestamp      string     // formato dei timestamp da ordinare (--timestamp)
+	tsFormat       tsFormat   // formato interpretato da applyOrderOptions
+	ip             bool       // confronto tra indirizzi IPv4/IPv6 (--ip)
+	lineEndings    string     // gestione dei terminatori di riga (--line-endings)
+	zeroTerminated bool       // record separati da NUL invece che da '\n' (come GNU sort -z)
+	compression    string     // compressione dell'input: auto, none, gzip, zstd, bzip2 o xz
+	outCompression string     // compressione del file di output: none, gzip o zstd
+	outLevel       int        // livello di compressione dell'output (0 = predefinito)
+	ipOrder        string     // ordine delle famiglie di indirizzi (--ip-order)
+	idTime         bool       // ordine per il timestamp di UUIDv7 e ULID (--id-time)
+	humanNumeric   bool       // numeri con suffissi K, M, G... (come GNU sort -h)
+	random         bool       // ordine casuale tramite hash delle righe (come GNU sort -R)
+	randomSeed     string     // seme dell'hash di --random ("" = generato)
+	check          bool       // verifica soltanto che l'input sia ordinato
+	merge          bool       // l'input è già ordinato: esegue solo il merge
+	inputs         stringList // file di input ("-" = stdin)
+	output         string     // file di output ("-" = stdout)
+	chunkDir       string     // directory dei chunk temporanei
+	chunkBytes     int        // dimensione massima di un chunk in memoria (0 = automatica)
+	pqItems        int        // elementi in memoria della coda di --pq, scelti da configureChunkSize
+	mergeFanIn     int        // file fusi al massimo da un singolo merge
+	workers        int        // numero di worker dello split
+	ioWorkers      int        // worker che scrivono i chunk ordinati
+	parseWorkers   int        // goroutine che analizzano l'input dello split (0 = lettura sequenziale)
+	intern         bool       // record uguali di un chunk condividono i byte
+	chunkSort      string     // algoritmo di ordinamento dei chunk (--chunk-sort)
+	runGen         string     // generazione dei run iniziali (--run-generation)
+	gnu            bool       // argomenti letti con la sintassi di GNU sort (--gnu)
+	dryRun         bool       // con --gnu: mostra la traduzione senza eseguire
+	gnuTempDir     bool       // con --gnu senza -T: la directory dei chunk è propria del processo
+	schema         string     // nome dello schema dei record (--schema)
+	schemaDef      *schema.Schema // schema interpretato da applyOrderOptions
+	csv            bool       // record CSV: colonne, virgolette e newline nei campi (--csv)
+	tsv            bool       // righe divise in colonne da tab, senza virgolette (--tsv)
+	csvDelimiter   string     // separatore dei campi CSV (--csv-delimiter)
+	csvComma       string     // separatore interpretato da configureCSV
+	csvKeys        stringList // colonne di ordinamento con tipo (--csv-key)
+	header         bool       // con --csv: il primo record è l'intestazione
+	skipHeader     int        // righe iniziali tenute fuori dall'ordinamento e riscritte in testa
+	jsonKeys       stringList // percorsi delle chiavi nei record JSON (--json-key)
+	avro           bool       // input in object container file Avro
+	avroOutput     string     // formato dell'output con --avro: avro o jsonl
+	jsonPaths      []jsonPath // chiavi interpretate da configureJSON
+	recordSize     int        // record binari di questa lunghezza, senza delimitatori (0 = righe)
+	framing        string     // codifica della lunghezza dei record binari (--record-framing)
+	protoFiles     stringList // FileDescriptorSet da caricare (--proto-descriptor)
+	protoMessage   string     // messaggio protobuf dei record delimitati
+	protoKeys      stringList // percorsi delle chiavi nei messaggi (--proto-key)
+	validateUTF8   string     // record con UTF-8 non valido: off, skip, replace o error
+	normalize      string     // normalizzazione Unicode delle chiavi: nfc, nfd ("" = nessuna)
+	normForm       norm.Form  // forma interpretata da applyOrderOptions
+	bom            string     // BOM dell'input: strip o keep (riscritto in output)
+	encoding       string     // codifica dell'input, convertita in UTF-8 durante la lettura
+	stable         bool       // a parità di chiave mantiene l'ordine di input
+	unique         bool       // scrive una sola riga per ogni chiave (come GNU sort -u)
+	count          bool       // scrive ogni chiave una volta con il numero di occorrenze
+	countPosition  string     // posizione del conteggio: prefix o suffix
+	ioProfile      string     // profilo dei buffer di I/O (--io-profile)
+	readerBuf      int        // buffer di lettura dei chunk scelto da configureIOBuffers
+	writerBuf      int        // buffer di scrittura dell'output scelto da configureIOBuffers
+	mmap           bool       // il merge legge i chunk mappandoli in memoria
+	prefetch       bool       // il merge legge in anticipo i chunk non mappati
+	directIO       bool       // chunk e run intermedi scritti con O_DIRECT
+	preallocate    bool       // chunk e output preallocati con fallocate
+	detectSorted   bool       // un input già ordinato viene copiato senza split né merge
+	inMemory       bool       // un input che sta in un chunk si ordina in memoria, senza file chunk
+	verifyContent  bool       // confronta i record dello split con quelli usciti dal merge
+	chunkCompress  string     // compressione dei chunk e dei run intermedi (--chunk-compression)
+	chunkFormat    string     // formato dei chunk e dei run intermedi (--chunk-format)
+	sealKeyPath    string     // chiave privata Ed25519 con cui sigillare l'output
+	sealKey        ed25519.PrivateKey
+	checksum       bool       // scrive OUTPUT.sha256 con checksum e righe dell'output
+	manifest       bool       // scrive OUTPUT.manifest.json con chiavi, conteggi e opzioni
+	objectEndpoint string     // endpoint compatibile S3 per l'output s3:// o gs://
+	objectPartMB   int        // dimensione in MiB delle parti dell'upload multipart
+	verifySeal     string     // sigillo da verificare invece di ordinare
+	sealPub        string     // chiave pubblica per --verify-seal
+	memHighArg     string     // soglia alta dell'heap durante lo split (--mem-watermark)
+	memHigh        uint64     // soglia interpretata in byte (0 = disattivata)
+	memBudgetArg   string     // memoria a disposizione del processo (--mem-budget)
+	memBudget      uint64     // budget interpretato in byte (0 = nessuno)
+	historyFile    string     // registro delle sessioni ("" = disattivato)
+	edges          int        // scrive solo i primi e gli ultimi N record (0 = tutti)
+	then           stringList // passi applicati allo stream ordinato (--then)
+	coop           string     // directory condivisa della modalità cooperativa
+	coopRangeBytes int64      // dimensione degli intervalli di input assegnati ai processi
+	coopFanIn      int        // chunk fusi da ogni gruppo intermedio
+	diskFullWait   time.Duration // attesa massima di spazio libero con disco pieno
+	ioRetries      int           // tentativi ripetuti dopo un errore di I/O transitorio
+	ioRetryDelay   time.Duration // attesa prima del primo tentativo ripetuto, poi raddoppiata
+	keepTemp       bool          // i file temporanei restano su disco a fine esecuzione
+	staleChunks    string        // chunk di esecuzioni precedenti: warn, refuse o clean
+	resume         bool          // riprende dal merge lo split completato da un'esecuzione fallita
+	spaceCheck     bool          // verifica lo spazio libero prima dello split
+	heartbeat      time.Duration // intervallo degli heartbeat (0 = disattivati)
+	stallAfter     time.Duration // tempo senza avanzamento dopo cui segnalare uno stallo
+	timeout        time.Duration // durata massima dell'esecuzione (0 = illimitata)
+}
+
+// opts contiene le opzioni dell'esecuzione corrente, valorizzate in main.
+var opts options
+
+// status riceve i messaggi di avanzamento. Viene spostato su stderr quando
+// l'output ordinato va su stdout e azzerato in modalità --gnu, silenziosa come sort.
+var status io.Writer = os.Stdout
+
+// parseFlags legge le opzioni da riga di comando e ne verifica la coerenza.
+func parseFlags() error {
+	flag.IntVar(&opts.maxRecordBytes, "max-record-bytes", 0, "dimensione massima di un record in byte (0 = nessun limite)")
+	flag.StringVar(&opts.oversizePolicy, "oversize-policy", oversizeReject, "gestione dei record oltre il limite: truncate, reject o divert")
+	flag.StringVar(&opts.oversizeFile, "oversize-file", "oversized.txt", "file in cui scrivere i record deviati (policy divert)")
+	flag.StringVar(&opts.rejectsFile, "rejects-file", "", "file in cui scrivere, così come sono stati letti, i record scartati dallo schema o da --validate-utf8 skip")
+	flag.BoolVar(&opts.ignoreBlanks, "ignore-leading-blanks", false, "ignora spazi e tab all'inizio della riga o dei campi delle chiavi, come GNU sort -b")
+	flag.BoolVar(&opts.dictionary, "dictionary-order", false, "confronta solo spazi, lettere e cifre ignorando la punteggiatura (le righe restano invariate), come GNU sort -d")
+	flag.BoolVar(&opts.ignoreCase, "ignore-case", false, "ordina ignorando maiuscole/minuscole (le righe restano invariate)")
+	flag.StringVar(&opts.locale, "locale", "", "ordina secondo le regole linguistiche della locale (es. it_IT) invece che byte per byte")
+	flag.Var(&opts.keys, "key", "ordina sul campo o intervallo di campi F[.C][OPTS][,F[.C][OPTS]] come GNU sort -k (ripetibile)")
+	flag.Var(&opts.keys, "k", "abbreviazione di --key")
+	flag.StringVar(&opts.keyBytes, "key-bytes", "", "confronta solo i LEN byte del record a partire da OFFSET (contato da 0), nel formato OFFSET:LEN")
+	flag.StringVar(&opts.fieldSep, "field-sep", "", "separatore di campo per --key (un carattere, es. ',' o '\\t'); default: sequenze di spazi")
+	flag.StringVar(&opts.fieldSep, "t", "", "abbreviazione di --field-sep")
+	flag.StringVar(&opts.runSet, "run-set", "", "conserva i chunk ordinati e il manifest in questa directory senza produrre il file unico")
+	flag.StringVar(&opts.query, "query", "", "interroga il run set in questa directory e scrive le righe ordinate su stdout")
+	flag.StringVar(&opts.queryFrom, "from", "", "con --query: prima chiave (inclusa) da restituire")
+	flag.StringVar(&opts.queryTo, "to", "", "con --query: ultima chiave (inclusa) da restituire")
+	flag.IntVar(&opts.queryLimit, "limit", 0, "con --query: numero massimo di righe (0 = tutte)")
+	flag.Var(&opts.tees, "tee", "invia lo stream ordinato anche a file:PATH, tcp:HOST:PORT o stats; suffisso ,drop per scartare invece di bloccare (ripetibile)")
+	flag.StringVar(&opts.pqDir, "pq", "", "avvia la coda di priorità su disco (comandi push/pop/len da stdin) con spill in questa directory")
+	flag.StringVar(&opts.partition, "partition", "", "divide l'output in un file ordinato per ogni valore della chiave F[.C][,F[.C]] (sintassi di --key)")
+	flag.StringVar(&opts.partitionDir, "partition-dir", "partitions", "con --partition: directory dei file di output per partizione")
+	flag.StringVar(&opts.partitionBy, "partition-by", "", "il merge scrive l'output in un file per intervallo di chiavi: prefix:N (primi N caratteri) o range:K1,K2,... (chiavi di confine), in --partition-dir")
+	flag.BoolVar(&opts.numeric, "numeric", false, "confronta le righe (o le chiavi) come numeri, come GNU sort -n")
+	flag.BoolVar(&opts.natural, "natural", false, "ordine naturale: i numeri nel testo si confrontano per valore (file2 prima di file10)")
+	flag.BoolVar(&opts.versionSort, "version-sort", false, "confronta le righe (o le chiavi) come numeri di versione, come GNU sort -V (v1.2.9 prima di v1.2.10)")
+	flag.BoolVar(&opts.humanNumeric, "human-numeric", false, "confronta le righe (o le chiavi) come dimensioni con suffisso (1K < 23M < 4G), come GNU sort -h")
+	flag.StringVar(&opts.timestamp, "timestamp", "", "ordina per il timestamp all'inizio della riga (o della chiave): rfc3339, syslog, epoch o un layout Go come \"2006-01-02 15:04:05\"")
+	flag.BoolVar(&opts.ip, "ip", false, "ordina per l'indirizzo IPv4 o IPv6 all'inizio della riga (o della chiave), come numero")
+	flag.StringVar(&opts.ipOrder, "ip-order", ipV4First, "con --ip, ordine delle famiglie: v4-first, v6-first o mixed (IPv4 come ::ffff:a.b.c.d)")
+	flag.BoolVar(&opts.idTime, "id-time", false, "ordina per il timestamp contenuto nell'UUIDv7 o ULID all'inizio della riga (o della chiave)")
+	flag.BoolVar(&opts.byLength, "by-length", false, "ordina le righe (o le chiavi) per lunghezza in caratteri e, a parità di lunghezza, con il confronto normale")
+	flag.BoolVar(&opts.monthSort, "month-sort", false, "confronta le righe (o le chiavi) come abbreviazioni di mesi JAN < FEB < ... < DEC, come GNU sort -M")
+	flag.BoolVar(&opts.random, "random", false, "mescola le righe ordinandole per hash, come GNU sort -R; le righe uguali restano vicine")
+	flag.StringVar(&opts.randomSeed, "random-seed", "", "con --random: seme dell'hash, per ripetere lo stesso ordine (vuoto = casuale)")
+	flag.BoolVar(&opts.reverse, "reverse", false, "inverte l'ordine del confronto, come GNU sort -r")
+	flag.BoolVar(&opts.stable, "stable", false, "a parità di chiave mantiene l'ordine di input invece di confrontare le righe intere")
+	flag.BoolVar(&opts.unique, "unique", false, "scrive una sola riga per ogni chiave, scartando i duplicati durante il merge")
+	flag.BoolVar(&opts.count, "count", false, "scrive ogni riga distinta una sola volta con il numero di occorrenze, come uniq -c")
+	flag.Var(&opts.then, "then", "passo applicato allo stream ordinato nello stesso processo: unique, count, head:N o partition:KEY (ripetibile, in ordine)")
+	flag.IntVar(&opts.edges, "edges", 0, "scrive solo i primi e gli ultimi N record dell'ordinamento, con statistiche riassuntive (0 = output completo)")
+	flag.StringVar(&opts.coop, "coop", "", "directory condivisa con cui più processi cooperano allo stesso ordinamento (intervalli di input e gruppi di merge)")
+	flag.Int64Var(&opts.coopRangeBytes, "coop-range-bytes", 256<<20, "con --coop: byte di input per ogni intervallo assegnato a un processo")
+	flag.IntVar(&opts.coopFanIn, "coop-fan-in", 16, "con --coop: chunk fusi da ogni gruppo intermedio")
+	flag.BoolVar(&opts.intern, "intern", false, "i record uguali di un chunk condividono un'unica copia in memoria: con dati molto ripetitivi un chunk contiene molti più record")
+	flag.StringVar(&opts.runGen, "run-generation", runGenSort, "generazione dei run iniziali: sort (chunk ordinati in parallelo dai worker) o replacement (replacement selection: run lunghi in media il doppio di --chunk-bytes, un solo thread)")
+	flag.StringVar(&opts.chunkSort, "chunk-sort", chunkSortAuto, "algoritmo di ordinamento dei chunk in memoria: auto (radix se le chiavi hanno lunghezza fissa), std, radix (chiavi corte o fisse), parallel o stable (dati quasi ordinati)")
+	flag.DurationVar(&opts.heartbeat, "heartbeat", 0, "scrive a questo intervallo la fase corrente e i byte elaborati (es. 1m; 0 = mai)")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "annulla l'esecuzione dopo questa durata (es. 2h; 0 = mai), scrivendo cancel.json nella directory dei chunk")
+	flag.DurationVar(&opts.stallAfter, "stall-after", 10*time.Minute, "senza avanzamento per questa durata segnala un probabile stallo e scrive un file di diagnostica (0 = mai)")
+	flag.StringVar(&opts.staleChunks, "stale-chunks", staleWarn, "chunk di esecuzioni precedenti nella directory dei chunk: warn (segnalati e ignorati), refuse (l'esecuzione non parte) o clean (rimossi)")
+	flag.IntVar(&opts.ioRetries, "io-retries", 3, "tentativi ripetuti dopo un errore di I/O transitorio (EINTR, EAGAIN, EIO, ETIMEDOUT) creando, aprendo, leggendo o scrivendo chunk, input e output (0 = nessuno)")
+	flag.DurationVar(&opts.ioRetryDelay, "io-retry-delay", 200*time.Millisecond, "attesa prima del primo tentativo ripetuto di --io-retries, raddoppiata a ogni tentativo (al massimo 30s)")
+	flag.BoolVar(&opts.resume, "resume", false, "dopo lo split salva resume.json nella directory dei chunk e, se l'esecuzione fallisce, conserva i chunk; rilanciando con gli stessi input e le stesse opzioni riprende dal merge")
+	flag.BoolVar(&opts.keepTemp, "keep-temp", false, "conserva chunk, run intermedi e directory delle partizioni invece di rimuoverli a fine esecuzione, riuscita o no (per il debug)")
+	flag.BoolVar(&opts.spaceCheck, "space-check", true, "prima dello split verifica che i file system dei chunk e dell'output abbiano spazio per circa due volte l'input e termina subito se non basta")
+	flag.DurationVar(&opts.diskFullWait, "disk-full-wait", 0, "con il disco pieno attende fino a questa durata che si liberi spazio invece di terminare (es. 30m)")
+	flag.StringVar(&opts.countPosition, "count-position", "prefix", "con --count: conteggio prima (prefix, come uniq -c) o dopo la riga (suffix, separato da tab)")
+	flag.BoolVar(&opts.check, "check", false, "verifica che l'input sia già ordinato invece di ordinarlo")
+	flag.BoolVar(&opts.merge, "merge", false, "i file di input sono già ordinati: esegue solo il merge")
+	flag.Var(&opts.inputs, "input", "file di input da ordinare, - per stdin (ripetibile; default random_2gb_data)")
+	flag.StringVar(&opts.output, "output", "merged.txt", "file di output ordinato, - per stdout, s3://BUCKET/KEY o gs://BUCKET/KEY")
+	flag.StringVar(&opts.objectEndpoint, "object-endpoint", "", "con output s3:// o gs://: endpoint compatibile S3 (es. http://localhost:9000 per MinIO), con URL in stile path")
+	flag.IntVar(&opts.objectPartMB, "object-part-mb", 64, "con output s3:// o gs://: dimensione in MiB delle parti dell'upload multipart (5-5120)")
+	flag.StringVar(&opts.chunkDir, "chunk-dir", "chunks", "directory dei chunk temporanei")
+	flag.IntVar(&opts.chunkBytes, "chunk-bytes", 0, "dimensione massima in byte di un chunk ordinato in memoria (0 = in base alla memoria disponibile)")
+	flag.IntVar(&opts.mergeFanIn, "merge-fan-in", 0, "file fusi al massimo da un singolo merge: oltre, i chunk vengono fusi a livelli in run intermedi (0 = secondo il profilo I/O dei chunk: 64 su hdd e rete, 256 su ssd, 1024 su nvme)")
+	flag.IntVar(&opts.workers, "workers", runtime.NumCPU(), "numero di worker che ordinano i chunk in parallelo")
+	flag.IntVar(&opts.ioWorkers, "io-workers", 2, "numero di worker che scrivono su disco i chunk ordinati, mentre i worker di --workers ordinano i successivi")
+	flag.IntVar(&opts.parseWorkers, "parse-workers", runtime.NumCPU()/2, "goroutine che dividono e analizzano in parallelo i blocchi di input dello split, letti da una goroutine dedicata (0 = lettura e analisi nella goroutine dello split)")
+	flag.StringVar(&opts.ioProfile, "io-profile", "auto", "dimensioni dei buffer di I/O: auto (rileva il dispositivo), fixed, hdd, ssd, nvme o network")
+	flag.BoolVar(&opts.mmap, "mmap", true, "il merge legge i chunk mappandoli in memoria (mmap), senza copie né letture, dove il sistema lo supporta")
+	flag.BoolVar(&opts.directIO, "direct-io", false, "scrive i chunk e i run intermedi con O_DIRECT (Linux), senza passare dalla page cache")
+	flag.StringVar(&opts.chunkCompress, "chunk-compression", chunkCompressNone, "compressione dei chunk e dei run intermedi: none, snappy o zstd (meno byte su disco, più CPU)")
+	flag.StringVar(&opts.chunkFormat, "chunk-format", chunkFormatText, "formato dei chunk e dei run intermedi: text (righe con separatore) o binary (record preceduti dalla lunghezza, letti dal merge senza cercare i separatori)")
+	flag.BoolVar(&opts.preallocate, "preallocate", true, "prealloca su disco (fallocate, Linux) i chunk e l'output di dimensione nota, per ridurre la frammentazione")
+	flag.BoolVar(&opts.verifyContent, "verify-content", false, "verifica alla fine che il merge abbia restituito esattamente i record letti dallo split (conteggio e hash indipendente dall'ordine), fallendo se differiscono")
+	flag.BoolVar(&opts.inMemory, "in-memory", true, "un input che sta in un solo chunk (--chunk-bytes) viene ordinato in memoria e scritto nell'output senza passare dai file chunk")
+	flag.BoolVar(&opts.detectSorted, "detect-sorted", true, "verifica prima dello split se l'input è già ordinato e in quel caso lo copia nell'output senza split né merge")
+	flag.BoolVar(&opts.prefetch, "prefetch", true, "il merge legge in background il prossimo blocco di righe dei chunk non mappati, mentre consuma quello corrente")
+	flag.StringVar(&opts.sealKeyPath, "seal-key", "", "chiave privata Ed25519 (PEM PKCS#8): firma il manifest dell'output in OUTPUT.seal.json")
+	flag.BoolVar(&opts.checksum, "checksum", false, "calcola lo SHA-256 dell'output durante il merge e lo scrive in OUTPUT.sha256 (formato di sha256sum, con il numero di righe)")
+	flag.BoolVar(&opts.manifest, "manifest", false, "scrive OUTPUT.manifest.json con prima e ultima chiave, record, byte, checksum, chunk, versione e opzioni dell'esecuzione")
+	flag.StringVar(&opts.verifySeal, "verify-seal", "", "verifica il sigillo indicato e il file di output a cui si riferisce")
+	flag.StringVar(&opts.sealPub, "seal-pub", "", "con --verify-seal: chiave pubblica Ed25519 (PEM) del firmatario")
+	flag.StringVar(&opts.memBudgetArg, "mem-budget", "auto", "memoria a disposizione del processo, per il limite di memoria del runtime e il GC di ogni fase: dimensione (es. 4G), auto (memoria disponibile e limite del container) o 0 (comportamento predefinito di Go)")
+	flag.StringVar(&opts.memHighArg, "mem-watermark", "0", "soglia dell'heap oltre cui lo split scrive subito il chunk corrente e svuota la coda: dimensione (es. 512M), auto (80% del limite del container) o 0")
+	flag.StringVar(&opts.historyFile, "history-file", defaultHistoryFile(), "registro delle sessioni ripetibili con il sottocomando history (vuoto = disattivato)")
+	flag.StringVar(&opts.lineEndings, "line-endings", eolAuto, "terminatori di riga: auto (come la prima riga dell'input), lf, crlf o preserve (ogni riga conserva il proprio)")
+	flag.StringVar(&opts.compression, "input-compression", compressAuto, "compressione dell'input: auto (riconosciuta dai magic byte o dall'estensione), none, gzip, zstd, bzip2 o xz")
+	flag.StringVar(&opts.outCompression, "output-compression", compressNone, "compressione del file di output, applicata durante il merge: none, gzip o zstd")
+	flag.IntVar(&opts.outLevel, "output-compression-level", 0, "livello di --output-compression: 1-9 per gzip, 1-22 per zstd (0 = predefinito)")
+	flag.BoolVar(&opts.zeroTerminated, "zero-terminated", false, "record separati da NUL invece che da newline, in input e in output (come GNU sort -z, per find -print0)")
+	flag.BoolVar(&opts.csv, "csv", false, "input CSV: i campi tra virgolette possono contenere separatori e newline e --key indica le colonne (equivale a --schema csv)")
+	flag.StringVar(&opts.csvDelimiter, "csv-delimiter", ",", "con --csv: separatore dei campi (un carattere, es. ';' o '\\t')")
+	flag.BoolVar(&opts.tsv, "tsv", false, "input TSV: colonne separate da tab, senza virgolette né escape; --key e --csv-key indicano le colonne (equivale a --schema tsv)")
+	flag.Var(&opts.csvKeys, "csv-key", "con --csv o --tsv: colonna di ordinamento COL[:TIPO][:desc], per numero o nome; tipi text, nocase, num, human, version, month (ripetibile)")
+	flag.BoolVar(&opts.header, "header", false, "con --csv o --tsv: il primo record è l'intestazione e resta in testa all'output")
+	flag.IntVar(&opts.skipHeader, "skip-header", 0, "righe iniziali dell'input tenute fuori dall'ordinamento e scritte invariate in testa all'output")
+	flag.Var(&opts.jsonKeys, "json-key", "input JSON Lines: ordina sul valore nel percorso PATH[:TIPO][:desc], es. user.id o items[0].sku (ripetibile; equivale a --schema jsonl)")
+	flag.BoolVar(&opts.avro, "avro", false, "input in object container file Avro, ordinati secondo --json-key sulla forma JSON dei record")
+	flag.StringVar(&opts.avroOutput, "avro-output", avroOutAvro, "con --avro: output avro (stesso schema dell'input) o jsonl")
+	flag.IntVar(&opts.recordSize, "record-size", 0, "input binario di record da N byte senza delimitatori, confrontati byte per byte (chiave con --key-bytes; equivale a --schema binary)")
+	flag.StringVar(&opts.framing, "record-framing", "", "input binario di record preceduti dalla lunghezza: uint32be, uint32le o varint (protobuf delimitati); chiave con --key-bytes sul contenuto o con uno schema registrato")
+	flag.Var(&opts.protoFiles, "proto-descriptor", "FileDescriptorSet (protoc --descriptor_set_out) con i messaggi di --proto-message (ripetibile)")
+	flag.StringVar(&opts.protoMessage, "proto-message", "", "input di messaggi protobuf delimitati (varint, salvo --record-framing) del tipo indicato, es. pkg.Evento")
+	flag.Var(&opts.protoKeys, "proto-key", "con --proto-message: campo di ordinamento PATH[:TIPO][:desc], es. user.id o items[0].sku (ripetibile)")
+	flag.StringVar(&opts.validateUTF8, "validate-utf8", utf8Off, "record con sequenze UTF-8 non valide: off, skip (scartati e contati), replace (con U+FFFD) o error")
+	flag.StringVar(&opts.normalize, "normalize", "", "normalizza le chiavi in forma Unicode nfc o nfd prima del confronto (le righe restano invariate)")
+	flag.StringVar(&opts.encoding, "input-encoding", charsetUTF8, "codifica dell'input, convertita in UTF-8 durante la lettura: utf-8, latin1 (iso-8859-1), iso-8859-15, windows-1252 (cp1252), utf-16le o utf-16be")
+	flag.StringVar(&opts.bom, "bom", bomStrip, "BOM UTF-8/UTF-16 all'inizio dell'input: strip (tolto, output UTF-8) o keep (riscritto in testa all'output, con la stessa codifica)")
+	flag.StringVar(&opts.schema, "schema", defaultSchemaName, "formato dei record registrato con schema.Register (parser, chiave e formato di output)")
+
+	if len(os.Args) > 1 && (os.Args[1] == "--gnu" || os.Args[1] == "-gnu") {
+		opts.gnu = true
+		if err := parseGNUArgs(os.Args[2:]); err != nil {
+			return err
+		}
+	} else {
+		flag.Parse()
+	}
+	if len(opts.inputs) == 0 {
+		opts.inputs = stringList{"random_2gb_data"}
+	}
+
+	switch opts.oversizePolicy {
+	case oversizeTruncate, oversizeReject, oversizeDivert:
+	default:
+		return fmt.Errorf("politica --oversize-policy non valida: %q", opts.oversizePolicy)
+	}
+	if opts.maxRecordBytes < 0 {
+		return fmt.Errorf("--max-record-bytes non può essere negativo")
+	}
+	if opts.countPosition != "prefix" && opts.countPosition != "suffix" {
+		return fmt.Errorf("--count-position non valida: %q (attesi prefix o suffix)", opts.countPosition)
+	}
+	if err := parseChunkSort(opts.chunkSort); err != nil {
+		return err
+	}
+	if err := parseRunGeneration(opts.runGen); err != nil {
+		return err
+	}
+	if opts.runGen == runGenReplacement && opts.partition != "" {
+		return fmt.Errorf("--run-generation replacement non è combinabile con --partition")
+	}
+	if err := parseInputCompression(opts.compression); err != nil {
+		return err
+	}
+	if err := parseInputEncoding(opts.encoding); err != nil {
+		return err
+	}
+	if err := parseOutputCompression(); err != nil {
+		return err
+	}
+	if err := parseChunkCompression(opts.chunkCompress); err != nil {
+		return err
+	}
+	if err := parseChunkFormat(opts.chunkFormat); err != nil {
+		return err
+	}
+	// L'indice dei run set punta a offset dei chunk non compressi.
+	if opts.chunkCompress != chunkCompressNone && (opts.runSet != "" || opts.query != "") {
+		return fmt.Errorf("--chunk-compression non è combinabile con --run-set e --query")
+	}
+	// I run set restano leggibili come testo da altri strumenti.
+	if opts.chunkFormat != chunkFormatText && (opts.runSet != "" || opts.query != "") {
+		return fmt.Errorf("--chunk-format binary non è combinabile con --run-set e --query")
+	}
+	if err := checkVerifyContent(); err != nil {
+		return err
+	}
+	if opts.ioRetries < 0 || opts.ioRetryDelay < 0 {
+		return fmt.Errorf("--io-retries e --io-retry-delay non possono essere negativi")
+	}
+	switch opts.staleChunks {
+	case staleWarn, staleRefuse, staleClean:
+	default:
+		return fmt.Errorf("--stale-chunks non valido: %q (attesi warn, refuse o clean)", opts.staleChunks)
+	}
+	if opts.resume {
+		if opts.merge || opts.check || opts.coop != "" || opts.pqDir != "" || opts.query != "" || opts.runSet != "" || opts.partition != "" || opts.verifyContent {
+			return fmt.Errorf("--resume riprende il merge finale di uno split: non è combinabile con --merge, --check, --coop, --pq, --query, --run-set, --partition e --verify-content")
+		}
+		if opts.gnuTempDir {
+			return fmt.Errorf("--resume richiede una directory dei chunk stabile: con --gnu indicarla con -T")
+		}
+		for _, in := range opts.inputs {
+			if in == "-" || isObjectURL(in) {
+				return fmt.Errorf("--resume richiede file di input locali, per verificare che non siano cambiati dopo lo split")
+			}
+		}
+	}
+	if opts.outCompression != compressNone && (opts.runSet != "" || opts.query != "" || opts.pqDir != "") {
+		return fmt.Errorf("--output-compression vale per il file di output: non è combinabile con --run-set, --query e --pq")
+	}
+	// --coop legge gli input per intervalli di byte.
+	if opts.coop != "" {
+		if inputCharset != nil {
+			return fmt.Errorf("--coop richiede input UTF-8: --input-encoding non è disponibile")
+		}
+		for _, in := range opts.inputs {
+			if compressedInput(in) || hasBOM(in) {
+				return fmt.Errorf("%s: --coop richiede input non compressi e senza BOM", in)
+			}
+		}
+	}
+	if opts.diskFullWait < 0 {
+		return fmt.Errorf("--disk-full-wait non può essere negativo")
+	}
+	if len(opts.then) > 0 {
+		// La catena viene verificata subito, prima di leggere l'input.
+		_, terminal, err := newPipeline(opts.then, &sinkStage{})
+		if err != nil {
+			return err
+		}
+		if terminal && (opts.partition != "" || opts.partitionBy != "" || opts.sealKeyPath != "" || opts.checksum || opts.manifest || len(opts.tees) > 0 || opts.header || opts.skipHeader > 0) {
+			return fmt.Errorf("--then partition non è combinabile con --partition, --partition-by, --seal-key, --checksum, --manifest, --tee, --header e --skip-header")
+		}
+		if opts.edges > 0 {
+			return fmt.Errorf("--then non è combinabile con --edges")
+		}
+	}
+	// I record CSV vengono letti a righe e possono continuare su quelle
+	// successive: servono l'input intero e '\n' come separatore.
+	if opts.csv || opts.schema == csvSchemaName {
+		if opts.merge || opts.coop != "" || opts.pqDir != "" || opts.zeroTerminated {
+			return fmt.Errorf("--csv non è combinabile con --merge, --coop, --pq e --zero-terminated")
+		}
+	}
+	// I record binari non hanno separatori né testo: niente modalità a righe.
+	if opts.recordSize < 0 {
+		return fmt.Errorf("--record-size non può essere negativo")
+	}
+	// I messaggi protobuf sono delimitati, salvo indicazione contraria, da un varint.
+	if opts.protoMessage != "" && opts.framing == "" {
+		opts.framing = framingVarint
+	}
+	if err := parseRecordFraming(opts.framing); err != nil {
+		return err
+	}
+	if err := parseValidateUTF8(opts.validateUTF8); err != nil {
+		return err
+	}
+	if err := parseBOM(opts.bom); err != nil {
+		return err
+	}
+	if binaryRecords() {
+		if opts.coop != "" || opts.pqDir != "" || opts.runSet != "" || opts.partition != "" || opts.count || opts.zeroTerminated || opts.lineEndings != eolAuto {
+			return fmt.Errorf("--record-size e --record-framing non sono combinabili con --coop, --pq, --run-set, --partition, --count, --zero-terminated e --line-endings")
+		}
+		if opts.validateUTF8 != utf8Off || opts.normalize != "" || inputCharset != nil {
+			return fmt.Errorf("--validate-utf8, --normalize e --input-encoding valgono solo per i record di testo")
+		}
+		for _, step := range opts.then {
+			if step == "count" || strings.HasPrefix(step, "partition:") {
+				return fmt.Errorf("--record-size e --record-framing non sono combinabili con --then %s", step)
+			}
+		}
+	}
+	// I file Avro diventano record di testo durante la lettura; l'output Avro
+	// viene ricostruito dalle righe dell'unico file di output.
+	if err := parseAvroOutput(opts.avroOutput); err != nil {
+		return err
+	}
+	if opts.avro {
+		if opts.merge || opts.coop != "" || opts.pqDir != "" || opts.runSet != "" || opts.partition != "" || opts.count || opts.edges > 0 || opts.header || opts.skipHeader > 0 || opts.zeroTerminated || opts.lineEndings != eolAuto || inputCharset != nil {
+			return fmt.Errorf("--avro non è combinabile con --merge, --coop, --pq, --run-set, --partition, --count, --edges, --header, --skip-header, --zero-terminated, --line-endings e --input-encoding")
+		}
+		for _, step := range opts.then {
+			if avroOutput() && (step == "count" || strings.HasPrefix(step, "partition:")) {
+				return fmt.Errorf("--avro-output avro non è combinabile con --then %s: usa --avro-output jsonl", step)
+			}
+		}
+		if avroOutput() && len(opts.tees) > 0 {
+			return fmt.Errorf("--avro-output avro non è combinabile con --tee: usa --avro-output jsonl")
+		}
+	}
+	// --partition-by sostituisce l'output unico con i file di --partition-dir.
+	if opts.partitionBy != "" {
+		if _, _, err := parsePartitionBy(opts.partitionBy); err != nil {
+			return err
+		}
+		if opts.partition != "" || opts.sealKeyPath != "" || opts.checksum || opts.manifest || len(opts.tees) > 0 || opts.header || opts.skipHeader > 0 || opts.edges > 0 || opts.runSet != "" || opts.query != "" || opts.pqDir != "" || opts.outCompression != compressNone {
+			return fmt.Errorf("--partition-by non è combinabile con --partition, --seal-key, --checksum, --manifest, --tee, --header, --skip-header, --edges, --run-set, --query, --pq e --output-compression")
+		}
+		if binaryRecords() || opts.avro && avroOutput() {
+			return fmt.Errorf("--partition-by vale solo per i record di testo (con --avro serve --avro-output jsonl)")
+		}
+	}
+	// L'intestazione viene tolta durante lo split e va in testa all'unico file di output.
+	if opts.skipHeader < 0 {
+		return fmt.Errorf("--skip-header non può essere negativo")
+	}
+	if (opts.header || opts.skipHeader > 0) && (opts.merge || opts.coop != "" || opts.pqDir != "" || opts.runSet != "" || opts.partition != "" || opts.edges > 0 || binaryRecords()) {
+		return fmt.Errorf("--header e --skip-header non sono combinabili con --merge, --coop, --pq, --run-set, --partition, --edges e i record binari")
+	}
+	// La coda di priorità riceve comandi a righe: i record non possono contenere newline.
+	if opts.zeroTerminated && opts.pqDir != "" {
+		return fmt.Errorf("--zero-terminated non è combinabile con --pq")
+	}
+	if opts.edges < 0 {
+		return fmt.Errorf("--edges non può essere negativo")
+	}
+	if opts.edges > 0 && (opts.count || opts.runSet != "" || opts.partition != "" || len(opts.tees) > 0 || opts.sealKeyPath != "" || opts.checksum || opts.manifest) {
+		return fmt.Errorf("--edges non è combinabile con --count, --run-set, --partition, --tee, --seal-key, --checksum e --manifest")
+	}
+	if opts.coop != "" {
+		if opts.coopRangeBytes <= 0 || opts.coopFanIn < 2 {
+			return fmt.Errorf("--coop-range-bytes deve essere positivo e --coop-fan-in almeno 2")
+		}
+		if opts.runSet != "" || opts.partition != "" || opts.oversizePolicy == oversizeDivert || opts.rejectsFile != "" {
+			return fmt.Errorf("--coop non è combinabile con --run-set, --partition, --oversize-policy divert e --rejects-file")
+		}
+		for _, in := range opts.inputs {
+			if in == "-" {
+				return fmt.Errorf("--coop richiede file di input, non stdin")
+			}
+		}
+	}
+	if opts.chunkBytes < 0 || opts.workers <= 0 || opts.ioWorkers <= 0 || opts.parseWorkers < 0 {
+		return fmt.Errorf("--chunk-bytes e --parse-workers non possono essere negativi, --workers e --io-workers devono essere positivi")
+	}
+	if opts.mergeFanIn != 0 && opts.mergeFanIn < 2 {
+		return fmt.Errorf("--merge-fan-in deve essere almeno 2 (0 = secondo il profilo I/O)")
+	}
+	if opts.check && len(opts.inputs) > 1 {
+		return fmt.Errorf("--check accetta un solo file di input")
+	}
+	wm, err := parseMemWatermark(opts.memHighArg)
+	if err != nil {
+		return err
+	}
+	opts.memHigh = wm
+	if opts.memBudget, err = parseMemBudget(opts.memBudgetArg); err != nil {
+		return err
+	}
+	if opts.sealKeyPath != "" {
+		if opts.output == "-" {
+			return fmt.Errorf("--seal-key richiede un file di output, non stdout")
+		}
+		key, err := loadSealKey(opts.sealKeyPath)
+		if err != nil {
+			return err
+		}
+		opts.sealKey = key
+	}
+	// L'output su object store viene caricato durante il merge, senza file
+	// locale accanto a cui scrivere sigillo e checksum.
+	if u, ok, err := parseObjectURL(opts.output); ok {
+		if err != nil {
+			return err
+		}
+		if opts.sealKeyPath != "" || opts.checksum || opts.manifest || opts.coop != "" {
+			return fmt.Errorf("%s://%s/%s: l'output su object store non è combinabile con --seal-key, --checksum, --manifest e --coop", u.scheme, u.bucket, u.key)
+		}
+		if opts.objectPartMB < objectMinPartMB || opts.objectPartMB > objectMaxPartMB {
+			return fmt.Errorf("--object-part-mb deve essere tra %d e %d", objectMinPartMB, objectMaxPartMB)
+		}
+		// Credenziali ed endpoint si verificano prima di leggere l'input.
+		if _, err := newObjectClient(u); err != nil {
+			return err
+		}
+	}
+	if (opts.checksum || opts.manifest) && (opts.output == "-" || opts.runSet != "" || opts.query != "" || opts.pqDir != "") {
+		return fmt.Errorf("--checksum e --manifest richiedono un file di output e non sono combinabili con --run-set, --query e --pq")
+	}
+	if opts.verifySeal != "" && opts.sealPub == "" {
+		return fmt.Errorf("--verify-seal richiede --seal-pub")
+	}
+	if opts.partition != "" {
+		k, err := parseKeySpec(opts.partition)
+		if err != nil {
+			return fmt.Errorf("--partition: %w", err)
+		}
+		opts.partitionKey = &k
+		if opts.runSet != "" || len(opts.tees) > 0 {
+			return fmt.Errorf("--partition non è combinabile con --run-set e --tee")
+		}
+	}
+	return nil
+}
+
+// applyOrderOptions prepara le strutture che dipendono dalle opzioni di
+// ordinamento. Va richiamata dopo ogni modifica di queste opzioni.
+func applyOrderOptions() error {
+	// --csv, --tsv, --json-key e --avro selezionano il proprio schema. Con
+	// --avro le chiavi di --json-key valgono per la forma JSON dei record.
+	selected := ""
+	for _, m := range []struct {
+		set        bool
+		flag, name string
+	}{{opts.csv, "--csv", csvSchemaName}, {opts.tsv, "--tsv", tsvSchemaName}, {len(opts.jsonKeys) > 0 && !opts.avro, "--json-key", jsonSchemaName}, {opts.avro, "--avro", avroSchemaName}, {opts.recordSize > 0, "--record-size", binarySchemaName}} {
+		if !m.set {
+			continue
+		}
+		if selected != "" {
+			return fmt.Errorf("%s e %s si escludono a vicenda", selected, m.flag)
+		}
+		if opts.schema != defaultSchemaName && opts.schema != m.name {
+			return fmt.Errorf("%s non è combinabile con --schema %s", m.flag, opts.schema)
+		}
+		opts.schema, selected = m.name, m.flag
+	}
+	// Con --record-framing uno schema registrato riceve il contenuto di ogni
+	// record e può estrarne la chiave; --proto-message seleziona lo schema
+	// dei messaggi protobuf.
+	if opts.framing != "" {
+		if selected != "" {
+			return fmt.Errorf("%s e --record-framing si escludono a vicenda", selected)
+		}
+		switch {
+		case opts.protoMessage != "":
+			if opts.schema != defaultSchemaName && opts.schema != protoSchemaName {
+				return fmt.Errorf("--proto-message non è combinabile con --schema %s", opts.schema)
+			}
+			opts.schema = protoSchemaName
+		case opts.schema == defaultSchemaName:
+			opts.schema = framedSchemaName
+		}
+	}
+	def, err := schema.Lookup(opts.schema)
+	if err != nil {
+		return err
+	}
+	opts.schemaDef = def
+	sep, err := parseFieldSep(opts.fieldSep)
+	if err != nil {
+		return err
+	}
+	opts.separator = sep
+	opts.keySpecs = opts.keySpecs[:0]
+	for _, spec := range opts.keys {
+		k, err := parseKeySpec(spec)
+		if err != nil {
+			return err
+		}
+		opts.keySpecs = append(opts.keySpecs, k)
+	}
+	if opts.keyOffset, opts.keyLen, err = parseKeyBytes(opts.keyBytes); err != nil {
+		return err
+	}
+	if opts.keyLen > 0 && len(opts.keySpecs) > 0 {
+		return fmt.Errorf("--key-bytes non è combinabile con --key")
+	}
+	if err := configureCSV(); err != nil {
+		return err
+	}
+	if err := configureJSON(); err != nil {
+		return err
+	}
+	if err := configureProto(); err != nil {
+		return err
+	}
+	if err := configureBinary(); err != nil {
+		return err
+	}
+	if opts.tsFormat, err = parseTimestampFormat(opts.timestamp); err != nil {
+		return err
+	}
+	if err := parseIPOrder(opts.ipOrder); err != nil {
+		return err
+	}
+	if opts.normForm, err = parseNormalize(opts.normalize); err != nil {
+		return err
+	}
+	// Come in GNU sort: il filtro di -d non ha senso su numeri e mesi.
+	if opts.dictionary && (opts.numeric || opts.humanNumeric || opts.monthSort) {
+		return fmt.Errorf("--dictionary-order non è combinabile con --numeric, --human-numeric e --month-sort")
+	}
+	// Con --dry-run il seme non serve e la traduzione resta quella del comando.
+	if opts.random && !opts.dryRun {
+		if err := initRandom(); err != nil {
+			return err
+		}
+	}
+	if opts.locale != "" {
+		return initCollation(opts.locale)
+	}
+	return nil
+}
+
 func main() {
-	inputPath := "random_2gb_data" // file di input da ordinare
-	outputDir := "chunks"        // cartella in cui scrivere i chunk ordinati
-	outputFile := "merged.txt"   // file di output con il merge finale ordinato
+	defer removeTempsOnPanic()
+	// Sottocomando history: elenca e ripete le sessioni registrate.
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		if err := runHistory(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if exit, ok := err.(*exec.ExitError); ok {
+				os.Exit(exit.ExitCode())
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	if err := parseFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := applyOrderOptions(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := configureLineEndings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// I confini di --partition-by si verificano sulle chiavi, prima dello split.
+	if opts.partitionBy != "" {
+		if _, err := newRangeStage(opts.partitionBy, opts.partitionDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	// Verifica di un sigillo prodotto da un'esecuzione precedente.
+	if opts.verifySeal != "" {
+		if err := verifySeal(opts.verifySeal, opts.sealPub, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	outputDir := opts.chunkDir   // cartella in cui scrivere i chunk ordinati
+	outputFile := opts.output    // file di output con il merge finale ordinato
+	if opts.runSet != "" {
+		outputDir = opts.runSet
+	}
+	switch {
+	case opts.gnu:
+		status = io.Discard
+	case outputFile == "-" || opts.query != "" || opts.pqDir != "":
+		status = os.Stderr
+	}
+
+	// I chunk letti nel merge stanno nella directory dei chunk, nel run set
+	// interrogato o, con --merge, accanto ai file di input.
+	readDir := outputDir
+	switch {
+	case opts.query != "":
+		readDir = opts.query
+	case opts.merge:
+		readDir = filepath.Dir(opts.inputs[0])
+	}
+	if err := configureIOBuffers(readDir, outputFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	applyMemBudget()
+	configureChunkSize()
+
+	// Le esecuzioni vere e proprie finiscono nel registro delle sessioni.
+	if !opts.dryRun {
+		recordSession(opts.historyFile)
+	}
+
+	// Con --gnu --dry-run mostra soltanto le opzioni native equivalenti.
+	if opts.dryRun {
+		args := []string{filepath.Base(os.Args[0])}
+		for _, a := range flagArgs(nil) {
+			args = append(args, shellQuote(a))
+		}
+		fmt.Println(strings.Join(args, " "))
+		return
+	}
+	// Stima dello spazio da scrivere per i messaggi di disco pieno: i chunk
+	// occupano quanto l'input e l'output altrettanto; con --merge solo l'output.
+	diskPlan.chunkDir = outputDir
+	if opts.coop != "" {
+		diskPlan.chunkDir = opts.coop
+	}
+	diskPlan.expect = 2 * inputSize(opts.inputs)
+	if opts.merge {
+		diskPlan.expect /= 2
+	}
+
+	// Heartbeat e rilevamento degli stalli; la coda di priorità resta ferma
+	// in attesa di comandi e non va considerata in stallo.
+	if opts.pqDir == "" {
+		startHeartbeat(opts.heartbeat, opts.stallAfter, diskPlan.chunkDir)
+	}
+	// Annullamento per segnale o --timeout, con rapporto nella directory dei chunk.
+	watchCancel(opts.timeout, diskPlan.chunkDir)
+
+	// Modalità verifica: nessun file viene scritto.
+	if opts.check {
+		setPhase("verifica")
+		if err := checkSorted(opts.inputs[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		return
+	}
+	// Un'altra esecuzione sulla stessa directory dei chunk o sullo stesso
+	// output viene fermata subito, prima di scrivere qualsiasi file.
+	if opts.query == "" && opts.pqDir == "" && opts.coop == "" {
+		if err := lockRun(outputDir, outputFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		defer releaseRunLocks()
+	}
+
+	// Modalità merge: gli input sono già ordinati e vengono fusi direttamente.
+	if opts.merge {
+		setPhase("merge")
+		merge := mergeFiles
+		if opts.edges > 0 {
+			merge = func(files []string, out string) error { return mergeEdges(files, out, opts.edges) }
+		}
+		if err := merge(opts.inputs, outputFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		return
+	}
+
+	// Modalità query: legge un run set esistente senza rifare lo split.
+	if opts.query != "" {
+		setPhase("query")
+		if err := queryRunSet(opts.query, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		return
+	}
+	// Modalità coda di priorità: nessun file di input, solo comandi da stdin.
+	if opts.pqDir != "" {
+		if err := runPriorityQueue(opts.pqDir, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		return
+	}
+
+	// Modalità cooperativa: il lavoro è diviso con gli altri processi
+	// tramite il manifest condiviso nella directory --coop.
+	if opts.coop != "" {
+		if err := runCoop(opts.coop, outputFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		return
+	}
 
 	start := time.Now()
+	// Un input già ordinato viene copiato nell'output senza split né merge.
+	if done, err := skipIfSorted(outputFile); err != nil || done {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		removeTemps()
+		fmt.Fprintf(status, "✅ Output scritto in %s\n", time.Since(start))
+		return
+	}
 	os.MkdirAll(outputDir, 0755) // crea la directory di output, se non esiste
+	// Con --resume lo split completato da un'esecuzione fallita sugli stessi
+	// input e con le stesse opzioni diventa quello di questa esecuzione.
+	chunks, resumed, err := resumeChunks(outputDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitRun(1)
+	}
+	if err := checkStaleChunks(outputDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitRun(1)
+	}
+	if opts.spaceCheck && !resumed {
+		if err := checkDiskSpace(outputDir, outputFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+	}
 
-	fmt.Println("🔹 Step 1: Split e ordinamento dei chunk...")
-	if err := splitAndSortChunksParallel(inputPath, outputDir); err != nil {
-		panic(err)
+	if !resumed {
+		fmt.Fprintln(status, "🔹 Step 1: Split e ordinamento dei chunk...")
+		setPhase("split")
+		chunks, err = splitAndSortChunksParallel(opts.inputs, outputDir)
+		if err == nil {
+			err = saveResume(outputDir, chunks)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		fmt.Fprintln(status, "✅ Split completato.")
+		if len(chunks) == 0 {
+			fmt.Fprintln(status, "⚠️  Nessun record in input: l'output non conterrà righe ordinate")
+		}
+		reportChunkCompression(chunks)
 	}
-	fmt.Println("✅ Split completato.")
 
-	fmt.Println("🔹 Step 2: Merge finale dei chunk...")
-	if err := mergeChunks(outputDir, outputFile); err != nil {
-		panic(err)
+	// Con --run-set i chunk restano su disco come run set interrogabile
+	// e il file unico di output non viene prodotto.
+	if opts.runSet != "" {
+		if err := writeRunManifest(outputDir, chunks); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		fmt.Fprintf(status, "✅ Run set salvato in %s (%d run) in %s\n", outputDir, len(chunks), time.Since(start))
+		return
 	}
-	fmt.Printf("✅ Merge completato in %s\n", time.Since(start))
+
+	// Con --partition ogni partizione viene fusa nel proprio file di output.
+	if opts.partitionKey != nil {
+		fmt.Fprintln(status, "🔹 Step 2: Merge dei chunk per partizione...")
+		setPhase("merge per partizione")
+		n, err := mergePartitions(chunks, opts.partitionDir)
+		if err == nil {
+			err = verifyContent()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		removeTemps()
+		fmt.Fprintf(status, "✅ %d partizioni scritte in %s in %s\n", n, opts.partitionDir, time.Since(start))
+		return
+	}
+
+	// Con --edges il merge attraversa tutti i chunk ma scrive solo gli estremi.
+	if opts.edges > 0 {
+		fmt.Fprintf(status, "🔹 Step 2: Merge dei chunk (solo i primi e gli ultimi %d record)...\n", opts.edges)
+		setPhase("merge")
+		files, err := chunkFiles(outputDir)
+		if err == nil {
+			err = mergeEdges(files, outputFile, opts.edges)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitRun(1)
+		}
+		releaseTemps()
+		removeTemps()
+		fmt.Fprintf(status, "✅ Merge completato in %s\n", time.Since(start))
+		return
+	}
+
+	fmt.Fprintln(status, "🔹 Step 2: Merge finale dei chunk...")
+	setPhase("merge")
+	err = mergeChunks(outputDir, outputFile, chunks)
+	if err == nil {
+		err = verifyContent()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitRun(1)
+	}
+	// I chunk hanno il nome dell'esecuzione: lasciati su disco si
+	// accumulerebbero a ogni lancio nella stessa --chunk-dir.
+	releaseTemps()
+	removeTemps()
+	fmt.Fprintf(status, "✅ Merge completato in %s\n", time.Since(start))
 }
 
-// splitAndSortChunksParallel legge chunk dal file input, li invia tramite canale a un pool di worker
+// splitAndSortChunksParallel legge chunk dai file input, li invia tramite canale a un pool di worker
 // che ordinano e scrivono i chunk in parallelo migliorando l'uso delle CPU multiple.
-func splitAndSortChunksParallel(inputFile, outputDir string) error {
-	file, err := os.Open(inputFile)
+// Restituisce la descrizione dei chunk scritti, nell'ordine in cui sono stati creati.
+func splitAndSortChunksParallel(inputFiles []string, outputDir string) ([]chunkInfo, error) {
+	file, err := openInputs(inputFiles)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
+	return splitReader(file, outputDir)
+}
 
-	reader := bufio.NewReader(file)
+// splitReader divide in chunk ordinati i record letti da file, scrivendoli
+// in outputDir (o nelle directory delle partizioni). I chunk sono numerati
+// da 0 nell'ordine dell'input.
+func splitReader(file io.Reader, outputDir string) ([]chunkInfo, error) {
+	var divert *bufio.Writer
+	if opts.maxRecordBytes > 0 && opts.oversizePolicy == oversizeDivert {
+		df, w, err := openDivertFile(opts.oversizeFile)
+		if err != nil {
+			return nil, err
+		}
+		defer df.Close()
+		divert = w
+	}
+	// La lettura passa da in, che la misura per il rapporto sul throughput.
+	start := time.Now()
+	in := &timedReader{r: file}
+	reader := newRecordReader(bufio.NewReader(in), opts.maxRecordBytes, opts.oversizePolicy, divert)
+	if opts.rejectsFile != "" {
+		rf, w, err := openDivertFile(opts.rejectsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer rf.Close()
+		reader.rejects = w
+	}
 	chunkSize := 0
-	chunk := make([]string, 0, 100_000)
 	chunkCount := 0
+	skipped := 0       // record scartati dallo schema (es. fixed32)
+	var records int64 // record accodati ai chunk
+
+	// Righe in attesa di formare un chunk, per partizione. Senza --partition
+	// esiste solo la partizione "" e i chunk finiscono direttamente in outputDir.
+	pending := map[string][]string{"": make([]string, 0, 100_000)}
+	pendingLines := 0
+	// I byte delle righe stanno in blocchi condivisi dai chunk (vedi lineArena).
+	var arena lineArena
+	var parts *partitioner
+	if opts.partitionKey != nil {
+		parts = newPartitioner(*opts.partitionKey, outputDir)
+	}
+	var rsel *replacementSelector
+	if opts.runGen == runGenReplacement {
+		rsel = newReplacementSelector(outputDir)
+	}
+	// Con --intern i record uguali dei chunk in formazione condividono i byte.
+	// La replacement selection copia comunque ogni record nel suo torneo.
+	var interned *internTable
+	if opts.intern && rsel == nil {
+		interned = newInternTable()
+	}
 
 	// Canale buffered per inviare chunk da ordinare ai worker
-	chunkChan := make(chan struct {
-		lines []string
-		id    int
-	}, 8)
+	chunkChan := make(chan chunkJob, splitQueue)
+	// inflight conta i chunk inviati e non ancora scritti: sotto pressione di
+	// memoria lo split aspetta che si svuoti prima di proseguire.
+	var inflight sync.WaitGroup
+	watermark := newMemWatermark(opts.memHigh)
+	earlyFlushes := 0
 
-	// Numero di worker = numero di CPU disponibili
-	numWorkers := runtime.NumCPU()
+	// Numero di worker = numero di CPU disponibili (o --workers)
+	numWorkers := opts.workers
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	infos := make(map[int]chunkInfo)
+	// failed è il primo errore di scrittura dei worker: interrompe lo split,
+	// lasciando su disco i chunk già completati.
+	var failed error
+	fail := func(err error) {
+		mu.Lock()
+		if failed == nil {
+			failed = err
+		}
+		mu.Unlock()
+	}
 
-	// Avvia i worker che ricevono chunk dal canale, li ordinano e scrivono su disco
+	// I worker di ordinamento ricevono i chunk dal canale e li passano,
+	// ordinati, ai worker di scrittura: un worker fermo sul disco non tiene
+	// ferma una CPU. writeChan limita i chunk ordinati in attesa del disco.
+	writeChan := make(chan chunkJob, writeQueue)
+	var sorting sync.WaitGroup
+	sorters := make([]workerStats, numWorkers)
+	writers := make([]workerStats, opts.ioWorkers)
 	for i := 0; i < numWorkers; i++ {
+		sorting.Add(1)
+		go func(stats *workerStats) {
+			defer sorting.Done()
+			defer removeTempsOnPanic()
+			for job := range chunkChan {
+				t := time.Now()
+				sortLines(job.lines)
+				stats.busy += time.Since(t)
+				stats.lines += int64(len(job.lines))
+				writeChan <- job
+			}
+		}(&sorters[i])
+	}
+	go func() {
+		sorting.Wait()
+		close(writeChan)
+	}()
+
+	// wg attende i worker di scrittura, gli ultimi della pipeline.
+	for i := 0; i < opts.ioWorkers; i++ {
 		wg.Add(1)
-		go func() {
+		go func(stats *workerStats) {
 			defer wg.Done()
-			for job := range chunkChan {
-				sort.Strings(job.lines)
-				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%03d.txt", job.id))
-				f, err := os.Create(chunkPath)
+			defer removeTempsOnPanic()
+			for job := range writeChan {
+				t := time.Now()
+				chunkPath := filepath.Join(job.dir, chunkName(job.id))
+				f, err := createChunk(chunkPath)
 				if err != nil {
-					fmt.Fprintln(os.Stderr, "Errore creazione file chunk:", err)
+					fail(fmt.Errorf("creazione del chunk: %w", err))
+					inflight.Done()
 					continue
 				}
-				writer := bufio.NewWriter(f)
-				for _, s := range job.lines {
-					writer.WriteString(s + "\n")
+				info := chunkInfo{Path: chunkPath, Lines: len(job.lines), Partition: job.partition}
+				// La dimensione compressa non è nota prima della scrittura.
+				if opts.chunkCompress == chunkCompressNone {
+					preallocate(f, chunkFileSize(job.lines))
 				}
-				writer.Flush()
-				f.Close()
+				cw, err := compressChunk(&spaceWriter{w: f, path: chunkPath})
+				if err != nil {
+					f.Close()
+					fail(err)
+					inflight.Done()
+					continue
+				}
+				writer := getChunkWriter(cw)
+				for i, s := range job.lines {
+					if i%runIndexStride == 0 {
+						info.Index = append(info.Index, indexEntry{Line: strings.Clone(s), Offset: info.Bytes})
+					}
+					info.Bytes += int64(writeRecord(writer, s))
+				}
+				err = writer.Flush()
+				putChunkWriter(writer)
+				if cerr := cw.Close(); err == nil {
+					err = cerr
+				}
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					fail(err)
+					inflight.Done()
+					continue
+				}
+				stats.busy += time.Since(t)
+				stats.bytes += info.Bytes
+
+				// Le righe puntano nell'arena dello split: chunkInfo ne tiene una copia.
+				if len(job.lines) > 0 {
+					info.First = strings.Clone(job.lines[0])
+					info.Last = strings.Clone(job.lines[len(job.lines)-1])
+				}
+				if job.recycle {
+					putLines(job.lines)
+				}
+				mu.Lock()
+				infos[job.id] = info
+				mu.Unlock()
+				inflight.Done()
 			}
-		}()
+		}(&writers[i])
 	}
 
-	// Legge linee dal file, crea chunk e li invia ai worker tramite canale
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return err
+	// add accoda un record letto dall'input al chunk della sua partizione.
+	// dup indica un record condiviso con --intern.
+	add := func(s string, dup bool) error {
+		records++
+		if opts.verifyContent {
+			splitContent.add(s)
+		}
+		// Con la replacement selection i run si scrivono durante la lettura.
+		if rsel != nil {
+			return rsel.push(s)
+		}
+		p := ""
+		if parts != nil {
+			p = parts.key(s)
+			// La chiave resta nella mappa: non deve trattenere il blocco dell'arena.
+			if _, seen := pending[p]; !seen {
+				p = strings.Clone(p)
+			}
+		}
+		pending[p] = append(pending[p], s)
+		pendingLines++
+		if dup {
+			chunkSize += internedCost
+		} else {
+			chunkSize += len(s) + 1
+		}
+		return nil
+	}
+
+	// flush invia ai worker i chunk in attesa quando raggiungono
+	// --chunk-bytes, alla fine dell'input (eof) o sotto pressione di memoria.
+	flush := func(eof bool) error {
+		// I chunk sono dimensionati in byte, qualunque sia la lunghezza delle
+		// righe; oltre la soglia di memoria il chunk corrente viene inviato in anticipo.
+		pressure := watermark.exceeded() && pendingLines > 0
+		if !pressure && chunkSize < opts.chunkBytes && (!eof || pendingLines == 0) {
+			return nil
+		}
+		// Un input entrato tutto in un chunk si ordina qui e resta in memoria.
+		if eof && !pressure && chunkCount == 0 && chunkSize < opts.chunkBytes && inMemorySort() {
+			memoryRun = pending[""]
+			pending[""], pendingLines, chunkSize = nil, 0, 0
+			sortLines(memoryRun)
+			fmt.Fprintf(status, "💽 Input in un solo chunk (%d righe): ordinato in memoria, senza file chunk\n", len(memoryRun))
+			return nil
+		}
+		// Un worker non è riuscito a scrivere il suo chunk: inutile proseguire.
+		mu.Lock()
+		ferr := failed
+		mu.Unlock()
+		if ferr != nil {
+			return ferr
 		}
 
-		if len(line) > 0 {
-			clean := bytes.TrimSpace(line)
-			if len(clean) == strLength {
-				chunk = append(chunk, string(clean))
-				chunkSize += len(clean) + 1
+		// Ogni partizione in attesa diventa un chunk; l'ordine delle chiavi
+		// rende deterministica la numerazione dei chunk.
+		keys := make([]string, 0, len(pending))
+		for p, lines := range pending {
+			if len(lines) > 0 {
+				keys = append(keys, p)
+			}
+		}
+		sort.Strings(keys)
+		for _, p := range keys {
+			dir := outputDir
+			if parts != nil {
+				var derr error
+				if dir, derr = parts.dir(p); derr != nil {
+					return derr
+				}
 			}
+			// La slice passa al worker, che la rimette nel pool dopo averla
+			// scritta; le righe successive vanno in una slice riciclata.
+			// Sotto pressione non si ricicla: la memoria torna al sistema.
+			inflight.Add(1)
+			chunkChan <- chunkJob{
+				lines:     pending[p],
+				id:        chunkCount,
+				dir:       dir,
+				partition: p,
+				recycle:   !pressure,
+			}
+			chunkCount++
+			if pressure {
+				pending[p] = nil
+			} else {
+				pending[p] = getLines(len(pending[p]))
+			}
+		}
+		pendingLines = 0
+		if interned != nil {
+			interned.reset()
 		}
+		chunkSize = 0
 
-		if chunkSize >= maxDiskSize || len(chunk) >= maxItems || (err == io.EOF && len(chunk) > 0) {
-			// Copia difensiva della slice prima di inviare ai worker
-			job := struct {
-				lines []string
-				id    int
-			}{
-				lines: append([]string(nil), chunk...),
-				id:    chunkCount,
+		// Sotto pressione la coda dei job si riduce a zero: si attende che i
+		// worker abbiano scritto tutti i chunk e si restituisce la memoria
+		// prima di leggere altro input.
+		if pressure {
+			earlyFlushes++
+			inflight.Wait()
+			arena.reset()
+			debug.FreeOSMemory()
+		}
+		return nil
+	}
+
+	// Legge linee dal file, crea chunk e li invia ai worker tramite canale
+	readSequential := func() error {
+		for {
+			line, err := reader.next()
+			if err != nil && err != io.EOF {
+				return err
 			}
-			chunkChan <- job
 
-			chunkCount++
-			chunk = chunk[:0]
-			chunkSize = 0
+			advanceProgress(len(line))
+			var s string
+			var ok, dup bool
+			if interned != nil {
+				s, ok, dup = interned.parse(&arena, line)
+			} else {
+				s, ok = parseRecord(&arena, line)
+			}
+			// Le righe di --skip-header non entrano nei chunk.
+			header := holdHeader(line)
+			if ok && !header {
+				// Con --csv --header l'intestazione non entra nei chunk.
+				var herr error
+				if header, herr = skipCSVHeader(s); herr != nil {
+					return herr
+				}
+			}
+			if ok && !header {
+				if aerr := add(s, dup); aerr != nil {
+					return aerr
+				}
+			} else if !ok && !header && len(line) > 0 {
+				skipped++
+				if rerr := reader.reject(line); rerr != nil {
+					return rerr
+				}
+			}
+
+			if ferr := flush(err == io.EOF); ferr != nil {
+				return ferr
+			}
+			if err == io.EOF {
+				return nil
+			}
 		}
+	}
 
-		if err == io.EOF {
-			break
+	var rerr error
+	if pipelinedSplit() {
+		// La lettura e l'analisi delle righe procedono in altre goroutine:
+		// qui restano solo la formazione dei chunk e l'invio ai worker. Le
+		// righe stanno nelle arene dei parser, che le sostituiscono come
+		// quella dello split.
+		rerr = readPipelined(reader.r, opts.parseWorkers, func(batch parsedBatch) error {
+			skipped += batch.skipped
+			for _, s := range batch.lines {
+				if err := add(s, false); err != nil {
+					return err
+				}
+				if err := flush(false); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if rerr == nil {
+			rerr = flush(true)
 		}
+	} else {
+		rerr = readSequential()
+	}
+	if rerr != nil {
+		close(chunkChan)
+		wg.Wait()
+		return nil, rerr
 	}
 
 	close(chunkChan) // chiude il canale per terminare i worker
 	wg.Wait()        // aspetta che tutti i worker finiscano
-	return nil
+	if failed != nil {
+		return nil, failed
+	}
+	reportSplitThroughput(in, records, time.Since(start), sorters, writers)
+
+	if interned != nil {
+		interned.report()
+	}
+	if earlyFlushes > 0 {
+		fmt.Fprintf(status, "⚠️  %d chunk scritti in anticipo per superamento della soglia di memoria (%d MB)\n", earlyFlushes, opts.memHigh>>20)
+	}
+
+	if reader.oversize > 0 {
+		fmt.Fprintf(status, "⚠️  %d record oltre %d byte (policy %s)\n", reader.oversize, opts.maxRecordBytes, opts.oversizePolicy)
+	}
+	if reader.invalid > 0 {
+		fmt.Fprintf(status, "⚠️  %d record con UTF-8 non valido (policy %s)\n", reader.invalid, opts.validateUTF8)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(status, "⚠️  %d record scartati dallo schema %s\n", skipped, opts.schemaDef.Name)
+	}
+	if divert != nil {
+		if err := divert.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if reader.rejects != nil {
+		if err := reader.rejects.Flush(); err != nil {
+			return nil, err
+		}
+		if reader.rejected > 0 {
+			fmt.Fprintf(status, "📝 %d record scartati scritti in %s\n", reader.rejected, opts.rejectsFile)
+		}
+	}
+
+	if rsel != nil {
+		return rsel.finish()
+	}
+	if memoryRun != nil {
+		return []chunkInfo{memoryRunInfo()}, nil
+	}
+	chunks := make([]chunkInfo, 0, len(infos))
+	for id := 0; id < chunkCount; id++ {
+		if info, ok := infos[id]; ok {
+			chunks = append(chunks, info)
+		}
+	}
+	return chunks, nil
 }
 
 // fillBuffer (CORRETTO) ora usa lo scanner persistente del chunkReader.
 // Questo previene la perdita di dati che avveniva creando un nuovo scanner ad ogni chiamata.
 func fillBuffer(r *chunkReader, count int) error {
+	if r.prefetch != nil {
+		// Il blocco successivo è già stato letto (o è in lettura) in
+		// background; a canale chiuso il chunk è esaurito.
+		b, ok := <-r.prefetch
+		r.buffer = b.lines
+		if !ok {
+			r.buffer = nil
+		}
+		return b.err
+	}
+	// La run in memoria si consegna tutta insieme.
+	if r.file == nil {
+		r.buffer, r.memory = r.memory, nil
+		return nil
+	}
 	r.buffer = r.buffer[:0]
-	for len(r.buffer) < count && r.scanner.Scan() {
-		r.buffer = append(r.buffer, r.scanner.Text())
+	if r.mapped != nil {
+		return fillMapped(r, count)
+	}
+	var err error
+	r.buffer, err = scanBatch(r, r.buffer, count)
+	return err
+}
+
+// scanBatch aggiunge a lines fino a count righe lette dallo scanner di r.
+func scanBatch(r *chunkReader, lines []string, count int) ([]string, error) {
+	for len(lines) < count && r.scanner.Scan() {
+		lines = append(lines, r.scanner.Text())
+	}
+	// La fine del file non è un errore: Err restituisce nil.
+	switch err := r.scanner.Err(); {
+	case err == bufio.ErrTooLong:
+		return lines, fmt.Errorf("%s: record oltre --max-record-bytes (%d byte)", r.file.Name(), opts.maxRecordBytes)
+	case err != nil:
+		return lines, fmt.Errorf("%s: %w", r.file.Name(), err)
+	}
+	return lines, nil
+}
+
+// prefetched è un blocco di righe letto in anticipo da un chunk.
+type prefetched struct {
+	lines []string
+	err   error
+}
+
+// startPrefetch avvia la lettura in anticipo (double buffering) di un chunk
+// letto con lo scanner: mentre il merge consuma un blocco, una goroutine
+// legge il successivo e lo consegna a fillBuffer, così il merge non aspetta
+// il disco o il decompressore a ogni blocco esaurito.
+func (r *chunkReader) startPrefetch(count int) {
+	r.prefetch = make(chan prefetched)
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	go func() {
+		defer close(r.stopped)
+		defer close(r.prefetch)
+		for {
+			lines, err := scanBatch(r, make([]string, 0, count), count)
+			select {
+			case r.prefetch <- prefetched{lines: lines, err: err}:
+			case <-r.stop:
+				return
+			}
+			if err != nil || len(lines) == 0 {
+				return
+			}
+		}
+	}()
+}
+
+// stopPrefetch ferma la lettura in anticipo e ne attende la fine, prima che
+// il file venga chiuso.
+func (r *chunkReader) stopPrefetch() {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.stopped
+		r.stop = nil
+	}
+}
+
+// fillMapped riempie il buffer di un chunk mappato in memoria. Le righe sono
+// stringhe che puntano direttamente alla regione mappata, senza copie né
+// chiamate di sistema: restano valide fino alla chiusura del merger o, con
+// holdMaps, fino a releaseHeldMaps.
+func fillMapped(r *chunkReader, count int) error {
+	for len(r.buffer) < count && r.pos < len(r.mapped) {
+		adv, tok, err := r.split(r.mapped[r.pos:], true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.file.Name(), err)
+		}
+		if adv == 0 {
+			break
+		}
+		r.pos += adv
+		if tok != nil {
+			r.buffer = append(r.buffer, mappedString(tok))
+		}
+	}
+	return nil
+}
+
+// chunkScanner crea lo scanner con cui si rileggono i chunk e i file da
+// fondere. Il buffer parte da 64 KB e cresce fino al record più lungo
+// ammesso: --max-record-bytes o, senza limite, qualsiasi lunghezza. extra
+// sono i byte oltre il record che compaiono nella stessa riga (almeno il
+// terminatore).
+func chunkScanner(r io.Reader, extra int) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	limit := math.MaxInt
+	if opts.maxRecordBytes > 0 {
+		limit = opts.maxRecordBytes + extra
 	}
-	// Restituisce l'errore dello scanner, se presente (es. fine del file).
-	return r.scanner.Err()
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), limit)
+	return s
 }
 
 
 // mergeChunks effettua il merge finale ordinato di tutti i chunk.
 // Usa un heap minimo per mantenere in cima la stringa alfabeticamente più piccola,
 // legge in batch da ciascun file per efficienza e scrive su outputFile.
-func mergeChunks(chunkDir string, outputFile string) error {
-	files, err := filepath.Glob(filepath.Join(chunkDir, "chunk_*.txt"))
+// chunks sono le descrizioni raccolte dallo split: con la prima e l'ultima
+// riga di ogni chunk i chunk isolati vengono copiati senza passare dal torneo.
+func mergeChunks(chunkDir string, outputFile string, chunks []chunkInfo) error {
+	files, err := chunkFiles(chunkDir)
 	if err != nil {
 		return err
 	}
+	// Un input ordinato in memoria non ha file chunk; un input vuoto non ha
+	// chunk e il merge scrive un output vuoto (con l'eventuale intestazione).
+	if memoryRun != nil {
+		files = []string{memoryRunPath}
+	}
+	infos := make(map[string]chunkInfo, len(chunks))
+	for _, c := range chunks {
+		infos[c.Path] = c
+	}
+	return mergeSorted(files, infos, outputFile)
+}
+
+// chunkFiles elenca i file chunk dell'esecuzione corrente in chunkDir,
+// nell'ordine di creazione.
+func chunkFiles(chunkDir string) ([]string, error) {
+	files, err := filepath.Glob(chunkPattern(chunkDir))
+	if err != nil {
+		return nil, err
+	}
+	// L'ordine di creazione dei chunk conta per --stable: si ordina per
+	// numero, senza contare sull'ordine alfabetico dei nomi di Glob.
+	sort.Slice(files, func(i, j int) bool { return chunkID(files[i]) < chunkID(files[j]) })
+	return files, nil
+}
+
+// mergeFiles fonde i file indicati, ciascuno già ordinato, scrivendo su
+// outputFile ("-" = stdout). È usata per --merge.
+func mergeFiles(files []string, outputFile string) error {
+	return mergeSorted(files, nil, outputFile)
+}
+
+// mergeSorted fonde i file indicati come mergeFiles. infos, se non nil,
+// descrive i chunk dello split per planSegments.
+func mergeSorted(files []string, infos map[string]chunkInfo, outputFile string) (err error) {
+	start := time.Now()
+	// Le righe dei chunk mappati possono essere ancora in uso nei consumer di
+	// --tee e nelle partizioni fino alla loro chiusura. Dopo un errore i
+	// consumer possono essere ancora attivi: le regioni restano mappate fino
+	// all'uscita del processo.
+	defer func() {
+		if err == nil {
+			releaseHeldMaps()
+		}
+	}()
+	// Passi --then applicati allo stream ordinato prima dell'output. Se la
+	// catena termina con partition l'output normale non viene scritto.
+	sink := &sinkStage{}
+	chain, terminal, err := newPipeline(opts.then, sink)
+	if err != nil {
+		return err
+	}
+	var out io.WriteCloser = nopWriteCloser{io.Discard}
+	if !terminal {
+		if out, err = createOutput(outputFile); err != nil {
+			return err
+		}
+		preallocate(out, outputSizeHint(infos))
+	}
+	defer out.Close()
+	// Con --seal-key, --checksum e --manifest checksum e conteggi vengono
+	// calcolati durante la scrittura.
+	var digest *outputDigest
+	// timed misura le scritture dell'output per il rapporto sul throughput.
+	timed := &timedWriter{w: &spaceWriter{w: out, path: outputFile}}
+	var dst io.Writer = timed
+	if (opts.sealKey != nil || opts.checksum || opts.manifest) && outputFile != "-" && !terminal {
+		digest = newOutputDigest()
+		dst = io.MultiWriter(dst, digest)
+	}
+	// La compressione sta sotto il sigillo, che copre i byte scritti su disco.
+	comp, err := compressOutput(dst)
+	if err != nil {
+		return err
+	}
+	dst = comp
+	if dst, err = outputBOM(dst); err != nil {
+		return err
+	}
+	var avroOut *avroWriter
+	if avroOutput() && !terminal {
+		if avroOut, err = newAvroWriter(dst); err != nil {
+			return err
+		}
+		dst = avroOut
+	}
+	writer := bufio.NewWriterSize(dst, opts.writerBuf)
+
+	// Consumer aggiuntivi registrati con --tee: ricevono la stessa sequenza
+	// ordinata del file di output, ciascuno con la propria coda.
+	bc, err := newBroadcaster(opts.tees)
+	if err != nil {
+		return err
+	}
+
+	// Le righe di --skip-header e, con --csv --header, l'intestazione
+	// precedono i record ordinati.
+	writeHeldHeader(writer)
+	records := int64(len(heldHeader))
+	if csvHeader.seen {
+		writer.WriteString(formatRecord(csvHeader.record))
+		writer.WriteString(lineEnd)
+		records++
+	}
+	var keys manifestKeys
+	sink.emit = func(out string) {
+		writeOutput(writer, out)
+		records++
+		if opts.manifest {
+			keys.add(out)
+		}
+		if bc != nil {
+			bc.send(out)
+		}
+	}
+
+	// mergeGroup fonde un gruppo di chunk e passa le righe alla catena.
+	mergeGroup := func(files []string) error {
+		runs, cleanup, err := cascadeMerge(files)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		m, err := newChunkMerger(runs)
+		if err != nil {
+			return err
+		}
+		m.holdMaps = true
+		defer m.close()
+
+		// Ciclo principale: estrae la riga più piccola e la passa alla catena.
+		// Con --count le righe con la stessa chiave, consecutive nel merge,
+		// formano un gruppo: esce la prima riga del gruppo con il suo conteggio.
+		var group heapItem
+		var groupN int64
+		for err == nil {
+			item, ok := m.next()
+			if ok && opts.verifyContent {
+				mergeContent.add(item.value)
+			}
+			if opts.count {
+				if ok && groupN > 0 && item.key == group.key {
+					groupN++
+					continue
+				}
+				if groupN > 0 {
+					err = chain.push(countedLine(formatRecord(group.value), groupN), group.key)
+				}
+				group, groupN = item, 1
+			} else if ok {
+				err = chain.push(formatRecord(item.value), item.key)
+			}
+			if !ok {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return m.Err()
+	}
+
+	// I segmenti sono intervalli di chiavi disgiunti e in ordine: i chunk
+	// isolati vanno direttamente nell'output.
+	for _, seg := range planSegments(files, infos) {
+		// Con --verify-content anche i chunk isolati passano dal merger,
+		// che ne conta i record.
+		if seg.copy && !opts.verifyContent {
+			err = copyChunk(writer, seg.files[0])
+			records += seg.lines
+		} else {
+			err = mergeGroup(seg.files)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil && err != errPipeDone {
+		return err
+	}
+	if err := chain.flush(); err != nil {
+		return err
+	}
+	if bc != nil {
+		if err := bc.close(); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if avroOut != nil {
+		if err := avroOut.Close(); err != nil {
+			return err
+		}
+	}
+	if err := comp.Close(); err != nil {
+		return err
+	}
+	if err := commitOutput(out); err != nil {
+		return err
+	}
+	if !terminal {
+		reportMergeThroughput(timed, records, time.Since(start))
+	}
+	if digest != nil && opts.checksum {
+		if err := writeChecksum(outputFile, digest, records); err != nil {
+			return err
+		}
+	}
+	if digest != nil && opts.manifest {
+		if err := writeManifest(outputFile, digest, records, len(files), &keys); err != nil {
+			return err
+		}
+	}
+	if digest != nil && opts.sealKey != nil {
+		return writeSeal(outputFile, digest, opts.sealKey)
+	}
+	return nil
+}
+
+// chunkMerger esegue il merge k-way di un insieme di chunk ordinati
+// restituendo una riga alla volta in ordine globale.
+// È usato dal merge finale, dal merge a livelli, da --edges e da --coop.
+type chunkMerger struct {
+	readers []*chunkReader
+	lt      *loserTree // riga corrente di ogni chunk, in torneo
+	err     error      // primo errore di lettura dei chunk
+	lastKey string // chiave dell'ultimo elemento restituito (per --unique)
+	emitted bool   // next ha già restituito almeno un elemento
+	holdMaps bool  // close affida le regioni mappate a releaseHeldMaps
+}
+
+// heldMaps sono le regioni mappate dei merger chiusi con holdMaps. Le righe
+// e le chiavi che puntano alla regione (vedi fillMapped) arrivano alla
+// catena --then, alle partizioni e ai consumer di --tee, che possono
+// trattenerle oltre la chiusura del merger: la regione resta mappata
+// finché l'output non è completo.
+var heldMaps [][]byte
+
+// releaseHeldMaps rilascia le regioni trattenute da heldMaps.
+func releaseHeldMaps() {
+	for _, data := range heldMaps {
+		unmapFile(data)
+	}
+	heldMaps = nil
+}
+
+// newChunkMerger apre i file chunk e mette in torneo la prima riga di ciascuno.
+func newChunkMerger(files []string) (*chunkMerger, error) {
+	m := &chunkMerger{lt: newLoserTree(len(files))}
 
 	// Apre tutti i file chunk e crea un chunkReader per ciascuno
-	readers := make([]*chunkReader, len(files))
 	for i, file := range files {
-		f, err := os.Open(file)
+		if file == memoryRunPath {
+			r := &chunkReader{memory: memoryRun, index: i}
+			m.readers = append(m.readers, r)
+			fillBuffer(r, bufferLines)
+			continue
+		}
+		f, err := openRetry(file)
 		if err != nil {
-			return err
+			m.close()
+			return nil, err
 		}
-		
+
 		// **MODIFICA CHIAVE**: Inizializza lo scanner una sola volta per file
 		// e lo assegna al chunkReader. Questo preserva lo stato di lettura.
-		scanner := bufio.NewScanner(bufio.NewReaderSize(f, readerBufSize))
+		// Con --merge i file sono gli input dell'utente, eventualmente compressi.
+		// Le letture dal file ripetono gli errori transitori (--io-retries).
+		rf := &retryReader{r: f, name: file}
+		var src io.Reader = rf
+		var dec io.Closer
+		if opts.merge && filepath.Dir(file) != cascadeDir {
+			if src, err = decompress(rf, file); err != nil {
+				f.Close()
+				m.close()
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			dec, _ = src.(io.Closer)
+			src = stripBOM(src)
+		} else if chunkCompressed(file) {
+			if src, dec, err = decompressChunk(rf); err != nil {
+				f.Close()
+				m.close()
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
 		r := &chunkReader{
-			file:    f,
-			scanner: scanner,
-			buffer:  []string{},
-			index:   i,
+			file:   f,
+			dec:    dec,
+			buffer: []string{},
+			index:  i,
+			split:  bufio.ScanLines,
 		}
-		
+		extra := 1
+		switch {
+		case opts.recordSize > 0:
+			r.split = scanFixed
+		case opts.framing != "":
+			r.split = scanFramed
+		case binaryChunk(file):
+			r.split = scanChunkRecord
+			extra = maxChunkHeader
+		case opts.lineEndings == eolPreserve || opts.zeroTerminated:
+			r.split = scanRecords
+		}
+		// I chunk scritti dallo split si leggono direttamente dalla memoria
+		// mappata; gli input di --merge e i chunk di --chunk-compression
+		// passano dal decompressore.
+		if opts.mmap && src == io.Reader(rf) {
+			if data, err := mapFile(f); err == nil && data != nil {
+				r.mapped = data
+			}
+		}
+		if r.mapped == nil {
+			r.scanner = chunkScanner(bufio.NewReaderSize(src, opts.readerBuf), extra)
+			r.scanner.Split(r.split)
+			switch {
+			case opts.recordSize >= bufio.MaxScanTokenSize:
+				r.scanner.Buffer(make([]byte, 0, opts.recordSize), opts.recordSize)
+			case opts.framing != "":
+				r.scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxFrameToken)
+			}
+			if opts.prefetch {
+				r.startPrefetch(bufferLines)
+			}
+		}
+		m.readers = append(m.readers, r)
+
 		if err := fillBuffer(r, bufferLines); err != nil {
-			return err
+			m.close()
+			return nil, err
 		}
-		readers[i] = r
 	}
-	defer func() {
-		for _, r := range readers {
-			r.file.Close()
-		}
-	}()
 
-	// Inizializza l'heap minimo e inserisce la prima riga di ogni chunk nel heap
-	h := &minHeapBuffered{}
-	heap.Init(h)
+	// Prima riga di ogni chunk, poi il torneo iniziale.
+	for _, r := range m.readers {
+		m.advance(r)
+	}
+	m.lt.init()
+	trackMerger(m, true)
+	return m, nil
+}
 
-	for _, r := range readers {
-		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
-			r.buffer = r.buffer[1:]
+// next restituisce il vincitore del torneo e lo rimpiazza con la riga
+// successiva dello stesso chunkReader. ok è false quando tutti i chunk sono esauriti.
+// Con --unique le righe con la stessa chiave escono dal merge consecutive:
+// viene restituita solo la prima, senza memoria aggiuntiva. Dopo un errore
+// di lettura ok è false e l'errore è restituito da Err.
+func (m *chunkMerger) next() (item heapItem, ok bool) {
+	for {
+		w, ok := m.lt.winner()
+		if !ok || m.err != nil {
+			return heapItem{}, false
+		}
+		item = m.lt.items[w]
+		m.advance(m.readers[w])
+		m.lt.replay(w)
+		advanceProgress(len(item.value) + 1)
+		// Con --count i duplicati servono a mergeFiles per contarli.
+		if opts.unique && !opts.count {
+			if m.emitted && item.key == m.lastKey {
+				continue
+			}
+			m.lastKey, m.emitted = item.key, true
 		}
+		return item, true
 	}
+}
 
-	out, err := os.Create(outputFile)
-	if err != nil {
-		return err
+// advance mette in torneo la prossima riga del reader, se ne ha ancora.
+func (m *chunkMerger) advance(r *chunkReader) {
+	// Se il buffer in RAM del reader è vuoto, prova a riempirlo dal file.
+	// Un errore di lettura ferma il merge: il chunk non va troncato in silenzio.
+	if len(r.buffer) == 0 {
+		if err := fillBuffer(r, bufferLines); err != nil && m.err == nil {
+			m.err = err
+		}
 	}
-	defer out.Close()
-	writer := bufio.NewWriterSize(out, writerBufferSize)
 
-	// Ciclo principale: estrae l'elemento più piccolo dall'heap, lo scrive,
-	// e lo rimpiazza con la riga successiva dello stesso chunkReader.
-	for h.Len() > 0 {
-		item := heap.Pop(h).(heapItem) // Estrae l'elemento più piccolo
-		writer.WriteString(item.value + "\n")
+	// Se dopo il tentativo di riempimento il buffer ha ancora dati,
+	// la prossima riga prende il posto della precedente nel torneo.
+	if len(r.buffer) > 0 {
+		// I chunk contengono porzioni consecutive dell'input e sono aperti
+		// nell'ordine di creazione: l'indice del reader è l'ordine di origine.
+		m.lt.set(r.index, heapItem{value: r.buffer[0], key: sortKey(r.buffer[0]), index: r.index, seq: int64(r.index)})
+		r.buffer = r.buffer[1:]
+		atomic.AddInt64(&r.consumed, 1)
+	} else {
+		m.lt.remove(r.index)
+		atomic.StoreInt32(&r.eof, 1)
+	}
+}
 
-		r := readers[item.index]
-		
-		// Se il buffer in RAM del reader è vuoto, prova a riempirlo dal file.
-		if len(r.buffer) == 0 {
-			if err := fillBuffer(r, bufferLines); err != nil {
-				// Non è un errore fatale, potrebbe essere solo EOF
-			}
-		}
+// Err restituisce il primo errore di lettura dei chunk, o nil.
+func (m *chunkMerger) Err() error { return m.err }
 
-		// Se dopo il tentativo di riempimento il buffer ha ancora dati,
-		// inserisce la prossima riga nell'heap.
-		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
-			r.buffer = r.buffer[1:]
+// close chiude tutti i file chunk aperti.
+func (m *chunkMerger) close() {
+	trackMerger(m, false)
+	for _, r := range m.readers {
+		r.stopPrefetch()
+		if r.dec != nil {
+			r.dec.Close()
+		}
+		if r.mapped != nil && m.holdMaps {
+			heldMaps = append(heldMaps, r.mapped)
+		} else if r.mapped != nil {
+			unmapFile(r.mapped)
+		}
+		if r.file != nil {
+			r.file.Close()
 		}
 	}
-	return writer.Flush()
-}
\ No newline at end of file
+}
+
+// countedLine aggiunge a una riga di output il numero di occorrenze secondo
+// --count-position: prima, allineato come uniq -c, oppure dopo un tab.
+func countedLine(line string, n int64) string {
+	if opts.countPosition == "suffix" {
+		return line + "\t" + strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%7d %s", n, line)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sorterMainEnv, impostata nell'ambiente, fa eseguire al binario dei test
+// il programma invece dei test.
+const sorterMainEnv = "SITHLORDS_TEST_MAIN"
+
+// TestMain esegue il programma al posto dei test quando sorterMainEnv è
+// impostata: runSorter rilancia il binario dei test come processo figlio,
+// così ogni esecuzione parte da opzioni globali pulite e un crash (segnale,
+// fatal error del runtime) fa fallire solo il test che lo provoca.
+func TestMain(m *testing.M) {
+	if os.Getenv(sorterMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runSorter esegue il programma con args nella directory dir e ne
+// restituisce stdout e stderr. err è non nil se il programma esce con un
+// codice diverso da zero. Anche lo storico delle esecuzioni finisce in dir.
+func runSorter(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), sorterMainEnv+"=1", "XDG_STATE_HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// mustRunSorter è runSorter per le esecuzioni che devono riuscire.
+func mustRunSorter(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := runSorter(t, dir, args...)
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// testLines restituisce n righe in ordine sparso, con chiavi ripetute e
+// prefissi diversi, sempre le stesse a parità di n.
+func testLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%d-%05d riga %d", (i*7919)%9+1, (i*104729)%30011, i)
+	}
+	return lines
+}
+
+// writeLines scrive lines in dir/name, una per riga, e ne restituisce il
+// percorso.
+func writeLines(t *testing.T, dir, name string, lines []string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readLines legge le righe di path, senza terminatori.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// sortedCopy restituisce lines ordinate per byte, come LC_ALL=C sort.
+func sortedCopy(lines []string) []string {
+	s := append([]string(nil), lines...)
+	sort.Strings(s)
+	return s
+}
+
+// equalLines confronta due sequenze di righe e segnala la prima differenza.
+func equalLines(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d righe, attese %d", what, len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s: riga %d = %q, attesa %q", what, i+1, got[i], want[i])
+		}
+	}
+}
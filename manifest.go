@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// manifestSuffix è il suffisso del manifest scritto accanto al file di output.
+const manifestSuffix = ".manifest.json"
+
+// manifestKeyMax è la lunghezza massima delle chiavi riportate nel manifest.
+const manifestKeyMax = 1024
+
+// outputManifest descrive un file di output ordinato per i sistemi a valle,
+// che possono validarlo e catalogarlo senza rileggerlo: intervallo delle
+// chiavi, dimensioni, checksum e opzioni dell'esecuzione.
+type outputManifest struct {
+	Version   int         `json:"version"`
+	Created   time.Time   `json:"created"`
+	Tool      string      `json:"tool"`   // versione del programma che ha scritto l'output
+	Output    string      `json:"output"` // nome del file, relativo alla directory del manifest
+	Records   int64       `json:"records"`
+	Bytes     int64       `json:"bytes"`
+	SHA256    string      `json:"sha256"`
+	Chunks    int         `json:"chunks"` // run ordinati fusi nell'output
+	FirstKey  *string     `json:"firstKey,omitempty"`
+	LastKey   *string     `json:"lastKey,omitempty"`
+	Args      []string    `json:"args"`      // opzioni dell'esecuzione, come -nome=valore
+	OrderArgs []string    `json:"orderArgs"` // opzioni che determinano l'ordine
+	Inputs    []sealInput `json:"inputs"`
+}
+
+// manifestKeys raccoglie la chiave del primo e dell'ultimo record scritti.
+type manifestKeys struct {
+	first, last string
+	seen        bool
+}
+
+// add registra un record di output. Con --count i record hanno il conteggio
+// e per i record binari la chiave non è testo: in questi casi non si registra.
+func (m *manifestKeys) add(line string) {
+	if opts.count || binaryRecords() || opts.avro || thenHasCount() {
+		return
+	}
+	k := displayKey(line)
+	if !m.seen {
+		m.first, m.seen = k, true
+	}
+	m.last = k
+}
+
+// thenHasCount indica se la catena --then contiene il passo count.
+func thenHasCount() bool {
+	for _, step := range opts.then {
+		if step == "count" {
+			return true
+		}
+	}
+	return false
+}
+
+// displayKey restituisce in forma leggibile la chiave di un record di
+// output: i campi di --key o --csv-key separati da tab, i valori di
+// --json-key, la porzione di --key-bytes o altrimenti il record intero.
+func displayKey(line string) string {
+	record := strings.TrimSuffix(line, "\r")
+	if s, ok := opts.schemaDef.Parse([]byte(record)); ok {
+		record = s
+	}
+	var parts []string
+	switch {
+	case len(opts.keySpecs) > 0:
+		for i := range opts.keySpecs {
+			a, b := opts.keySpecs[i].keyBounds(record)
+			part := record[a:b]
+			if opts.csv {
+				part = csvUnescape(part)
+			}
+			parts = append(parts, part)
+		}
+	case opts.keyLen > 0:
+		parts = append(parts, byteRange(record, opts.keyOffset, opts.keyLen))
+	case len(opts.jsonPaths) > 0:
+		for i := range opts.jsonPaths {
+			raw, _ := jsonLookup(record, opts.jsonPaths[i].steps)
+			parts = append(parts, string(raw))
+		}
+	default:
+		parts = append(parts, record)
+	}
+	k := strings.Join(parts, "\t")
+	if len(k) > manifestKeyMax {
+		k = k[:manifestKeyMax]
+	}
+	return strings.ToValidUTF8(k, "�")
+}
+
+// toolVersion restituisce la versione del modulo e, se disponibile, la
+// revisione del sorgente con cui è stato compilato il programma.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "sconosciuta"
+	}
+	v := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			v += " " + s.Value
+		}
+	}
+	return v
+}
+
+// writeManifest salva in outputFile + manifestSuffix il manifest dell'output
+// appena scritto da merge di chunks run ordinati.
+func writeManifest(outputFile string, d *outputDigest, records int64, chunks int, keys *manifestKeys) error {
+	m := outputManifest{
+		Version:   1,
+		Created:   time.Now().UTC(),
+		Tool:      toolVersion(),
+		Output:    filepath.Base(outputFile),
+		Records:   records,
+		Bytes:     d.bytes,
+		SHA256:    d.sum(),
+		Chunks:    chunks,
+		Args:      flagArgs(nil),
+		OrderArgs: orderArgs(),
+		Inputs:    sealInputs(),
+	}
+	if keys.seen {
+		m.FirstKey, m.LastKey = &keys.first, &keys.last
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile+manifestSuffix, append(data, '\n'), 0644)
+}
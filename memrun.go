@@ -0,0 +1,27 @@
+package main
+
+// memoryRunPath è il nome con cui la run in memoria compare tra i file del
+// merge, nei rapporti e nella diagnostica.
+const memoryRunPath = "(memoria)"
+
+// memoryRun sono le righe ordinate di un input entrato in un solo chunk:
+// invece di scriverle in un chunk e rileggerle, il merge le legge da qui e
+// passa dalla stessa catena di output (--unique, --count, --tee, sigillo...).
+var memoryRun []string
+
+// inMemorySort indica se un input che sta in un solo chunk può essere
+// ordinato in memoria. Partizioni, run set, --edges e la replacement
+// selection hanno bisogno dei chunk su disco.
+func inMemorySort() bool {
+	return opts.inMemory && opts.partitionKey == nil && opts.runSet == "" && opts.edges == 0 && opts.runGen != runGenReplacement
+}
+
+// memoryRunInfo descrive la run in memoria come un chunk dello split.
+func memoryRunInfo() chunkInfo {
+	return chunkInfo{
+		Path:  memoryRunPath,
+		Lines: len(memoryRun),
+		First: memoryRun[0],
+		Last:  memoryRun[len(memoryRun)-1],
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// memCheckEvery è ogni quanti record lo split controlla l'uso dell'heap.
+const memCheckEvery = 1024
+
+// heapMetric è la metrica con i byte occupati dagli oggetti vivi (o non
+// ancora raccolti) nell'heap. Leggerla non ferma il programma, a differenza
+// di runtime.ReadMemStats.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// memWatermark segnala quando l'heap supera la soglia alta durante lo split,
+// ad esempio per righe molto lunghe o per troppe copie di chunk in coda.
+type memWatermark struct {
+	limit  uint64 // soglia alta in byte (0 = disattivata)
+	count  int    // record visti dall'ultimo controllo
+	sample []metrics.Sample
+}
+
+// newMemWatermark crea il controllo per la soglia indicata (0 = disattivato).
+func newMemWatermark(limit uint64) *memWatermark {
+	return &memWatermark{limit: limit, sample: []metrics.Sample{{Name: heapMetric}}}
+}
+
+// exceeded va chiamata a ogni record: ogni memCheckEvery record legge l'heap
+// e indica se ha superato la soglia.
+func (w *memWatermark) exceeded() bool {
+	if w.limit == 0 {
+		return false
+	}
+	if w.count++; w.count < memCheckEvery {
+		return false
+	}
+	w.count = 0
+	return w.heapBytes() >= w.limit
+}
+
+// heapBytes restituisce i byte correnti dell'heap.
+func (w *memWatermark) heapBytes() uint64 {
+	metrics.Read(w.sample)
+	if w.sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return w.sample[0].Value.Uint64()
+}
+
+// parseMemWatermark interpreta --mem-watermark: 0 (disattivata), auto
+// (80% del limite di memoria del container o di GOMEMLIMIT) oppure una
+// dimensione con suffisso opzionale K, M o G.
+func parseMemWatermark(v string) (uint64, error) {
+	if v == "auto" {
+		limit := memoryLimit()
+		if limit == 0 {
+			return 0, nil
+		}
+		return limit / 10 * 8, nil
+	}
+	n, err := parseMemSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("--mem-watermark non valida: %q (attesi auto, 0 o una dimensione come 512M)", v)
+	}
+	return n, nil
+}
+
+// parseMemSize interpreta una dimensione in byte con suffisso opzionale K, M o G.
+func parseMemSize(v string) (uint64, error) {
+	mult := uint64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult, v = 1<<10, strings.TrimSuffix(v, "K")
+	case strings.HasSuffix(v, "M"):
+		mult, v = 1<<20, strings.TrimSuffix(v, "M")
+	case strings.HasSuffix(v, "G"):
+		mult, v = 1<<30, strings.TrimSuffix(v, "G")
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// memoryLimit restituisce il limite di memoria effettivo del processo: il più
+// basso tra GOMEMLIMIT e il limite del cgroup (v2 o v1). 0 se non c'è limite.
+func memoryLimit() uint64 {
+	var limit uint64
+	if l := debug.SetMemoryLimit(-1); l > 0 && l < math.MaxInt64 {
+		limit = uint64(l)
+	}
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		// "max" (v2) o valori enormi (v1) indicano l'assenza di limite.
+		if err != nil || l >= 1<<60 {
+			continue
+		}
+		if limit == 0 || l < limit {
+			limit = l
+		}
+	}
+	return limit
+}
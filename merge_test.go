@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// keyedLines restituisce n righe "chiave posizione" con chiavi numeriche
+// ripetute da 0 a keys-1 in ordine sparso: la posizione nell'input rende
+// visibile l'ordine tra righe con la stessa chiave.
+func keyedLines(n, keys int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%d %d", (i*7919)%keys, i)
+	}
+	return lines
+}
+
+// lineKey è la chiave numerica di una riga di keyedLines.
+func lineKey(l string) int {
+	k, _, _ := strings.Cut(l, " ")
+	n, _ := strconv.Atoi(k)
+	return n
+}
+
+// stableByKey ordina lines per chiave numerica, crescente o decrescente,
+// conservando l'ordine dell'input a parità di chiave; con unique tiene la
+// prima riga di ogni chiave.
+func stableByKey(lines []string, desc, unique bool) []string {
+	s := append([]string(nil), lines...)
+	sort.SliceStable(s, func(i, j int) bool {
+		if desc {
+			return lineKey(s[i]) > lineKey(s[j])
+		}
+		return lineKey(s[i]) < lineKey(s[j])
+	})
+	if !unique {
+		return s
+	}
+	var out []string
+	for i, l := range s {
+		if i == 0 || lineKey(l) != lineKey(s[i-1]) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// TestMergeOrder verifica l'ordine dell'output del merge con molti chunk,
+// con e senza livelli intermedi, e dei merge di input già ordinati: il
+// torneo deve rispettare le opzioni di confronto e, con --stable e
+// --unique, l'ordine dell'input tra righe con la stessa chiave anche quando
+// provengono da chunk diversi.
+func TestMergeOrder(t *testing.T) {
+	lines := keyedLines(6000, 50)
+	split := []string{"--chunk-bytes", "4000", "--in-memory=false"}
+	byKey := []string{"--numeric", "--key", "1,1"}
+	tests := []struct {
+		name  string
+		merge func([]string) []string // se non nil, l'input è diviso in tre file ordinati con merge e fusi con --merge
+		args  []string
+		want  []string
+	}{
+		{name: "byte", args: split, want: sortedCopy(lines)},
+		{name: "byte a livelli", args: append([]string{"--merge-fan-in", "3"}, split...), want: sortedCopy(lines)},
+		{name: "stable", args: append(append([]string{"--stable"}, byKey...), split...),
+			want: stableByKey(lines, false, false)},
+		{name: "stable a livelli", args: append(append([]string{"--stable", "--merge-fan-in", "2"}, byKey...), split...),
+			want: stableByKey(lines, false, false)},
+		{name: "reverse stable", args: append(append([]string{"--stable", "--reverse"}, byKey...), split...),
+			want: stableByKey(lines, true, false)},
+		{name: "unique", args: append(append([]string{"--unique"}, byKey...), split...),
+			want: stableByKey(lines, false, true)},
+		{name: "merge", merge: sortedCopy, args: []string{"--merge"}, want: sortedCopy(lines)},
+		{name: "merge stable", merge: func(l []string) []string { return stableByKey(l, false, false) }, args: append([]string{"--merge", "--stable"}, byKey...),
+			want: stableByKey(lines, false, false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := append([]string{"--output", "out.txt"}, tt.args...)
+			if tt.merge != nil {
+				// Ogni file contiene un terzo consecutivo delle righe: a parità
+				// di chiave, con --stable, il primo input viene prima.
+				for i := 0; i < 3; i++ {
+					part := tt.merge(lines[i*len(lines)/3 : (i+1)*len(lines)/3])
+					args = append(args, "--input", writeLines(t, dir, fmt.Sprintf("input%d.txt", i), part))
+				}
+			} else {
+				args = append(args, "--input", writeLines(t, dir, "input.txt", lines))
+			}
+			mustRunSorter(t, dir, args...)
+			equalLines(t, tt.name, readLines(t, filepath.Join(dir, "out.txt")), tt.want)
+		})
+	}
+}
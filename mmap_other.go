@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile non è implementata su questo sistema: il merge legge i chunk
+// con lo scanner.
+func mapFile(f *os.File) ([]byte, error) {
+	return nil, errors.New("mmap non supportato")
+}
+
+func unmapFile(data []byte) error { return nil }
+
+func mappedString(b []byte) string { return string(b) }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// TestMappedChunksOutliveMerger verifica che i consumer dello stream ordinato
+// possano usare le righe dei chunk mappati anche dopo la chiusura del merger
+// che le ha prodotte: --tee, --partition-by e --then partition le trattengono
+// fino alla chiusura dell'output.
+func TestMappedChunksOutliveMerger(t *testing.T) {
+	lines := testLines(30000)
+	want := sortedCopy(lines)
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, dir string)
+	}{
+		{"tee stats", []string{"--tee", "stats"}, nil},
+		{"tee file", []string{"--tee", "file:tee.txt"}, func(t *testing.T, dir string) {
+			equalLines(t, "tee.txt", readLines(t, filepath.Join(dir, "tee.txt")), want)
+		}},
+		{"partition-by", []string{"--partition-by", "prefix:1"}, checkPartitions(want)},
+		{"then partition", []string{"--then", "partition:1.1,1.1"}, checkPartitions(want)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", lines)
+			args := append([]string{"--input", input, "--output", "out.txt", "--chunk-bytes", "100000", "--mmap=true"}, tt.args...)
+			mustRunSorter(t, dir, args...)
+			if tt.check != nil {
+				tt.check(t, dir)
+			} else {
+				equalLines(t, "out.txt", readLines(t, filepath.Join(dir, "out.txt")), want)
+			}
+		})
+	}
+}
+
+// checkPartitions verifica che i file di partitions/, concatenati in ordine
+// di nome, diano want: ogni partizione è un intervallo di chiavi ordinato.
+func checkPartitions(want []string) func(t *testing.T, dir string) {
+	return func(t *testing.T, dir string) {
+		t.Helper()
+		names, err := filepath.Glob(filepath.Join(dir, "partitions", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) < 2 {
+			t.Fatalf("%d partizioni scritte, attese più di una", len(names))
+		}
+		sort.Strings(names)
+		var got []string
+		for _, name := range names {
+			got = append(got, readLines(t, name)...)
+		}
+		equalLines(t, "partizioni", got, want)
+		if _, err := os.Stat(filepath.Join(dir, "out.txt")); err == nil {
+			t.Error("out.txt scritto con l'output partizionato")
+		}
+	}
+}
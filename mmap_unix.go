@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile mappa in sola lettura l'intero contenuto di f. Un file vuoto
+// restituisce nil senza errore.
+func mapFile(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 || int64(int(size)) != size {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile rilascia una regione mappata con mapFile.
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+// mappedString restituisce b come stringa senza copiarlo. b deve restare
+// mappato finché la stringa è in uso.
+func mappedString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
@@ -0,0 +1,33 @@
+package main
+
+// monthNames sono le abbreviazioni riconosciute da --month-sort, come nella
+// locale C di GNU sort.
+var monthNames = [12]string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// monthKey restituisce la chiave di s per --month-sort: un byte con il numero
+// del mese (1-12) indicato dai primi tre caratteri dopo gli spazi iniziali,
+// senza distinzione tra maiuscole e minuscole, oppure 0 se non sono un mese.
+// Come in GNU sort -M, "January" vale JAN e i valori sconosciuti precedono
+// gennaio; a parità di mese decide il resto del confronto.
+func monthKey(s string) string {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	if len(s)-i >= 3 {
+		var abbr [3]byte
+		for j := range abbr {
+			c := s[i+j]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			abbr[j] = c
+		}
+		for m, name := range monthNames {
+			if string(abbr[:]) == name {
+				return string([]byte{byte(m + 1)})
+			}
+		}
+	}
+	return "\x00"
+}
@@ -0,0 +1,42 @@
+package main
+
+// naturalKey codifica s in una chiave il cui ordine binario è l'ordine
+// "naturale": le sequenze di cifre si confrontano come numeri, quindi
+// "file2" precede "file10".
+//
+// Ogni sequenza di cifre diventa 0x01, il numero di cifre significative su
+// 4 byte e le cifre senza zeri iniziali; a parità di valore decide il
+// confronto finale sulla riga. I byte di testo restano invariati, tranne
+// 0x00-0x02 che vengono preceduti da 0x02: così un numero (0x01) precede
+// sempre il testo nella stessa posizione. Con fold il testo viene confrontato
+// in maiuscolo, come per --ignore-case.
+func naturalKey(s string, fold bool) string {
+	buf := make([]byte, 0, len(s)+8)
+	for i := 0; i < len(s); {
+		c := s[i]
+		if !isDigit(c) {
+			if fold && c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c <= 0x02 {
+				buf = append(buf, 0x02)
+			}
+			buf = append(buf, c)
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		digits := s[start:i]
+		for len(digits) > 1 && digits[0] == '0' {
+			digits = digits[1:]
+		}
+		n := len(digits)
+		buf = append(buf, 0x01, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		buf = append(buf, digits...)
+	}
+	return string(buf)
+}
@@ -0,0 +1,63 @@
+package main
+
+// numericKey codifica il numero all'inizio di s in una chiave il cui ordine
+// binario coincide con l'ordine numerico, come GNU sort -n: spazi iniziali,
+// segno meno opzionale, cifre e parte decimale opzionale. Un prefisso non
+// numerico vale zero; il resto della stringa viene ignorato.
+//
+// Formato: classe ('0' negativo, '1' zero, '2' positivo), numero di cifre
+// intere su 4 byte, cifre intere senza zeri iniziali, cifre decimali senza
+// zeri finali e un terminatore 0x00. Per i negativi i byte dopo la classe
+// vengono complementati, così l'ordine delle magnitudini si inverte.
+func numericKey(s string) string {
+	i := 0
+	for i < len(s) && isBlank(s[i]) {
+		i++
+	}
+	neg := i < len(s) && s[i] == '-'
+	if neg {
+		i++
+	}
+	intStart := i
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	intPart := s[intStart:i]
+	var frac string
+	if i < len(s) && s[i] == '.' {
+		i++
+		fracStart := i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		frac = s[fracStart:i]
+	}
+
+	for len(intPart) > 0 && intPart[0] == '0' {
+		intPart = intPart[1:]
+	}
+	for len(frac) > 0 && frac[len(frac)-1] == '0' {
+		frac = frac[:len(frac)-1]
+	}
+	if intPart == "" && frac == "" {
+		return "1"
+	}
+
+	buf := make([]byte, 0, 6+len(intPart)+len(frac))
+	buf = append(buf, '2')
+	n := len(intPart)
+	buf = append(buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	buf = append(buf, intPart...)
+	buf = append(buf, frac...)
+	buf = append(buf, 0)
+	if neg {
+		buf[0] = '0'
+		for j := 1; j < len(buf); j++ {
+			buf[j] = ^buf[j]
+		}
+	}
+	return string(buf)
+}
+
+// isDigit riconosce le cifre decimali ASCII.
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiti delle parti di un upload multipart S3 (valgono anche per GCS).
+const (
+	objectMinPartMB = 5
+	objectMaxPartMB = 5 << 10
+	objectMaxParts  = 10000
+)
+
+// objectRetries è il numero di tentativi di ogni richiesta all'object store.
+const objectRetries = 4
+
+// objectURL è una destinazione s3://BUCKET/KEY o gs://BUCKET/KEY.
+type objectURL struct {
+	scheme string
+	bucket string
+	key    string
+}
+
+// parseObjectURL riconosce le destinazioni su object store. ok è false per i
+// percorsi locali.
+func parseObjectURL(s string) (u objectURL, ok bool, err error) {
+	scheme, rest, found := strings.Cut(s, "://")
+	if !found || scheme != "s3" && scheme != "gs" {
+		return u, false, nil
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+		return u, true, fmt.Errorf("destinazione %q non valida: attesa %s://BUCKET/KEY", s, scheme)
+	}
+	return objectURL{scheme: scheme, bucket: bucket, key: key}, true, nil
+}
+
+// isObjectURL indica se il percorso è una destinazione su object store.
+func isObjectURL(s string) bool {
+	_, ok, _ := parseObjectURL(s)
+	return ok
+}
+
+// objectClient firma con AWS Signature V4 le richieste all'API S3 o
+// all'API XML di Cloud Storage, che con una chiave HMAC accetta le stesse
+// richieste. Le credenziali vengono dalle variabili AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN e AWS_REGION.
+type objectClient struct {
+	http     *http.Client
+	endpoint *url.URL // con --object-endpoint: URL in stile path (endpoint/bucket/key)
+	host     string   // host virtuale del bucket, senza --object-endpoint
+	region   string
+	access   string
+	secret   string
+	token    string
+}
+
+// newObjectClient prepara il client per la destinazione u.
+func newObjectClient(u objectURL) (*objectClient, error) {
+	c := &objectClient{
+		http:   &http.Client{Timeout: 10 * time.Minute},
+		access: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+		region: os.Getenv("AWS_REGION"),
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	// Cloud Storage accetta la firma V4 di S3 con la regione "auto".
+	if c.region == "" {
+		c.region = "us-east-1"
+		if u.scheme == "gs" {
+			c.region = "auto"
+		}
+	}
+	if c.access == "" || c.secret == "" {
+		return nil, fmt.Errorf("%s://%s: servono le credenziali in AWS_ACCESS_KEY_ID e AWS_SECRET_ACCESS_KEY", u.scheme, u.bucket)
+	}
+	switch {
+	case opts.objectEndpoint != "":
+		ep, err := url.Parse(strings.TrimSuffix(opts.objectEndpoint, "/"))
+		if err != nil || ep.Host == "" || ep.Scheme != "http" && ep.Scheme != "https" {
+			return nil, fmt.Errorf("--object-endpoint non valido %q: atteso un URL http o https", opts.objectEndpoint)
+		}
+		c.endpoint = ep
+	case u.scheme == "gs":
+		c.host = u.bucket + ".storage.googleapis.com"
+	default:
+		c.host = u.bucket + ".s3." + c.region + ".amazonaws.com"
+	}
+	return c, nil
+}
+
+// objectURI restituisce l'URL della richiesta per la chiave del bucket.
+func (c *objectClient) objectURI(u objectURL, query url.Values) *url.URL {
+	r := &url.URL{Scheme: "https", Host: c.host, Path: "/" + u.key}
+	if c.endpoint != nil {
+		r.Scheme, r.Host = c.endpoint.Scheme, c.endpoint.Host
+		r.Path = c.endpoint.Path + "/" + u.bucket + r.Path
+	}
+	// Il percorso viene inviato con la stessa codifica usata per la firma.
+	r.RawPath = objectEscape(r.Path)
+	r.RawQuery = objectQuery(query)
+	return r
+}
+
+// objectEscape codifica un percorso come richiede la firma V4: tutto tranne
+// i caratteri non riservati e le barre.
+func objectEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectQuery scrive la query string in forma canonica: parametri ordinati
+// e codificati come i percorsi, anche quando il valore è vuoto (uploads=).
+func objectQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.ReplaceAll(objectEscape(q.Get(k)), "/", "%2F")
+		parts = append(parts, objectEscape(k)+"="+v)
+	}
+	return strings.Join(parts, "&")
+}
+
+// do firma ed esegue una richiesta, ripetendola sugli errori di rete e sulle
+// risposte 5xx. Restituisce la risposta con il corpo già letto.
+func (c *objectClient) do(method string, u objectURL, query url.Values, body []byte) (*http.Response, []byte, error) {
+	var lastErr error
+	for attempt := 0; attempt < objectRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * 250 * time.Millisecond)
+		}
+		req, err := http.NewRequest(method, "", bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.URL = c.objectURI(u, query)
+		req.Host = req.URL.Host
+		req.ContentLength = int64(len(body))
+		c.sign(req, body, time.Now().UTC())
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			lastErr = objectError(method, u, resp, data)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return nil, nil, objectError(method, u, resp, data)
+		}
+		return resp, data, nil
+	}
+	return nil, nil, lastErr
+}
+
+// objectError descrive una risposta di errore, con il codice XML se presente.
+func objectError(method string, u objectURL, resp *http.Response, data []byte) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	xml.Unmarshal(data, &e)
+	if e.Code != "" {
+		return fmt.Errorf("%s %s://%s/%s: %s (%s: %s)", method, u.scheme, u.bucket, u.key, resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("%s %s://%s/%s: %s", method, u.scheme, u.bucket, u.key, resp.Status)
+}
+
+// sign aggiunge a req la firma AWS Signature V4 calcolata sul corpo body.
+func (c *objectClient) sign(req *http.Request, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	stamp := now.Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.Host}
+	for k := range req.Header {
+		n := strings.ToLower(k)
+		names = append(names, n)
+		values[n] = strings.TrimSpace(req.Header.Get(k))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + values[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonHeaders.String(), signed, payload}, "\n")
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := []byte("AWS4" + c.secret)
+	for _, part := range []string{day, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.access, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// objectPart è una parte dell'upload multipart pronta per l'invio.
+type objectPart struct {
+	n    int
+	data []byte
+}
+
+// objectWriter scrive l'output direttamente su object store: i byte vengono
+// raccolti in parti di --object-part-mb e caricati con un upload multipart
+// mentre il merge prosegue, quindi l'output non passa dal disco locale. Un
+// output più piccolo di una parte viene caricato con una sola richiesta.
+// L'oggetto diventa visibile solo con Commit; Close senza Commit annulla
+// l'upload, così un merge interrotto non lascia oggetti incompleti.
+type objectWriter struct {
+	dst      objectURL
+	client   *objectClient
+	partSize int
+	buf      []byte
+	uploadID string
+	next     int             // numero della prossima parte
+	queue    chan objectPart // parti in attesa del caricamento
+	done     chan struct{}   // chiuso quando il caricamento termina
+
+	mu    sync.Mutex
+	etags []string // ETag delle parti caricate, per numero di parte
+	err   error    // primo errore di caricamento
+
+	closed bool
+}
+
+// newObjectWriter prepara la scrittura dell'oggetto u. Nessuna richiesta
+// parte prima che l'output raggiunga la dimensione di una parte.
+func newObjectWriter(u objectURL) (*objectWriter, error) {
+	c, err := newObjectClient(u)
+	if err != nil {
+		return nil, err
+	}
+	size := opts.objectPartMB << 20
+	return &objectWriter{dst: u, client: c, partSize: size, buf: make([]byte, 0, size), next: 1}, nil
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if err := w.uploadErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := w.partSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if len(w.buf) == w.partSize {
+			if err := w.sendPart(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// sendPart mette in coda la parte corrente, avviando l'upload multipart alla
+// prima parte. La coda contiene una parte sola: la memoria usata resta entro
+// tre parti e il merge si ferma se la rete non tiene il passo.
+func (w *objectWriter) sendPart() error {
+	if w.uploadID == "" {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if w.next > objectMaxParts {
+		return fmt.Errorf("%s://%s/%s: output oltre %d parti, aumentare --object-part-mb", w.dst.scheme, w.dst.bucket, w.dst.key, objectMaxParts)
+	}
+	w.queue <- objectPart{n: w.next, data: w.buf}
+	w.next++
+	w.buf = make([]byte, 0, w.partSize)
+	return nil
+}
+
+// start avvia l'upload multipart e la goroutine che carica le parti.
+func (w *objectWriter) start() error {
+	_, data, err := w.client.do(http.MethodPost, w.dst, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &res); err != nil || res.UploadID == "" {
+		return fmt.Errorf("%s://%s/%s: risposta senza UploadId all'avvio dell'upload", w.dst.scheme, w.dst.bucket, w.dst.key)
+	}
+	w.uploadID = res.UploadID
+	w.queue = make(chan objectPart, 1)
+	w.done = make(chan struct{})
+	go w.upload()
+	return nil
+}
+
+// upload carica le parti in coda. Dopo un errore le parti successive vengono
+// scartate: l'errore arriva alla prossima Write o a Commit.
+func (w *objectWriter) upload() {
+	defer close(w.done)
+	for p := range w.queue {
+		if w.uploadErr() != nil {
+			continue
+		}
+		q := url.Values{"partNumber": {fmt.Sprint(p.n)}, "uploadId": {w.uploadID}}
+		resp, _, err := w.client.do(http.MethodPut, w.dst, q, p.data)
+		w.mu.Lock()
+		if err != nil {
+			w.err = err
+		} else {
+			for len(w.etags) < p.n {
+				w.etags = append(w.etags, "")
+			}
+			w.etags[p.n-1] = resp.Header.Get("ETag")
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *objectWriter) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// wait chiude la coda e attende il caricamento delle parti già inviate.
+func (w *objectWriter) wait() error {
+	if w.uploadID == "" || w.closed {
+		return w.uploadErr()
+	}
+	w.closed = true
+	close(w.queue)
+	<-w.done
+	return w.uploadErr()
+}
+
+// Commit carica l'ultima parte e completa l'upload: da questo momento
+// l'oggetto è visibile con tutto l'output.
+func (w *objectWriter) Commit() error {
+	if w.uploadID == "" {
+		// Output più piccolo di una parte: una sola richiesta PUT.
+		_, _, err := w.client.do(http.MethodPut, w.dst, nil, w.buf)
+		w.closed = true
+		return err
+	}
+	if len(w.buf) > 0 {
+		if err := w.sendPart(); err != nil {
+			return err
+		}
+	}
+	if err := w.wait(); err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range w.etags {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>", i+1)
+		xml.EscapeText(&body, []byte(etag))
+		body.WriteString("</ETag></Part>")
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+	_, data, err := w.client.do(http.MethodPost, w.dst, url.Values{"uploadId": {w.uploadID}}, body.Bytes())
+	if err != nil {
+		return err
+	}
+	// Il completamento può fallire anche con 200: l'errore è nel corpo.
+	var e struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &e) == nil && e.XMLName.Local == "Error" {
+		return fmt.Errorf("%s://%s/%s: completamento dell'upload fallito (%s: %s)", w.dst.scheme, w.dst.bucket, w.dst.key, e.Code, e.Message)
+	}
+	w.uploadID = ""
+	return nil
+}
+
+// Close annulla l'upload se Commit non è stato chiamato o non è riuscito.
+func (w *objectWriter) Close() error {
+	if w.uploadID == "" {
+		return nil
+	}
+	w.wait()
+	_, _, err := w.client.do(http.MethodDelete, w.dst, url.Values{"uploadId": {w.uploadID}}, nil)
+	w.uploadID = ""
+	return err
+}
+
+// commitOutput rende definitivo un output aperto con createOutput. Per i file
+// locali non fa nulla.
+func commitOutput(out io.Writer) error {
+	if w, ok := out.(*objectWriter); ok {
+		if err := w.Commit(); err != nil {
+			return err
+		}
+		fmt.Fprintf(status, "📝 Output caricato su %s://%s/%s\n", w.dst.scheme, w.dst.bucket, w.dst.key)
+	}
+	return nil
+}
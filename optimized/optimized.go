@@ -1,9 +1,13 @@
+//go:build ignore
+
+// Le varianti in questa directory sono programmi indipendenti: si
+// compilano una alla volta (go build optimized.go) e restano fuori da ./...
+
 package main
 
 import (
 	"bufio"
 	"bytes"
-	"container/heap"
 	"fmt"
 	"io"
 	"os"
@@ -23,30 +27,62 @@ type heapItem struct {
 }
 
 // minHeapBuffered è un heap minimo di heapItem ordinato alfabeticamente
-// per mantenere sempre in cima la stringa più piccola.
+// per mantenere sempre in cima la stringa più piccola. push e pop lavorano
+// sul posto, senza container/heap: nessun heapItem passa da interface{} e,
+// creato con newMinHeap, l'heap non si rialloca durante il merge.
 type minHeapBuffered []heapItem
 
-// Len restituisce la lunghezza dell'heap (numero di elementi)
-func (h minHeapBuffered) Len() int { return len(h) }
+// newMinHeap crea un heap vuoto con posto per n elementi, uno per chunk.
+func newMinHeap(n int) minHeapBuffered { return make(minHeapBuffered, 0, n) }
 
-// Less confronta due elementi dell'heap per mantenere ordine alfabetico
-func (h minHeapBuffered) Less(i, j int) bool { return h[i].value < h[j].value }
+// push aggiunge un elemento risalendo fino alla sua posizione.
+func (h *minHeapBuffered) push(it heapItem) {
+	*h = append(*h, it)
+	q := *h
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if q[parent].value <= q[i].value {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
 
-// Swap scambia due elementi nell'heap
-func (h minHeapBuffered) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+// pop toglie l'elemento più piccolo.
+func (h *minHeapBuffered) pop() {
+	q := *h
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = heapItem{}
+	*h = q[:n]
+	h.down(0)
+}
 
-// Push aggiunge un nuovo elemento all'heap (richiesto da container/heap)
-func (h *minHeapBuffered) Push(x interface{}) {
-	*h = append(*h, x.(heapItem))
+// replaceTop sostituisce l'elemento più piccolo con it: un solo passaggio
+// verso il basso invece di pop seguito da push.
+func (h minHeapBuffered) replaceTop(it heapItem) {
+	h[0] = it
+	h.down(0)
 }
 
-// Pop rimuove e restituisce l'ultimo elemento dell'heap (richiesto da container/heap)
-func (h *minHeapBuffered) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[:n-1]
-	return item
+// down fa scendere l'elemento i fino alla sua posizione.
+func (h minHeapBuffered) down(i int) {
+	n := len(h)
+	for {
+		small, l := i, 2*i+1
+		if l < n && h[l].value < h[small].value {
+			small = l
+		}
+		if r := l + 1; r < n && h[r].value < h[small].value {
+			small = r
+		}
+		if small == i {
+			return
+		}
+		h[i], h[small] = h[small], h[i]
+		i = small
+	}
 }
 
 // chunkReader rappresenta un file chunk con un buffer interno.
@@ -112,6 +148,18 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 	// Numero di worker = numero di CPU disponibili
 	numWorkers := runtime.NumCPU()
 	var wg sync.WaitGroup
+	// Il primo errore di un worker ferma la lettura e fa fallire lo split:
+	// un chunk mancante perderebbe le sue righe senza che nessuno se ne accorga.
+	// done viene chiuso al primo errore; i worker restanti svuotano il canale.
+	var firstErr error
+	var once sync.Once
+	done := make(chan struct{})
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
 
 	// Avvia i worker che ricevono chunk dal canale, li ordinano e scrivono su disco
 	for i := 0; i < numWorkers; i++ {
@@ -120,26 +168,35 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 			defer wg.Done()
 			for job := range chunkChan {
 				sort.Strings(job.lines)
-				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%03d.txt", job.id))
+				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%06d.txt", job.id))
 				f, err := os.Create(chunkPath)
 				if err != nil {
-					fmt.Fprintln(os.Stderr, "Errore creazione file chunk:", err)
+					fail(fmt.Errorf("creazione del chunk: %w", err))
 					continue
 				}
 				writer := bufio.NewWriter(f)
 				for _, s := range job.lines {
-					writer.WriteString(s + "\n")
+					writer.WriteString(s)
+					writer.WriteByte('\n')
+				}
+				err = writer.Flush()
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					fail(fmt.Errorf("scrittura di %s: %w", chunkPath, err))
 				}
-				writer.Flush()
-				f.Close()
 			}
 		}()
 	}
 
 	// Legge linee dal file, crea chunk e li invia ai worker tramite canale
+read:
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil && err != io.EOF {
+			close(chunkChan)
+			wg.Wait()
 			return err
 		}
 
@@ -152,18 +209,22 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 		}
 
 		if chunkSize >= maxDiskSize || len(chunk) >= maxItems || (err == io.EOF && len(chunk) > 0) {
-			// Copia difensiva della slice prima di inviare ai worker
+			// La slice passa al worker: il lettore continua su una slice nuova.
 			job := struct {
 				lines []string
 				id    int
 			}{
-				lines: append([]string(nil), chunk...),
+				lines: chunk,
 				id:    chunkCount,
 			}
-			chunkChan <- job
+			select {
+			case chunkChan <- job:
+			case <-done:
+				break read
+			}
 
 			chunkCount++
-			chunk = chunk[:0]
+			chunk = make([]string, 0, len(chunk))
 			chunkSize = 0
 		}
 
@@ -174,7 +235,7 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 
 	close(chunkChan) // chiude il canale per terminare i worker
 	wg.Wait()        // aspetta che tutti i worker finiscano
-	return nil
+	return firstErr
 }
 
 // fillBuffer (CORRETTO) ora usa lo scanner persistente del chunkReader.
@@ -230,12 +291,11 @@ func mergeChunks(chunkDir string, outputFile string) error {
 	}()
 
 	// Inizializza l'heap minimo e inserisce la prima riga di ogni chunk nel heap
-	h := &minHeapBuffered{}
-	heap.Init(h)
+	h := newMinHeap(len(readers))
 
 	for _, r := range readers {
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.push(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
 		}
 	}
@@ -249,9 +309,10 @@ func mergeChunks(chunkDir string, outputFile string) error {
 
 	// Ciclo principale: estrae l'elemento più piccolo dall'heap, lo scrive,
 	// e lo rimpiazza con la riga successiva dello stesso chunkReader.
-	for h.Len() > 0 {
-		item := heap.Pop(h).(heapItem) // Estrae l'elemento più piccolo
-		writer.WriteString(item.value + "\n")
+	for len(h) > 0 {
+		item := h[0] // elemento più piccolo
+		writer.WriteString(item.value)
+		writer.WriteByte('\n')
 
 		r := readers[item.index]
 
@@ -262,11 +323,14 @@ func mergeChunks(chunkDir string, outputFile string) error {
 			}
 		}
 
-		// Se dopo il tentativo di riempimento il buffer ha ancora dati,
-		// inserisce la prossima riga nell'heap.
+		// Se dopo il tentativo di riempimento il buffer ha ancora dati, la
+		// prossima riga prende il posto di quella scritta; altrimenti il
+		// chunk esce dall'heap.
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.replaceTop(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
+		} else {
+			h.pop()
 		}
 	}
 	return writer.Flush()
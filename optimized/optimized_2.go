@@ -1,9 +1,13 @@
+//go:build ignore
+
+// Le varianti in questa directory sono programmi indipendenti: si
+// compilano una alla volta (go build optimized_2.go) e restano fuori da ./...
+
 package main
 
 import (
 	"bufio"
 	"bytes"
-	"container/heap"
 	"fmt"
 	"io"
 	"os"
@@ -19,20 +23,63 @@ type heapItem struct {
 	index int
 }
 
+// minHeapBuffered è un heap minimo di heapItem ordinato alfabeticamente
+// per mantenere sempre in cima la stringa più piccola. push e pop lavorano
+// sul posto, senza container/heap: nessun heapItem passa da interface{} e,
+// creato con newMinHeap, l'heap non si rialloca durante il merge.
 type minHeapBuffered []heapItem
 
-func (h minHeapBuffered) Len() int           { return len(h) }
-func (h minHeapBuffered) Less(i, j int) bool { return h[i].value < h[j].value }
-func (h minHeapBuffered) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h *minHeapBuffered) Push(x interface{}) {
-	*h = append(*h, x.(heapItem))
+// newMinHeap crea un heap vuoto con posto per n elementi, uno per chunk.
+func newMinHeap(n int) minHeapBuffered { return make(minHeapBuffered, 0, n) }
+
+// push aggiunge un elemento risalendo fino alla sua posizione.
+func (h *minHeapBuffered) push(it heapItem) {
+	*h = append(*h, it)
+	q := *h
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if q[parent].value <= q[i].value {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
 }
-func (h *minHeapBuffered) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[:n-1]
-	return item
+
+// pop toglie l'elemento più piccolo.
+func (h *minHeapBuffered) pop() {
+	q := *h
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = heapItem{}
+	*h = q[:n]
+	h.down(0)
+}
+
+// replaceTop sostituisce l'elemento più piccolo con it: un solo passaggio
+// verso il basso invece di pop seguito da push.
+func (h minHeapBuffered) replaceTop(it heapItem) {
+	h[0] = it
+	h.down(0)
+}
+
+// down fa scendere l'elemento i fino alla sua posizione.
+func (h minHeapBuffered) down(i int) {
+	n := len(h)
+	for {
+		small, l := i, 2*i+1
+		if l < n && h[l].value < h[small].value {
+			small = l
+		}
+		if r := l + 1; r < n && h[r].value < h[small].value {
+			small = r
+		}
+		if small == i {
+			return
+		}
+		h[i], h[small] = h[small], h[i]
+		i = small
+	}
 }
 
 type chunkReader struct {
@@ -92,6 +139,16 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 
 	numWorkers := runtime.NumCPU()
 	var wg sync.WaitGroup
+	// Il primo errore di un worker ferma la lettura e fa fallire lo split.
+	var firstErr error
+	var once sync.Once
+	done := make(chan struct{})
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
@@ -99,25 +156,34 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 			defer wg.Done()
 			for job := range chunkChan {
 				sort.Strings(job.lines)
-				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%03d.txt", job.id))
+				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%06d.txt", job.id))
 				f, err := os.Create(chunkPath)
 				if err != nil {
-					fmt.Fprintln(os.Stderr, "Errore creazione file chunk:", err)
+					fail(fmt.Errorf("creazione del chunk: %w", err))
 					continue
 				}
 				writer := bufio.NewWriter(f)
 				for _, s := range job.lines {
-					writer.WriteString(s + "\n")
+					writer.WriteString(s)
+					writer.WriteByte('\n')
+				}
+				err = writer.Flush()
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					fail(fmt.Errorf("scrittura di %s: %w", chunkPath, err))
 				}
-				writer.Flush()
-				f.Close()
 			}
 		}()
 	}
 
+read:
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil && err != io.EOF {
+			close(chunkChan)
+			wg.Wait()
 			return err
 		}
 		if len(line) > 0 {
@@ -132,12 +198,16 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 				lines []string
 				id    int
 			}{
-				lines: append([]string(nil), chunk...),
+				lines: chunk,
 				id:    chunkCount,
 			}
-			chunkChan <- job
+			select {
+			case chunkChan <- job:
+			case <-done:
+				break read
+			}
 			chunkCount++
-			chunk = chunk[:0]
+			chunk = make([]string, 0, len(chunk))
 			chunkSize = 0
 		}
 		if err == io.EOF {
@@ -147,7 +217,7 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 
 	close(chunkChan)
 	wg.Wait()
-	return nil
+	return firstErr
 }
 
 func fillBuffer(r *chunkReader, count int) error {
@@ -188,11 +258,10 @@ func mergeChunks(chunkDir string, outputFile string) error {
 		}
 	}()
 
-	h := &minHeapBuffered{}
-	heap.Init(h)
+	h := newMinHeap(len(readers))
 	for _, r := range readers {
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.push(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
 		}
 	}
@@ -206,8 +275,8 @@ func mergeChunks(chunkDir string, outputFile string) error {
 	writer := bufio.NewWriterSize(out, writerBufferSize)
 	writeBuffer := make([]byte, 0, writeFlushThreshold)
 
-	for h.Len() > 0 {
-		item := heap.Pop(h).(heapItem)
+	for len(h) > 0 {
+		item := h[0] // elemento più piccolo
 		writeBuffer = append(writeBuffer, item.value...)
 		writeBuffer = append(writeBuffer, '\n')
 		if len(writeBuffer) >= writeFlushThreshold {
@@ -223,8 +292,10 @@ func mergeChunks(chunkDir string, outputFile string) error {
 			}
 		}
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.replaceTop(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
+		} else {
+			h.pop()
 		}
 	}
 	if len(writeBuffer) > 0 {
@@ -1,9 +1,14 @@
+//go:build ignore
+
+// Le varianti in questa directory sono programmi indipendenti: si
+// compilano una alla volta (go build optimized_3.go) e restano fuori da ./...
+
 package main
 
 import (
 	"bufio"
 	"bytes"
-	"container/heap"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -20,18 +25,63 @@ type heapItem struct {
 	index int
 }
 
+// minHeapBuffered è un heap minimo di heapItem ordinato alfabeticamente
+// per mantenere sempre in cima la stringa più piccola. push e pop lavorano
+// sul posto, senza container/heap: nessun heapItem passa da interface{} e,
+// creato con newMinHeap, l'heap non si rialloca durante il merge.
 type minHeapBuffered []heapItem
 
-func (h minHeapBuffered) Len() int            { return len(h) }
-func (h minHeapBuffered) Less(i, j int) bool  { return h[i].value < h[j].value }
-func (h minHeapBuffered) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
-func (h *minHeapBuffered) Push(x interface{}) { *h = append(*h, x.(heapItem)) }
-func (h *minHeapBuffered) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[:n-1]
-	return item
+// newMinHeap crea un heap vuoto con posto per n elementi, uno per chunk.
+func newMinHeap(n int) minHeapBuffered { return make(minHeapBuffered, 0, n) }
+
+// push aggiunge un elemento risalendo fino alla sua posizione.
+func (h *minHeapBuffered) push(it heapItem) {
+	*h = append(*h, it)
+	q := *h
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if q[parent].value <= q[i].value {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
+
+// pop toglie l'elemento più piccolo.
+func (h *minHeapBuffered) pop() {
+	q := *h
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = heapItem{}
+	*h = q[:n]
+	h.down(0)
+}
+
+// replaceTop sostituisce l'elemento più piccolo con it: un solo passaggio
+// verso il basso invece di pop seguito da push.
+func (h minHeapBuffered) replaceTop(it heapItem) {
+	h[0] = it
+	h.down(0)
+}
+
+// down fa scendere l'elemento i fino alla sua posizione.
+func (h minHeapBuffered) down(i int) {
+	n := len(h)
+	for {
+		small, l := i, 2*i+1
+		if l < n && h[l].value < h[small].value {
+			small = l
+		}
+		if r := l + 1; r < n && h[r].value < h[small].value {
+			small = r
+		}
+		if small == i {
+			return
+		}
+		h[i], h[small] = h[small], h[i]
+		i = small
+	}
 }
 
 type chunkReader struct {
@@ -48,6 +98,8 @@ const (
 	bufferLines      = 9000
 	readerBufSize    = 256 * 1024
 	writerBufferSize = 4 * 1024 * 1024
+	recordLen        = strLength + 1 // byte di una riga dei chunk, newline compreso
+	samplesPerChunk  = 64            // righe campionate dal chunk più grande per i confini delle parti
 )
 
 func main() {
@@ -65,7 +117,7 @@ func main() {
 	fmt.Println("✅ Split completato.")
 
 	fmt.Println("🔹 Step 2: Merge finale parallelo...")
-	if err := mergeChunksParallelGrouped(outputDir, outputFile); err != nil {
+	if err := mergeChunksParallelRanged(outputDir, outputFile); err != nil {
 		panic(err)
 	}
 	fmt.Printf("✅ Merge completato in %s\n", time.Since(start))
@@ -89,31 +141,52 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 
 	numWorkers := runtime.NumCPU()
 	var wg sync.WaitGroup
+	// Il primo errore di un worker ferma la lettura e fa fallire lo split:
+	// un chunk mancante perderebbe le sue righe senza che nessuno se ne accorga.
+	// done viene chiuso al primo errore; i worker restanti svuotano il canale.
+	var firstErr error
+	var once sync.Once
+	done := make(chan struct{})
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for job := range chunkChan {
 				sort.Strings(job.lines)
-				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%03d.txt", job.id))
+				chunkPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%06d.txt", job.id))
 				f, err := os.Create(chunkPath)
 				if err != nil {
-					fmt.Fprintln(os.Stderr, "Errore creazione file chunk:", err)
+					fail(fmt.Errorf("creazione del chunk: %w", err))
 					continue
 				}
 				writer := bufio.NewWriter(f)
 				for _, s := range job.lines {
-					writer.WriteString(s + "\n")
+					writer.WriteString(s)
+					writer.WriteByte('\n')
+				}
+				err = writer.Flush()
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					fail(fmt.Errorf("scrittura di %s: %w", chunkPath, err))
 				}
-				writer.Flush()
-				f.Close()
 			}
 		}()
 	}
 
+read:
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil && err != io.EOF {
+			close(chunkChan)
+			wg.Wait()
 			return err
 		}
 
@@ -129,10 +202,14 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 			job := struct {
 				lines []string
 				id    int
-			}{lines: append([]string(nil), chunk...), id: chunkCount}
-			chunkChan <- job
+			}{lines: chunk, id: chunkCount}
+			select {
+			case chunkChan <- job:
+			case <-done:
+				break read
+			}
 			chunkCount++
-			chunk = chunk[:0]
+			chunk = make([]string, 0, len(chunk))
 			chunkSize = 0
 		}
 		if err == io.EOF {
@@ -141,7 +218,7 @@ func splitAndSortChunksParallel(inputFile, outputDir string) error {
 	}
 	close(chunkChan)
 	wg.Wait()
-	return nil
+	return firstErr
 }
 
 func fillBuffer(r *chunkReader, count int) error {
@@ -152,31 +229,38 @@ func fillBuffer(r *chunkReader, count int) error {
 	return r.scanner.Err()
 }
 
-func mergeChunks(chunkFiles []string, outputFile string) error {
-	readers := make([]*chunkReader, len(chunkFiles))
+// errMergeStopped è restituito da mergeRanges quando un'altra parte del
+// merge è fallita e stop è stato chiuso.
+var errMergeStopped = errors.New("merge interrotto")
+
+// mergeRanges fonde con l'heap, da ogni chunk, solo le righe comprese
+// nell'intervallo di byte ranges[i] del file i, e scrive il risultato in outputFile.
+// Si ferma con errMergeStopped se stop viene chiuso.
+func mergeRanges(chunkFiles []string, ranges [][2]int64, outputFile string, stop <-chan struct{}) error {
+	readers := make([]*chunkReader, 0, len(chunkFiles))
+	defer func() {
+		for _, r := range readers {
+			r.file.Close()
+		}
+	}()
 	for i, file := range chunkFiles {
 		f, err := os.Open(file)
 		if err != nil {
 			return err
 		}
-		scanner := bufio.NewScanner(bufio.NewReaderSize(f, readerBufSize))
+		section := io.NewSectionReader(f, ranges[i][0], ranges[i][1]-ranges[i][0])
+		scanner := bufio.NewScanner(bufio.NewReaderSize(section, readerBufSize))
 		r := &chunkReader{file: f, scanner: scanner, buffer: []string{}, index: i}
+		readers = append(readers, r)
 		if err := fillBuffer(r, bufferLines); err != nil {
 			return err
 		}
-		readers[i] = r
 	}
-	defer func() {
-		for _, r := range readers {
-			r.file.Close()
-		}
-	}()
 
-	h := &minHeapBuffered{}
-	heap.Init(h)
+	h := newMinHeap(len(readers))
 	for _, r := range readers {
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.push(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
 		}
 	}
@@ -188,57 +272,193 @@ func mergeChunks(chunkFiles []string, outputFile string) error {
 	defer out.Close()
 	writer := bufio.NewWriterSize(out, writerBufferSize)
 
-	for h.Len() > 0 {
-		item := heap.Pop(h).(heapItem)
-		writer.WriteString(item.value + "\n")
+	for len(h) > 0 {
+		item := h[0] // elemento più piccolo
+		writer.WriteString(item.value)
+		writer.WriteByte('\n')
 		r := readers[item.index]
 		if len(r.buffer) == 0 {
-			_ = fillBuffer(r, bufferLines)
+			// Un'altra parte è fallita: inutile finire questa.
+			select {
+			case <-stop:
+				return errMergeStopped
+			default:
+			}
+			if err := fillBuffer(r, bufferLines); err != nil {
+				return err
+			}
 		}
 		if len(r.buffer) > 0 {
-			heap.Push(h, heapItem{value: r.buffer[0], index: r.index})
+			h.replaceTop(heapItem{value: r.buffer[0], index: r.index})
 			r.buffer = r.buffer[1:]
+		} else {
+			h.pop()
 		}
 	}
-	return writer.Flush()
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
 }
 
-func mergeChunksParallelGrouped(chunkDir, finalOutput string) error {
+// readRecord legge la riga numero i di un chunk. Le righe hanno tutte
+// lunghezza recordLen, quindi la riga i inizia al byte i*recordLen.
+func readRecord(f *os.File, i int64) (string, error) {
+	buf := make([]byte, strLength)
+	if _, err := f.ReadAt(buf, i*recordLen); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// sampleKeys estrae righe a intervalli regolari da ogni chunk, in numero
+// proporzionale alla sua dimensione: il chunk più grande ne dà
+// samplesPerChunk, gli altri in proporzione e almeno una. Così i quantili
+// del campione dividono i byte dei chunk, non i chunk, e le parti costano
+// lo stesso ai worker anche quando l'ultimo chunk è molto più piccolo.
+func sampleKeys(files []string) ([]string, error) {
+	sizes := make([]int64, len(files))
+	var largest int64
+	for i, file := range files {
+		st, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = st.Size() / recordLen
+		largest = max(largest, sizes[i])
+	}
+	var samples []string
+	for i, file := range files {
+		n := sizes[i]
+		if n == 0 {
+			continue
+		}
+		count := max(samplesPerChunk*n/largest, 1)
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		for s := int64(0); s < count; s++ {
+			key, err := readRecord(f, s*n/count)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			samples = append(samples, key)
+		}
+		f.Close()
+	}
+	return samples, nil
+}
+
+// lowerBound restituisce l'offset della prima riga del chunk f che non
+// precede key, cercandola per bisezione sulle righe a lunghezza fissa.
+func lowerBound(f *os.File, n int64, key string) (int64, error) {
+	lo, hi := int64(0), n
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		v, err := readRecord(f, mid)
+		if err != nil {
+			return 0, err
+		}
+		if v < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo * recordLen, nil
+}
+
+// mergeChunksParallelRanged esegue il merge finale in parallelo dividendo lo
+// spazio delle chiavi in intervalli: i confini sono i quantili di un campione
+// preso da tutti i chunk, ogni worker fonde da tutti i chunk solo le righe del
+// proprio intervallo e le parti, disgiunte e già in ordine tra loro, vengono
+// concatenate. A differenza del merge a gruppi, l'output è ordinato globalmente.
+func mergeChunksParallelRanged(chunkDir, finalOutput string) error {
 	files, err := filepath.Glob(filepath.Join(chunkDir, "chunk_*.txt"))
 	if err != nil {
 		return err
 	}
+	samples, err := sampleKeys(files)
+	if err != nil {
+		return err
+	}
+	sort.Strings(samples)
 
-	const groupSize = 16
-	numGroups := (len(files) + groupSize - 1) / groupSize
-	tempFiles := make([]string, numGroups)
+	// Confini degli intervalli: la parte p contiene le chiavi in
+	// [bounds[p-1], bounds[p]). Confini ripetuti darebbero parti vuote.
+	numParts := runtime.NumCPU()
+	var bounds []string
+	for p := 1; p < numParts && len(samples) > 0; p++ {
+		b := samples[p*len(samples)/numParts]
+		if len(bounds) == 0 || b > bounds[len(bounds)-1] {
+			bounds = append(bounds, b)
+		}
+	}
+	numParts = len(bounds) + 1
 
-	var wg sync.WaitGroup
-	errChan := make(chan error, numGroups)
+	// offsets[i][p] è il byte del chunk i da cui inizia la parte p.
+	offsets := make([][]int64, len(files))
+	for i, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		n := st.Size() / recordLen
+		offsets[i] = make([]int64, numParts+1)
+		for p, b := range bounds {
+			if offsets[i][p+1], err = lowerBound(f, n, b); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		offsets[i][numParts] = n * recordLen
+		f.Close()
+	}
 
-	for i := 0; i < numGroups; i++ {
-		start := i * groupSize
-		end := start + groupSize
-		if end > len(files) {
-			end = len(files)
+	tempFiles := make([]string, numParts)
+	var wg sync.WaitGroup
+	// Gli errori delle parti si raccolgono tutti: il primo chiude stop e
+	// ferma le altre, che terminano con errMergeStopped senza aggiungerne.
+	var mu sync.Mutex
+	var errs []error
+	var once sync.Once
+	stop := make(chan struct{})
+	fail := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+		once.Do(func() { close(stop) })
+	}
+	for p := 0; p < numParts; p++ {
+		ranges := make([][2]int64, len(files))
+		for i := range files {
+			ranges[i] = [2]int64{offsets[i][p], offsets[i][p+1]}
 		}
-		group := files[start:end]
-		partName := fmt.Sprintf("part_%02d", i)
-		tempFiles[i] = partName
+		partName := fmt.Sprintf("part_%02d", p)
+		tempFiles[p] = partName
 
 		wg.Add(1)
-		go func(groupFiles []string, output string) {
+		go func(output string) {
 			defer wg.Done()
-			if err := mergeChunks(groupFiles, output); err != nil {
-				errChan <- err
+			if err := mergeRanges(files, ranges, output, stop); err != nil && err != errMergeStopped {
+				fail(fmt.Errorf("%s: %w", output, err))
 			}
-		}(group, partName)
+		}(partName)
 	}
 
 	wg.Wait()
-	close(errChan)
-	if len(errChan) > 0 {
-		return <-errChan
+	if len(errs) > 0 {
+		for _, part := range tempFiles {
+			os.Remove(part)
+		}
+		return errors.Join(errs...)
 	}
 
 	out, err := os.Create(finalOutput)
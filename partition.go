@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// partitioner assegna ogni riga a una partizione in base a una chiave nel
+// formato di --key. I chunk di ciascuna partizione vengono scritti in una
+// sottodirectory propria della directory dei chunk, così lo split resta un
+// unico passaggio sull'input e il merge può fondere ogni partizione a parte.
+type partitioner struct {
+	spec keySpec
+	root string            // directory dei chunk
+	dirs map[string]string // chiave di partizione -> directory dei suoi chunk
+}
+
+// newPartitioner crea un partitioner che scrive le partizioni sotto root.
+func newPartitioner(spec keySpec, root string) *partitioner {
+	return &partitioner{spec: spec, root: root, dirs: make(map[string]string)}
+}
+
+// key restituisce la chiave di partizione di una riga. L'opzione f della
+// chiave unisce le partizioni che differiscono solo per maiuscole/minuscole.
+func (p *partitioner) key(line string) string {
+	a, b := p.spec.keyBounds(line)
+	key := line[a:b]
+	if opts.csv {
+		key = csvUnescape(key)
+	}
+	if p.spec.ignoreCase {
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// dir restituisce la directory dei chunk della partizione, creandola al primo uso.
+// Le directory sono numerate: la chiave può contenere caratteri non validi nei nomi di file.
+func (p *partitioner) dir(key string) (string, error) {
+	if d, ok := p.dirs[key]; ok {
+		return d, nil
+	}
+	d := filepath.Join(p.root, fmt.Sprintf("part_%04d", len(p.dirs)))
+	trackTemp(d)
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return "", err
+	}
+	p.dirs[key] = d
+	return d, nil
+}
+
+// partitionFileName converte una chiave di partizione in un nome di file.
+// La chiave viene codificata come segmento di URL, quindi chiavi diverse
+// producono sempre nomi diversi; "(vuoto)" non può essere prodotto dalla
+// codifica perché le parentesi vengono sempre codificate.
+func partitionFileName(key string) string {
+	switch key {
+	case "":
+		return "(vuoto).txt"
+	case ".", "..":
+		return strings.ReplaceAll(key, ".", "%2E") + ".txt"
+	}
+	return url.PathEscape(key) + ".txt"
+}
+
+// mergePartitions fonde separatamente i chunk di ogni partizione scrivendo
+// un file ordinato per partizione in outDir. Restituisce il numero di partizioni.
+func mergePartitions(chunks []chunkInfo, outDir string) (int, error) {
+	dirs := make(map[string]string)
+	for _, c := range chunks {
+		dirs[c.Partition] = filepath.Dir(c.Path)
+	}
+	keys := make([]string, 0, len(dirs))
+	for k := range dirs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := mergeChunks(dirs[k], filepath.Join(outDir, partitionFileName(k)), chunks); err != nil {
+			return 0, fmt.Errorf("partizione %q: %w", k, err)
+		}
+	}
+	return len(keys), nil
+}
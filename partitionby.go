@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parsePartitionBy interpreta --partition-by: prefix:N divide l'output per i
+// primi N caratteri della chiave, range:K1,K2,... per intervalli delimitati
+// dalle chiavi di confine indicate, in ordine crescente.
+func parsePartitionBy(v string) (prefix int, bounds []string, err error) {
+	mode, arg, _ := strings.Cut(v, ":")
+	switch mode {
+	case "prefix":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return 0, nil, fmt.Errorf("--partition-by %s: serve un numero positivo di caratteri", v)
+		}
+		return n, nil, nil
+	case "range":
+		if arg == "" {
+			return 0, nil, fmt.Errorf("--partition-by %s: servono una o più chiavi di confine", v)
+		}
+		return 0, strings.Split(arg, ","), nil
+	}
+	return 0, nil, fmt.Errorf("--partition-by non valido %q (attesi prefix:N o range:K1,K2,...)", v)
+}
+
+// newRangeStage crea il passo finale di --partition-by. Lo stream è ordinato:
+// con range ogni intervallo è un tratto contiguo dello stream e i file si
+// riempiono uno dopo l'altro; con prefix lo stesso accade quando l'ordine è
+// quello del testo, altrimenti un file già chiuso viene riaperto in append.
+// Va chiamata dopo applyOrderOptions, che stabilisce le chiavi di confronto.
+func newRangeStage(v, dir string) (*partitionStage, error) {
+	prefix, bounds, err := parsePartitionBy(v)
+	if err != nil {
+		return nil, err
+	}
+	s := &partitionStage{
+		dir:     dir,
+		open:    map[string]*partitionFile{},
+		created: map[string]bool{},
+	}
+	if prefix > 0 {
+		s.route = func(line, _ string) string { return linePrefix(line, prefix) }
+		return s, nil
+	}
+	// I confini vengono confrontati con la stessa chiave dei record.
+	keys := make([]string, len(bounds))
+	for i, b := range bounds {
+		keys[i] = sortKey(b)
+		if i > 0 && keys[i] <= keys[i-1] {
+			return nil, fmt.Errorf("--partition-by %s: le chiavi di confine devono essere distinte e crescenti", v)
+		}
+	}
+	// L'intervallo i contiene le chiavi da bounds[i-1] (incluso) a bounds[i]
+	// (escluso): range_0000 quelle prima del primo confine.
+	s.route = func(_, key string) string {
+		i := sort.Search(len(keys), func(i int) bool { return keys[i] > key })
+		return fmt.Sprintf("range_%04d", i)
+	}
+	return s, nil
+}
+
+// linePrefix restituisce i primi n caratteri della chiave di partizione di
+// una riga: il campo della prima chiave di --key, o la riga intera. Con
+// --ignore-case i prefissi che differiscono solo per le maiuscole coincidono.
+func linePrefix(line string, n int) string {
+	if len(opts.keySpecs) > 0 && !opts.csv {
+		a, b := opts.keySpecs[0].keyBounds(line)
+		line = line[a:b]
+	}
+	if opts.ignoreCase {
+		line = strings.ToUpper(line)
+	}
+	i := 0
+	for ; n > 0 && i < len(line); n-- {
+		_, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+	}
+	return line[:i]
+}
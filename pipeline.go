@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pipeStage è un passo della catena --then. Riceve in ordine i record dello
+// stream ordinato, con la loro chiave di confronto, e li passa (filtrati o
+// trasformati) al passo successivo. Tutta la catena gira nel merge: nessun
+// passo rilegge o riscrive file intermedi.
+type pipeStage interface {
+	push(line, key string) error
+	flush() error
+}
+
+// errPipeDone segnala che la catena non accetta altri record: il merge può
+// fermarsi senza leggere il resto dei chunk.
+var errPipeDone = errors.New("catena --then completata")
+
+// maxPartitionFiles è il numero massimo di file di partizione tenuti aperti
+// contemporaneamente dal passo partition.
+const maxPartitionFiles = 64
+
+// newPipeline costruisce la catena descritta da specs, che termina in sink.
+// terminal è true se l'ultimo passo scrive da sé i propri file (partition)
+// e l'output normale resta quindi vuoto.
+func newPipeline(specs []string, sink pipeStage) (first pipeStage, terminal bool, err error) {
+	first = sink
+	// Con --partition-by il merge scrive i file per intervallo invece dell'output.
+	if opts.partitionBy != "" {
+		if first, err = newRangeStage(opts.partitionBy, opts.partitionDir); err != nil {
+			return nil, false, err
+		}
+		terminal = true
+	}
+	for i := len(specs) - 1; i >= 0; i-- {
+		name, arg, hasArg := strings.Cut(specs[i], ":")
+		switch {
+		case name == "unique" && !hasArg:
+			first = &uniqueStage{next: first}
+		case name == "count" && !hasArg:
+			first = &countStage{next: first}
+		case name == "head" && hasArg:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return nil, false, fmt.Errorf("--then %s: serve un numero positivo di record", specs[i])
+			}
+			first = &headStage{next: first, left: n}
+		case name == "partition" && hasArg:
+			if i != len(specs)-1 {
+				return nil, false, fmt.Errorf("--then %s: partition deve essere l'ultimo passo", specs[i])
+			}
+			spec, err := parseKeySpec(arg)
+			if err != nil {
+				return nil, false, fmt.Errorf("--then %s: %w", specs[i], err)
+			}
+			first = newPartitionStage(spec, opts.partitionDir)
+			terminal = true
+		default:
+			return nil, false, fmt.Errorf("--then non valido: %q (attesi unique, count, head:N o partition:KEY)", specs[i])
+		}
+	}
+	return first, terminal, nil
+}
+
+// sinkStage è la fine della catena: consegna i record all'output.
+type sinkStage struct {
+	emit func(line string)
+}
+
+func (s *sinkStage) push(line, key string) error {
+	s.emit(line)
+	return nil
+}
+
+func (s *sinkStage) flush() error { return nil }
+
+// uniqueStage scarta i record con la stessa chiave del precedente.
+type uniqueStage struct {
+	next    pipeStage
+	lastKey string
+	have    bool
+}
+
+func (s *uniqueStage) push(line, key string) error {
+	if s.have && key == s.lastKey {
+		return nil
+	}
+	s.lastKey, s.have = key, true
+	return s.next.push(line, key)
+}
+
+func (s *uniqueStage) flush() error { return s.next.flush() }
+
+// countStage raggruppa i record consecutivi con la stessa chiave e passa il
+// primo di ogni gruppo con il conteggio, nel formato di --count-position.
+type countStage struct {
+	next pipeStage
+	line string
+	key  string
+	n    int64
+}
+
+func (s *countStage) push(line, key string) error {
+	if s.n > 0 && key == s.key {
+		s.n++
+		return nil
+	}
+	if err := s.emit(); err != nil {
+		return err
+	}
+	s.line, s.key, s.n = line, key, 1
+	return nil
+}
+
+func (s *countStage) emit() error {
+	if s.n == 0 {
+		return nil
+	}
+	return s.next.push(countedLine(s.line, s.n), s.key)
+}
+
+func (s *countStage) flush() error {
+	// Se un passo successivo è già completo l'ultimo gruppo viene scartato,
+	// ma la catena va comunque chiusa.
+	if err := s.emit(); err != nil && err != errPipeDone {
+		return err
+	}
+	s.n = 0
+	return s.next.flush()
+}
+
+// headStage passa solo i primi record e poi ferma la catena.
+type headStage struct {
+	next pipeStage
+	left int
+}
+
+func (s *headStage) push(line, key string) error {
+	if s.left == 0 {
+		return errPipeDone
+	}
+	s.left--
+	if err := s.next.push(line, key); err != nil {
+		return err
+	}
+	if s.left == 0 {
+		return errPipeDone
+	}
+	return nil
+}
+
+func (s *headStage) flush() error { return s.next.flush() }
+
+// partitionStage scrive ogni record nel file della sua partizione in dir.
+// Lo stream è ordinato, quindi lo è anche ogni file. Restano aperti al più
+// maxPartitionFiles file: gli altri vengono chiusi e riaperti in append.
+type partitionStage struct {
+	route   func(line, key string) string // partizione di un record
+	dir     string
+	open    map[string]*partitionFile
+	created map[string]bool // partizioni già create (da riaprire in append)
+	tick    int64
+}
+
+// partitionFile è un file di partizione aperto, con l'ultimo uso per l'LRU.
+type partitionFile struct {
+	f    *os.File
+	w    *bufio.Writer
+	used int64
+}
+
+func newPartitionStage(spec keySpec, dir string) *partitionStage {
+	parts := newPartitioner(spec, dir)
+	return &partitionStage{
+		route:   func(line, _ string) string { return parts.key(line) },
+		dir:     dir,
+		open:    map[string]*partitionFile{},
+		created: map[string]bool{},
+	}
+}
+
+func (s *partitionStage) push(line, key string) error {
+	p := s.route(line, key)
+	pf, ok := s.open[p]
+	if !ok {
+		if len(s.open) >= maxPartitionFiles {
+			if err := s.closeLRU(); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return err
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if s.created[p] {
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+		path := filepath.Join(s.dir, partitionFileName(p))
+		f, err := os.OpenFile(path, flags, 0644)
+		if err != nil {
+			return err
+		}
+		pf = &partitionFile{f: f, w: bufio.NewWriter(&spaceWriter{w: f, path: path})}
+		s.open[p] = pf
+		s.created[p] = true
+	}
+	s.tick++
+	pf.used = s.tick
+	pf.w.WriteString(line)
+	_, err := pf.w.WriteString(lineEnd)
+	return err
+}
+
+// closeLRU chiude il file di partizione usato meno di recente.
+func (s *partitionStage) closeLRU() error {
+	var oldest string
+	first := true
+	for p, pf := range s.open {
+		if first || pf.used < s.open[oldest].used {
+			oldest, first = p, false
+		}
+	}
+	return s.closeFile(oldest)
+}
+
+func (s *partitionStage) closeFile(p string) error {
+	pf := s.open[p]
+	delete(s.open, p)
+	err := pf.w.Flush()
+	if cerr := pf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *partitionStage) flush() error {
+	for p := range s.open {
+		if err := s.closeFile(p); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(status, "📝 %d partizioni scritte in %s\n", len(s.created), s.dir)
+	return nil
+}
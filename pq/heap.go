@@ -0,0 +1,46 @@
+package pq
+
+// heapPush aggiunge it allo heap h, ordinato secondo less, risalendo fino
+// alla sua posizione.
+func heapPush(h *[]item, it item, less func(a, b item) bool) {
+	*h = append(*h, it)
+	q := *h
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !less(q[i], q[parent]) {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
+
+// heapPop toglie e restituisce l'elemento più piccolo di h.
+func heapPop(h *[]item, less func(a, b item) bool) item {
+	q := *h
+	top := q[0]
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = item{}
+	*h = q[:n]
+	heapDown(*h, 0, less)
+	return top
+}
+
+// heapDown fa scendere l'elemento i fino alla sua posizione.
+func heapDown(q []item, i int, less func(a, b item) bool) {
+	for {
+		smallest := i
+		if l := 2*i + 1; l < len(q) && less(q[l], q[smallest]) {
+			smallest = l
+		}
+		if r := 2*i + 2; r < len(q) && less(q[r], q[smallest]) {
+			smallest = r
+		}
+		if smallest == i {
+			return
+		}
+		q[i], q[smallest] = q[smallest], q[i]
+		i = smallest
+	}
+}
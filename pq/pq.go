@@ -0,0 +1,255 @@
+// Package pq è una coda di priorità di stringhe su disco, pensata per lo
+// scheduling out-of-core: gli elementi restano in un heap in memoria finché
+// non superano i limiti di Options, poi vengono ordinati e scritti come run
+// su disco. PopMin confronta la testa dell'heap con le teste dei run, quindi
+// Push e PopMin possono alternarsi liberamente anche dopo uno spill.
+//
+// È la coda dietro l'opzione --pq del programma, che la espone su
+// stdin/stdout; le applicazioni Go la usano direttamente:
+//
+//	q, err := pq.New(dir, pq.Options{MaxItems: 100_000})
+//	...
+//	defer q.Close()
+//	q.Push("b")
+//	q.Push("a")
+//	v, ok, err := q.PopMin() // "a"
+package pq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	writerBufSize = 1024 * 1024 // buffer di scrittura di uno spill
+	readerBufSize = 64 * 1024   // buffer di lettura di ciascun run
+)
+
+// Options configura una coda. Il valore zero è una coda in memoria che
+// ordina le stringhe per byte.
+type Options struct {
+	// MaxItems e MaxBytes limitano gli elementi tenuti in memoria: quando
+	// uno dei due viene raggiunto, gli elementi in memoria diventano un
+	// nuovo run su disco. Zero non pone limiti su quella misura.
+	MaxItems int
+	MaxBytes int
+	// Key ricava la chiave di ordinamento di un elemento; è calcolata una
+	// volta per elemento. nil usa l'elemento stesso.
+	Key func(v string) string
+	// Less indica se l'elemento a, con chiave aKey, precede b. nil confronta
+	// le chiavi per byte.
+	Less func(aKey, a, bKey, b string) bool
+	// Stable fa uscire gli elementi con la stessa chiave nell'ordine di
+	// inserimento invece di confrontarli con Less.
+	Stable bool
+}
+
+// item è un elemento in coda con la sua chiave. seq è l'ordine di
+// inserimento per gli elementi in memoria e il numero del run per le teste
+// dei run: i run contengono elementi inseriti prima di quelli successivi.
+type item struct {
+	value string
+	key   string
+	seq   int64
+	run   int // run di provenienza, per le teste dei run
+}
+
+// Queue è una coda di priorità che riversa su disco gli elementi oltre i
+// limiti di memoria. Non è sicura per l'uso da più goroutine.
+type Queue struct {
+	dir      string
+	opts     Options
+	mem      []item // heap degli elementi in memoria
+	memBytes int
+	heads    []item // heap della testa di ciascun run non esaurito
+	runs     []*run // run su disco, per numero
+	size     int64
+	pushed   int64 // numero di Push, ordine di inserimento per Stable
+}
+
+// run è un file di elementi ordinati scritto da uno spill.
+type run struct {
+	path string
+	f    *os.File
+	r    *bufio.Reader
+}
+
+// New crea una coda che scrive i propri run nella directory dir, creandola
+// se non esiste.
+func New(dir string, o Options) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Queue{dir: dir, opts: o}, nil
+}
+
+// Len restituisce il numero di elementi in coda, in memoria e su disco.
+func (q *Queue) Len() int64 { return q.size }
+
+// Push inserisce v; se la memoria è piena gli elementi in memoria vengono
+// riversati su disco come un nuovo run ordinato.
+func (q *Queue) Push(v string) error {
+	heapPush(&q.mem, item{value: v, key: q.key(v), seq: q.pushed}, q.less)
+	q.pushed++
+	q.memBytes += len(v)
+	q.size++
+	if q.opts.MaxItems > 0 && len(q.mem) >= q.opts.MaxItems ||
+		q.opts.MaxBytes > 0 && q.memBytes >= q.opts.MaxBytes {
+		return q.spill()
+	}
+	return nil
+}
+
+// PopMin estrae l'elemento più piccolo; ok è false se la coda è vuota.
+func (q *Queue) PopMin() (v string, ok bool, err error) {
+	if q.size == 0 {
+		return "", false, nil
+	}
+	// I run contengono elementi inseriti prima di quelli in memoria: a
+	// parità, con Stable, vince il run.
+	if len(q.heads) == 0 || len(q.mem) > 0 && q.precedes(q.mem[0], q.heads[0]) {
+		it := heapPop(&q.mem, q.less)
+		q.memBytes -= len(it.value)
+		q.size--
+		return it.value, true, nil
+	}
+
+	it := q.heads[0]
+	r := q.runs[it.run]
+	next, err := r.read()
+	switch {
+	case err == io.EOF:
+		// Run esaurito: il file non serve più.
+		heapPop(&q.heads, q.less)
+		r.close()
+	case err != nil:
+		return "", false, fmt.Errorf("%s: %w", r.path, err)
+	default:
+		// L'elemento successivo dello stesso run prende il posto della testa.
+		q.heads[0] = item{value: next, key: q.key(next), seq: it.seq, run: it.run}
+		heapDown(q.heads, 0, q.less)
+	}
+	q.size--
+	return it.value, true, nil
+}
+
+// Close chiude e rimuove i run ancora presenti su disco. La directory
+// della coda resta.
+func (q *Queue) Close() error {
+	var first error
+	for _, r := range q.runs {
+		if err := r.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	q.runs, q.heads, q.mem = nil, nil, nil
+	q.size, q.memBytes = 0, 0
+	return first
+}
+
+// key restituisce la chiave di ordinamento di v.
+func (q *Queue) key(v string) string {
+	if q.opts.Key == nil {
+		return v
+	}
+	return q.opts.Key(v)
+}
+
+// less confronta due elementi dello stesso heap.
+func (q *Queue) less(a, b item) bool {
+	if q.opts.Stable && a.key == b.key {
+		return a.seq < b.seq
+	}
+	return q.lessValue(a, b)
+}
+
+// precedes indica se l'elemento in memoria m precede la testa di run h.
+func (q *Queue) precedes(m, h item) bool {
+	if q.opts.Stable && m.key == h.key {
+		return false
+	}
+	return q.lessValue(m, h)
+}
+
+func (q *Queue) lessValue(a, b item) bool {
+	if q.opts.Less == nil {
+		return a.key < b.key
+	}
+	return q.opts.Less(a.key, a.value, b.key, b.value)
+}
+
+// spill ordina gli elementi in memoria e li scrive come nuovo run. Ogni
+// elemento è preceduto dalla sua lunghezza (varint), quindi può contenere
+// qualsiasi byte, compresi i separatori di riga.
+func (q *Queue) spill() error {
+	items := q.mem
+	sort.Slice(items, func(i, j int) bool { return q.less(items[i], items[j]) })
+
+	index := len(q.runs)
+	path := filepath.Join(q.dir, fmt.Sprintf("pq_run_%06d.run", index))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	r := &run{path: path, f: f}
+	w := bufio.NewWriterSize(f, writerBufSize)
+	var n [binary.MaxVarintLen64]byte
+	for _, it := range items {
+		w.Write(n[:binary.PutUvarint(n[:], uint64(len(it.value)))])
+		w.WriteString(it.value)
+	}
+	if err := w.Flush(); err != nil {
+		r.close()
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		r.close()
+		return err
+	}
+	r.r = bufio.NewReaderSize(f, readerBufSize)
+	q.runs = append(q.runs, r)
+
+	first, err := r.read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	heapPush(&q.heads, item{value: first, key: q.key(first), seq: int64(index), run: index}, q.less)
+	q.mem = q.mem[:0]
+	q.memBytes = 0
+	return nil
+}
+
+// read restituisce l'elemento successivo del run, o io.EOF.
+func (r *run) read() (string, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// close chiude e rimuove il file del run; le chiamate successive non fanno
+// nulla.
+func (r *run) close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	if rerr := os.Remove(r.path); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package pq
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// popAll estrae tutti gli elementi di q.
+func popAll(t *testing.T, q *Queue) []string {
+	t.Helper()
+	var out []string
+	for {
+		v, ok, err := q.PopMin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+	}
+}
+
+// runFiles restituisce i run presenti in dir.
+func runFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// TestOrderAcrossSpills verifica che PopMin restituisca gli elementi in
+// ordine qualunque sia il numero di run su disco.
+func TestOrderAcrossSpills(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]string, 5000)
+	for i := range values {
+		values[i] = fmt.Sprintf("%08d", rng.Intn(1000))
+	}
+	want := append([]string(nil), values...)
+	sort.Strings(want)
+	tests := []struct {
+		name string
+		opts Options
+		runs int // run attesi dopo tutti i Push
+	}{
+		{"in memoria", Options{}, 0},
+		{"MaxItems", Options{MaxItems: 100}, 50},
+		{"MaxBytes", Options{MaxBytes: 8 * 1000}, 5},
+		{"run piccoli", Options{MaxItems: 25}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, err := New(dir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+			for _, v := range values {
+				if err := q.Push(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := len(runFiles(t, dir)); n != tt.runs {
+				t.Errorf("%d run su disco, attesi %d", n, tt.runs)
+			}
+			if q.Len() != int64(len(values)) {
+				t.Errorf("Len = %d, atteso %d", q.Len(), len(values))
+			}
+			got := popAll(t, q)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("ordine errato: %v...", got[:10])
+			}
+			if n := len(runFiles(t, dir)); n != 0 {
+				t.Errorf("%d run rimasti con la coda vuota", n)
+			}
+		})
+	}
+}
+
+// TestInterleaved alterna Push e PopMin prima e dopo gli spill e confronta
+// ogni estrazione con il minimo di una coda di riferimento.
+func TestInterleaved(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	q, err := New(t.TempDir(), Options{MaxItems: 37})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	var ref []string
+	for i := 0; i < 20000; i++ {
+		if rng.Intn(3) > 0 || len(ref) == 0 {
+			v := fmt.Sprintf("%06d", rng.Intn(100000))
+			if err := q.Push(v); err != nil {
+				t.Fatal(err)
+			}
+			ref = append(ref, v)
+			continue
+		}
+		sort.Strings(ref)
+		v, ok, err := q.PopMin()
+		if err != nil || !ok || v != ref[0] {
+			t.Fatalf("passo %d: PopMin = %q, %v, %v; atteso %q", i, v, ok, err, ref[0])
+		}
+		ref = ref[1:]
+	}
+	if q.Len() != int64(len(ref)) {
+		t.Fatalf("Len = %d, atteso %d", q.Len(), len(ref))
+	}
+}
+
+// TestStable verifica che con Stable gli elementi con la stessa chiave
+// escano nell'ordine di inserimento, anche quando sono divisi tra run
+// diversi e la memoria.
+func TestStable(t *testing.T) {
+	q, err := New(t.TempDir(), Options{
+		MaxItems: 3,
+		Key:      func(v string) string { k, _, _ := strings.Cut(v, ":"); return k },
+		Stable:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	in := []string{"b:1", "a:1", "b:2", "a:2", "b:3", "a:3", "b:4", "a:4"}
+	for _, v := range in {
+		if err := q.Push(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := strings.Join(popAll(t, q), " ")
+	if want := "a:1 a:2 a:3 a:4 b:1 b:2 b:3 b:4"; got != want {
+		t.Fatalf("ottenuto %q, atteso %q", got, want)
+	}
+}
+
+// TestLessAndBinaryValues verifica un ordine personalizzato e che gli
+// elementi su disco possano contenere qualsiasi byte.
+func TestLessAndBinaryValues(t *testing.T) {
+	q, err := New(t.TempDir(), Options{
+		MaxItems: 2,
+		Less:     func(aKey, _, bKey, _ string) bool { return aKey > bKey },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	in := []string{"a\nb", "", "c\r", "\x00z", "b"}
+	for _, v := range in {
+		if err := q.Push(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := popAll(t, q)
+	want := []string{"c\r", "b", "a\nb", "\x00z", ""}
+	if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Fatalf("ottenuto %q, atteso %q", got, want)
+	}
+}
+
+// TestClose verifica che Close rimuova i run ancora su disco.
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+	q, err := New(dir, Options{MaxItems: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"d", "c", "b", "a"} {
+		if err := q.Push(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _, _ := q.PopMin(); v != "a" {
+		t.Fatalf("PopMin = %q, atteso a", v)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names := runFiles(t, dir); len(names) != 0 {
+		t.Fatalf("run rimasti dopo Close: %v", names)
+	}
+	if _, ok, _ := q.PopMin(); ok {
+		t.Fatal("PopMin dopo Close ha restituito un elemento")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/afraccalvieri-ca/SithLords/pq"
+)
+
+// newPriorityQueue crea la coda di --pq nella directory dir, con i limiti
+// di memoria e l'ordine definiti dalle opzioni correnti.
+func newPriorityQueue(dir string) (*pq.Queue, error) {
+	return pq.New(dir, pq.Options{
+		MaxItems: opts.pqItems,
+		MaxBytes: opts.chunkBytes,
+		Key:      sortKey,
+		Less:     lessKeyed,
+		// Come lessSeq: --unique e --count implicano lo spareggio di --stable.
+		Stable: opts.stable || opts.unique || opts.count,
+	})
+}
+
+// runPriorityQueue espone la coda su stdin/stdout con un protocollo a righe,
+// così script e applicazioni esterne possono usarla per scheduling out-of-core:
+//
+//	push <elemento>   inserisce un elemento
+//	pop               scrive l'elemento minimo (riga vuota se la coda è vuota)
+//	len               scrive il numero di elementi in coda
+func runPriorityQueue(dir string, in io.Reader, out io.Writer) error {
+	q, err := newPriorityQueue(dir)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	scanner := chunkScanner(in, len("push ")+1)
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+	for scanner.Scan() {
+		cmd, arg, _ := strings.Cut(scanner.Text(), " ")
+		switch cmd {
+		case "push":
+			if err := q.Push(arg); err != nil {
+				return err
+			}
+		case "pop":
+			v, ok, err := q.PopMin()
+			if err != nil {
+				return err
+			}
+			if ok {
+				v = formatRecord(v)
+			}
+			writer.WriteString(v)
+			writer.WriteByte('\n')
+		case "len":
+			fmt.Fprintln(writer, q.Len())
+		case "":
+		default:
+			return fmt.Errorf("comando --pq non valido: %q (attesi push, pop o len)", cmd)
+		}
+		// Le risposte devono arrivare subito a chi sta pilotando la coda.
+		if cmd != "push" {
+			writer.Flush()
+		}
+	}
+	return scanner.Err()
+}
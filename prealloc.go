@@ -0,0 +1,42 @@
+package main
+
+import "encoding/binary"
+
+// recordOverhead restituisce i byte che writeRecord aggiunge a un record di
+// n byte: lunghezza in testa o separatore.
+func recordOverhead(n int) int {
+	var hdr [binary.MaxVarintLen64]byte
+	switch {
+	case opts.framing != "":
+		return len(appendFrameHeader(hdr[:0], n))
+	case opts.recordSize > 0:
+		return 0
+	case binaryChunks():
+		return len(appendChunkHeader(hdr[:0], n))
+	}
+	return 1
+}
+
+// chunkFileSize restituisce la dimensione del chunk che writeRecord scrive
+// con lines, per preallocarlo.
+func chunkFileSize(lines []string) int64 {
+	var n int64
+	for _, s := range lines {
+		n += int64(len(s) + recordOverhead(len(s)))
+	}
+	return n
+}
+
+// outputSizeHint stima la dimensione dell'output del merge dai chunk dello
+// split: è esatta quando i byte dei chunk sono già l'output (chunksCopyable)
+// e l'output non è compresso, altrimenti 0 (nessuna preallocazione).
+func outputSizeHint(infos map[string]chunkInfo) int64 {
+	if infos == nil || !chunksCopyable() || opts.outCompression != compressNone {
+		return 0
+	}
+	var n int64
+	for _, info := range infos {
+		n += info.Bytes
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize è FALLOC_FL_KEEP_SIZE: i blocchi vengono riservati senza
+// cambiare la dimensione del file, così una stima sbagliata non lascia byte
+// nulli in coda.
+const fallocKeepSize = 0x1
+
+// preallocate riserva size byte su disco per il file dietro w con
+// fallocate(2), se --preallocate è attivo: il filesystem può assegnare
+// extent contigui invece di estendere il file a ogni scrittura, il che
+// riduce la frammentazione quando i worker scrivono molti chunk insieme.
+// È un suggerimento: dove fallocate non è supportato (tmpfs datati, NFS,
+// FUSE) l'errore viene ignorato.
+func preallocate(w io.Writer, size int64) {
+	if !opts.preallocate || size <= 0 {
+		return
+	}
+	var f *os.File
+	switch v := w.(type) {
+	case *os.File:
+		f = v
+	case *directFile:
+		f = v.f
+	default:
+		return
+	}
+	sc, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	sc.Control(func(fd uintptr) {
+		syscall.Fallocate(int(fd), fallocKeepSize, 0, size)
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "io"
+
+// preallocate non fa nulla fuori da Linux: i file crescono con le scritture.
+func preallocate(w io.Writer, size int64) {}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// protoSchemaName è lo schema dei messaggi protobuf delimitati, selezionato
+// da --proto-message.
+const protoSchemaName = "protobuf"
+
+// Tipi dei campi in FieldDescriptorProto (descriptor.proto).
+const (
+	protoDouble   = 1
+	protoFloat    = 2
+	protoInt64    = 3
+	protoUint64   = 4
+	protoInt32    = 5
+	protoFixed64  = 6
+	protoFixed32  = 7
+	protoBool     = 8
+	protoString   = 9
+	protoGroup    = 10
+	protoMessage  = 11
+	protoBytes    = 12
+	protoUint32   = 13
+	protoEnum     = 14
+	protoSfixed32 = 15
+	protoSfixed64 = 16
+	protoSint32   = 17
+	protoSint64   = 18
+)
+
+// protoMessageDesc è un messaggio registrato da un descrittore.
+type protoMessageDesc struct {
+	name   string
+	fields map[string]*protoFieldDesc
+}
+
+// protoFieldDesc è un campo di un messaggio.
+type protoFieldDesc struct {
+	name     string
+	number   int
+	kind     int    // tipo del campo, una delle costanti proto*
+	typeName string // messaggio dei campi di tipo protoMessage, senza il punto iniziale
+}
+
+// protoMessages sono i messaggi registrati, per nome completo (package.Messaggio).
+var protoMessages = map[string]*protoMessageDesc{}
+
+// protoPath è una chiave di --proto-key: il percorso con i campi risolti sul
+// descrittore. fields è parallelo a steps ed è nil per i passi con indice.
+type protoPath struct {
+	jsonPath
+	fields []*protoFieldDesc
+}
+
+// protoPaths sono le chiavi di --proto-key, risolte da configureProto.
+var protoPaths []protoPath
+
+// protoValue è un campo letto dal formato wire: il numero, il tipo wire e il
+// valore (u per varint e fixed, data per i campi delimitati).
+type protoValue struct {
+	num  int
+	wire int
+	u    uint64
+	data []byte
+}
+
+// protoNext legge il campo all'inizio di b. ok è false se b è malformato o
+// usa i gruppi, non supportati.
+func protoNext(b []byte) (v protoValue, rest []byte, ok bool) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return v, nil, false
+	}
+	b = b[n:]
+	v.num, v.wire = int(tag>>3), int(tag&7)
+	switch v.wire {
+	case 0:
+		if v.u, n = binary.Uvarint(b); n <= 0 {
+			return v, nil, false
+		}
+		b = b[n:]
+	case 1:
+		if len(b) < 8 {
+			return v, nil, false
+		}
+		v.u, b = binary.LittleEndian.Uint64(b), b[8:]
+	case 5:
+		if len(b) < 4 {
+			return v, nil, false
+		}
+		v.u, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	case 2:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || l > uint64(len(b)-n) {
+			return v, nil, false
+		}
+		v.data, b = b[n:n+int(l)], b[n+int(l):]
+	default:
+		return v, nil, false
+	}
+	return v, b, true
+}
+
+// protoFields restituisce i campi di un messaggio serializzato.
+func protoFields(b []byte) ([]protoValue, error) {
+	var fields []protoValue
+	for len(b) > 0 {
+		v, rest, ok := protoNext(b)
+		if !ok {
+			return nil, fmt.Errorf("messaggio protobuf malformato")
+		}
+		fields, b = append(fields, v), rest
+	}
+	return fields, nil
+}
+
+// addProtoDescriptor registra i messaggi di un FileDescriptorSet.
+func addProtoDescriptor(set []byte) error {
+	files, err := protoFields(set)
+	if err != nil {
+		return fmt.Errorf("descrittore non valido: %w", err)
+	}
+	for _, f := range files {
+		if f.num != 1 || f.wire != 2 {
+			continue
+		}
+		// FileDescriptorProto: package (2) e message_type (4).
+		fields, err := protoFields(f.data)
+		if err != nil {
+			return fmt.Errorf("descrittore non valido: %w", err)
+		}
+		pkg := ""
+		for _, ff := range fields {
+			if ff.num == 2 && ff.wire == 2 {
+				pkg = string(ff.data)
+			}
+		}
+		for _, ff := range fields {
+			if ff.num == 4 && ff.wire == 2 {
+				if err := addProtoMessage(pkg, ff.data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addProtoMessage registra un DescriptorProto, con i messaggi annidati, nel
+// namespace prefix.
+func addProtoMessage(prefix string, desc []byte) error {
+	fields, err := protoFields(desc)
+	if err != nil {
+		return fmt.Errorf("descrittore non valido: %w", err)
+	}
+	m := &protoMessageDesc{fields: map[string]*protoFieldDesc{}}
+	for _, f := range fields {
+		if f.num == 1 && f.wire == 2 {
+			m.name = string(f.data)
+		}
+	}
+	if prefix != "" {
+		m.name = prefix + "." + m.name
+	}
+	for _, f := range fields {
+		switch {
+		case f.num == 2 && f.wire == 2:
+			// FieldDescriptorProto: name (1), number (3), type (5), type_name (6).
+			fd, err := protoFields(f.data)
+			if err != nil {
+				return fmt.Errorf("descrittore non valido: %w", err)
+			}
+			field := &protoFieldDesc{}
+			for _, x := range fd {
+				switch x.num {
+				case 1:
+					field.name = string(x.data)
+				case 3:
+					field.number = int(x.u)
+				case 5:
+					field.kind = int(x.u)
+				case 6:
+					field.typeName = strings.TrimPrefix(string(x.data), ".")
+				}
+			}
+			m.fields[field.name] = field
+		case f.num == 3 && f.wire == 2:
+			if err := addProtoMessage(m.name, f.data); err != nil {
+				return err
+			}
+		}
+	}
+	protoMessages[m.name] = m
+	return nil
+}
+
+// configureProto carica i descrittori di --proto-descriptor e risolve le
+// chiavi di --proto-key sul messaggio di --proto-message. Va chiamata da
+// applyOrderOptions dopo la lettura di --key.
+func configureProto() error {
+	protoPaths = nil
+	if opts.protoMessage == "" {
+		if len(opts.protoKeys) > 0 || len(opts.protoFiles) > 0 {
+			return fmt.Errorf("--proto-key e --proto-descriptor richiedono --proto-message")
+		}
+		return nil
+	}
+	for i, set := range schema.ProtoDescriptors() {
+		if err := addProtoDescriptor(set); err != nil {
+			return fmt.Errorf("descrittore %d di schema.RegisterProtoDescriptor: %w", i+1, err)
+		}
+	}
+	for _, path := range opts.protoFiles {
+		set, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := addProtoDescriptor(set); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	root, ok := protoMessages[strings.TrimPrefix(opts.protoMessage, ".")]
+	if !ok {
+		return fmt.Errorf("--proto-message: messaggio %q non registrato (usa --proto-descriptor o schema.RegisterProtoDescriptor)", opts.protoMessage)
+	}
+	if len(opts.protoKeys) > 0 && (len(opts.keySpecs) > 0 || opts.keyLen > 0) {
+		return fmt.Errorf("--proto-key non è combinabile con --key e --key-bytes")
+	}
+	for _, v := range opts.protoKeys {
+		jp, err := parseJSONKey("--proto-key", v)
+		if err != nil {
+			return err
+		}
+		p := protoPath{jsonPath: jp}
+		cur := root
+		for i, st := range jp.steps {
+			if st.index >= 0 {
+				if i == 0 {
+					return fmt.Errorf("--proto-key %s: l'indice deve seguire un campo ripetuto", v)
+				}
+				p.fields = append(p.fields, nil)
+				continue
+			}
+			if cur == nil {
+				return fmt.Errorf("--proto-key %s: %q non è un campo di un messaggio", v, st.name)
+			}
+			f, ok := cur.fields[st.name]
+			if !ok {
+				return fmt.Errorf("--proto-key %s: campo %q assente nel messaggio %s", v, st.name, cur.name)
+			}
+			p.fields = append(p.fields, f)
+			cur = nil
+			if f.kind == protoMessage {
+				cur = protoMessages[f.typeName]
+			}
+		}
+		protoPaths = append(protoPaths, p)
+	}
+	return nil
+}
+
+// protoKey costruisce la chiave composta di un messaggio a partire da
+// --proto-key, con le regole di confronto di --json-key. Senza chiavi il
+// messaggio si confronta byte per byte.
+func protoKey(record string) string {
+	if len(protoPaths) == 0 {
+		return record
+	}
+	msg := []byte(record)
+	buf := make([]byte, 0, 64)
+	for i := range protoPaths {
+		p := &protoPaths[i]
+		raw, ok := protoLookup(msg, p)
+		buf = appendKeyPart(buf, jsonValueKey(raw, ok, &p.jsonPath), p.desc)
+	}
+	return string(buf)
+}
+
+// protoLookup restituisce il valore nel percorso p, come valore JSON. Un
+// campo scalare singolo assente vale lo zero del suo tipo, come in proto3;
+// un messaggio o un elemento ripetuto assente rende ok false.
+func protoLookup(msg []byte, p *protoPath) (raw json.RawMessage, ok bool) {
+	steps := p.steps
+	for i := 0; i < len(steps); i++ {
+		f := p.fields[i]
+		idx := -1
+		if i+1 < len(steps) && steps[i+1].index >= 0 {
+			i++
+			idx = steps[i].index
+		}
+		v, found := protoFind(msg, f, idx)
+		last := i == len(steps)-1
+		if !found {
+			if last && idx < 0 {
+				return protoZero(f), true
+			}
+			return nil, false
+		}
+		if last {
+			return protoJSON(f, v)
+		}
+		if f.kind != protoMessage || v.wire != 2 {
+			return nil, false
+		}
+		msg = v.data
+	}
+	return nil, false
+}
+
+// protoFind cerca il campo f in msg: con idx negativo l'ultima occorrenza
+// (come per i campi singoli), altrimenti l'elemento idx del campo ripetuto,
+// anche in codifica packed.
+func protoFind(msg []byte, f *protoFieldDesc, idx int) (v protoValue, found bool) {
+	n := 0
+	for len(msg) > 0 {
+		x, rest, ok := protoNext(msg)
+		if !ok {
+			return v, found
+		}
+		msg = rest
+		if x.num != f.number {
+			continue
+		}
+		elems := []protoValue{x}
+		if x.wire == 2 && protoWire(f.kind) != 2 {
+			elems = protoUnpack(x.data, f.kind)
+		}
+		for _, e := range elems {
+			if idx < 0 {
+				v, found = e, true
+				continue
+			}
+			if n == idx {
+				return e, true
+			}
+			n++
+		}
+	}
+	return v, found
+}
+
+// protoWire restituisce il tipo wire dei campi di tipo kind.
+func protoWire(kind int) int {
+	switch kind {
+	case protoDouble, protoFixed64, protoSfixed64:
+		return 1
+	case protoFloat, protoFixed32, protoSfixed32:
+		return 5
+	case protoString, protoBytes, protoMessage, protoGroup:
+		return 2
+	}
+	return 0
+}
+
+// protoUnpack divide un campo numerico ripetuto in codifica packed.
+func protoUnpack(b []byte, kind int) []protoValue {
+	w := protoWire(kind)
+	var elems []protoValue
+	for len(b) > 0 {
+		e := protoValue{wire: w}
+		switch w {
+		case 1:
+			if len(b) < 8 {
+				return elems
+			}
+			e.u, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 5:
+			if len(b) < 4 {
+				return elems
+			}
+			e.u, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			u, n := binary.Uvarint(b)
+			if n <= 0 {
+				return elems
+			}
+			e.u, b = u, b[n:]
+		}
+		elems = append(elems, e)
+	}
+	return elems
+}
+
+// protoZero restituisce il valore predefinito di un campo scalare assente.
+func protoZero(f *protoFieldDesc) json.RawMessage {
+	switch f.kind {
+	case protoString, protoBytes:
+		return json.RawMessage(`""`)
+	case protoBool:
+		return json.RawMessage("false")
+	case protoMessage, protoGroup:
+		return nil
+	}
+	return json.RawMessage("0")
+}
+
+// protoJSON converte il valore v del campo f in un valore JSON. Gli enum
+// valgono il loro numero; un tipo wire diverso da quello del campo rende ok false.
+func protoJSON(f *protoFieldDesc, v protoValue) (json.RawMessage, bool) {
+	if v.wire != protoWire(f.kind) {
+		return nil, false
+	}
+	var buf []byte
+	switch f.kind {
+	case protoDouble:
+		buf = appendJSONFloat(buf, math.Float64frombits(v.u), 64)
+	case protoFloat:
+		buf = appendJSONFloat(buf, float64(math.Float32frombits(uint32(v.u))), 32)
+	case protoInt64, protoSfixed64:
+		buf = strconv.AppendInt(buf, int64(v.u), 10)
+	case protoInt32, protoEnum:
+		buf = strconv.AppendInt(buf, int64(int32(v.u)), 10)
+	case protoSfixed32:
+		buf = strconv.AppendInt(buf, int64(int32(uint32(v.u))), 10)
+	case protoSint32, protoSint64:
+		buf = strconv.AppendInt(buf, int64(v.u>>1)^-int64(v.u&1), 10)
+	case protoBool:
+		buf = strconv.AppendBool(buf, v.u != 0)
+	case protoString:
+		buf = appendJSONString(buf, v.data, false)
+	case protoBytes, protoMessage:
+		buf = appendJSONString(buf, v.data, true)
+	default:
+		buf = strconv.AppendUint(buf, v.u, 10)
+	}
+	return buf, true
+}
+
+func init() {
+	// Messaggi protobuf delimitati (--record-framing), con la chiave
+	// decodificata secondo il descrittore: vedi --proto-message.
+	schema.Register(schema.Schema{Name: protoSchemaName, Parse: framedRecord, Key: protoKey})
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// pbVarint aggiunge a b un campo varint con numero num.
+func pbVarint(b []byte, num int, u uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3)
+	return binary.AppendUvarint(b, u)
+}
+
+// pbBytes aggiunge a b un campo delimitato con numero num.
+func pbBytes(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// pbField codifica un FieldDescriptorProto.
+func pbField(name string, number, kind int, typeName string) []byte {
+	f := pbBytes(nil, 1, []byte(name))
+	f = pbVarint(f, 3, uint64(number))
+	f = pbVarint(f, 5, uint64(kind))
+	if typeName != "" {
+		f = pbBytes(f, 6, []byte(typeName))
+	}
+	return f
+}
+
+// testProtoDescriptor è il FileDescriptorSet dei test: il messaggio
+// shop.Evento con un int64, una stringa, un int32 ripetuto, un messaggio
+// annidato e un sint64.
+func testProtoDescriptor() []byte {
+	cliente := pbBytes(nil, 1, []byte("Cliente"))
+	cliente = pbBytes(cliente, 2, pbField("id", 1, protoInt64, ""))
+	evento := pbBytes(nil, 1, []byte("Evento"))
+	evento = pbBytes(evento, 2, pbField("id", 1, protoInt64, ""))
+	evento = pbBytes(evento, 2, pbField("nome", 2, protoString, ""))
+	evento = pbBytes(evento, 2, pbField("codici", 3, protoInt32, ""))
+	evento = pbBytes(evento, 2, pbField("cliente", 4, protoMessage, ".shop.Evento.Cliente"))
+	evento = pbBytes(evento, 2, pbField("delta", 5, protoSint64, ""))
+	evento = pbBytes(evento, 3, cliente)
+	file := pbBytes(nil, 2, []byte("shop"))
+	file = pbBytes(file, 4, evento)
+	return pbBytes(nil, 1, file)
+}
+
+// writeProtoDescriptor scrive testProtoDescriptor in dir e ne restituisce il percorso.
+func writeProtoDescriptor(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "eventi.desc")
+	if err := os.WriteFile(path, testProtoDescriptor(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// pbEvent codifica uno shop.Evento con id e nome.
+func pbEvent(id int64, nome string) []byte {
+	return pbBytes(pbVarint(nil, 1, uint64(id)), 2, []byte(nome))
+}
+
+// pbEventSized codifica uno shop.Evento di esattamente size byte,
+// allungando il nome.
+func pbEventSized(t *testing.T, id int64, size int) []byte {
+	t.Helper()
+	for n := size; n >= 0; n-- {
+		if msg := pbEvent(id, strings.Repeat("x", n)); len(msg) == size {
+			return msg
+		}
+	}
+	t.Fatalf("nessun evento di %d byte", size)
+	return nil
+}
+
+// frameVarint delimita i messaggi con la loro lunghezza in varint.
+func frameVarint(msgs [][]byte) []byte {
+	var out []byte
+	for _, m := range msgs {
+		out = binary.AppendUvarint(out, uint64(len(m)))
+		out = append(out, m...)
+	}
+	return out
+}
+
+// readVarintFrames legge i messaggi delimitati dal file path.
+func readVarintFrames(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs [][]byte
+	for len(data) > 0 {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || uint64(len(data)-k) < n {
+			t.Fatalf("%s: record malformato dopo %d messaggi", path, len(msgs))
+		}
+		msgs = append(msgs, data[k:k+int(n)])
+		data = data[k+int(n):]
+	}
+	return msgs
+}
+
+// equalFrames confronta i messaggi ottenuti con quelli attesi.
+func equalFrames(t *testing.T, what string, got, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d messaggi, attesi %d", what, len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("%s: messaggio %d = %x, atteso %x", what, i, got[i], want[i])
+		}
+	}
+}
+
+// TestProtoFraming verifica i messaggi le cui lunghezze sono ai limiti della
+// codifica varint: vuoto, 127 byte (un byte di lunghezza), 128 byte (due
+// byte), sia in memoria sia attraverso i chunk.
+func TestProtoFraming(t *testing.T) {
+	sized := map[int64]int{0: 0, 1: 127, 2: 128, 3: 300}
+	var msgs, want [][]byte
+	for id := int64(0); id < 4; id++ {
+		var msg []byte
+		if sized[id] > 0 {
+			msg = pbEventSized(t, id, sized[id])
+		}
+		if len(msg) != sized[id] {
+			t.Fatalf("evento %d di %d byte, attesi %d", id, len(msg), sized[id])
+		}
+		want = append(want, msg)
+	}
+	for id := int64(4); id < 1000; id++ {
+		want = append(want, pbEvent(id, fmt.Sprintf("evento %d", id)))
+	}
+	// I messaggi ai limiti sparsi nell'input, il resto in ordine inverso.
+	for i := len(want) - 1; i >= 4; i-- {
+		msgs = append(msgs, want[i])
+		if i%200 == 0 {
+			msgs = append(msgs, want[i/200-1])
+		}
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"in memoria", nil},
+		{"chunk", []string{"--chunk-bytes", "2000"}},
+		{"chunk senza in memoria", []string{"--chunk-bytes", "1000000", "--in-memory=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeProtoDescriptor(t, dir)
+			if err := os.WriteFile(filepath.Join(dir, "eventi.pb"), frameVarint(msgs), 0644); err != nil {
+				t.Fatal(err)
+			}
+			args := append([]string{"--proto-descriptor", "eventi.desc", "--proto-message", "shop.Evento", "--proto-key", "id",
+				"--input", "eventi.pb", "--output", "out.pb"}, tt.args...)
+			mustRunSorter(t, dir, args...)
+			equalFrames(t, tt.name, readVarintFrames(t, filepath.Join(dir, "out.pb")), want)
+		})
+	}
+}
+
+// TestProtoFramingCorrupt verifica che una lunghezza troncata o non valida
+// faccia fallire l'esecuzione indicando l'offset, invece di disallineare i
+// messaggi successivi.
+func TestProtoFramingCorrupt(t *testing.T) {
+	valid := frameVarint([][]byte{pbEvent(2, "due"), pbEventSized(t, 1, 200)})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"prefisso troncato", append(append([]byte(nil), valid...), 0x80), fmt.Sprintf("lunghezza del record all'offset %d non valida", len(valid))},
+		{"prefisso troncato a due byte", append(append([]byte(nil), valid...), 0xc8), fmt.Sprintf("lunghezza del record all'offset %d non valida", len(valid))},
+		{"varint oltre 64 bit", append(append([]byte(nil), valid...), bytes.Repeat([]byte{0xff}, 11)...), "non valida"},
+		{"contenuto troncato", append(binary.AppendUvarint(append([]byte(nil), valid...), 10), "abc"...), "record incompleto alla fine dell'input: 3 byte su 10"},
+		{"lunghezza oltre il limite", binary.AppendUvarint(append([]byte(nil), valid...), 1<<31), "lunghezza oltre il limite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeProtoDescriptor(t, dir)
+			if err := os.WriteFile(filepath.Join(dir, "eventi.pb"), tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			out, err := runSorter(t, dir, "--proto-descriptor", "eventi.desc", "--proto-message", "shop.Evento", "--proto-key", "id",
+				"--input", "eventi.pb", "--output", "out.pb")
+			if err == nil {
+				t.Fatalf("nessun errore\n%s", out)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("l'errore non contiene %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+// setProtoKeys configura --proto-message shop.Evento con le chiavi indicate,
+// ripristinando le opzioni alla fine del test.
+func setProtoKeys(t *testing.T, keys ...string) {
+	t.Helper()
+	savedMessage, savedFiles, savedKeys, savedPaths := opts.protoMessage, opts.protoFiles, opts.protoKeys, protoPaths
+	t.Cleanup(func() {
+		opts.protoMessage, opts.protoFiles, opts.protoKeys, protoPaths = savedMessage, savedFiles, savedKeys, savedPaths
+	})
+	opts.protoMessage, opts.protoKeys = "shop.Evento", keys
+	opts.protoFiles = stringList{writeProtoDescriptor(t, t.TempDir())}
+	if err := configureProto(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProtoLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		key  string
+		want string // "" se il valore è assente
+	}{
+		{"scalare", pbVarint(nil, 1, 7), "id", "7"},
+		{"int64 negativo", pbVarint(nil, 1, uint64(1<<64-5)), "id", "-5"},
+		{"sint64 negativo", pbVarint(nil, 5, 5), "delta", "-3"},
+		{"scalare assente", pbBytes(nil, 2, []byte("x")), "id", "0"},
+		{"stringa assente", pbVarint(nil, 1, 7), "nome", `""`},
+		{"stringa", pbBytes(nil, 2, []byte("Rossi")), "nome", `"Rossi"`},
+		{"campo ripetuto: vince l'ultimo", pbVarint(pbVarint(nil, 1, 1), 1, 9), "id", "9"},
+		{"campi sconosciuti ignorati", pbVarint(pbBytes(pbVarint(nil, 9, 1), 10, []byte("z")), 1, 4), "id", "4"},
+		{"messaggio assente", pbVarint(nil, 1, 1), "cliente.id", ""},
+		{"messaggio vuoto", pbBytes(nil, 4, nil), "cliente.id", "0"},
+		{"messaggio annidato", pbBytes(nil, 4, pbVarint(nil, 1, 42)), "cliente.id", "42"},
+		{"indice", pbVarint(pbVarint(pbVarint(nil, 3, 4), 3, 5), 3, 6), "codici[1]", "5"},
+		{"indice packed", pbBytes(nil, 3, []byte{4, 5, 6}), "codici[2]", "6"},
+		{"packed e non packed", pbBytes(pbVarint(nil, 3, 4), 3, []byte{5, 6}), "codici[2]", "6"},
+		{"indice oltre la fine", pbBytes(nil, 3, []byte{4, 5, 6}), "codici[3]", ""},
+		{"ripetuto senza indice: l'ultimo", pbBytes(nil, 3, []byte{4, 5, 6}), "codici", "6"},
+		{"int32 negativo", pbVarint(nil, 3, uint64(1<<64-1)), "codici[0]", "-1"},
+		{"tipo wire errato", binary.LittleEndian.AppendUint32([]byte{1<<3 | 5}, 7), "id", ""},
+		{"messaggio malformato", append(pbVarint(nil, 1, 3), 0x12, 0x05, 'a'), "id", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setProtoKeys(t, tt.key)
+			raw, ok := protoLookup(tt.msg, &protoPaths[0])
+			if got := string(raw); ok != (tt.want != "") || got != tt.want {
+				t.Errorf("%s = %s (ok %v), atteso %q", tt.key, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+// TestProtoKeyErrors verifica gli errori di --proto-key sul descrittore.
+func TestProtoKeyErrors(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"prezzo", `campo "prezzo" assente nel messaggio shop.Evento`},
+		{"cliente.nome", `campo "nome" assente nel messaggio shop.Evento.Cliente`},
+		{"id.x", `"x" non è un campo di un messaggio`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			dir := t.TempDir()
+			writeProtoDescriptor(t, dir)
+			writeLines(t, dir, "eventi.pb", nil)
+			out, err := runSorter(t, dir, "--proto-descriptor", "eventi.desc", "--proto-message", "shop.Evento", "--proto-key", tt.key,
+				"--input", "eventi.pb", "--output", "out.pb")
+			if err == nil || !strings.Contains(out, tt.want) {
+				t.Errorf("errore %v, l'output non contiene %q:\n%s", err, tt.want, out)
+			}
+		})
+	}
+}
+
+// TestProtoSortOrder verifica l'ordine dei messaggi per i campi scelti con
+// --proto-key: numeri negativi, stringhe in ordine inverso e campi di
+// messaggi annidati, assenti in parte dei record.
+func TestProtoSortOrder(t *testing.T) {
+	type evento struct {
+		id      int64
+		nome    string
+		cliente int64 // -1 se il messaggio è assente
+		msg     []byte
+	}
+	const n = 300
+	events := make([]evento, n)
+	for i := range events {
+		e := evento{id: int64((i*73)%n) - n/2, nome: fmt.Sprintf("n%03d", (i*31)%n), cliente: -1}
+		e.msg = pbEvent(e.id, e.nome)
+		if i%3 != 0 {
+			e.cliente = int64(i % 7)
+			e.msg = pbBytes(e.msg, 4, pbVarint(nil, 1, uint64(e.cliente)))
+		}
+		events[i] = e
+	}
+	var input [][]byte
+	for _, e := range events {
+		input = append(input, e.msg)
+	}
+	tests := []struct {
+		name string
+		keys []string
+		less func(a, b evento) bool
+	}{
+		{"int64 con negativi", []string{"id"}, func(a, b evento) bool { return a.id < b.id }},
+		{"stringa decrescente", []string{"nome:desc"}, func(a, b evento) bool { return a.nome > b.nome }},
+		{"campo annidato", []string{"cliente.id", "id:desc"}, func(a, b evento) bool {
+			if a.cliente != b.cliente {
+				return a.cliente < b.cliente
+			}
+			return a.id > b.id
+		}},
+	}
+	for _, tt := range tests {
+		sorted := append([]evento(nil), events...)
+		sort.Slice(sorted, func(i, j int) bool { return tt.less(sorted[i], sorted[j]) })
+		var want [][]byte
+		for _, e := range sorted {
+			want = append(want, e.msg)
+		}
+		for _, chunked := range []bool{false, true} {
+			name := tt.name
+			if chunked {
+				name += " con chunk"
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				writeProtoDescriptor(t, dir)
+				if err := os.WriteFile(filepath.Join(dir, "eventi.pb"), frameVarint(input), 0644); err != nil {
+					t.Fatal(err)
+				}
+				args := []string{"--proto-descriptor", "eventi.desc", "--proto-message", "shop.Evento", "--input", "eventi.pb", "--output", "out.pb"}
+				for _, k := range tt.keys {
+					args = append(args, "--proto-key", k)
+				}
+				if chunked {
+					args = append(args, "--chunk-bytes", "1500")
+				}
+				mustRunSorter(t, dir, args...)
+				equalFrames(t, name, readVarintFrames(t, filepath.Join(dir, "out.pb")), want)
+			})
+		}
+	}
+}
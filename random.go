@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+)
+
+// randomOffset è lo stato iniziale dell'hash dopo aver assorbito il seme
+// di --random-seed, calcolato una volta da initRandom.
+var randomOffset uint64
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// initRandom prepara l'hash di --random. Senza un seme esplicito ne genera
+// uno casuale e lo registra come valore del flag, così finisce nel manifest
+// dei run set e nel registro delle sessioni e l'esecuzione resta ripetibile.
+func initRandom() error {
+	if opts.randomSeed == "" {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		if err := flag.Set("random-seed", hex.EncodeToString(b[:])); err != nil {
+			return err
+		}
+	}
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(opts.randomSeed); i++ {
+		h = (h ^ uint64(opts.randomSeed[i])) * fnvPrime64
+	}
+	randomOffset = h
+	return nil
+}
+
+// randomKey restituisce la chiave di s per --random: 8 byte di hash seguiti
+// da s. L'ordine dipende solo dall'hash, ma righe uguali restano vicine e a
+// parità di hash decide il testo, quindi l'ordine è deterministico a parità
+// di seme. L'hash è FNV-1a, reso uniforme dal finalizzatore di splitmix64.
+func randomKey(s string) string {
+	h := randomOffset
+	for i := 0; i < len(s); i++ {
+		h = (h ^ uint64(s[i])) * fnvPrime64
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	buf := make([]byte, 8, 8+len(s))
+	for i := 0; i < 8; i++ {
+		buf[i] = byte(h >> (56 - 8*i))
+	}
+	return string(append(buf, s...))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Politiche applicabili ai record che superano --max-record-bytes.
+const (
+	oversizeTruncate = "truncate" // tronca il record al limite e scarta il resto
+	oversizeReject   = "reject"   // interrompe l'esecuzione con un errore
+	oversizeDivert   = "divert"   // sposta il record intero nel file laterale
+)
+
+// recordReader legge i record (righe terminate da recordSep) dal file di input
+// senza mai tenere in RAM più di maxBytes byte per record.
+// Un record patologico (es. una riga da 2 GB) viene gestito in streaming
+// secondo la politica configurata invece di essere caricato intero in memoria.
+type recordReader struct {
+	r        *bufio.Reader
+	maxBytes int           // limite per record, 0 = nessun limite
+	policy   string        // politica per i record troppo grandi
+	divert   *bufio.Writer // destinazione dei record deviati (solo policy divert)
+	buf      []byte        // buffer riutilizzato per il record corrente
+	offset   int64         // offset in byte dell'inizio del record corrente
+	oversize int64         // numero di record che hanno superato il limite
+	csvBuf   []byte        // record CSV su più righe in corso di lettura
+	invalid  int64         // record con UTF-8 non valido (--validate-utf8)
+	rejects  *bufio.Writer // destinazione dei record scartati (--rejects-file)
+	rejected int64         // record scritti in rejects
+}
+
+// newRecordReader crea un recordReader sopra r con il limite e la politica indicati.
+// divert può essere nil se la politica non è oversizeDivert.
+func newRecordReader(r *bufio.Reader, maxBytes int, policy string, divert *bufio.Writer) *recordReader {
+	return &recordReader{r: r, maxBytes: maxBytes, policy: policy, divert: divert}
+}
+
+// next restituisce il prossimo record, incluso il separatore finale se presente,
+// con il terminatore uniformato secondo --line-endings. Come
+// bufio.Reader.ReadBytes restituisce io.EOF insieme all'ultimo record non
+// terminato. Il slice restituito è valido solo fino alla chiamata successiva.
+// I record deviati non vengono restituiti: next passa direttamente al successivo.
+// Con --csv un record con newline nei campi tra virgolette comprende più righe;
+// con --record-size e --record-framing i record sono blocchi binari.
+// --validate-utf8 scarta, corregge o rifiuta i record non validi.
+func (rr *recordReader) next() ([]byte, error) {
+	if opts.recordSize > 0 {
+		return rr.readFixed()
+	}
+	if opts.framing != "" {
+		return rr.readFramed()
+	}
+	for {
+		start := rr.offset
+		line, err := rr.read()
+		line = normalizeEOL(line)
+		if opts.csv {
+			line, err = rr.joinQuoted(line, err)
+		}
+		if err != nil && err != io.EOF {
+			return line, err
+		}
+		line, skip, verr := rr.checkUTF8(line, start)
+		if verr != nil {
+			return nil, verr
+		}
+		if skip && err == nil {
+			continue
+		}
+		return line, err
+	}
+}
+
+// read legge il prossimo record applicando --max-record-bytes.
+func (rr *recordReader) read() ([]byte, error) {
+	if rr.maxBytes <= 0 {
+		// Il record resta nel buffer del bufio.Reader quando ci sta: nessuna
+		// allocazione per riga, lo copia chi lo conserva (Parse, lineArena).
+		line, err := rr.r.ReadSlice(recordSep)
+		if err == bufio.ErrBufferFull {
+			rr.buf = append(rr.buf[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = rr.r.ReadSlice(recordSep)
+				rr.buf = append(rr.buf, line...)
+			}
+			line = rr.buf
+		}
+		rr.offset += int64(len(line))
+		return line, err
+	}
+
+	for {
+		start := rr.offset
+		rr.buf = rr.buf[:0]
+		oversized := false
+		for {
+			frag, err := rr.r.ReadSlice(recordSep)
+			rr.offset += int64(len(frag))
+
+			// Il separatore finale non conta nel limite.
+			n := len(rr.buf) + len(frag)
+			if err == nil {
+				n--
+			}
+			if !oversized && n > rr.maxBytes {
+				oversized = true
+				rr.oversize++
+				switch rr.policy {
+				case oversizeReject:
+					return nil, fmt.Errorf("record all'offset %d supera il limite di %d byte", start, rr.maxBytes)
+				case oversizeDivert:
+					if _, werr := rr.divert.Write(rr.buf); werr != nil {
+						return nil, werr
+					}
+				}
+			}
+
+			switch {
+			case !oversized:
+				rr.buf = append(rr.buf, frag...)
+			case rr.policy == oversizeDivert:
+				if _, werr := rr.divert.Write(frag); werr != nil {
+					return nil, werr
+				}
+			case len(rr.buf) < rr.maxBytes:
+				rr.buf = append(rr.buf, frag[:rr.maxBytes-len(rr.buf)]...)
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if !oversized || rr.policy != oversizeDivert {
+				return rr.buf, err
+			}
+
+			// Record deviato: garantisce il newline finale nel file laterale
+			// e passa al record successivo.
+			if err != nil {
+				if err == io.EOF {
+					err = rr.divert.WriteByte(recordSep)
+				}
+				if err == nil {
+					err = io.EOF
+				}
+				return nil, err
+			}
+			break
+		}
+	}
+}
+
+// reject scrive nel file di --rejects-file, se richiesto, il record scartato
+// line così come è stato letto, con il separatore finale.
+func (rr *recordReader) reject(line []byte) error {
+	if rr.rejects == nil || len(line) == 0 {
+		return nil
+	}
+	rr.rejected++
+	if _, err := rr.rejects.Write(line); err != nil {
+		return err
+	}
+	if line[len(line)-1] != recordSep {
+		return rr.rejects.WriteByte(recordSep)
+	}
+	return nil
+}
+
+// openDivertFile apre il file laterale per i record deviati o scartati.
+func openDivertFile(path string) (*os.File, *bufio.Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, bufio.NewWriterSize(f, writerBufferSize), nil
+}
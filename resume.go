@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// resumeName è il manifest di --resume nella directory dei chunk.
+const resumeName = "resume.json"
+
+// resumeFreeFlags sono i flag che non cambiano il contenuto dei chunk: una
+// ripresa può usarli diversi dall'esecuzione che ha fatto lo split. Gli
+// input sono confrontati a parte, con dimensione e data di modifica.
+var resumeFreeFlags = map[string]bool{
+	"resume":         true,
+	"stale-chunks":   true,
+	"keep-temp":      true,
+	"timeout":        true,
+	"output":         true,
+	"input":          true,
+	"disk-full-wait": true,
+}
+
+// resumeManifest descrive uno split completato, scritto con --resume prima
+// del merge: un'esecuzione successiva sugli stessi input e con le stesse
+// opzioni riprende dal merge con questi chunk invece di rifare lo split.
+type resumeManifest struct {
+	Version int           `json:"version"`
+	RunID   string        `json:"runId"` // esecuzione che ha scritto i chunk
+	Created time.Time     `json:"created"`
+	Args    []string      `json:"args"` // opzioni, senza resumeFreeFlags
+	Inputs  []resumeInput `json:"inputs"`
+	Chunks  []resumeChunk `json:"chunks"`
+	Header  [][]byte      `json:"header,omitempty"`    // righe di --skip-header
+	CSV     *string       `json:"csvHeader,omitempty"` // intestazione di --header
+}
+
+// resumeInput identifica un file di input com'era al momento dello split.
+type resumeInput struct {
+	Path    string    `json:"path"` // percorso assoluto
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// resumeChunk è un chunk dello split con la dimensione del file su disco,
+// che con --chunk-compression differisce dai byte scritti.
+type resumeChunk struct {
+	chunkInfo
+	Size int64 `json:"size"`
+}
+
+// resumeInputs descrive gli input correnti per il manifest di --resume.
+func resumeInputs() ([]resumeInput, error) {
+	var inputs []resumeInput
+	for _, in := range opts.inputs {
+		path, err := filepath.Abs(in)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("--resume: %w", err)
+		}
+		inputs = append(inputs, resumeInput{Path: path, Bytes: fi.Size(), ModTime: fi.ModTime().UTC()})
+	}
+	return inputs, nil
+}
+
+// resumeArgs restituisce le opzioni da cui dipende il contenuto dei chunk.
+func resumeArgs() []string {
+	var args []string
+	for _, a := range flagArgs(nil) {
+		if name, _, _ := strings.Cut(strings.TrimPrefix(a, "-"), "="); !resumeFreeFlags[name] {
+			args = append(args, a)
+		}
+	}
+	return args
+}
+
+// saveResume scrive il manifest di --resume per i chunk di uno split appena
+// completato e li esclude dalla rimozione in caso di errore, così
+// un'esecuzione fallita nel merge si riprende da qui. Un input ordinato in
+// memoria non ha chunk da riprendere.
+func saveResume(dir string, chunks []chunkInfo) error {
+	if !opts.resume || memoryRun != nil {
+		return nil
+	}
+	inputs, err := resumeInputs()
+	if err != nil {
+		return err
+	}
+	m := resumeManifest{Version: 1, RunID: runID, Created: time.Now().UTC(), Args: resumeArgs(), Inputs: inputs, Header: heldHeader}
+	if csvHeader.seen {
+		m.CSV = &csvHeader.record
+	}
+	keep := []string{}
+	for _, c := range chunks {
+		fi, err := os.Stat(c.Path)
+		if err != nil {
+			return fmt.Errorf("--resume: %w", err)
+		}
+		m.Chunks = append(m.Chunks, resumeChunk{chunkInfo: c, Size: fi.Size()})
+		keep = append(keep, c.Path)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, resumeName)
+	trackTemp(path)
+	// Il manifest compare solo completo: un'esecuzione interrotta mentre lo
+	// scrive lascia quello precedente o nessuno.
+	tmp := path + ".tmp"
+	trackTemp(tmp)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	keepTemps(append(keep, path))
+	return nil
+}
+
+// resumeChunks cerca nella directory dei chunk lo split di un'esecuzione
+// precedente e, se gli input e le opzioni coincidono con quelli correnti,
+// lo adotta: i chunk vengono rinominati con il runID corrente e restituiti
+// come se li avesse appena scritti lo split. Altrimenti restituisce ok
+// false, indicando il motivo: i chunk restano file di un'altra esecuzione,
+// trattati secondo --stale-chunks.
+func resumeChunks(dir string) (chunks []chunkInfo, ok bool, err error) {
+	if !opts.resume {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, resumeName))
+	if os.IsNotExist(err) {
+		fmt.Fprintf(status, "📝 --resume: nessuno split da riprendere in %s\n", dir)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var m resumeManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", filepath.Join(dir, resumeName), err)
+	}
+	if reason := resumeMismatch(dir, &m); reason != "" {
+		fmt.Fprintf(status, "⚠️  --resume: lo split di %s non è riutilizzabile (%s): split da capo\n", dir, reason)
+		return nil, false, nil
+	}
+
+	// I chunk diventano dell'esecuzione corrente: il merge, staleChunks e
+	// la rimozione finale li trattano come quelli di un nuovo split.
+	for _, c := range m.Chunks {
+		path := filepath.Join(dir, chunkName(chunkID(c.Path)))
+		trackTemp(path)
+		if err := os.Rename(filepath.Join(dir, filepath.Base(c.Path)), path); err != nil {
+			return nil, false, fmt.Errorf("--resume: %w", err)
+		}
+		c.chunkInfo.Path = path
+		chunks = append(chunks, c.chunkInfo)
+	}
+	heldHeader = m.Header
+	if m.CSV != nil {
+		if _, err := skipCSVHeader(*m.CSV); err != nil {
+			return nil, false, err
+		}
+	}
+	fmt.Fprintf(status, "🔹 Step 1: ripresa dei %d chunk dello split dell'esecuzione %s (--resume)\n", len(chunks), m.RunID)
+	return chunks, true, saveResume(dir, chunks)
+}
+
+// resumeMismatch restituisce il motivo per cui lo split descritto da m,
+// trovato in dir, non vale per l'esecuzione corrente, o "" se è riutilizzabile.
+func resumeMismatch(dir string, m *resumeManifest) string {
+	if m.Version != 1 {
+		return fmt.Sprintf("versione %d del manifest", m.Version)
+	}
+	inputs, err := resumeInputs()
+	if err != nil {
+		return err.Error()
+	}
+	if len(inputs) != len(m.Inputs) {
+		return fmt.Sprintf("%d input invece di %d", len(inputs), len(m.Inputs))
+	}
+	for i, in := range inputs {
+		was := m.Inputs[i]
+		switch {
+		case in.Path != was.Path:
+			return fmt.Sprintf("input %s invece di %s", in.Path, was.Path)
+		case in.Bytes != was.Bytes || !in.ModTime.Equal(was.ModTime):
+			return fmt.Sprintf("%s modificato dopo lo split", in.Path)
+		}
+	}
+	if args := resumeArgs(); !slices.Equal(args, m.Args) {
+		return fmt.Sprintf("opzioni diverse: %q invece di %q", args, m.Args)
+	}
+	for _, c := range m.Chunks {
+		name := filepath.Base(c.Path)
+		if chunkID(name) < 0 || !strings.HasPrefix(name, "chunk_"+m.RunID+"_") {
+			return fmt.Sprintf("chunk %s estraneo all'esecuzione %s", name, m.RunID)
+		}
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Sprintf("chunk mancante: %v", err)
+		}
+		if fi.Size() != c.Size {
+			return fmt.Sprintf("chunk %s di %d byte invece di %d", name, fi.Size(), c.Size)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failMerge esegue lo split di input con --resume e fa fallire il merge,
+// con un output che è una directory: i chunk devono restare per la ripresa.
+func failMerge(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "occupato"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runSorter(t, dir, append([]string{"--resume", "--output", "occupato"}, args...)...)
+	if err == nil {
+		t.Fatalf("il merge non è fallito\n%s", out)
+	}
+	if !strings.Contains(out, "rilanciare con --resume") {
+		t.Fatalf("nessuna indicazione sulla ripresa:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chunks", resumeName)); err != nil {
+		t.Fatalf("manifest di --resume assente dopo il fallimento: %v", err)
+	}
+}
+
+// TestResume verifica che --resume riprenda dal merge lo split di
+// un'esecuzione fallita solo se input, opzioni e chunk coincidono, e che
+// altrimenti rifaccia lo split; in entrambi i casi l'output è completo e i
+// chunk sono rimossi alla fine.
+func TestResume(t *testing.T) {
+	lines := testLines(4000)
+	split := []string{"--chunk-bytes", "20000", "--in-memory=false", "--detect-sorted=false"}
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, dir string) // modifica tra le due esecuzioni
+		args    []string                       // opzioni della ripresa, oltre a split
+		resumed bool
+		message string // atteso nell'output della ripresa
+		want    []string
+	}{
+		{name: "ripresa", resumed: true, message: "ripresa dei", want: sortedCopy(lines)},
+		{name: "opzioni libere diverse", args: []string{"--timeout", "1h", "--keep-temp=false"}, resumed: true, message: "ripresa dei", want: sortedCopy(lines)},
+		{name: "input modificato", change: func(t *testing.T, dir string) {
+			writeLines(t, dir, "input.txt", lines[:3000])
+			future := time.Now().Add(time.Hour)
+			os.Chtimes(filepath.Join(dir, "input.txt"), future, future)
+		}, message: "modificato dopo lo split", want: sortedCopy(lines[:3000])},
+		{name: "opzioni diverse", args: []string{"--reverse"}, message: "opzioni diverse", want: reversed(sortedCopy(lines))},
+		{name: "chunk mancante", change: func(t *testing.T, dir string) {
+			chunks, _ := filepath.Glob(filepath.Join(dir, "chunks", "chunk_*.txt"))
+			os.Remove(chunks[len(chunks)-1])
+		}, message: "chunk mancante", want: sortedCopy(lines)},
+		{name: "chunk troncato", change: func(t *testing.T, dir string) {
+			chunks, _ := filepath.Glob(filepath.Join(dir, "chunks", "chunk_*.txt"))
+			os.Truncate(chunks[0], 10)
+		}, message: "byte invece di", want: sortedCopy(lines)},
+		{name: "manifest illeggibile", change: func(t *testing.T, dir string) {
+			os.WriteFile(filepath.Join(dir, "chunks", resumeName), []byte("{"), 0644)
+		}, message: resumeName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", lines)
+			failMerge(t, dir, append([]string{"--input", input}, split...)...)
+			if tt.change != nil {
+				tt.change(t, dir)
+			}
+			args := append(append([]string{"--resume", "--input", input, "--output", "out.txt", "--stale-chunks", "clean"}, split...), tt.args...)
+			out, err := runSorter(t, dir, args...)
+			if tt.want == nil {
+				if err == nil || !strings.Contains(out, tt.message) {
+					t.Fatalf("errore %v, l'output non contiene %q:\n%s", err, tt.message, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			if !strings.Contains(out, tt.message) {
+				t.Errorf("l'output non contiene %q:\n%s", tt.message, out)
+			}
+			if got := strings.Contains(out, "Split e ordinamento"); got == tt.resumed {
+				t.Errorf("split eseguito %v, atteso %v:\n%s", got, !tt.resumed, out)
+			}
+			equalLines(t, tt.name, readLines(t, filepath.Join(dir, "out.txt")), tt.want)
+			left, _ := filepath.Glob(filepath.Join(dir, "chunks", "*"))
+			for _, f := range left {
+				if filepath.Base(f) != runLockName {
+					t.Errorf("file rimasto dopo la ripresa: %s", f)
+				}
+			}
+		})
+	}
+}
+
+// reversed restituisce lines in ordine inverso.
+func reversed(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[len(lines)-1-i] = l
+	}
+	return out
+}
+
+// TestResumeHeader verifica che la ripresa conservi le intestazioni lette
+// dallo split, che non viene ripetuto: le righe di --skip-header e
+// l'intestazione di --header, con le colonne di --csv-key indicate per nome.
+func TestResumeHeader(t *testing.T) {
+	dir := t.TempDir()
+	input := writeLines(t, dir, "input.csv", append([]string{"# export", "nome,n"}, func() []string {
+		var rows []string
+		for i := 0; i < 3000; i++ {
+			rows = append(rows, "riga,"+strings.Repeat("9", i%7+1))
+		}
+		return rows
+	}()...))
+	args := []string{"--input", input, "--csv", "--skip-header", "1", "--header", "--csv-key", "n:num:desc", "--chunk-bytes", "8000", "--in-memory=false", "--detect-sorted=false"}
+	failMerge(t, dir, args...)
+	out := mustRunSorter(t, dir, append([]string{"--resume", "--output", "out.csv"}, args...)...)
+	if !strings.Contains(out, "ripresa dei") {
+		t.Fatalf("split non ripreso:\n%s", out)
+	}
+	got := readLines(t, filepath.Join(dir, "out.csv"))
+	if len(got) != 3002 || got[0] != "# export" || got[1] != "nome,n" || got[2] != "riga,9999999" || got[3001] != "riga,9" {
+		t.Fatalf("output ripreso errato: %d righe, inizio %q, fine %q", len(got), got[:min(4, len(got))], got[len(got)-1])
+	}
+}
+
+// TestResumeStaleManifest verifica che senza --resume lo split conservato
+// sia trattato come i chunk di un'altra esecuzione, manifest compreso.
+func TestResumeStaleManifest(t *testing.T) {
+	dir := t.TempDir()
+	input := writeLines(t, dir, "input.txt", testLines(3000))
+	split := []string{"--input", input, "--chunk-bytes", "20000", "--in-memory=false", "--detect-sorted=false"}
+	failMerge(t, dir, split...)
+	out, err := runSorter(t, dir, append([]string{"--output", "out.txt", "--stale-chunks", "refuse"}, split...)...)
+	if err == nil || !strings.Contains(out, "chunk di esecuzioni precedenti") {
+		t.Fatalf("split conservato non segnalato (errore %v):\n%s", err, out)
+	}
+	mustRunSorter(t, dir, append([]string{"--output", "out.txt", "--stale-chunks", "clean"}, split...)...)
+	if _, err := os.Stat(filepath.Join(dir, "chunks", resumeName)); !os.IsNotExist(err) {
+		t.Fatalf("manifest di --resume non rimosso da --stale-chunks clean: %v", err)
+	}
+}
+
+func TestResumeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"stdin", []string{"--input", "-"}, "richiede file di input locali"},
+		{"merge", []string{"--merge"}, "non è combinabile con --merge"},
+		{"run-set", []string{"--run-set", "runs"}, "non è combinabile"},
+		{"verify-content", []string{"--verify-content"}, "non è combinabile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", testLines(10))
+			out, err := runSorter(t, dir, append([]string{"--resume", "--input", input, "--output", "out.txt"}, tt.args...)...)
+			if err == nil || !strings.Contains(out, tt.want) {
+				t.Errorf("errore %v, l'output non contiene %q:\n%s", err, tt.want, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runLockName è il file di lock delle esecuzioni nella directory dei chunk.
+const runLockName = ".sort.lock"
+
+// errLocked indica un lock già preso da un altro processo.
+var errLocked = errors.New("lock già acquisito")
+
+// runLock è un lock di lockRun, tenuto fino alla fine dell'esecuzione. Il
+// kernel lo rilascia alla chiusura del file, anche se il processo termina
+// in modo anomalo.
+type runLock struct {
+	f      *os.File
+	remove bool // il file di lock accanto all'output viene rimosso alla fine
+}
+
+var runLocks []runLock
+
+// lockRun impedisce che due esecuzioni lavorino sugli stessi file: prende
+// senza attendere il lock della directory dei chunk e quello dell'output
+// locale, in un file .lock accanto all'output o alla directory delle
+// partizioni. Se un'altra esecuzione ha già uno dei due lock restituisce
+// subito un errore che la identifica. Con --merge i chunk non si scrivono e
+// il lock della directory dei chunk non serve. Con --gnu senza -T la
+// directory dei chunk è propria del processo, in $TMPDIR: viene rimossa alla
+// fine insieme al suo file di lock.
+func lockRun(chunkDir, outputFile string) error {
+	if !opts.merge {
+		private := opts.gnuTempDir && opts.runSet == ""
+		if private {
+			trackTemp(chunkDir)
+		}
+		if err := os.MkdirAll(chunkDir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(chunkDir, runLockName)
+		if private {
+			trackTemp(path)
+		}
+		if err := acquireRunLock(path, chunkDir, false); err != nil {
+			return err
+		}
+	}
+	target := outputFile
+	switch {
+	case opts.runSet != "":
+		return nil
+	case opts.partitionKey != nil || opts.partitionBy != "":
+		target = filepath.Clean(opts.partitionDir)
+	case outputFile == "-" || isObjectURL(outputFile):
+		return nil
+	}
+	return acquireRunLock(target+".lock", target, true)
+}
+
+// acquireRunLock prende il lock del file path, che protegge what. Nel file
+// scrive PID e sessione, riportati a chi trova il lock già preso.
+func acquireRunLock(path, what string, remove bool) error {
+	f, holder, err := lockPath(path)
+	if errors.Is(err, errLocked) {
+		return fmt.Errorf("%s è in uso da un'altra esecuzione (%s): attendi che termini o usa --chunk-dir e --output diversi",
+			what, holder)
+	}
+	if err != nil {
+		return fmt.Errorf("lock di %s: %w", what, err)
+	}
+	f.Truncate(0)
+	fmt.Fprintf(f, "pid %d, sessione %s\n", os.Getpid(), runID)
+	runLocks = append(runLocks, runLock{f: f, remove: remove})
+	return nil
+}
+
+// lockPath apre path, creandolo se non esiste, e ne prende il lock senza
+// attendere. Se il lock è di un'altra esecuzione restituisce errLocked e il
+// contenuto del file, che la identifica.
+func lockPath(path string) (f *os.File, holder string, err error) {
+	for {
+		if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return nil, "", err
+		}
+		if err := tryLockFile(f); err != nil {
+			data, _ := io.ReadAll(f)
+			f.Close()
+			return nil, strings.TrimSpace(string(data)), err
+		}
+		// L'esecuzione che teneva il lock può aver rimosso il file tra
+		// l'apertura e il lock (vedi releaseRunLocks): il lock preso su quel
+		// file non protegge nulla, perché chi arriva dopo ne crea uno nuovo.
+		// Si riprova sul file che si trova ora in path.
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		if cur, err := os.Stat(path); err == nil && os.SameFile(held, cur) {
+			return f, "", nil
+		}
+		f.Close()
+	}
+}
+
+// releaseRunLocks rilascia i lock di lockRun. Il file di lock accanto
+// all'output viene rimosso prima del rilascio: chi lo aveva già aperto se ne
+// accorge in lockPath e riprova sul nuovo file. Quello nella directory dei
+// chunk resta, come il resto dello spazio di lavoro, salvo nella directory
+// propria del processo di --gnu, rimossa da removeTemps.
+func releaseRunLocks() {
+	for _, l := range runLocks {
+		if l.remove {
+			os.Remove(l.f.Name())
+		}
+		l.f.Close()
+	}
+	runLocks = nil
+}
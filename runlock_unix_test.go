@@ -0,0 +1,123 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRunLockContention tiene il lock di un'altra esecuzione sulla
+// directory dei chunk, sull'output o sulla directory delle partizioni e
+// verifica che il programma si fermi subito, senza attendere né scrivere,
+// indicando chi tiene il lock; rilasciato il lock, la stessa esecuzione
+// riesce.
+func TestRunLockContention(t *testing.T) {
+	tests := []struct {
+		name   string
+		lock   string // file di lock tenuto dal test, relativo alla directory
+		args   []string
+		gnu    bool   // args con la sintassi di --gnu, l'input in fondo
+		output string // output che non deve esistere finché il lock è tenuto
+		left   bool   // il file di lock resta dopo l'esecuzione riuscita
+	}{
+		{name: "chunk", lock: filepath.Join("chunks", runLockName), args: []string{"--output", "out.txt"}, output: "out.txt", left: true},
+		{name: "output", lock: "out.txt.lock", args: []string{"--output", "out.txt"}, output: "out.txt"},
+		{name: "partizioni", lock: "parts.lock", args: []string{"--partition", "1.1,1.1", "--partition-dir", "parts"}, output: "parts"},
+		{name: "gnu -T", lock: filepath.Join("shared", runLockName), args: []string{"-o", "out.txt", "-T", "shared"}, gnu: true, output: "out.txt", left: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", testLines(1000))
+			args := append([]string{"--input", input}, tt.args...)
+			if tt.gnu {
+				args = append(append([]string{"--gnu"}, tt.args...), input)
+			}
+
+			path := filepath.Join(dir, tt.lock)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := lockFile(f); err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString("pid 1, sessione prova\n")
+
+			start := time.Now()
+			out, err := runSorter(t, dir, args...)
+			if err == nil {
+				t.Fatalf("esecuzione riuscita con il lock tenuto:\n%s", out)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("errore dopo %s: il lock non va atteso", elapsed)
+			}
+			if !strings.Contains(out, "in uso da un'altra esecuzione (pid 1, sessione prova)") {
+				t.Errorf("l'errore non indica chi tiene il lock:\n%s", out)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.output)); err == nil {
+				t.Errorf("%s scritto senza il lock", tt.output)
+			}
+
+			if err := unlockFile(f); err != nil {
+				t.Fatal(err)
+			}
+			mustRunSorter(t, dir, args...)
+			if _, err := os.Stat(path); (err == nil) != tt.left {
+				t.Errorf("file di lock presente: %v, atteso %v", err == nil, tt.left)
+			}
+		})
+	}
+}
+
+// TestLockPathRemoved fa prendere e rilasciare lo stesso lock a più
+// goroutine, rimuovendo il file prima di chiuderlo come releaseRunLocks:
+// chi aveva aperto il file rimosso non deve credersi l'unico proprietario
+// mentre un altro tiene il lock sul nuovo file.
+func TestLockPathRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt.lock")
+	var holders, acquired, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				f, _, err := lockPath(path)
+				if errors.Is(err, errLocked) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if holders.Add(1) > 1 {
+					overlaps.Add(1)
+				}
+				acquired.Add(1)
+				time.Sleep(time.Microsecond)
+				holders.Add(-1)
+				os.Remove(path)
+				f.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("per %d volte più esecuzioni hanno tenuto il lock insieme", n)
+	}
+	if acquired.Load() == 0 {
+		t.Fatal("lock mai acquisito")
+	}
+}
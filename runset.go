@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/afraccalvieri-ca/SithLords/runset"
+)
+
+// runIndexStride è ogni quante righe un chunk registra una voce nell'indice sparso.
+const runIndexStride = 4096
+
+// orderFlags elenca le opzioni che influenzano l'ordinamento: vengono salvate
+// nel manifest e riapplicate in query, così il run set viene sempre letto
+// con lo stesso criterio con cui è stato scritto.
+var orderFlags = map[string]bool{
+	"ignore-case":           true,
+	"ignore-leading-blanks": true,
+	"dictionary-order":      true,
+	"locale":                true,
+	"key":                   true,
+	"k":                     true,
+	"key-bytes":             true,
+	"field-sep":             true,
+	"t":                     true,
+	"numeric":               true,
+	"natural":               true,
+	"version-sort":          true,
+	"month-sort":            true,
+	"by-length":             true,
+	"timestamp":             true,
+	"ip":                    true,
+	"ip-order":              true,
+	"id-time":               true,
+	"line-endings":          true,
+	"normalize":             true,
+	"human-numeric":         true,
+	"random":                true,
+	"random-seed":           true,
+	"reverse":               true,
+	"schema":                true,
+	"csv":                   true,
+	"tsv":                   true,
+	"csv-delimiter":         true,
+	"csv-key":               true,
+	"json-key":              true,
+	"stable":                true,
+}
+
+// indexEntry è una voce dell'indice sparso di un chunk, nel formato del
+// manifest dei run set.
+type indexEntry = runset.IndexEntry
+
+// writeRunManifest salva il manifest del run set nella directory dei chunk.
+func writeRunManifest(dir string, chunks []chunkInfo) error {
+	m := runset.Manifest{Version: 1, Created: time.Now().UTC(), OrderArgs: orderArgs()}
+	for _, c := range chunks {
+		m.Runs = append(m.Runs, runset.Run{Path: c.Path, Lines: c.Lines, Bytes: c.Bytes, First: c.First, Last: c.Last, Index: c.Index})
+	}
+	return runset.WriteManifest(dir, m)
+}
+
+// openRunSet apre il run set contenuto in dir e riapplica le opzioni di
+// ordinamento con cui è stato scritto. Le righe vengono lette e confrontate
+// come i chunk del merge.
+func openRunSet(dir string) (*runset.Set, error) {
+	m, err := runset.ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := setOrderArgs(m.OrderArgs); err != nil {
+		return nil, err
+	}
+	split := bufio.ScanLines
+	switch {
+	case opts.recordSize > 0:
+		split = scanFixed
+	case opts.framing != "":
+		split = scanFramed
+	case opts.lineEndings == eolPreserve || opts.zeroTerminated:
+		split = scanRecords
+	}
+	return runset.Open(dir, runset.Options{
+		Key:    sortKey,
+		Less:   lessKeyed,
+		Stable: opts.stable || opts.unique || opts.count,
+		Split:  split,
+	})
+}
+
+// orderArgs restituisce le opzioni di ordinamento impostate da riga di comando
+// nella forma -nome=valore, con un elemento per ogni valore dei flag ripetibili.
+func orderArgs() []string {
+	return flagArgs(orderFlags)
+}
+
+// flagArgs restituisce i flag impostati nella forma -nome=valore, limitandosi
+// a quelli presenti in only se non è nil. I flag ripetibili producono un
+// elemento per valore.
+func flagArgs(only map[string]bool) []string {
+	var args []string
+	seen := map[*stringList]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		if only != nil && !only[f.Name] {
+			return
+		}
+		if list, ok := f.Value.(*stringList); ok {
+			// Alias come -k e --key condividono la stessa lista.
+			if seen[list] {
+				return
+			}
+			seen[list] = true
+			for _, v := range *list {
+				args = append(args, "-"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// setOrderArgs sostituisce le opzioni di ordinamento correnti con quelle
+// indicate (nel formato di orderArgs) e ne ricalcola le strutture derivate.
+func setOrderArgs(args []string) error {
+	opts.keys = nil
+	for _, arg := range args {
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("opzione %s non applicabile: %w", arg, err)
+		}
+	}
+	return applyOrderOptions()
+}
+
+// queryRunSet scrive su w le righe del run set in dir selezionate da
+// --from, --to e --limit.
+func queryRunSet(dir string, w io.Writer) error {
+	rs, err := openRunSet(dir)
+	if err != nil {
+		return err
+	}
+	it, err := rs.Query(opts.queryFrom, opts.queryTo)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	writer := bufio.NewWriterSize(w, writerBufferSize)
+	var lastKey string
+	for n := 0; opts.queryLimit <= 0 || n < opts.queryLimit; n++ {
+		line, ok := it.Next()
+		if !ok {
+			break
+		}
+		// Con --unique, come nel merge, esce solo la prima riga di ogni chiave.
+		if opts.unique {
+			key := sortKey(line)
+			if n > 0 && key == lastKey {
+				n--
+				continue
+			}
+			lastKey = key
+		}
+		writer.WriteString(formatRecord(line))
+		writer.WriteString(lineEnd)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,325 @@
+// Package runset legge i run set scritti con --run-set: i chunk ordinati
+// conservati dopo lo split, con il manifest runs.json che ne descrive
+// intervallo di chiavi e indice sparso. Lo stesso run set si interroga
+// quante volte si vuole, anche in parallelo, senza produrre un file unico:
+//
+//	rs, err := runset.Open("runs", runset.Options{})
+//	...
+//	it, err := rs.Query("b", "d") // righe con chiave tra b e d, incluse
+//	...
+//	defer it.Close()
+//	for line, ok := it.Next(); ok; line, ok = it.Next() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Il programma usa lo stesso pacchetto per --query.
+package runset
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestName è il nome del manifest nella directory del run set.
+const ManifestName = "runs.json"
+
+// IndexEntry è una voce dell'indice sparso di un run: la riga che si trova
+// all'offset indicato. Permette alle query di saltare le parti non richieste.
+type IndexEntry struct {
+	Line   string `json:"line"`
+	Offset int64  `json:"offset"`
+}
+
+// Run descrive un run del set: un file di righe ordinate.
+type Run struct {
+	Path  string       `json:"file"`            // file del run, relativo alla directory
+	Lines int          `json:"lines"`           // numero di righe
+	Bytes int64        `json:"bytes"`           // dimensione in byte del file
+	First string       `json:"first"`           // prima riga (la più piccola)
+	Last  string       `json:"last"`            // ultima riga (la più grande)
+	Index []IndexEntry `json:"index,omitempty"` // indice sparso per il posizionamento
+}
+
+// Manifest è il contenuto di runs.json.
+type Manifest struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	OrderArgs []string  `json:"orderArgs"` // opzioni di ordinamento del programma, come -nome=valore: chi legge il run set deve usare lo stesso ordine
+	Lines     int64     `json:"lines"`
+	Runs      []Run     `json:"runs"`
+}
+
+// WriteManifest salva m nella directory dir. I percorsi dei run sono resi
+// relativi alla directory, che può quindi essere spostata.
+func WriteManifest(dir string, m Manifest) error {
+	runs := make([]Run, len(m.Runs))
+	m.Lines = 0
+	for i, r := range m.Runs {
+		r.Path = filepath.Base(r.Path)
+		m.Lines += int64(r.Lines)
+		runs[i] = r
+	}
+	m.Runs = runs
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestName), data, 0644)
+}
+
+// Options descrive come sono ordinate le righe del run set. Il valore zero
+// corrisponde a un run set scritto senza opzioni di ordinamento: righe
+// separate da newline, in ordine di byte. Le funzioni possono essere
+// chiamate da più iteratori in parallelo.
+type Options struct {
+	// Key ricava la chiave di ordinamento di una riga. Le chiavi si
+	// confrontano per byte con i limiti di Query. nil usa la riga stessa.
+	Key func(line string) string
+	// Less indica se la riga a, con chiave aKey, precede b nel merge. nil
+	// confronta le chiavi e, a parità, le righe.
+	Less func(aKey, a, bKey, b string) bool
+	// Stable fa uscire le righe con la stessa chiave nell'ordine dei run,
+	// cioè dell'input, invece di confrontarle con Less.
+	Stable bool
+	// Split divide i file dei run in righe. nil usa bufio.ScanLines.
+	Split bufio.SplitFunc
+}
+
+// Set è un run set aperto in lettura.
+type Set struct {
+	dir      string
+	opts     Options
+	manifest Manifest
+}
+
+// ReadManifest legge il manifest del run set contenuto in dir.
+func ReadManifest(dir string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("manifest del run set non valido: %w", err)
+	}
+	return m, nil
+}
+
+// Open apre il run set contenuto in dir, le cui righe sono ordinate come
+// descritto da o.
+func Open(dir string, o Options) (*Set, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Set{dir: dir, opts: o, manifest: m}, nil
+}
+
+// Manifest restituisce il manifest del run set.
+func (rs *Set) Manifest() Manifest { return rs.manifest }
+
+// Lines restituisce il numero totale di righe del run set.
+func (rs *Set) Lines() int64 { return rs.manifest.Lines }
+
+// Query apre un iteratore sulle righe con chiave in [from, to], in ordine
+// globale. Stringhe vuote indicano un intervallo aperto su quel lato; i
+// limiti passano da Options.Key come le righe. I run interamente fuori
+// dall'intervallo non vengono aperti e l'indice sparso di ciascun run evita
+// di leggere le righe precedenti a from.
+func (rs *Set) Query(from, to string) (*Iterator, error) {
+	it := &Iterator{opts: rs.opts, fromOK: from != "", toOK: to != ""}
+	if it.fromOK {
+		it.from = it.key(from)
+	}
+	if it.toOK {
+		it.to = it.key(to)
+	}
+	for i, run := range rs.manifest.Runs {
+		// Salta i run interamente fuori dall'intervallo richiesto.
+		if it.fromOK && it.key(run.Last) < it.from || it.toOK && it.key(run.First) > it.to {
+			continue
+		}
+		var offset int64
+		if it.fromOK {
+			for _, e := range run.Index {
+				if it.key(e.Line) >= it.from {
+					break
+				}
+				offset = e.Offset
+			}
+		}
+		if err := it.open(filepath.Join(rs.dir, run.Path), offset, i); err != nil {
+			it.Close()
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// Iterator restituisce le righe di una query. Non è sicuro per l'uso da
+// più goroutine, ma iteratori diversi dello stesso Set sono indipendenti.
+type Iterator struct {
+	opts    Options
+	fromOK  bool   // true se esiste un limite inferiore
+	from    string // chiave minima (inclusa)
+	toOK    bool   // true se esiste un limite superiore
+	to      string // chiave massima (inclusa)
+	readers []*reader
+	heads   []head // riga corrente di ogni run non esaurito, in heap
+	done    bool
+	err     error
+}
+
+// reader legge le righe di un run.
+type reader struct {
+	path    string
+	f       *os.File
+	scanner *bufio.Scanner
+	run     int // posizione del run nel manifest
+}
+
+// head è la riga corrente di un run.
+type head struct {
+	line, key string
+	r         *reader
+}
+
+// open apre il file di un run a partire da offset e ne mette in heap la
+// prima riga.
+func (it *Iterator) open(path string, offset int64, run int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, 0); err != nil {
+		f.Close()
+		return err
+	}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), math.MaxInt)
+	if it.opts.Split != nil {
+		s.Split(it.opts.Split)
+	}
+	r := &reader{path: path, f: f, scanner: s, run: run}
+	it.readers = append(it.readers, r)
+	return it.advance(r)
+}
+
+// advance mette in heap la riga successiva di r, se ne ha ancora.
+func (it *Iterator) advance(r *reader) error {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %w", r.path, err)
+		}
+		return nil
+	}
+	line := r.scanner.Text()
+	it.push(head{line: line, key: it.key(line), r: r})
+	return nil
+}
+
+// Next restituisce la prossima riga dell'intervallo; ok è false a fine
+// intervallo o dopo un errore di lettura, restituito da Err.
+func (it *Iterator) Next() (line string, ok bool) {
+	for !it.done && len(it.heads) > 0 {
+		h := it.pop()
+		if err := it.advance(h.r); err != nil {
+			// h è già stata letta: la query si ferma dalla prossima chiamata.
+			it.err, it.done = err, true
+		}
+		if it.fromOK && h.key < it.from {
+			continue
+		}
+		if it.toOK && h.key > it.to {
+			it.done = true
+			break
+		}
+		return h.line, true
+	}
+	return "", false
+}
+
+// Err restituisce il primo errore di lettura dei run, o nil.
+func (it *Iterator) Err() error { return it.err }
+
+// Close chiude i file dei run aperti dall'iteratore.
+func (it *Iterator) Close() error {
+	var first error
+	for _, r := range it.readers {
+		if err := r.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	it.readers, it.heads, it.done = nil, nil, true
+	return first
+}
+
+func (it *Iterator) key(line string) string {
+	if it.opts.Key == nil {
+		return line
+	}
+	return it.opts.Key(line)
+}
+
+// less confronta due righe in heap: a parità di chiave, con Stable, vince
+// il run che viene prima nel manifest.
+func (it *Iterator) less(a, b head) bool {
+	if it.opts.Stable && a.key == b.key {
+		return a.r.run < b.r.run
+	}
+	if it.opts.Less != nil {
+		return it.opts.Less(a.key, a.line, b.key, b.line)
+	}
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return a.line < b.line
+}
+
+// push aggiunge h all'heap delle righe correnti.
+func (it *Iterator) push(h head) {
+	it.heads = append(it.heads, h)
+	q := it.heads
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !it.less(q[i], q[parent]) {
+			break
+		}
+		q[i], q[parent] = q[parent], q[i]
+		i = parent
+	}
+}
+
+// pop toglie e restituisce la riga più piccola.
+func (it *Iterator) pop() head {
+	q := it.heads
+	top := q[0]
+	n := len(q) - 1
+	q[0] = q[n]
+	q[n] = head{}
+	q = q[:n]
+	it.heads = q
+	for i := 0; ; {
+		smallest := i
+		if l := 2*i + 1; l < n && it.less(q[l], q[smallest]) {
+			smallest = l
+		}
+		if r := 2*i + 2; r < n && it.less(q[r], q[smallest]) {
+			smallest = r
+		}
+		if smallest == i {
+			break
+		}
+		q[i], q[smallest] = q[smallest], q[i]
+		i = smallest
+	}
+	return top
+}
@@ -0,0 +1,230 @@
+package runset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeSet scrive in dir un run set con un run per ciascun elemento di
+// runs, già ordinato, separando le righe con sep, e un indice sparso ogni
+// stride righe.
+func writeSet(t *testing.T, dir string, runs [][]string, sep string, stride int) {
+	t.Helper()
+	m := Manifest{Version: 1, OrderArgs: []string{"-stable=true"}}
+	for i, lines := range runs {
+		r := Run{Path: filepath.Join(dir, fmt.Sprintf("chunk_%06d.txt", i)), Lines: len(lines), First: lines[0], Last: lines[len(lines)-1]}
+		var data strings.Builder
+		for j, l := range lines {
+			if j%stride == 0 {
+				r.Index = append(r.Index, IndexEntry{Line: l, Offset: int64(data.Len())})
+			}
+			data.WriteString(l + sep)
+		}
+		r.Bytes = int64(data.Len())
+		if err := os.WriteFile(r.Path, []byte(data.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m.Runs = append(m.Runs, r)
+	}
+	if err := WriteManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// collect restituisce tutte le righe di una query. Segnala gli errori
+// senza fermare il test, quindi si può chiamare da altre goroutine.
+func collect(t *testing.T, rs *Set, from, to string) []string {
+	t.Helper()
+	it, err := rs.Query(from, to)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer it.Close()
+	var out []string
+	for line, ok := it.Next(); ok; line, ok = it.Next() {
+		out = append(out, line)
+	}
+	if err := it.Err(); err != nil {
+		t.Error(err)
+	}
+	return out
+}
+
+// testRuns divide n righe numerate in run ordinati e sovrapposti.
+func testRuns(n, runs int) (parts [][]string, all []string) {
+	parts = make([][]string, runs)
+	for i := 0; i < n; i++ {
+		l := fmt.Sprintf("%05d", (i*7919)%n)
+		parts[i%runs] = append(parts[i%runs], l)
+		all = append(all, l)
+	}
+	for _, p := range parts {
+		sort.Strings(p)
+	}
+	sort.Strings(all)
+	return parts, all
+}
+
+// between restituisce le righe di all comprese tra from e to.
+func between(all []string, from, to string) []string {
+	var out []string
+	for _, l := range all {
+		if (from == "" || l >= from) && (to == "" || l <= to) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func TestQuery(t *testing.T) {
+	dir := t.TempDir()
+	parts, all := testRuns(10000, 7)
+	writeSet(t, dir, parts, "\n", 64)
+	rs, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Lines() != int64(len(all)) {
+		t.Errorf("Lines = %d, atteso %d", rs.Lines(), len(all))
+	}
+	if args := rs.Manifest().OrderArgs; len(args) != 1 || args[0] != "-stable=true" {
+		t.Errorf("OrderArgs = %v", args)
+	}
+	tests := []struct{ from, to string }{
+		{"", ""},
+		{"05000", ""},
+		{"", "00999"},
+		{"01234", "01300"},
+		{"04000", "04000"},
+		{"09999", ""},
+		{"99999", ""},
+		{"", "."},
+		{"03000", "02000"},
+	}
+	// Lo stesso run set si interroga più volte, anche in parallelo.
+	var wg sync.WaitGroup
+	for round := 0; round < 2; round++ {
+		for _, tt := range tests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got := strings.Join(collect(t, rs, tt.from, tt.to), ",")
+				if want := strings.Join(between(all, tt.from, tt.to), ","); got != want {
+					t.Errorf("Query(%q, %q): %d righe diverse da quelle attese", tt.from, tt.to, strings.Count(got, ","))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// TestQueryIndex verifica che l'indice sparso non faccia perdere righe
+// quando from cade tra due voci, con righe ripetute tra i run.
+func TestQueryIndex(t *testing.T) {
+	dir := t.TempDir()
+	runs := [][]string{{"a", "b", "b", "b", "c", "d"}, {"b", "b", "c"}}
+	writeSet(t, dir, runs, "\n", 2)
+	rs, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(collect(t, rs, "b", "c"), " "); got != "b b b b b c c" {
+		t.Fatalf("Query(b, c) = %q", got)
+	}
+}
+
+// TestQueryOptions verifica chiave, ordine, stabilità e separatore
+// personalizzati.
+func TestQueryOptions(t *testing.T) {
+	dir := t.TempDir()
+	// Ordine decrescente sul campo dopo ":"; a parità, l'ordine dei run.
+	runs := [][]string{{"x:9", "a:5", "c:5", "b:1"}, {"y:9", "d:5", "e:2"}}
+	writeSet(t, dir, runs, "\x00", 1)
+	key := func(l string) string { _, k, _ := strings.Cut(l, ":"); return k }
+	rs, err := Open(dir, Options{
+		Key:    key,
+		Less:   func(aKey, a, bKey, b string) bool { return aKey > bKey },
+		Stable: true,
+		Split:  splitNUL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(collect(t, rs, "", ""), " "); got != "x:9 y:9 a:5 c:5 d:5 e:2 b:1" {
+		t.Fatalf("Query = %q", got)
+	}
+}
+
+// splitNUL divide i run in record terminati da NUL.
+func splitNUL(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(dir, Options{}); err == nil {
+		t.Error("Open senza manifest non ha restituito errori")
+	}
+	os.WriteFile(filepath.Join(dir, ManifestName), []byte("{"), 0644)
+	if _, err := Open(dir, Options{}); err == nil {
+		t.Error("Open con un manifest non valido non ha restituito errori")
+	}
+
+	// Un run mancante fa fallire la query, non l'apertura.
+	writeSet(t, dir, [][]string{{"a"}, {"b"}}, "\n", 1)
+	os.Remove(filepath.Join(dir, "chunk_000001.txt"))
+	rs, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Query("", ""); err == nil {
+		t.Error("Query con un run mancante non ha restituito errori")
+	}
+}
+
+// TestQueryReadError verifica che un errore di lettura a metà run arrivi a
+// Err invece di troncare la query in silenzio.
+func TestQueryReadError(t *testing.T) {
+	dir := t.TempDir()
+	writeSet(t, dir, [][]string{{"a", "b", "c"}}, "\n", 1)
+	rs, err := Open(dir, Options{Split: func(data []byte, atEOF bool) (int, []byte, error) {
+		adv, tok, err := bufio.ScanLines(data, atEOF)
+		if string(tok) == "b" {
+			return 0, nil, fmt.Errorf("record illeggibile")
+		}
+		return adv, tok, err
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := rs.Query("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var got []string
+	for line, ok := it.Next(); ok; line, ok = it.Next() {
+		got = append(got, line)
+	}
+	if it.Err() == nil {
+		t.Fatalf("nessun errore, righe %v", got)
+	}
+	// La riga letta prima dell'errore viene comunque restituita.
+	if strings.Join(got, " ") != "a" {
+		t.Fatalf("righe prima dell'errore: %v", got)
+	}
+}
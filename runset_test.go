@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRunSetQuery scrive un run set e lo interroga più volte da riga di
+// comando: le opzioni di ordinamento registrate nel manifest valgono anche
+// per le query, che non le ripetono.
+func TestRunSetQuery(t *testing.T) {
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = strconv.Itoa((i*7919)%5000) + " riga"
+	}
+	dir := t.TempDir()
+	input := writeLines(t, dir, "input.txt", lines)
+	mustRunSorter(t, dir, "--input", input, "--run-set", "runs", "--chunk-bytes", "8000", "--numeric", "--key", "1,1")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, numbered(0, 4999)},
+		{[]string{"--from", "1000", "--to", "1010"}, numbered(1000, 1010)},
+		{[]string{"--from", "4990"}, numbered(4990, 4999)},
+		{[]string{"--to", "9", "--limit", "5"}, numbered(0, 4)},
+	}
+	for _, tt := range tests {
+		out := mustRunSorter(t, dir, append([]string{"--query", "runs"}, tt.args...)...)
+		var got []string
+		for _, l := range strings.Split(out, "\n") {
+			if strings.HasSuffix(l, " riga") {
+				got = append(got, l)
+			}
+		}
+		equalLines(t, strings.Join(tt.args, " "), got, tt.want)
+	}
+}
+
+// numbered restituisce le righe da "from riga" a "to riga".
+func numbered(from, to int) []string {
+	var out []string
+	for i := from; i <= to; i++ {
+		out = append(out, strconv.Itoa(i)+" riga")
+	}
+	return out
+}
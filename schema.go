@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// defaultSchemaName è lo schema usato senza --schema.
+const defaultSchemaName = "lines"
+
+// formatRecord restituisce la riga di output di un record secondo lo schema corrente.
+func formatRecord(record string) string {
+	if opts.schemaDef != nil && opts.schemaDef.Format != nil {
+		return opts.schemaDef.Format(record)
+	}
+	return record
+}
+
+// lineRecord accetta ogni riga così com'è, righe vuote comprese.
+func lineRecord(line []byte) (string, bool) {
+	b, ok := lineSlice(line)
+	return string(b), ok
+}
+
+// lineSlice è lineRecord senza allocazione.
+func lineSlice(line []byte) ([]byte, bool) {
+	if len(line) == 0 {
+		return nil, false // fine dell'input
+	}
+	return bytes.TrimSuffix(line, []byte{recordSep}), true
+}
+
+func init() {
+	// Righe di testo di lunghezza qualsiasi.
+	schema.Register(schema.Schema{Name: defaultSchemaName, Parse: lineRecord, Slice: lineSlice})
+	// Formato storico a tracciato fisso: righe di strLength caratteri, spazi
+	// esclusi. Le altre righe vengono scartate e contate.
+	schema.Register(schema.Schema{Name: "fixed32", Parse: inputRecord, Slice: inputSlice})
+}
@@ -0,0 +1,121 @@
+// Package schema è il registro dei formati di record selezionabili con
+// --schema. Uno schema riunisce in un unico punto come leggere un formato,
+// come ricavarne la chiave di ordinamento e come riscriverlo in output.
+//
+// Il programma registra qui i propri formati (lines, fixed32, csv, jsonl...);
+// un formato proprietario si registra allo stesso modo dalla funzione init
+// di un pacchetto, che diventa disponibile nel programma importandolo per i
+// suoi effetti:
+//
+//	func init() {
+//		schema.Register(schema.Schema{
+//			Name:  "ordini",
+//			Parse: func(line []byte) (string, bool) { return strings.TrimSpace(string(line)), len(line) > 1 },
+//			Key:   func(r string) string { return r[10:20] }, // codice cliente
+//		})
+//	}
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema descrive un formato di record: come leggerlo dall'input, come
+// ricavarne la chiave di ordinamento e come scriverlo in output.
+// I formati vengono registrati una volta sola, con Register da una funzione
+// init, e diventano selezionabili con --schema in tutte le modalità
+// (ordinamento, partizioni, run set, coda di priorità).
+type Schema struct {
+	Name string
+
+	// Parse converte un record letto dall'input (con il separatore finale,
+	// se presente) nella forma interna. ok a false scarta il record. La forma
+	// interna non deve contenere il separatore ('\n', o NUL con
+	// --zero-terminated): i chunk su disco sono divisi su di esso.
+	Parse func(line []byte) (record string, ok bool)
+
+	// Slice, facoltativa, fa lo stesso lavoro di Parse ma restituisce il
+	// record come porzione di line, senza allocare: lo split lo copia nei
+	// propri blocchi. Va fornita solo se il record è un tratto contiguo
+	// della riga.
+	Slice func(line []byte) (record []byte, ok bool)
+
+	// Key restituisce la chiave di confronto di un record: l'ordine binario
+	// delle chiavi determina l'ordine dei record. nil usa il record intero
+	// e le opzioni di ordinamento correnti. --key ha comunque la precedenza.
+	Key func(record string) string
+
+	// Format restituisce la riga scritta in output, senza '\n'. nil scrive
+	// il record così com'è.
+	Format func(record string) string
+}
+
+var (
+	mu      sync.RWMutex
+	schemas = map[string]*Schema{} // schemi registrati, per nome
+	protos  [][]byte               // descrittori di RegisterProtoDescriptor
+)
+
+// Register rende disponibile uno schema con --schema. Come
+// database/sql.Register va chiamata da init e va in panic per nomi
+// duplicati o schemi senza Parse, che sono errori di programmazione.
+func Register(s Schema) {
+	if s.Name == "" || s.Parse == nil {
+		panic("schema.Register: nome e Parse sono obbligatori")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := schemas[s.Name]; dup {
+		panic("schema.Register: schema già registrato: " + s.Name)
+	}
+	schemas[s.Name] = &s
+}
+
+// Lookup restituisce lo schema registrato con il nome indicato. L'errore
+// per un nome sconosciuto elenca gli schemi disponibili.
+func Lookup(name string) (*Schema, error) {
+	mu.RLock()
+	s, ok := schemas[name]
+	mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("schema --schema sconosciuto %q (disponibili: %s)", name, strings.Join(Names(), ", "))
+}
+
+// Names restituisce i nomi degli schemi registrati, in ordine alfabetico.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(schemas))
+	for n := range schemas {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterProtoDescriptor registra un FileDescriptorSet serializzato, come
+// quello prodotto da protoc --descriptor_set_out (anche incorporato con
+// go:embed). Va chiamata in init, come Register; i messaggi descritti si
+// selezionano poi con --proto-message. Il descrittore viene interpretato
+// all'avvio, insieme a quelli di --proto-descriptor.
+func RegisterProtoDescriptor(set []byte) {
+	if len(set) == 0 {
+		panic("schema.RegisterProtoDescriptor: descrittore vuoto")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	protos = append(protos, set)
+}
+
+// ProtoDescriptors restituisce i descrittori registrati con
+// RegisterProtoDescriptor, nell'ordine di registrazione.
+func ProtoDescriptors() [][]byte {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([][]byte(nil), protos...)
+}
@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseAll(line []byte) (string, bool) { return string(line), true }
+
+// mustPanic verifica che f vada in panic con un messaggio che contiene want.
+func mustPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if r == nil {
+			t.Fatalf("nessun panic, atteso %q", want)
+		}
+		if msg, _ := r.(string); !strings.Contains(msg, want) {
+			t.Fatalf("panic %v, atteso %q", r, want)
+		}
+	}()
+	f()
+}
+
+func TestRegisterLookup(t *testing.T) {
+	Register(Schema{
+		Name:  "test-maiuscole",
+		Parse: parseAll,
+		Key:   strings.ToUpper,
+	})
+	s, err := Lookup("test-maiuscole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "test-maiuscole" || s.Key("abc") != "ABC" || s.Slice != nil || s.Format != nil {
+		t.Fatalf("schema registrato diverso: %+v", s)
+	}
+	if r, ok := s.Parse([]byte("riga")); !ok || r != "riga" {
+		t.Fatalf("Parse = %q, %v", r, ok)
+	}
+	found := false
+	for _, n := range Names() {
+		found = found || n == "test-maiuscole"
+	}
+	if !found {
+		t.Fatalf("Names() = %v, manca test-maiuscole", Names())
+	}
+}
+
+// TestRegisterCopy verifica che lo schema registrato non cambi se il
+// chiamante modifica il valore passato a Register.
+func TestRegisterCopy(t *testing.T) {
+	s := Schema{Name: "test-copia", Parse: parseAll}
+	Register(s)
+	s.Format = strings.ToUpper
+	if got, _ := Lookup("test-copia"); got.Format != nil {
+		t.Fatal("lo schema registrato segue le modifiche del chiamante")
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	Register(Schema{Name: "test-doppio", Parse: parseAll})
+	tests := []struct {
+		name string
+		s    Schema
+		want string
+	}{
+		{"nome duplicato", Schema{Name: "test-doppio", Parse: parseAll}, "già registrato: test-doppio"},
+		{"senza nome", Schema{Parse: parseAll}, "obbligatori"},
+		{"senza Parse", Schema{Name: "test-senza-parse"}, "obbligatori"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustPanic(t, tt.want, func() { Register(tt.s) })
+		})
+	}
+	// Lo schema rifiutato non viene registrato.
+	if _, err := Lookup("test-senza-parse"); err == nil {
+		t.Fatal("schema senza Parse registrato")
+	}
+}
+
+func TestLookupUnknown(t *testing.T) {
+	Register(Schema{Name: "test-b", Parse: parseAll})
+	Register(Schema{Name: "test-a", Parse: parseAll})
+	_, err := Lookup("inesistente")
+	if err == nil {
+		t.Fatal("nessun errore per uno schema sconosciuto")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `"inesistente"`) || !strings.Contains(msg, "test-a, test-b") {
+		t.Fatalf("errore %q: attesi il nome e gli schemi disponibili in ordine", msg)
+	}
+}
+
+func TestRegisterProtoDescriptor(t *testing.T) {
+	before := len(ProtoDescriptors())
+	RegisterProtoDescriptor([]byte{1})
+	RegisterProtoDescriptor([]byte{2})
+	got := ProtoDescriptors()
+	if len(got) != before+2 || got[before][0] != 1 || got[before+1][0] != 2 {
+		t.Fatalf("ProtoDescriptors() = %v", got)
+	}
+	mustPanic(t, "vuoto", func() { RegisterProtoDescriptor(nil) })
+}
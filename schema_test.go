@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/afraccalvieri-ca/SithLords/schema"
+)
+
+// testSchemaName è uno schema registrato solo dal binario dei test, come
+// farebbe un pacchetto importato per i suoi effetti: runSorter rilancia
+// lo stesso binario, quindi è selezionabile con --schema.
+const testSchemaName = "test-seconda-parola"
+
+func init() {
+	schema.Register(schema.Schema{
+		Name:  testSchemaName,
+		Parse: lineRecord,
+		Key: func(r string) string {
+			_, k, _ := strings.Cut(r, " ")
+			return k
+		},
+		Format: strings.ToUpper,
+	})
+}
+
+// TestSchemaSelection verifica che --schema scelga parser, chiave e formato
+// di output tra gli schemi registrati, compresi quelli esterni.
+func TestSchemaSelection(t *testing.T) {
+	fixed := strings.Repeat("b", 32)
+	tests := []struct {
+		name  string
+		input []string
+		args  []string
+		want  []string
+	}{
+		{"predefinito", []string{"b 2", "a 3", "c 1"}, nil, []string{"a 3", "b 2", "c 1"}},
+		{"lines", []string{"b 2", "", "a 3"}, []string{"--schema", "lines"}, []string{"", "a 3", "b 2"}},
+		{"fixed32", []string{fixed, "corta", "  " + strings.Repeat("a", 32) + " ", strings.Repeat("c", 33)},
+			[]string{"--schema", "fixed32"}, []string{strings.Repeat("a", 32), fixed}},
+		{"registrato", []string{"b 2", "a 3", "c 1"}, []string{"--schema", testSchemaName},
+			[]string{"C 1", "B 2", "A 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", tt.input)
+			mustRunSorter(t, dir, append([]string{"--input", input, "--output", "out.txt"}, tt.args...)...)
+			equalLines(t, tt.name, readLines(t, filepath.Join(dir, "out.txt")), tt.want)
+		})
+	}
+}
+
+// TestSchemaUnknown verifica che uno schema sconosciuto fermi il programma
+// elencando quelli disponibili.
+func TestSchemaUnknown(t *testing.T) {
+	dir := t.TempDir()
+	input := writeLines(t, dir, "input.txt", []string{"a"})
+	out, err := runSorter(t, dir, "--input", input, "--output", "out.txt", "--schema", "inesistente")
+	if err == nil {
+		t.Fatalf("--schema inesistente non ha restituito errori:\n%s", out)
+	}
+	for _, want := range []string{`"inesistente"`, "fixed32", "lines", testSchemaName} {
+		if !strings.Contains(out, want) {
+			t.Errorf("l'errore non contiene %q:\n%s", want, out)
+		}
+	}
+}
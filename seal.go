@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sealSuffix è il suffisso del sigillo scritto accanto al file di output.
+const sealSuffix = ".seal.json"
+
+// sealManifest descrive un file di output ordinato: contenuto, dimensioni e
+// opzioni con cui è stato prodotto. È la parte firmata del sigillo.
+type sealManifest struct {
+	Version int         `json:"version"`
+	Created time.Time   `json:"created"`
+	Output  string      `json:"output"` // nome del file, relativo alla directory del sigillo
+	Bytes   int64       `json:"bytes"`
+	Lines   int64       `json:"lines"`
+	SHA256  string      `json:"sha256"`
+	Args    []string    `json:"args"` // opzioni dell'esecuzione, come -nome=valore
+	Inputs  []sealInput `json:"inputs"`
+}
+
+// sealInput identifica un file di input dell'esecuzione.
+type sealInput struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes,omitempty"`
+}
+
+// seal è il sigillo di fine esecuzione: il manifest e la sua firma Ed25519.
+// La firma copre i byte di Manifest in forma compatta (json.Compact), così
+// può essere verificata anche senza questo programma.
+type seal struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	KeyID     string          `json:"keyId"` // primi 8 byte dello SHA-256 della chiave pubblica
+	Signature string          `json:"signature"`
+}
+
+// outputDigest calcola checksum, byte e righe dell'output mentre viene scritto.
+type outputDigest struct {
+	h     hash.Hash
+	bytes int64
+	lines int64
+}
+
+func newOutputDigest() *outputDigest { return &outputDigest{h: sha256.New()} }
+
+func (d *outputDigest) Write(p []byte) (int, error) {
+	d.h.Write(p)
+	d.bytes += int64(len(p))
+	d.lines += int64(bytes.Count(p, []byte{recordSep}))
+	return len(p), nil
+}
+
+// sum restituisce in esadecimale lo SHA-256 dei byte scritti finora.
+func (d *outputDigest) sum() string { return hex.EncodeToString(d.h.Sum(nil)) }
+
+// sealInputs descrive i file di input dell'esecuzione, con la loro dimensione.
+func sealInputs() []sealInput {
+	var inputs []sealInput
+	for _, in := range opts.inputs {
+		si := sealInput{Path: in}
+		if fi, err := os.Stat(in); err == nil && in != "-" {
+			si.Bytes = fi.Size()
+		}
+		inputs = append(inputs, si)
+	}
+	return inputs
+}
+
+// checksumSuffix è il suffisso del file di checksum scritto con --checksum.
+const checksumSuffix = ".sha256"
+
+// writeChecksum scrive in outputFile + checksumSuffix lo SHA-256 dell'output
+// appena scritto, nel formato di sha256sum (verificabile con sha256sum -c),
+// preceduto da un commento con il numero di record e i byte su disco. I
+// record sono contati prima dell'eventuale compressione.
+func writeChecksum(outputFile string, d *outputDigest, records int64) error {
+	data := fmt.Sprintf("# %d record, %d byte\n%s  %s\n", records, d.bytes, d.sum(), filepath.Base(outputFile))
+	return os.WriteFile(outputFile+checksumSuffix, []byte(data), 0644)
+}
+
+// loadSealKey legge una chiave privata Ed25519 in formato PEM PKCS#8,
+// come quella prodotta da `openssl genpkey -algorithm ed25519`.
+func loadSealKey(path string) (ed25519.PrivateKey, error) {
+	key, err := parsePEMKey(path, "PRIVATE KEY", func(der []byte) (interface{}, error) {
+		return x509.ParsePKCS8PrivateKey(der)
+	})
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: la chiave non è Ed25519", path)
+	}
+	return priv, nil
+}
+
+// loadSealPublicKey legge una chiave pubblica Ed25519 in formato PEM PKIX.
+func loadSealPublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := parsePEMKey(path, "PUBLIC KEY", x509.ParsePKIXPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: la chiave non è Ed25519", path)
+	}
+	return pub, nil
+}
+
+// parsePEMKey legge il primo blocco PEM del tipo indicato e lo decodifica con parse.
+func parsePEMKey(path, blockType string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: nessun blocco PEM %q", path, blockType)
+		}
+		if block.Type == blockType {
+			key, err := parse(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return key, nil
+		}
+	}
+}
+
+// keyID restituisce l'identificativo breve di una chiave pubblica.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeSeal firma il manifest dell'output appena scritto e lo salva in
+// outputFile + sealSuffix.
+func writeSeal(outputFile string, d *outputDigest, key ed25519.PrivateKey) error {
+	m := sealManifest{
+		Version: 1,
+		Created: time.Now().UTC(),
+		Output:  filepath.Base(outputFile),
+		Bytes:   d.bytes,
+		Lines:   d.lines,
+		SHA256:  d.sum(),
+		Args:    flagArgs(nil),
+		Inputs:  sealInputs(),
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s := seal{
+		Manifest:  payload,
+		KeyID:     keyID(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile+sealSuffix, data, 0644)
+}
+
+// verifySeal controlla la firma del sigillo con la chiave pubblica e poi
+// che il file di output indicato corrisponda a checksum, byte e righe.
+func verifySeal(sealPath, pubPath string, w io.Writer) error {
+	pub, err := loadSealPublicKey(pubPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(sealPath)
+	if err != nil {
+		return err
+	}
+	var s seal
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("sigillo non valido: %w", err)
+	}
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, s.Manifest); err != nil {
+		return fmt.Errorf("sigillo non valido: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil || !ed25519.Verify(pub, payload.Bytes(), sig) {
+		return fmt.Errorf("sigillo %s: firma non valida per la chiave %s", sealPath, keyID(pub))
+	}
+
+	var m sealManifest
+	if err := json.Unmarshal(payload.Bytes(), &m); err != nil {
+		return fmt.Errorf("sigillo non valido: %w", err)
+	}
+	f, err := os.Open(filepath.Join(filepath.Dir(sealPath), m.Output))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := newOutputDigest()
+	if _, err := io.Copy(d, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(d.h.Sum(nil)); sum != m.SHA256 || d.bytes != m.Bytes || d.lines != m.Lines {
+		return fmt.Errorf("%s: contenuto diverso dal sigillo (%d byte, %d righe, sha256 %s)", m.Output, d.bytes, d.lines, sum)
+	}
+	fmt.Fprintf(w, "✅ %s: sigillo valido (chiave %s, %d righe, %d byte, creato %s)\n",
+		m.Output, keyID(pub), m.Lines, m.Bytes, m.Created.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sortedShortcutAllowed indica se un input già ordinato può essere copiato
+// così com'è nell'output: un solo file regolare, letto senza conversioni, e
+// un output che coincide con le righe ordinate, senza passi che contano,
+// filtrano o firmano i record.
+func sortedShortcutAllowed() bool {
+	if !opts.detectSorted || len(opts.inputs) != 1 || opts.inputs[0] == "-" {
+		return false
+	}
+	if opts.runSet != "" || opts.partitionKey != nil || opts.edges > 0 || opts.avro ||
+		opts.header || opts.skipHeader > 0 || opts.validateUTF8 != utf8Off || inputCharset != nil ||
+		opts.outCompression != compressNone || opts.sealKey != nil || opts.checksum {
+		return false
+	}
+	path := opts.inputs[0]
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() || compressedInput(path) || hasBOM(path) {
+		return false
+	}
+	return chunksCopyable()
+}
+
+// inputSorted legge path una volta e indica se i suoi record sono già in
+// ordine secondo le opzioni correnti. Si ferma al primo record fuori ordine:
+// su un input casuale costa poche righe, su uno quasi ordinato al più una
+// lettura sequenziale. Un record scartato o modificato dallo schema o dai
+// limiti di lunghezza rende l'input non copiabile: i byte letti devono
+// coincidere con quelli del file.
+func inputSorted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	// I record deviati da --oversize-policy divert non servono: basta sapere
+	// che ce n'è uno.
+	discard := bufio.NewWriter(io.Discard)
+	reader := newRecordReader(bufio.NewReaderSize(f, opts.readerBuf), opts.maxRecordBytes, opts.oversizePolicy, discard)
+	var prev, prevKey string
+	var have bool
+	var seen int64
+	for {
+		line, err := reader.next()
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if reader.oversize > 0 {
+			return false, nil
+		}
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		seen += int64(len(line))
+		advanceProgress(len(line))
+		s, ok := opts.schemaDef.Parse(line)
+		if !ok || s != string(bytes.TrimSuffix(line, []byte{recordSep})) {
+			return false, nil
+		}
+		key := sortKey(s)
+		if have && lessSeq(key, s, 1, prevKey, prev, 0) {
+			return false, nil
+		}
+		prev, prevKey, have = s, key, true
+		if err == io.EOF {
+			break
+		}
+	}
+	return seen == fi.Size(), nil
+}
+
+// copySortedInput copia l'input già ordinato in outputFile, aggiungendo il
+// separatore finale se manca. Su Linux io.Copy tra due file usa
+// copy_file_range: i dati non passano dallo spazio utente.
+func copySortedInput(path, outputFile string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if fi, err := os.Stat(outputFile); err == nil && outputFile != "-" {
+		if src, _ := in.Stat(); os.SameFile(src, fi) {
+			return nil
+		}
+	}
+	out, err := createOutput(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	n, err := io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		last := make([]byte, 1)
+		if _, err := in.ReadAt(last, n-1); err != nil {
+			return err
+		}
+		if last[0] != recordSep {
+			if _, err := out.Write([]byte{recordSep}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := commitOutput(out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// skipIfSorted verifica con una lettura dell'input se è già ordinato e in
+// quel caso lo copia nell'output senza split né merge. Restituisce true se
+// l'output è stato scritto.
+func skipIfSorted(outputFile string) (bool, error) {
+	if !sortedShortcutAllowed() {
+		return false, nil
+	}
+	setPhase("verifica ordine")
+	path := opts.inputs[0]
+	sorted, err := inputSorted(path)
+	if err != nil || !sorted {
+		return false, err
+	}
+	fmt.Fprintf(status, "✅ %s è già ordinato: copiato senza split né merge\n", path)
+	setPhase("copia")
+	return true, copySortedInput(path, outputFile)
+}
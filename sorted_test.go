@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDetectSortedDivert verifica --oversize-policy divert con il
+// rilevamento degli input già ordinati: il record troppo lungo finisce in
+// --oversize-file e l'output contiene gli altri, ordinati.
+func TestDetectSortedDivert(t *testing.T) {
+	long := "c" + strings.Repeat("x", 40)
+	tests := []struct {
+		name  string
+		input []string
+	}{
+		{"ordinato", []string{"a", "b", long, "d", "e"}},
+		{"non ordinato", []string{"e", long, "b", "d", "a"}},
+		{"lungo in testa", []string{long, "a", "b", "d", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", tt.input)
+			mustRunSorter(t, dir, "--input", input, "--output", "out.txt",
+				"--max-record-bytes", "10", "--oversize-policy", "divert", "--oversize-file", "oversized.txt",
+				"--detect-sorted=true")
+			equalLines(t, "out.txt", readLines(t, filepath.Join(dir, "out.txt")), []string{"a", "b", "d", "e"})
+			equalLines(t, "oversized.txt", readLines(t, filepath.Join(dir, "oversized.txt")), []string{long})
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// pipeBlock è la dimensione dei blocchi di input letti dalla goroutine di
+// lettura dello split a pipeline.
+const pipeBlock = 1 << 20
+
+// pipeBlockPool ricicla i blocchi di input già analizzati (*[]byte).
+var pipeBlockPool sync.Pool
+
+// parsedBatch è il risultato dell'analisi di un blocco di input.
+type parsedBatch struct {
+	lines   []string // record del blocco, nell'ordine dell'input
+	skipped int      // righe non vuote scartate dallo schema
+	err     error    // errore di lettura: ultimo batch
+}
+
+// pipelinedSplit indica se lo split può leggere e analizzare l'input a
+// pipeline: servono uno schema con Slice e record che si possono separare
+// cercando recordSep in un blocco, senza stato tra un record e l'altro.
+// Limiti di lunghezza, validazione UTF-8, intestazioni, CSV su più righe e
+// --intern restano nella lettura sequenziale.
+func pipelinedSplit() bool {
+	if opts.parseWorkers <= 0 || opts.schemaDef.Slice == nil {
+		return false
+	}
+	return !opts.csv && !opts.header && opts.skipHeader == 0 && opts.maxRecordBytes == 0 &&
+		opts.validateUTF8 == utf8Off && opts.rejectsFile == "" && !binaryRecords() && !eolPending && !opts.intern
+}
+
+// readPipelined legge r a blocchi in una goroutine e li fa analizzare da
+// workers goroutine, ognuna con la propria lineArena; consume riceve i
+// record di ogni blocco nell'ordine dell'input, dalla goroutine chiamante.
+// Un blocco termina all'ultimo separatore: il resto passa in testa al
+// successivo, e un record più lungo del blocco lo fa crescere.
+func readPipelined(r io.Reader, workers int, consume func(parsedBatch) error) error {
+	type parseJob struct {
+		block *[]byte
+		out   chan parsedBatch
+	}
+	jobs := make(chan parseJob, workers)
+	// order porta i risultati nell'ordine dei blocchi: ognuno ha il suo canale.
+	order := make(chan chan parsedBatch, 2*workers)
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	// All'uscita, anche per un errore di consume, le goroutine terminano
+	// prima che il chiamante chiuda r.
+	defer running.Wait()
+	defer close(done)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer close(order)
+		defer close(jobs)
+		send := func(job parseJob) bool {
+			select {
+			case order <- job.out:
+			case <-done:
+				return false
+			}
+			if job.block == nil {
+				return true
+			}
+			select {
+			case jobs <- job:
+				return true
+			case <-done:
+				return false
+			}
+		}
+		var carry []byte
+		for {
+			bp := getPipeBlock()
+			b := append((*bp)[:0], carry...)
+			var err error
+			for {
+				var n int
+				n, err = io.ReadFull(r, b[len(b):cap(b)])
+				b = b[:len(b)+n]
+				if err != nil || bytes.LastIndexByte(b, recordSep) >= 0 {
+					break
+				}
+				// Nessun separatore nel blocco: un record più lungo del blocco.
+				grown := make([]byte, len(b), 2*cap(b))
+				copy(grown, b)
+				b = grown
+			}
+			eof := err == io.EOF || err == io.ErrUnexpectedEOF
+			if err != nil && !eof {
+				out := make(chan parsedBatch, 1)
+				out <- parsedBatch{err: err}
+				send(parseJob{out: out})
+				return
+			}
+			carry = carry[:0]
+			if !eof {
+				i := bytes.LastIndexByte(b, recordSep)
+				carry = append(carry, b[i+1:]...)
+				b = b[:i+1]
+			}
+			*bp = b
+			if len(b) > 0 && !send(parseJob{block: bp, out: make(chan parsedBatch, 1)}) {
+				return
+			}
+			if eof {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer removeTempsOnPanic()
+			var arena lineArena
+			hint := 0
+			for job := range jobs {
+				batch := parsedBatch{lines: make([]string, 0, hint)}
+				data := *job.block
+				advanceProgress(len(data))
+				for len(data) > 0 {
+					n := bytes.IndexByte(data, recordSep) + 1
+					if n == 0 {
+						n = len(data)
+					}
+					line := normalizeEOL(data[:n])
+					data = data[n:]
+					if b, ok := opts.schemaDef.Slice(line); ok {
+						batch.lines = append(batch.lines, arena.add(b))
+					} else if len(line) > 0 {
+						batch.skipped++
+					}
+				}
+				hint = len(batch.lines)
+				pipeBlockPool.Put(job.block)
+				job.out <- batch
+			}
+		}()
+	}
+
+	for out := range order {
+		batch := <-out
+		if batch.err != nil {
+			return batch.err
+		}
+		if err := consume(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPipeBlock restituisce un blocco di input vuoto, riciclato se disponibile.
+func getPipeBlock() *[]byte {
+	if v, ok := pipeBlockPool.Get().(*[]byte); ok {
+		return v
+	}
+	b := make([]byte, 0, pipeBlock)
+	return &b
+}
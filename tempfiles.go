@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// temps registra i file temporanei dell'esecuzione: chunk dello split, run
+// intermedi e directory delle partizioni. Un'esecuzione li rimuove quando
+// l'output è completo e quando termina con un errore, un panic o un
+// segnale, invece di lasciarli nella directory dei chunk. Con --run-set i
+// chunk sono il risultato dell'esecuzione e restano; con --resume restano,
+// se l'esecuzione fallisce, i chunk di uno split completato.
+var temps struct {
+	mu      sync.Mutex
+	paths   []string
+	keep    map[string]bool // file conservati se l'esecuzione fallisce (--resume)
+	removed bool
+}
+
+// trackTemp registra path tra i file temporanei. Va chiamata prima di
+// crearlo, così anche un file scritto a metà viene rimosso. In modalità
+// cooperativa i chunk appartengono al manifest condiviso e non sono
+// registrati: rilanciando con lo stesso --coop restano validi.
+func trackTemp(path string) {
+	if opts.coop != "" {
+		return
+	}
+	temps.mu.Lock()
+	temps.paths = append(temps.paths, path)
+	temps.mu.Unlock()
+}
+
+// keepTemps conserva paths, già registrati con trackTemp, se l'esecuzione
+// fallisce: saveResume li indica dopo lo split, per la ripresa con --resume.
+func keepTemps(paths []string) {
+	temps.mu.Lock()
+	defer temps.mu.Unlock()
+	temps.keep = make(map[string]bool, len(paths))
+	for _, p := range paths {
+		temps.keep[p] = true
+	}
+}
+
+// releaseTemps annulla keepTemps: l'output è completo e i chunk non servono più.
+func releaseTemps() {
+	temps.mu.Lock()
+	temps.keep = nil
+	temps.mu.Unlock()
+}
+
+// resumable indica se, fallendo ora, l'esecuzione lascia uno split da
+// riprendere con --resume.
+func resumable() bool {
+	temps.mu.Lock()
+	defer temps.mu.Unlock()
+	return len(temps.keep) > 0
+}
+
+// removeTemps rimuove i file temporanei registrati, in ordine inverso: i
+// file prima delle directory che li contengono. Con --keep-temp li elenca
+// soltanto. Le chiamate successive alla prima non fanno nulla.
+func removeTemps() {
+	temps.mu.Lock()
+	defer temps.mu.Unlock()
+	if temps.removed || len(temps.paths) == 0 {
+		return
+	}
+	temps.removed = true
+	if opts.keepTemp {
+		fmt.Fprintf(os.Stderr, "📝 %d file temporanei conservati (--keep-temp), a partire da %s\n", len(temps.paths), temps.paths[0])
+		return
+	}
+	for i := len(temps.paths) - 1; i >= 0; i-- {
+		// Una directory non vuota (file di altre esecuzioni) resta dov'è.
+		if !temps.keep[temps.paths[i]] {
+			os.Remove(temps.paths[i])
+		}
+	}
+	if len(temps.keep) > 0 {
+		fmt.Fprintf(os.Stderr, "📝 Split conservato (%d file): rilanciare con --resume per riprendere dal merge\n", len(temps.keep))
+	}
+}
+
+// exitRun termina un'esecuzione fallita con code dopo aver rimosso i file
+// temporanei e rilasciato i lock: os.Exit non esegue le funzioni differite.
+func exitRun(code int) {
+	removeTemps()
+	releaseRunLocks()
+	os.Exit(code)
+}
+
+// removeTempsOnPanic va differita all'inizio di main e delle goroutine che
+// eseguono codice dell'ordinamento: un panic rimuove i file temporanei e
+// prosegue, terminando il programma con la traccia originale.
+func removeTempsOnPanic() {
+	if r := recover(); r != nil {
+		removeTemps()
+		panic(r)
+	}
+}
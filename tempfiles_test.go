@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestChunksRemovedAfterSuccess verifica che un'esecuzione riuscita rimuova
+// i propri chunk, così esecuzioni ripetute sulla stessa --chunk-dir non li
+// accumulano, e che --keep-temp e --run-set li conservino.
+func TestChunksRemovedAfterSuccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		dir    string // directory dei chunk
+		chunks bool   // i chunk devono restare su disco
+	}{
+		{"merge", nil, "chunks", false},
+		{"partition", []string{"--partition", "1.1,1.1"}, "chunks", false},
+		{"edges", []string{"--edges", "3"}, "chunks", false},
+		{"keep-temp", []string{"--keep-temp"}, "chunks", true},
+		{"run-set", []string{"--run-set", "runs"}, "runs", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := writeLines(t, dir, "input.txt", testLines(3000))
+			args := append([]string{"--input", input, "--output", "out.txt", "--chunk-bytes", "20000", "--in-memory=false", "--detect-sorted=false"}, tt.args...)
+			for run := 0; run < 2; run++ {
+				mustRunSorter(t, dir, args...)
+			}
+			chunks, err := filepath.Glob(filepath.Join(dir, tt.dir, "chunk_*.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts, _ := filepath.Glob(filepath.Join(dir, tt.dir, "part_*"))
+			left := len(chunks) + len(parts)
+			if tt.chunks && len(chunks) == 0 {
+				t.Fatal("nessun chunk conservato")
+			}
+			if !tt.chunks && left > 0 {
+				t.Fatalf("%d file temporanei rimasti dopo due esecuzioni riuscite", left)
+			}
+		})
+	}
+}
+
+// TestGNUTempDirRemoved verifica che con --gnu senza -T la directory dei
+// chunk creata in $TMPDIR per il processo venga rimossa alla fine, anche
+// quando l'input è già ordinato, mentre la directory di -T resta.
+func TestGNUTempDirRemoved(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted bool
+		args   []string
+		keep   string // directory che deve restare, relativa alla directory del test
+	}{
+		{name: "split e merge", args: []string{"-S", "20K"}},
+		{name: "in memoria"},
+		{name: "già ordinato", sorted: true},
+		{name: "unique", args: []string{"-u", "-S", "20K"}},
+		{name: "-T", args: []string{"-S", "20K", "-T", "tmp"}, keep: "tmp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("TMPDIR", dir)
+			lines := testLines(3000)
+			if tt.sorted {
+				lines = sortedCopy(lines)
+			}
+			input := writeLines(t, dir, "input.txt", lines)
+			if tt.keep != "" {
+				if err := os.Mkdir(filepath.Join(dir, tt.keep), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := append([]string{"--gnu", "-o", "out.txt"}, tt.args...)
+			mustRunSorter(t, dir, append(args, input)...)
+			if left, _ := filepath.Glob(filepath.Join(dir, "sithlords-*")); len(left) > 0 {
+				t.Fatalf("directory dei chunk rimaste in $TMPDIR: %v", left)
+			}
+			if tt.keep != "" {
+				if _, err := os.Stat(filepath.Join(dir, tt.keep)); err != nil {
+					t.Fatalf("la directory di -T è stata rimossa: %v", err)
+				}
+			}
+		})
+	}
+}
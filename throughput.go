@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// diskBoundShare è la quota della durata di una fase passata ad aspettare
+// l'I/O oltre la quale la fase è considerata limitata dal disco.
+const diskBoundShare = 0.5
+
+// timedReader conta i byte letti da r e il tempo passato ad aspettarli.
+// Confrontato con la durata della fase dice se la fase aspetta il disco o
+// la CPU. Le Read arrivano a blocchi (bufio, blocchi dello split a
+// pipeline): leggere l'orologio a ogni chiamata non pesa.
+type timedReader struct {
+	r     io.Reader
+	bytes int64
+	busy  time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.busy += time.Since(start)
+	t.bytes += int64(n)
+	return n, err
+}
+
+// timedWriter è timedReader per la scrittura.
+type timedWriter struct {
+	w     io.Writer
+	bytes int64
+	busy  time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.busy += time.Since(start)
+	t.bytes += int64(n)
+	return n, err
+}
+
+// workerStats è il lavoro svolto da un worker dello split. Ogni worker
+// aggiorna solo la propria voce; le voci si leggono dopo la sua fine.
+type workerStats struct {
+	lines int64
+	bytes int64
+	busy  time.Duration
+}
+
+// formatRate rende leggibile un throughput in byte al secondo (es. 120.5 MB/s).
+func formatRate(n int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return formatBytes(int64(float64(n)/d.Seconds())) + "/s"
+}
+
+// formatLineRate rende leggibile un numero di righe al secondo (es. 1.2 M righe/s).
+func formatLineRate(lines int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	rate := float64(lines) / d.Seconds()
+	switch {
+	case rate >= 1e6:
+		return fmt.Sprintf("%.1f M righe/s", rate/1e6)
+	case rate >= 1e3:
+		return fmt.Sprintf("%.1f K righe/s", rate/1e3)
+	}
+	return fmt.Sprintf("%.0f righe/s", rate)
+}
+
+// boundBy indica il collo di bottiglia di una fase durata wall che ha
+// passato wait ad aspettare il disco.
+func boundBy(wait, wall time.Duration) string {
+	if wall > 0 && wait.Seconds() >= diskBoundShare*wall.Seconds() {
+		return "disco"
+	}
+	return "CPU"
+}
+
+// reportSplitThroughput riporta il throughput dello split: la lettura
+// dell'input, l'ordinamento e la scrittura di ogni worker. Una lettura
+// quasi sempre in attesa indica il disco come limite; worker di scrittura
+// lenti rispetto all'ordinamento suggeriscono di rivedere --io-workers.
+func reportSplitThroughput(in *timedReader, lines int64, wall time.Duration, sorters, writers []workerStats) {
+	fmt.Fprintf(status, "📊 Lettura: %s, %d righe in %s (%s, %s); %s in attesa dell'input, collo di bottiglia %s\n",
+		formatBytes(in.bytes), lines, wall.Round(time.Millisecond), formatRate(in.bytes, wall),
+		formatLineRate(lines, wall), in.busy.Round(time.Millisecond), boundBy(in.busy, wall))
+
+	var sorted int64
+	var sortBusy time.Duration
+	for _, s := range sorters {
+		sorted += s.lines
+		sortBusy += s.busy
+	}
+	if sorted > 0 {
+		fmt.Fprintf(status, "📊 Ordinamento: %d worker, %d righe in %s complessivi (%s per worker)\n",
+			len(sorters), sorted, sortBusy.Round(time.Millisecond), formatLineRate(sorted, sortBusy))
+	}
+
+	var parts []string
+	for i, w := range writers {
+		if w.bytes > 0 {
+			parts = append(parts, fmt.Sprintf("worker %d %s in %s (%s)",
+				i+1, formatBytes(w.bytes), w.busy.Round(time.Millisecond), formatRate(w.bytes, w.busy)))
+		}
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(status, "📊 Scrittura chunk: %s\n", strings.Join(parts, ", "))
+	}
+}
+
+// reportMergeThroughput riporta il ritmo di uscita del merge: byte scritti,
+// record e tempo passato nelle scritture dell'output.
+func reportMergeThroughput(out *timedWriter, records int64, wall time.Duration) {
+	fmt.Fprintf(status, "📊 Merge: %s, %d record in %s (%s, %s); %s in scrittura, collo di bottiglia %s\n",
+		formatBytes(out.bytes), records, wall.Round(time.Millisecond), formatRate(out.bytes, wall),
+		formatLineRate(records, wall), out.busy.Round(time.Millisecond), boundBy(out.busy, wall))
+}
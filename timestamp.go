@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsFormat è il formato dei timestamp di --timestamp.
+type tsFormat struct {
+	layout string // layout di time.Parse con i campi separati da uno spazio
+	fields int    // campi (separati da spazi) occupati dal timestamp
+	epoch  bool   // secondi dal 1970, con eventuale parte frazionaria
+}
+
+// parseTimestampFormat interpreta il valore di --timestamp: rfc3339, syslog,
+// epoch oppure un layout di time.Parse (es. "2006-01-02 15:04:05").
+func parseTimestampFormat(v string) (tsFormat, error) {
+	layout := v
+	switch v {
+	case "":
+		return tsFormat{}, nil
+	case "epoch":
+		return tsFormat{fields: 1, epoch: true}, nil
+	case "rfc3339":
+		layout = time.RFC3339Nano
+	case "syslog":
+		layout = time.Stamp
+	default:
+		// Un layout deve contenere almeno un elemento di data o ora.
+		if time.Now().Format(v) == v {
+			return tsFormat{}, fmt.Errorf("--timestamp non valido: %q (attesi rfc3339, syslog, epoch o un layout come \"2006-01-02 15:04:05\")", v)
+		}
+	}
+	f := strings.Fields(layout)
+	return tsFormat{layout: strings.Join(f, " "), fields: len(f)}, nil
+}
+
+// timestampKey restituisce la chiave di s per --timestamp: un byte che vale
+// 1 se all'inizio di s (dopo gli spazi) c'è un timestamp nel formato
+// richiesto, seguito dall'istante in nanosecondi su 8 byte. I valori non
+// riconosciuti hanno chiave 0 e precedono tutti gli altri, come i mesi
+// sconosciuti di --month-sort; a parità di istante decide il resto del confronto.
+// I timestamp senza fuso orario (syslog, layout senza zona) sono letti in UTC
+// e quelli syslog, privi di anno, vengono ordinati all'interno dell'anno.
+func timestampKey(s string, f tsFormat) string {
+	text, ok := leadingFields(s, f.fields)
+	if !ok {
+		return "\x00"
+	}
+	var ns int64
+	if f.epoch {
+		if ns, ok = parseEpoch(text); !ok {
+			return "\x00"
+		}
+	} else {
+		t, err := parseTimestamp(f.layout, text)
+		if err != nil {
+			return "\x00"
+		}
+		ns = t.UnixNano()
+	}
+	var buf [9]byte
+	buf[0] = 1
+	// Il bit di segno invertito rende confrontabili come byte anche gli istanti negativi.
+	binary.BigEndian.PutUint64(buf[1:], uint64(ns)^1<<63)
+	return string(buf[:])
+}
+
+// parseTimestamp interpreta text con layout ignorando il testo che segue il
+// timestamp nello stesso campo, come la parentesi di "[10/Oct/2023:13:55:36 -0700]".
+func parseTimestamp(layout, text string) (time.Time, error) {
+	t, err := time.Parse(layout, text)
+	var perr *time.ParseError
+	if errors.As(err, &perr) && strings.HasPrefix(perr.Message, ": extra text") {
+		return time.Parse(layout, text[:len(text)-len(perr.ValueElem)])
+	}
+	return t, err
+}
+
+// leadingFields restituisce i primi n campi di s separati da uno spazio,
+// senza allocazioni quando n è 1. ok è false se s ha meno di n campi.
+func leadingFields(s string, n int) (text string, ok bool) {
+	var b strings.Builder
+	i := 0
+	for k := 0; k < n; k++ {
+		i = skipBlanks(s, i)
+		if i == len(s) {
+			return "", false
+		}
+		j := i
+		for j < len(s) && !isBlank(s[j]) {
+			j++
+		}
+		if n == 1 {
+			return s[i:j], true
+		}
+		if k > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s[i:j])
+		i = j
+	}
+	return b.String(), true
+}
+
+// parseEpoch interpreta secondi dal 1970 con parte frazionaria opzionale
+// (fino ai nanosecondi) e restituisce l'istante in nanosecondi.
+func parseEpoch(s string) (int64, bool) {
+	sec, frac, hasFrac := strings.Cut(s, ".")
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || n > 1<<33 || n < -(1<<33) {
+		return 0, false
+	}
+	ns := n * int64(time.Second)
+	if hasFrac {
+		if frac == "" || len(frac) > 9 {
+			return 0, false
+		}
+		d, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		for i := len(frac); i < 9; i++ {
+			d *= 10
+		}
+		if strings.HasPrefix(sec, "-") {
+			ns -= int64(d)
+		} else {
+			ns += int64(d)
+		}
+	}
+	return ns, true
+}
@@ -0,0 +1,15 @@
+package main
+
+import "github.com/afraccalvieri-ca/SithLords/schema"
+
+// tsvSchemaName è lo schema delle righe TSV, selezionato da --tsv.
+const tsvSchemaName = "tsv"
+
+// tsvFieldSep separa le colonne di un record TSV.
+const tsvFieldSep = "\t"
+
+func init() {
+	// Righe TSV: nessuna virgoletta né escape, il record resta la riga letta
+	// e le colonne si trovano direttamente sui tab, senza il parser di --csv.
+	schema.Register(schema.Schema{Name: tsvSchemaName, Parse: lineRecord})
+}
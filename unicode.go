@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Politiche di --validate-utf8 per i record con sequenze UTF-8 non valide.
+const (
+	utf8Off     = "off"     // nessun controllo: i record vengono confrontati come byte
+	utf8Skip    = "skip"    // il record viene scartato e contato
+	utf8Replace = "replace" // le sequenze non valide diventano U+FFFD
+	utf8Error   = "error"   // interrompe l'esecuzione con l'offset del record
+)
+
+// parseValidateUTF8 verifica il valore di --validate-utf8.
+func parseValidateUTF8(v string) error {
+	switch v {
+	case utf8Off, utf8Skip, utf8Replace, utf8Error:
+		return nil
+	}
+	return fmt.Errorf("--validate-utf8 non valido: %q (attesi off, skip, replace o error)", v)
+}
+
+// checkUTF8 applica --validate-utf8 al record line, iniziato all'offset start.
+// skip è true se il record va scartato.
+func (rr *recordReader) checkUTF8(line []byte, start int64) (out []byte, skip bool, err error) {
+	if opts.validateUTF8 == utf8Off || utf8.Valid(line) {
+		return line, false, nil
+	}
+	rr.invalid++
+	switch opts.validateUTF8 {
+	case utf8Skip:
+		return nil, true, rr.reject(line)
+	case utf8Replace:
+		return bytes.ToValidUTF8(line, []byte(string(utf8.RuneError))), false, nil
+	}
+	return nil, false, fmt.Errorf("record all'offset %d: UTF-8 non valido", start)
+}
+
+// parseNormalize interpreta il valore di --normalize.
+func parseNormalize(v string) (form norm.Form, err error) {
+	switch v {
+	case "", "nfc":
+		return norm.NFC, nil
+	case "nfd":
+		return norm.NFD, nil
+	}
+	return 0, fmt.Errorf("--normalize non valido: %q (attesi nfc o nfd)", v)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// crockford mappa i caratteri dell'alfabeto base32 di Crockford usato dai
+// ULID al loro valore (-1 = carattere non valido). Come da specifica, le
+// minuscole valgono come le maiuscole e I, L, O come 1, 1, 0.
+var crockford = func() [256]int8 {
+	var t [256]int8
+	for i := range t {
+		t[i] = -1
+	}
+	for v, c := range "0123456789ABCDEFGHJKMNPQRSTVWXYZ" {
+		t[c] = int8(v)
+		t[c+'a'-'A'] = int8(v)
+	}
+	for _, c := range "IiLl" {
+		t[c] = 1
+	}
+	t['O'], t['o'] = 0, 0
+	return t
+}()
+
+// idTimeKey restituisce la chiave di s per --id-time: l'identificativo
+// UUIDv7 o ULID all'inizio di s (dopo gli spazi) come 16 byte, preceduti da
+// un byte che vale 1 se l'identificativo è valido. Entrambi i formati
+// iniziano con i millisecondi dal 1970 su 48 bit, quindi il confronto dei
+// byte ordina per istante di creazione anche mescolando i due formati, e a
+// parità di millisecondo per la parte restante. Gli identificativi senza
+// timestamp (come gli UUIDv4) e i valori non riconosciuti hanno chiave 0 e
+// precedono tutti gli altri.
+func idTimeKey(s string) string {
+	text, ok := leadingFields(s, 1)
+	if !ok {
+		return "\x00"
+	}
+	// L'identificativo termina al primo carattere che non può contenere,
+	// come la virgola di un CSV.
+	if i := strings.IndexFunc(text, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' || r == '{' || r == '}' || r == ':')
+	}); i >= 0 {
+		text = text[:i]
+	}
+	var id [17]byte
+	id[0] = 1
+	switch {
+	case len(text) == 26 && decodeULID(text, id[1:]):
+	case decodeUUID(text, id[1:]) && id[1+6]>>4 == 7:
+	default:
+		return "\x00"
+	}
+	return string(id[:])
+}
+
+// decodeUUID decodifica un UUID in forma testuale (con o senza trattini,
+// tra graffe o con prefisso urn:uuid:) nei 16 byte di dst.
+func decodeUUID(text string, dst []byte) bool {
+	if len(text) > 9 && strings.EqualFold(text[:9], "urn:uuid:") {
+		text = text[9:]
+	}
+	if len(text) == 38 && text[0] == '{' && text[37] == '}' {
+		text = text[1:37]
+	}
+	if len(text) == 36 {
+		if text[8] != '-' || text[13] != '-' || text[18] != '-' || text[23] != '-' {
+			return false
+		}
+		text = text[:8] + text[9:13] + text[14:18] + text[19:23] + text[24:]
+	}
+	if len(text) != 32 {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(text))
+	return err == nil
+}
+
+// decodeULID decodifica un ULID di 26 caratteri nei 16 byte di dst. Il primo
+// carattere porta solo 3 bit: valori oltre 7 non sono ULID validi.
+func decodeULID(text string, dst []byte) bool {
+	if crockford[text[0]] > 7 {
+		return false
+	}
+	var acc uint
+	var bits, n int
+	for i := 0; i < len(text); i++ {
+		v := crockford[text[i]]
+		if v < 0 {
+			return false
+		}
+		acc = acc<<5 | uint(v)
+		bits += 5
+		// I primi 2 bit (130 - 128) sono sempre zero e vengono scartati.
+		if i == 0 {
+			bits -= 2
+		}
+		for bits >= 8 {
+			bits -= 8
+			dst[n] = byte(acc >> bits)
+			n++
+		}
+		acc &= 1<<bits - 1
+	}
+	return n == 16
+}
@@ -0,0 +1,101 @@
+package main
+
+// versionKey codifica s in una chiave il cui ordine binario coincide con
+// l'ordine di GNU sort -V (filevercmp): "", ".", ".." e i nomi nascosti
+// vengono prima, poi si confrontano le versioni senza le estensioni finali
+// (come .tar.gz) e, a parità, i nomi interi.
+func versionKey(s string) string {
+	var class byte
+	switch {
+	case s == "":
+		class = 0
+	case s == ".":
+		class = 1
+	case s == "..":
+		class = 2
+	case s[0] == '.':
+		class = 3
+		s = s[1:] // tra due nomi nascosti il punto iniziale non conta
+	default:
+		class = 4
+	}
+	buf := []byte{class}
+	buf = appendVersion(buf, s[:len(s)-len(fileSuffix(s))])
+	buf = appendVersion(buf, s)
+	return string(buf)
+}
+
+// fileSuffix restituisce la più lunga coda di s formata da estensioni del
+// tipo \.[A-Za-z~][A-Za-z0-9~]*, che filevercmp esclude dal primo confronto.
+func fileSuffix(s string) string {
+	end := len(s)
+	for {
+		dot := -1
+		for i := end - 1; i >= 0; i-- {
+			if s[i] == '.' {
+				dot = i
+				break
+			}
+		}
+		if dot < 0 || dot+1 >= end || !isVersionAlpha(s[dot+1]) {
+			return s[end:]
+		}
+		for i := dot + 2; i < end; i++ {
+			if !isVersionAlpha(s[i]) && !isDigit(s[i]) {
+				return s[end:]
+			}
+		}
+		end = dot
+	}
+}
+
+// isVersionAlpha riconosce i caratteri che possono iniziare un'estensione.
+func isVersionAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '~'
+}
+
+// appendVersion aggiunge a dst la codifica di s secondo il confronto delle
+// versioni Debian (verrevcmp). La stringa è una sequenza di coppie (testo,
+// cifre): ogni carattere di testo diventa un peso su 2 byte ('~' prima della
+// fine del segmento, poi lettere, poi altri caratteri), il segmento termina
+// con il peso 2; le cifre diventano lunghezza su 4 byte e cifre senza zeri
+// iniziali. Un peso 2 finale fa sì che la fine della stringa valga come la
+// fine di un segmento, quindi "1~" precede "1" che precede "1a". La codifica
+// è prefix-free e può essere concatenata.
+func appendVersion(dst []byte, s string) []byte {
+	i := 0
+	for {
+		for ; i < len(s) && !isDigit(s[i]); i++ {
+			w := versionWeight(s[i])
+			dst = append(dst, byte(w>>8), byte(w))
+		}
+		dst = append(dst, 0, 2)
+
+		start := i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		digits := s[start:i]
+		for len(digits) > 0 && digits[0] == '0' {
+			digits = digits[1:]
+		}
+		n := len(digits)
+		dst = append(dst, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		dst = append(dst, digits...)
+		if i >= len(s) {
+			return append(dst, 0, 2)
+		}
+	}
+}
+
+// versionWeight restituisce il peso di un carattere di testo in verrevcmp,
+// spostato di 2 per lasciare posto a '~' (1) e alla fine del segmento (2).
+func versionWeight(c byte) int {
+	switch {
+	case c == '~':
+		return 1
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c) + 2
+	}
+	return int(c) + 258
+}